@@ -27,6 +27,8 @@ import (
 	"time"
 
 	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/transfer"
+	"github.com/containerd/containerd/v2/core/transfer/xfer"
 	"github.com/containerd/containerd/v2/pkg/progress"
 )
 
@@ -192,14 +194,21 @@ func shortenRef(ref string) string {
 	return ref
 }
 
-// DockerShowProgress displays progress in docker-style format
-func DockerShowProgress(ctx context.Context, ongoing *Jobs, cs content.Store, out io.Writer) {
+// DockerShowProgress displays progress in docker-style format. Each
+// job's row is driven by events manager pushes over Manager.Watch,
+// rather than by re-listing cs's active ingests on a poll tick the way
+// this used to work; cs is only still consulted for a job that manager
+// has no transfer for - because it already landed in the content store
+// before this pull started (StatusExists), or finished so quickly no
+// Watch was ever attached before Manager forgot it (StatusDone).
+func DockerShowProgress(ctx context.Context, ongoing *Jobs, manager *xfer.Manager, cs content.Store, out io.Writer) {
 	display := NewDockerContentDisplay(out)
 
 	var (
 		ticker   = time.NewTicker(100 * time.Millisecond)
 		start    = time.Now()
 		statuses = map[string]StatusInfo{}
+		watches  = map[string]<-chan transfer.Progress{}
 		done     bool
 	)
 	defer ticker.Stop()
@@ -217,37 +226,37 @@ func DockerShowProgress(ctx context.Context, ongoing *Jobs, cs content.Store, ou
 			}
 			display.updateProgress(statuses[ongoing.name])
 
-			activeSeen := map[string]struct{}{}
-			if !done {
-				active, err := cs.ListStatuses(ctx, "")
-				if err != nil {
-					continue
-				}
-				// update status of active entries!
-				for _, active := range active {
-					status := StatusInfo{
-						Ref:       active.Ref,
-						Status:    StatusDownloading,
-						Offset:    active.Offset,
-						Total:     active.Total,
-						StartedAt: active.StartedAt,
-						UpdatedAt: active.UpdatedAt,
+			for _, j := range ongoing.Jobs() {
+				key := fmt.Sprintf("sha256:%s", j.Digest.Encoded())
+
+				ch, watching := watches[key]
+				if !watching && !done {
+					if c, err := manager.Watch(ctx, j.Digest); err == nil {
+						watches[key] = c
+						ch, watching = c, true
 					}
-					statuses[active.Ref] = status
-					display.updateProgress(status)
-					activeSeen[active.Ref] = struct{}{}
 				}
-			}
 
-			// now, update the items in jobs that are not in active
-			for _, j := range ongoing.Jobs() {
-				key := fmt.Sprintf("sha256:%s", j.Digest.Encoded())
-				if _, ok := activeSeen[key]; ok {
-					continue
+				if watching {
+					status, updated, closed := drainLatest(ch, key)
+					if updated {
+						statuses[key] = status
+						display.updateProgress(status)
+					}
+					if closed {
+						// manager forgot the transfer - either it
+						// finished (the last update already carried
+						// StatusDone) or we lost a race and it was never
+						// actually running. Stop watching either way; a
+						// still-unresolved status falls through to the
+						// cs.Info check below like an untracked job.
+						delete(watches, key)
+						watching = false
+					}
 				}
 
 				status, ok := statuses[key]
-				if !done && (!ok || status.Status == StatusDownloading) {
+				if !done && (!ok || status.Status == StatusDownloading) && !watching {
 					info, err := cs.Info(ctx, j.Digest)
 					if err != nil {
 						status = StatusInfo{
@@ -298,3 +307,44 @@ func DockerShowProgress(ctx context.Context, ongoing *Jobs, cs content.Store, ou
 		}
 	}
 }
+
+// drainLatest reads whatever xfer.Progress events manager has pushed for
+// key since the last tick onto ch - which only ever holds the latest
+// one, see xfer.Manager.Watch - translating the newest into a
+// StatusInfo. updated is false if nothing arrived this tick; closed is
+// true once ch itself has been closed, telling the caller to stop
+// watching it.
+func drainLatest(ch <-chan transfer.Progress, key string) (status StatusInfo, updated, closed bool) {
+	for {
+		select {
+		case p, open := <-ch:
+			if !open {
+				return status, updated, true
+			}
+			status, updated = progressToStatus(key, p), true
+		default:
+			return status, updated, false
+		}
+	}
+}
+
+// progressToStatus maps one xfer.Transfer state transition onto the
+// StatusInfoStatus rows this display already knows how to render.
+// "verifying" and "extracting" - states a single layer transfer passes
+// through that this pull-level display has no dedicated row for - both
+// fold into StatusDownloading, the same as they did with the original
+// cs.ListStatuses-only view, which had no visibility into them at all.
+func progressToStatus(key string, p transfer.Progress) StatusInfo {
+	status := StatusInfo{Ref: key, Offset: p.Progress, Total: p.Total, UpdatedAt: time.Now()}
+	switch p.Event {
+	case "waiting":
+		status.Status = StatusWaiting
+	case "complete":
+		status.Status = StatusDone
+	case "error":
+		status.Status = StatusDone
+	default: // "downloading", "verifying", "extracting"
+		status.Status = StatusDownloading
+	}
+	return status
+}
@@ -0,0 +1,119 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package content
+
+import (
+	"sync"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// StatusInfoStatus is the state DockerShowProgress renders one line of
+// output for - not a 1:1 mirror of xfer.State, since a pull also has
+// "resolving the manifest" and "already present, nothing to fetch"
+// states a single layer transfer doesn't.
+type StatusInfoStatus string
+
+const (
+	StatusResolving   StatusInfoStatus = "resolving"
+	StatusResolved    StatusInfoStatus = "resolved"
+	StatusWaiting     StatusInfoStatus = "waiting"
+	StatusDownloading StatusInfoStatus = "downloading"
+	StatusDone        StatusInfoStatus = "done"
+	StatusExists      StatusInfoStatus = "exists"
+)
+
+// StatusInfo is one row of DockerShowProgress's display: either the
+// manifest reference itself, or one piece of content (by ref, usually
+// its digest) that reference's pull is waiting on.
+type StatusInfo struct {
+	Ref       string
+	Status    StatusInfoStatus
+	Offset    int64
+	Total     int64
+	StartedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Job is one piece of content - a manifest or a layer - that a Jobs is
+// tracking on behalf of a pull.
+type Job struct {
+	Digest digest.Digest
+}
+
+// Jobs tracks the content a single image pull is resolving and fetching,
+// for DockerShowProgress to render. It is a thin view over the pull's
+// *xfer.Manager: Add only remembers that this pull cares about dgst, it
+// doesn't start or own a download - that happens wherever the pull calls
+// Manager.Get for the same digest. DockerShowProgress asks the same
+// Manager for each job's progress via Manager.Watch.
+type Jobs struct {
+	name string
+
+	mu       sync.Mutex
+	resolved bool
+	jobs     []Job
+	added    map[digest.Digest]struct{}
+}
+
+// NewJobs creates a Jobs for name, typically the image reference a pull
+// was given on the command line.
+func NewJobs(name string) *Jobs {
+	return &Jobs{
+		name:  name,
+		added: make(map[digest.Digest]struct{}),
+	}
+}
+
+// Add records dgst as content this pull is waiting on. Calling Add again
+// for a digest already added is a no-op - a pull resolving the same
+// layer digest for two platforms shouldn't show it twice.
+func (j *Jobs) Add(dgst digest.Digest) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.added[dgst]; ok {
+		return
+	}
+	j.added[dgst] = struct{}{}
+	j.jobs = append(j.jobs, Job{Digest: dgst})
+}
+
+// Jobs returns the digests added so far, in the order Add first saw
+// them.
+func (j *Jobs) Jobs() []Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make([]Job, len(j.jobs))
+	copy(out, j.jobs)
+	return out
+}
+
+// MarkResolved records that j's manifest has been resolved, for
+// IsResolved.
+func (j *Jobs) MarkResolved() {
+	j.mu.Lock()
+	j.resolved = true
+	j.mu.Unlock()
+}
+
+// IsResolved reports whether MarkResolved has been called.
+func (j *Jobs) IsResolved() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.resolved
+}
@@ -21,9 +21,7 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/containerd/containerd/v2/core/transfer"
@@ -31,21 +29,9 @@ import (
 )
 
 type dockerProgressDisplay struct {
-	out    io.Writer
-	mutex  sync.RWMutex
-	layers map[string]*layerProgress
-	done   map[string]bool
-	start  time.Time
-	isTTY  bool
-}
-
-type layerProgress struct {
-	id       string
-	digest   string
-	status   string
-	current  int64
-	total    int64
-	lastTime time.Time
+	out     io.Writer
+	tracker *progressTracker
+	isTTY   bool
 }
 
 // NewDockerDisplay creates a new docker-style progress display
@@ -57,68 +43,23 @@ func NewDockerDisplay(out io.Writer) *dockerProgressDisplay {
 	}
 
 	return &dockerProgressDisplay{
-		out:    out,
-		layers: make(map[string]*layerProgress),
-		done:   make(map[string]bool),
-		start:  time.Now(),
-		isTTY:  isTTY,
+		out:     out,
+		tracker: newProgressTracker(),
+		isTTY:   isTTY,
 	}
 }
 
 func (d *dockerProgressDisplay) updateProgress(p transfer.Progress) {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-
-	// Skip if no descriptor
-	if p.Desc == nil {
-		return
-	}
-
-	// Create layer ID from digest
-	layerID := shortenDigest(p.Desc.Digest.String())
-
-	// Update or create layer progress
-	if layer, exists := d.layers[layerID]; exists {
-		layer.status = p.Event
-		layer.current = p.Progress
-		layer.total = p.Total
-		layer.lastTime = time.Now()
-	} else {
-		d.layers[layerID] = &layerProgress{
-			id:       layerID,
-			digest:   p.Desc.Digest.String(),
-			status:   p.Event,
-			current:  p.Progress,
-			total:    p.Total,
-			lastTime: time.Now(),
-		}
-	}
-
-	// Mark as done if complete
-	if p.Event == "complete" || p.Event == "done" {
-		d.done[layerID] = true
-	}
+	d.tracker.update(p)
 }
 
 func (d *dockerProgressDisplay) render() {
-	d.mutex.RLock()
-	defer d.mutex.RUnlock()
-
 	if d.isTTY {
 		// Clear previous output and move cursor to top
 		fmt.Fprint(d.out, "\033[2J\033[H")
 	}
 
-	// Sort layers by ID for consistent output
-	var layerIDs []string
-	for id := range d.layers {
-		layerIDs = append(layerIDs, id)
-	}
-	sort.Strings(layerIDs)
-
-	// Display each layer
-	for _, id := range layerIDs {
-		layer := d.layers[id]
+	for _, layer := range d.tracker.snapshot() {
 		d.renderLayer(layer)
 	}
 
@@ -165,9 +106,11 @@ func (d *dockerProgressDisplay) renderLayer(layer *layerProgress) {
 			fmt.Fprintf(d.out, "%s: Extracting\n", layer.id)
 		}
 	case "complete", "done":
-		if d.done[layer.id] {
+		if d.tracker.isDone(layer.id) {
 			fmt.Fprintf(d.out, "%s: Pull complete\n", layer.id)
 		}
+	case "verifying":
+		fmt.Fprintf(d.out, "%s: Verifying signature\n", layer.id)
 	default:
 		fmt.Fprintf(d.out, "%s: %s\n", layer.id, layer.status)
 	}
@@ -194,7 +137,7 @@ func (d *dockerProgressDisplay) createProgressBar(current, total int64, width in
 }
 
 func (d *dockerProgressDisplay) calculateSpeed(layer *layerProgress) string {
-	elapsed := time.Since(d.start)
+	elapsed := time.Since(d.tracker.start)
 	if elapsed.Seconds() == 0 || layer.current == 0 {
 		return ""
 	}
@@ -208,7 +151,7 @@ func (d *dockerProgressDisplay) calculateETA(layer *layerProgress) time.Duration
 		return 0
 	}
 
-	elapsed := time.Since(d.start)
+	elapsed := time.Since(d.tracker.start)
 	if elapsed.Seconds() == 0 {
 		return 0
 	}
@@ -221,16 +164,6 @@ func (d *dockerProgressDisplay) calculateETA(layer *layerProgress) time.Duration
 	return 0
 }
 
-func shortenDigest(digest string) string {
-	if strings.HasPrefix(digest, "sha256:") && len(digest) >= 19 {
-		return digest[7:19]
-	}
-	if len(digest) > 12 {
-		return digest[:12]
-	}
-	return digest
-}
-
 // DockerProgressHandler creates a progress handler that mimics docker pull output
 func DockerProgressHandler(ctx context.Context, out io.Writer) (transfer.ProgressFunc, func()) {
 	ctx, cancel := context.WithCancel(ctx)
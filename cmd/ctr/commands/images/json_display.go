@@ -0,0 +1,200 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/v2/core/transfer"
+)
+
+// jsonProgressDetail is Docker jsonmessage's progressDetail object.
+type jsonProgressDetail struct {
+	Current int64 `json:"current,omitempty"`
+	Total   int64 `json:"total,omitempty"`
+}
+
+// jsonMessage is one newline-delimited record of the stream
+// JSONProgressHandler writes, matching the subset of Docker's
+// jsonmessage.JSONMessage schema a pull's progress actually needs.
+type jsonMessage struct {
+	ID             string              `json:"id,omitempty"`
+	Status         string              `json:"status,omitempty"`
+	Progress       string              `json:"progress,omitempty"`
+	ProgressDetail *jsonProgressDetail `json:"progressDetail,omitempty"`
+	Error          string              `json:"error,omitempty"`
+	ErrorDetail    *jsonErrorDetail    `json:"errorDetail,omitempty"`
+	Aux            map[string]string   `json:"aux,omitempty"`
+}
+
+type jsonErrorDetail struct {
+	Message string `json:"message,omitempty"`
+}
+
+// statusText maps a layerProgress.status (the same transfer.Progress
+// event strings dockerProgressDisplay.renderLayer switches on) to the
+// human-readable status Docker's own jsonmessage records use.
+func statusText(status string) string {
+	switch status {
+	case "waiting":
+		return "Waiting"
+	case "resolving":
+		return "Pulling fs layer"
+	case "downloading":
+		return "Downloading"
+	case "verifying":
+		return "Verifying Checksum"
+	case "extracting":
+		return "Extracting"
+	case "complete", "done":
+		return "Pull complete"
+	default:
+		return status
+	}
+}
+
+// JSONProgressHandler returns a transfer.ProgressFunc emitting one
+// NDJSON jsonMessage record per update, for API/daemon consumers (CRI,
+// CI systems, buildkit-style clients) that want structured pull
+// progress instead of dockerProgressDisplay's ANSI table. It shares its
+// layer-state tracking with DockerProgressHandler via progressTracker,
+// so the two can watch the same pull side by side without disagreeing
+// about what "done" means for a given layer.
+//
+// Two event strings get special handling rather than flowing through
+// the normal layer tracker: "error" is emitted as an errorDetail record
+// (transfer.Progress has no dedicated error field, so by convention the
+// failure message is carried in Progress.Name), and "digest" is
+// emitted as the terminal {"status":"Digest: ...","aux":{"Digest":...}}
+// record a pull is expected to report once after resolving its image's
+// manifest - again with the digest string itself carried in
+// Progress.Name since Progress has no field of its own for it.
+func JSONProgressHandler(ctx context.Context, out io.Writer) (transfer.ProgressFunc, func()) {
+	_, cancel := context.WithCancel(ctx)
+	tracker := newProgressTracker()
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(out)
+
+	write := func(msg jsonMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Best-effort: a pull's progress stream isn't worth aborting
+		// over a write failure on whatever out is.
+		_ = enc.Encode(msg)
+	}
+
+	progressFunc := func(p transfer.Progress) {
+		switch p.Event {
+		case "error":
+			write(jsonMessage{
+				Status:      fmt.Sprintf("error: %s", p.Name),
+				Error:       p.Name,
+				ErrorDetail: &jsonErrorDetail{Message: p.Name},
+			})
+			return
+		case "digest":
+			write(jsonMessage{
+				Status: fmt.Sprintf("Digest: %s", p.Name),
+				Aux:    map[string]string{"Digest": p.Name},
+			})
+			return
+		}
+
+		layer := tracker.update(p)
+		if layer == nil {
+			return
+		}
+
+		msg := jsonMessage{
+			ID:     layer.id,
+			Status: statusText(layer.status),
+		}
+		if layer.status == "downloading" || layer.status == "extracting" {
+			msg.ProgressDetail = &jsonProgressDetail{Current: layer.current, Total: layer.total}
+			msg.Progress = jsonProgressBar(layer.current, layer.total, 20)
+		}
+		write(msg)
+	}
+
+	done := func() {
+		cancel()
+	}
+
+	return progressFunc, done
+}
+
+// jsonProgressBar renders the ascii bar Docker's jsonmessage puts in
+// its "progress" field, followed by the raw byte counts, e.g.
+// "[=====>    ] 12582912B/31457280B".
+func jsonProgressBar(current, total int64, width int) string {
+	if total <= 0 {
+		return ""
+	}
+
+	filled := int(float64(current) / float64(total) * float64(width))
+	if filled > width {
+		filled = width
+	}
+
+	bar := "["
+	for i := 0; i < width; i++ {
+		switch {
+		case i < filled:
+			bar += "="
+		case i == filled:
+			bar += ">"
+		default:
+			bar += " "
+		}
+	}
+	bar += "]"
+
+	return fmt.Sprintf("%s %dB/%dB", bar, current, total)
+}
+
+// ProgressHandler pairs the transfer.ProgressFunc/done functions every
+// handler in this package returns, so NewMultiHandler can fan out to
+// several of them without each caller re-deriving the pairing.
+type ProgressHandler struct {
+	Func transfer.ProgressFunc
+	Done func()
+}
+
+// NewMultiHandler combines handlers into a single (transfer.ProgressFunc,
+// func()) pair that forwards every update - and the final done() call -
+// to each of them, e.g. to write the docker-style TTY table to stdout
+// and an NDJSON stream to a log file from the same pull.
+func NewMultiHandler(handlers ...ProgressHandler) (transfer.ProgressFunc, func()) {
+	progressFunc := func(p transfer.Progress) {
+		for _, h := range handlers {
+			h.Func(p)
+		}
+	}
+
+	done := func() {
+		for _, h := range handlers {
+			h.Done()
+		}
+	}
+
+	return progressFunc, done
+}
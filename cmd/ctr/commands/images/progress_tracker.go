@@ -0,0 +1,120 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package images
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/transfer"
+)
+
+// layerProgress is one layer's last-known state, shared between
+// whatever renderers are watching a pull - the TTY table
+// dockerProgressDisplay draws and the NDJSON records
+// JSONProgressHandler emits both read the same layerProgress values
+// out of a progressTracker instead of keeping their own copies.
+type layerProgress struct {
+	id       string
+	digest   string
+	status   string
+	current  int64
+	total    int64
+	lastTime time.Time
+}
+
+// progressTracker turns the transfer.Progress events a pull reports
+// into the per-layer state a renderer needs, independent of how that
+// state ends up displayed.
+type progressTracker struct {
+	mu     sync.RWMutex
+	layers map[string]*layerProgress
+	done   map[string]bool
+	start  time.Time
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{
+		layers: make(map[string]*layerProgress),
+		done:   make(map[string]bool),
+		start:  time.Now(),
+	}
+}
+
+// update records p against its layer, creating the entry on first
+// sight, and returns the updated layerProgress - or nil if p carries
+// no descriptor to key a layer on (the terminal digest/error events
+// JSONProgressHandler also has to handle don't necessarily have one).
+func (t *progressTracker) update(p transfer.Progress) *layerProgress {
+	if p.Desc == nil {
+		return nil
+	}
+
+	id := shortenDigest(p.Desc.Digest.String())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	layer, exists := t.layers[id]
+	if !exists {
+		layer = &layerProgress{id: id, digest: p.Desc.Digest.String()}
+		t.layers[id] = layer
+	}
+	layer.status = p.Event
+	layer.current = p.Progress
+	layer.total = p.Total
+	layer.lastTime = time.Now()
+
+	if p.Event == "complete" || p.Event == "done" {
+		t.done[id] = true
+	}
+
+	return layer
+}
+
+// isDone reports whether id's layer has reached a terminal complete/
+// done event.
+func (t *progressTracker) isDone(id string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.done[id]
+}
+
+// snapshot returns every tracked layer, sorted by id for stable
+// rendering order.
+func (t *progressTracker) snapshot() []*layerProgress {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	layers := make([]*layerProgress, 0, len(t.layers))
+	for _, layer := range t.layers {
+		layers = append(layers, layer)
+	}
+	sort.Slice(layers, func(i, j int) bool { return layers[i].id < layers[j].id })
+	return layers
+}
+
+func shortenDigest(digest string) string {
+	if len(digest) >= 19 && digest[:7] == "sha256:" {
+		return digest[7:19]
+	}
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
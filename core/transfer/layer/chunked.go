@@ -0,0 +1,277 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package layer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerd/containerd/v2/core/content"
+)
+
+// DefaultChunkSize is the chunk size MarshalAny frames the outgoing
+// stream with when WithChunkSize isn't used.
+const DefaultChunkSize = 4 << 20 // 4MiB
+
+// chunkHeaderSize is a chunk's fixed-size header: an 8-byte big-endian
+// payload length followed by the payload's SHA-256.
+const chunkHeaderSize = 8 + sha256.Size
+
+// WithChunkSize sets the chunk size MarshalAny frames the outgoing layer
+// stream with, instead of DefaultChunkSize. Smaller chunks mean more
+// frequent durable checkpoints on the receive side (see ChunkedReceiver)
+// at the cost of more per-chunk overhead.
+func WithChunkSize(size int64) StreamOpt {
+	return func(s *Stream) {
+		s.chunkSize = size
+	}
+}
+
+// WithResumeFrom configures s to resume a transfer that previously
+// delivered dgst's content up to offset bytes. On the send side,
+// MarshalAny skips offset bytes of its source before framing and sending
+// the remainder. On the receive side, UnmarshalAny treats offset as
+// already accounted for, so a caller computing a new resumable offset
+// from a chunkReader's Offset (see Stream.Offset) gets a number relative
+// to the whole layer, not just this attempt's remainder. dgst is not
+// itself transmitted - transfertypes.LayerStream is a generated protobuf
+// message this snapshot doesn't vendor the source .proto for, so it
+// can't be extended with a new wire field here - the caller is
+// responsible for only pairing an offset with the Stream it came from.
+func WithResumeFrom(dgst digest.Digest, offset int64) StreamOpt {
+	return func(s *Stream) {
+		s.resumeDigest = dgst
+		s.resumeOffset = offset
+	}
+}
+
+// chunkWriter frames writes to w as a sequence of chunkHeaderSize-prefixed
+// chunks, each at most size bytes of payload, individually hashed so a
+// chunkReader on the other end can verify and durably commit a chunk as
+// soon as it arrives instead of only at the end of the whole layer.
+type chunkWriter struct {
+	w    io.Writer
+	size int
+	buf  []byte
+}
+
+func newChunkWriter(w io.Writer, size int64) *chunkWriter {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	return &chunkWriter{w: w, size: int(size)}
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		room := cw.size - len(cw.buf)
+		n := len(p)
+		if n > room {
+			n = room
+		}
+		cw.buf = append(cw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(cw.buf) == cw.size {
+			if err := cw.flushChunk(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush writes out any buffered, not-yet-full trailing chunk. Must be
+// called once after the last Write, or up to size-1 bytes are lost.
+func (cw *chunkWriter) Flush() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	return cw.flushChunk()
+}
+
+func (cw *chunkWriter) flushChunk() error {
+	sum := sha256.Sum256(cw.buf)
+
+	var header [chunkHeaderSize]byte
+	binary.BigEndian.PutUint64(header[:8], uint64(len(cw.buf)))
+	copy(header[8:], sum[:])
+
+	if _, err := cw.w.Write(header[:]); err != nil {
+		return fmt.Errorf("failed to write chunk header: %w", err)
+	}
+	if _, err := cw.w.Write(cw.buf); err != nil {
+		return fmt.Errorf("failed to write chunk payload: %w", err)
+	}
+	cw.buf = cw.buf[:0]
+	return nil
+}
+
+// chunkReader reads back a stream framed by chunkWriter, verifying each
+// chunk's checksum before handing its payload to the caller, and
+// tracking how many bytes of decoded payload it has delivered so a
+// caller like ChunkedReceiver can report a resumable offset on error.
+type chunkReader struct {
+	r       io.Reader
+	pending []byte
+	offset  int64
+}
+
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{r: r}
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if len(cr.pending) == 0 {
+		if err := cr.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, cr.pending)
+	cr.pending = cr.pending[n:]
+	cr.offset += int64(n)
+	return n, nil
+}
+
+func (cr *chunkReader) nextChunk() error {
+	var header [chunkHeaderSize]byte
+	if _, err := io.ReadFull(cr.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated chunk header: %w", err)
+		}
+		// A clean io.EOF between chunks is the normal end of stream;
+		// propagate it as-is so callers can tell it apart from a failure.
+		return err
+	}
+
+	size := binary.BigEndian.Uint64(header[:8])
+	wantSum := header[8:]
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, payload); err != nil {
+		return fmt.Errorf("truncated chunk payload: %w", err)
+	}
+
+	gotSum := sha256.Sum256(payload)
+	if !bytes.Equal(gotSum[:], wantSum) {
+		return fmt.Errorf("chunk checksum mismatch at offset %d", cr.offset)
+	}
+
+	cr.pending = payload
+	return nil
+}
+
+// Offset reports how many bytes of decoded chunk payload Read has
+// delivered so far.
+func (cr *chunkReader) Offset() int64 {
+	return cr.offset
+}
+
+// Offset reports how far into the layer the last UnmarshalAny's stream
+// has been read, relative to the whole layer rather than just this
+// attempt's remainder: the resumeOffset a prior WithResumeFrom supplied,
+// plus however much of this attempt's own chunk-framed stream has been
+// decoded so far. If s wasn't populated by a chunked UnmarshalAny, it is
+// just resumeOffset (zero unless WithResumeFrom was used).
+// A caller that hits an error reading s's stream can pass this value,
+// with s's descriptor digest, to WithResumeFrom on the next attempt.
+func (s *Stream) Offset() int64 {
+	if s.chunkStream == nil {
+		return s.resumeOffset
+	}
+	return s.resumeOffset + s.chunkStream.Offset()
+}
+
+// ChunkedReceiver decodes a chunk-framed layer stream (see chunkWriter)
+// and writes its payload into a content store via content.Writer,
+// writing each chunk to the writer as soon as it's checksum-verified so
+// a later resume - via the writer's own Status().Offset, the same
+// resumability primitive Destination.seekToResumeOffset relies on - only
+// has to replay whatever wasn't already made durable.
+type ChunkedReceiver struct {
+	content content.Store
+	ref     string
+}
+
+// NewChunkedReceiver creates a ChunkedReceiver writing into cs under ref.
+// A resumed attempt must reuse the same ref so its content.Writer
+// resumes the same ingest instead of starting a new, unrelated one.
+func NewChunkedReceiver(cs content.Store, ref string) *ChunkedReceiver {
+	return &ChunkedReceiver{content: cs, ref: ref}
+}
+
+// Receive decodes r as a chunk-framed stream and writes it into cr's
+// content store against desc. resumeOffset is the offset the caller
+// believes was already committed by a prior attempt (e.g. from
+// Stream.Offset); if the writer's own ingest state disagrees, Receive
+// restarts the write from zero rather than risk misaligned chunks
+// corrupting the blob. It returns the offset successfully committed so
+// far any time it returns a non-nil error, so the caller can retry by
+// reopening the stream with WithResumeFrom(desc.Digest, offset).
+func (cr *ChunkedReceiver) Receive(ctx context.Context, r io.Reader, desc ocispec.Descriptor, resumeOffset int64) (committed int64, err error) {
+	writer, err := content.OpenWriter(ctx, cr.content,
+		content.WithRef(cr.ref),
+		content.WithDescriptor(desc))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open writer: %w", err)
+	}
+	defer writer.Close()
+
+	status, err := writer.Status()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get writer status: %w", err)
+	}
+	committed = status.Offset
+
+	if committed != resumeOffset {
+		if err := writer.Truncate(0); err != nil {
+			return 0, fmt.Errorf("failed to restart write: %w", err)
+		}
+		committed = 0
+	}
+
+	cd := newChunkReader(r)
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := cd.Read(buf)
+		if n > 0 {
+			if _, werr := writer.Write(buf[:n]); werr != nil {
+				return committed, fmt.Errorf("failed to write chunk: %w", werr)
+			}
+			committed += int64(n)
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				break
+			}
+			return committed, fmt.Errorf("failed to read chunk: %w", rerr)
+		}
+	}
+
+	if err := writer.Commit(ctx, desc.Size, desc.Digest); err != nil {
+		return committed, fmt.Errorf("failed to commit layer: %w", err)
+	}
+	return desc.Size, nil
+}
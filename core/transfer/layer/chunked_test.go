@@ -0,0 +1,85 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package layer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestChunkWriterReaderRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("containerd-chunked-stream-"), 10000) // ~260KiB
+
+	var framed bytes.Buffer
+	cw := newChunkWriter(&framed, 4096)
+	if _, err := cw.Write(payload); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := cw.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if framed.Len() <= len(payload) {
+		t.Fatalf("expected framed output to be larger than payload due to chunk headers, got %d vs %d", framed.Len(), len(payload))
+	}
+
+	cr := newChunkReader(bytes.NewReader(framed.Bytes()))
+	got, err := io.ReadAll(cr)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("round-tripped payload does not match original")
+	}
+	if cr.Offset() != int64(len(payload)) {
+		t.Fatalf("offset = %d, want %d", cr.Offset(), len(payload))
+	}
+}
+
+func TestChunkReaderDetectsCorruption(t *testing.T) {
+	var framed bytes.Buffer
+	cw := newChunkWriter(&framed, 4096)
+	if _, err := cw.Write([]byte("hello chunked world")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := cw.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	corrupted := framed.Bytes()
+	corrupted[len(corrupted)-1] ^= 0xff // flip a bit in the payload
+
+	cr := newChunkReader(bytes.NewReader(corrupted))
+	if _, err := io.ReadAll(cr); err == nil {
+		t.Fatal("expected checksum mismatch error, got nil")
+	}
+}
+
+func TestChunkReaderTruncatedHeader(t *testing.T) {
+	cr := newChunkReader(bytes.NewReader([]byte{0x00, 0x01, 0x02}))
+	if _, err := io.ReadAll(cr); err == nil {
+		t.Fatal("expected truncated header error, got nil")
+	}
+}
+
+func TestStreamOffsetBeforeUnmarshal(t *testing.T) {
+	s := NewStream(nil)
+	WithResumeFrom("sha256:deadbeef", 1024)(s)
+	if got := s.Offset(); got != 1024 {
+		t.Fatalf("Offset() = %d, want 1024", got)
+	}
+}
@@ -20,12 +20,29 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strconv"
+	"time"
 
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/containerd/containerd/v2/core/content"
 )
 
+// offsetLabel records the last offset a WriteLayer with a flush interval
+// reached. The value is kept in the Destination's in-memory labels and is
+// only written to the content store as part of Commit's labels; it is not
+// persisted while the write is still in progress, so a process restart
+// does not see it. Resuming relies on the content store's own ingest
+// tracking of writer.Status().Offset, not on this label.
+const offsetLabel = "containerd.io/distribution.offset"
+
+// RangeReader is implemented by a layer source that can re-request its
+// content starting at an arbitrary byte offset, for resuming a write
+// whose underlying reader doesn't support io.Seek (e.g. an HTTP body).
+type RangeReader interface {
+	ReadRange(offset int64) (io.ReadCloser, error)
+}
+
 // DestinationOpt defines options when configuring a layer destination
 type DestinationOpt func(*Destination)
 
@@ -36,6 +53,35 @@ func WithLabels(labels map[string]string) DestinationOpt {
 	}
 }
 
+// WithResume enables resuming a previously interrupted WriteLayer: the
+// writer's existing Status().Offset is used to skip already-written
+// bytes, via r's RangeReader or io.ReadSeeker interface, rather than
+// starting over from zero. If r supports neither, the existing partial
+// write is discarded and the write restarts from zero.
+func WithResume(resume bool) DestinationOpt {
+	return func(d *Destination) {
+		d.resume = resume
+	}
+}
+
+// WithProgress registers a callback invoked as a layer is written, with
+// the bytes written so far and desc.Size.
+func WithProgress(fn func(written, total int64)) DestinationOpt {
+	return func(d *Destination) {
+		d.progress = fn
+	}
+}
+
+// WithFlushInterval sets how often an in-progress write records the
+// offset it has reached in offsetLabel. The recorded value is held in
+// memory and reaches the content store only with Commit. Zero, the
+// default, records no offset.
+func WithFlushInterval(interval time.Duration) DestinationOpt {
+	return func(d *Destination) {
+		d.flushInterval = interval
+	}
+}
+
 // NewDestination creates a new layer destination that writes to a content store
 func NewDestination(cs content.Store, opts ...DestinationOpt) *Destination {
 	d := &Destination{
@@ -51,6 +97,10 @@ func NewDestination(cs content.Store, opts ...DestinationOpt) *Destination {
 type Destination struct {
 	content content.Store
 	labels  map[string]string
+
+	resume        bool
+	progress      func(written, total int64)
+	flushInterval time.Duration
 }
 
 func (d *Destination) String() string {
@@ -61,11 +111,6 @@ func (d *Destination) String() string {
 func (d *Destination) WriteLayer(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error {
 	ref := fmt.Sprintf("layer-write-%s", desc.Digest.String())
 
-	var opts []content.Opt
-	if len(d.labels) > 0 {
-		opts = append(opts, content.WithLabels(d.labels))
-	}
-
 	writer, err := content.OpenWriter(ctx, d.content,
 		content.WithRef(ref),
 		content.WithDescriptor(desc))
@@ -74,9 +119,18 @@ func (d *Destination) WriteLayer(ctx context.Context, desc ocispec.Descriptor, r
 	}
 	defer writer.Close()
 
-	// Copy data to writer
-	if _, err := io.Copy(writer, r); err != nil {
-		return fmt.Errorf("failed to write layer: %w", err)
+	written, err := d.seekToResumeOffset(writer, &r)
+	if err != nil {
+		return err
+	}
+
+	if err := d.copyWithProgress(writer, r, written, desc.Size); err != nil {
+		return err
+	}
+
+	var opts []content.Opt
+	if len(d.labels) > 0 {
+		opts = append(opts, content.WithLabels(d.labels))
 	}
 
 	// Commit the content
@@ -86,3 +140,91 @@ func (d *Destination) WriteLayer(ctx context.Context, desc ocispec.Descriptor, r
 
 	return nil
 }
+
+// seekToResumeOffset returns the offset WriteLayer should resume from,
+// advancing *r (via RangeReader or io.Seek) to match when d.resume is
+// enabled and the writer already has a nonzero offset. If r supports
+// neither interface, the write restarts from zero so previously written
+// bytes can't corrupt the result.
+func (d *Destination) seekToResumeOffset(writer content.Writer, r *io.Reader) (int64, error) {
+	if !d.resume {
+		return 0, nil
+	}
+
+	status, err := writer.Status()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get writer status: %w", err)
+	}
+	if status.Offset <= 0 {
+		return 0, nil
+	}
+
+	switch src := (*r).(type) {
+	case RangeReader:
+		rc, err := src.ReadRange(status.Offset)
+		if err != nil {
+			return 0, fmt.Errorf("failed to resume from offset %d: %w", status.Offset, err)
+		}
+		*r = rc
+		return status.Offset, nil
+	case io.ReadSeeker:
+		if _, err := src.Seek(status.Offset, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("failed to seek to offset %d: %w", status.Offset, err)
+		}
+		return status.Offset, nil
+	default:
+		if err := writer.Truncate(0); err != nil {
+			return 0, fmt.Errorf("failed to restart non-resumable write: %w", err)
+		}
+		return 0, nil
+	}
+}
+
+// copyWithProgress copies r into writer, reporting incremental progress
+// through d.progress and, if d.flushInterval is set, periodically
+// recording the offset reached so far in offsetLabel.
+func (d *Destination) copyWithProgress(writer content.Writer, r io.Reader, written, total int64) error {
+	if d.progress == nil && d.flushInterval <= 0 {
+		if _, err := io.Copy(writer, r); err != nil {
+			return fmt.Errorf("failed to write layer: %w", err)
+		}
+		return nil
+	}
+
+	buf := make([]byte, 32*1024)
+	lastFlush := time.Now()
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if _, werr := writer.Write(buf[:n]); werr != nil {
+				return fmt.Errorf("failed to write layer: %w", werr)
+			}
+			written += int64(n)
+			if d.progress != nil {
+				d.progress(written, total)
+			}
+		}
+
+		if d.flushInterval > 0 && time.Since(lastFlush) >= d.flushInterval {
+			d.recordOffset(written)
+			lastFlush = time.Now()
+		}
+
+		if rerr != nil {
+			if rerr == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read layer: %w", rerr)
+		}
+	}
+}
+
+// recordOffset stashes written into offsetLabel in d.labels, which are
+// applied the next time this Destination commits a write. Nothing is
+// written to the content store by this call itself.
+func (d *Destination) recordOffset(written int64) {
+	if d.labels == nil {
+		d.labels = map[string]string{}
+	}
+	d.labels[offsetLabel] = strconv.FormatInt(written, 10)
+}
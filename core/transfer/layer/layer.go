@@ -22,6 +22,7 @@ import (
 	"io"
 
 	"github.com/containerd/typeurl/v2"
+	digest "github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	transfertypes "github.com/containerd/containerd/api/types/transfer"
@@ -79,6 +80,17 @@ type Stream struct {
 	desc      ocispec.Descriptor
 	mediaType string
 	content   content.Store
+
+	// chunkSize, resumeDigest and resumeOffset are set by WithChunkSize
+	// and WithResumeFrom; see chunked.go.
+	chunkSize    int64
+	resumeDigest digest.Digest
+	resumeOffset int64
+
+	// chunkStream is the chunkReader UnmarshalAny wraps its received
+	// stream in, kept so Stream.Offset can report progress. Nil unless
+	// this Stream was populated by UnmarshalAny.
+	chunkStream *chunkReader
 }
 
 func (s *Stream) String() string {
@@ -114,8 +126,16 @@ func (s *Stream) ImportStream(context.Context) (io.Reader, string, error) {
 	return s.stream, s.mediaType, nil
 }
 
-// MarshalAny marshals the layer stream for transfer over RPC
+// MarshalAny marshals the layer stream for transfer over RPC, framing it
+// as a sequence of chunkSize chunks (see WithChunkSize, chunked.go) each
+// tagged with its own SHA-256 so the receiving UnmarshalAny can verify
+// and durably commit a chunk as soon as it arrives, rather than only the
+// layer as a whole once every byte has crossed the wire.
 func (s *Stream) MarshalAny(ctx context.Context, sm streaming.StreamCreator) (typeurl.Any, error) {
+	if s.resumeOffset > 0 && s.resumeDigest != "" && s.desc.Digest != "" && s.resumeDigest != s.desc.Digest {
+		return nil, fmt.Errorf("resume digest %s does not match stream descriptor %s", s.resumeDigest, s.desc.Digest)
+	}
+
 	sid := tstreaming.GenerateID("layer")
 	stream, err := sm.Create(ctx, sid)
 	if err != nil {
@@ -123,7 +143,28 @@ func (s *Stream) MarshalAny(ctx context.Context, sm streaming.StreamCreator) (ty
 	}
 
 	if s.stream != nil {
-		tstreaming.SendStream(ctx, s.stream, stream)
+		src := s.stream
+		if s.resumeOffset > 0 {
+			if seeker, ok := src.(io.Seeker); ok {
+				if _, err := seeker.Seek(s.resumeOffset, io.SeekStart); err != nil {
+					return nil, fmt.Errorf("failed to seek to resume offset %d: %w", s.resumeOffset, err)
+				}
+			} else if _, err := io.CopyN(io.Discard, src, s.resumeOffset); err != nil {
+				return nil, fmt.Errorf("failed to skip to resume offset %d: %w", s.resumeOffset, err)
+			}
+		}
+
+		pr, pw := io.Pipe()
+		cw := newChunkWriter(pw, s.chunkSize)
+		go func() {
+			_, err := io.Copy(cw, src)
+			if err == nil {
+				err = cw.Flush()
+			}
+			pw.CloseWithError(err)
+		}()
+
+		tstreaming.SendStream(ctx, pr, stream)
 	}
 
 	ls := &transfertypes.LayerStream{
@@ -138,7 +179,19 @@ func (s *Stream) MarshalAny(ctx context.Context, sm streaming.StreamCreator) (ty
 	return typeurl.MarshalAny(ls)
 }
 
-// UnmarshalAny unmarshals the layer stream from RPC
+// UnmarshalAny unmarshals the layer stream from RPC. The received stream
+// is assumed to be chunk-framed the way MarshalAny sends it; s.stream is
+// left as a plain, already-dechunked io.Reader so existing consumers
+// (GetLayer, ImportStream) don't need to know about chunking at all. The
+// raw chunk-framed stream is not kept, so a ChunkedReceiver, which
+// decodes the framing itself, cannot be pointed at what this call
+// retrieved.
+//
+// If this Stream was configured with WithResumeFrom before this call,
+// the offset it names is assumed to already be accounted for - e.g. the
+// sender's MarshalAny skipped those bytes of its source - so
+// Stream.Offset reports resumeOffset plus whatever this attempt itself
+// decodes, not just this attempt's own progress.
 func (s *Stream) UnmarshalAny(ctx context.Context, sm streaming.StreamGetter, a typeurl.Any) error {
 	var ls transfertypes.LayerStream
 	if err := typeurl.UnmarshalTo(a, &ls); err != nil {
@@ -151,11 +204,13 @@ func (s *Stream) UnmarshalAny(ctx context.Context, sm streaming.StreamGetter, a
 		return err
 	}
 
-	s.stream = tstreaming.ReceiveStream(ctx, stream)
+	cr := newChunkReader(tstreaming.ReceiveStream(ctx, stream))
+	s.chunkStream = cr
+	s.stream = cr
 	if ls.Desc != nil {
 		s.desc = ocispec.Descriptor{
 			MediaType: ls.Desc.MediaType,
-			Digest:    ls.Desc.Digest,
+			Digest:    digest.Digest(ls.Desc.Digest),
 			Size:      ls.Desc.Size_,
 		}
 	}
@@ -175,6 +230,14 @@ func (r *readerAtWrapper) Read(p []byte) (n int, err error) {
 	return
 }
 
+// ReadAt lets a caller that already knows an offset - e.g. the eStargz
+// footer probe in core/transfer/local, seeking to the tail of the layer
+// by its descriptor size - read directly without disturbing the sequential
+// offset Read tracks.
+func (r *readerAtWrapper) ReadAt(p []byte, off int64) (int, error) {
+	return r.ra.ReadAt(p, off)
+}
+
 func (r *readerAtWrapper) Close() error {
 	return r.ra.Close()
 }
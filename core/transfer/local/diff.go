@@ -21,13 +21,36 @@ import (
 	"fmt"
 	"io"
 
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
 	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/diff"
+	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/transfer"
 	"github.com/containerd/log"
 )
 
+// comparerSource is implemented by a SnapshotSource that already knows
+// which diff.Comparer to use (snapshot.Source, via snapshot.WithComparer).
+type comparerSource interface {
+	Comparer() diff.Comparer
+}
+
+// TOCAwareComparer is an optional interface a diff.Comparer can implement
+// to short-circuit diffSnapshot's normal Compare path for a snapshot
+// backed by a TOC-indexed lazy layer (stargz/eStargz, see estargz.go's
+// probeEstargz): rather than walking upper/lower mounts and rehashing
+// file content that was never pulled in full, CompareTOC returns the
+// upper snapshot's already-sealed layer descriptor and a reader sourced
+// straight from the snapshotter's content-addressable layer cache. A
+// snapshot that was unpacked the ordinary way, or whose snapshotter
+// doesn't keep such a cache, returns ok == false and diffSnapshot falls
+// back to Compare exactly as before.
+type TOCAwareComparer interface {
+	CompareTOC(ctx context.Context, upper []mount.Mount) (desc ocispec.Descriptor, rc io.ReadCloser, ok bool, err error)
+}
+
 // diffSnapshot implements SnapshotSource -> LayerDestination transfer
 // This creates a diff from a snapshot and writes it as a layer
 func (ts *localTransferService) diffSnapshot(
@@ -65,17 +88,56 @@ func (ts *localTransferService) diffSnapshot(
 		"lower_mounts": len(lower),
 	}).Debug("creating diff")
 
-	// Get comparer for the snapshotter
-	comparer := ts.getComparer(src.GetSnapshotter())
+	// Prefer a comparer the source already carries (snapshot.WithComparer)
+	// over the snapshotter->Comparer mapping below, so a caller doesn't
+	// have to configure UnpackPlatforms just to make this dispatch work.
+	var comparer diff.Comparer
+	if cs, ok := src.(comparerSource); ok {
+		comparer = cs.Comparer()
+	}
+	if comparer == nil {
+		comparer = ts.getComparer(src.GetSnapshotter())
+	}
 	if comparer == nil {
 		return fmt.Errorf("no comparer available for snapshotter")
 	}
 
-	// Create diff
-	var diffOpts []diff.Opt
-	desc, err := comparer.Compare(ctx, lower, upper, diffOpts...)
-	if err != nil {
-		return fmt.Errorf("failed to compare: %w", err)
+	// A TOC-aware comparer can hand back a layer it already has sealed in
+	// its content-addressable cache - e.g. the base layers of an image
+	// that was lazy-pulled via stargz/eStargz and never fully applied -
+	// without walking upper/lower mounts or rehashing file content. Skip
+	// straight to writing that out; only fall back to the normal
+	// Compare/ReaderAt path below if it declines.
+	var (
+		desc ocispec.Descriptor
+		r    io.ReadCloser
+	)
+	if tc, ok := comparer.(TOCAwareComparer); ok {
+		tocDesc, tocRC, tocOK, err := tc.CompareTOC(ctx, upper)
+		if err != nil {
+			return fmt.Errorf("failed to compare toc: %w", err)
+		}
+		if tocOK {
+			desc, r = tocDesc, tocRC
+		}
+	}
+
+	if r == nil {
+		var diffOpts []diff.Opt
+		desc, err = comparer.Compare(ctx, lower, upper, diffOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to compare: %w", err)
+		}
+
+		// Read diff content
+		ra, err := ts.content.ReaderAt(ctx, desc)
+		if err != nil {
+			return fmt.Errorf("failed to read diff: %w", err)
+		}
+		defer ra.Close()
+
+		// Create a reader from ReaderAt
+		r = &readerAtWrapper{ra: ra}
 	}
 
 	log.G(ctx).WithFields(log.Fields{
@@ -91,20 +153,14 @@ func (ts *localTransferService) diffSnapshot(
 		})
 	}
 
-	// Read diff content
-	ra, err := ts.content.ReaderAt(ctx, desc)
-	if err != nil {
-		return fmt.Errorf("failed to read diff: %w", err)
-	}
-	defer ra.Close()
-
-	// Create a reader from ReaderAt
-	r := &readerAtWrapper{ra: ra}
-
 	// Write to destination
 	if err := dest.WriteLayer(ctx, desc, r); err != nil {
+		r.Close()
 		return fmt.Errorf("failed to write layer: %w", err)
 	}
+	if err := r.Close(); err != nil {
+		return fmt.Errorf("failed to close layer reader: %w", err)
+	}
 
 	if tops.Progress != nil {
 		tops.Progress(transfer.Progress{
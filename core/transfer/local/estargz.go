@@ -0,0 +1,250 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package local
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/transfer"
+)
+
+const (
+	// estargzFooterSize is the fixed size, in bytes, of the gzip member
+	// eStargz appends to the end of a layer blob to record the byte
+	// offset of its table-of-contents gzip stream. See
+	// github.com/containerd/stargz-snapshotter/estargz's Writer.Close -
+	// this package doesn't depend on that module, since probing only
+	// needs to parse the footer's handful of fixed fields back out.
+	estargzFooterSize = 51
+
+	// estargzTOCDigestAnnotation is set on a layer descriptor with the
+	// digest of its TOC (stargz.index.json), matching the annotation key
+	// github.com/containerd/stargz-snapshotter/estargz uses.
+	estargzTOCDigestAnnotation = "containerd.io/snapshot/stargz/toc.digest"
+)
+
+// estargzProbe is the result of finding a valid eStargz footer and TOC at
+// the end of a layer blob.
+type estargzProbe struct {
+	tocDigest digest.Digest
+}
+
+// estargzProbeCache remembers probeEstargz's result by layer digest, so
+// unpacking the same layer into a second platform or a second time doesn't
+// re-read and re-hash its TOC.
+var (
+	estargzProbeCacheMu sync.Mutex
+	estargzProbeCache   = map[digest.Digest]*estargzProbe{}
+)
+
+// remoteSnapshotPreparer is implemented by a SnapshotDestination that can
+// mount a layer directly from its origin instead of requiring the layer to
+// be applied into it first - the "remote snapshotter" pattern eStargz-aware
+// snapshotters use. *snapshot.Destination implements this via
+// PrepareRemoteSnapshot. A destination that doesn't implement it is
+// treated as not advertising remote-snapshot support, and unpackLayer
+// falls back to its ordinary apply path.
+type remoteSnapshotPreparer interface {
+	PrepareRemoteSnapshot(ctx context.Context, key, reference string, desc ocispec.Descriptor) ([]mount.Mount, error)
+}
+
+// referenceSource is implemented by a LayerSource that knows the registry
+// reference its layer came from. Remote mounting needs this to tell the
+// destination snapshotter where to chunk-fetch from; a LayerSource that
+// doesn't implement it (e.g. layer.NewStream over a bare io.Reader, with
+// no associated registry) can never take the eStargz fast path.
+type referenceSource interface {
+	Reference() string
+}
+
+// probeEstargz checks whether desc's layer, read through ra, is an eStargz
+// blob: a "+gzip" media type, a parseable footer, and a TOC digest
+// matching desc's estargzTOCDigestAnnotation. It returns (nil, nil) - not
+// an error - for anything that isn't: a non-gzip media type, a too-short
+// or malformed footer, or a missing/mismatched TOC digest. Only a genuine
+// I/O error reading through ra is returned as an error.
+//
+// Results are cached by desc.Digest (see estargzProbeCache), so this only
+// does the ReadAt/gzip/hash work once per layer.
+func probeEstargz(desc ocispec.Descriptor, ra io.ReaderAt) (*estargzProbe, error) {
+	if !strings.HasSuffix(desc.MediaType, "+gzip") {
+		return nil, nil
+	}
+	wantDigest := desc.Annotations[estargzTOCDigestAnnotation]
+	if wantDigest == "" {
+		return nil, nil
+	}
+	tocDigest, err := digest.Parse(wantDigest)
+	if err != nil {
+		return nil, nil
+	}
+
+	estargzProbeCacheMu.Lock()
+	if p, ok := estargzProbeCache[desc.Digest]; ok {
+		estargzProbeCacheMu.Unlock()
+		return p, nil
+	}
+	estargzProbeCacheMu.Unlock()
+
+	p, err := readEstargzFooter(desc, ra, tocDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	estargzProbeCacheMu.Lock()
+	estargzProbeCache[desc.Digest] = p
+	estargzProbeCacheMu.Unlock()
+	return p, nil
+}
+
+// readEstargzFooter does the actual footer parse and TOC digest check
+// probeEstargz caches the result of.
+func readEstargzFooter(desc ocispec.Descriptor, ra io.ReaderAt, tocDigest digest.Digest) (*estargzProbe, error) {
+	if desc.Size < estargzFooterSize {
+		return nil, nil
+	}
+
+	footer := make([]byte, estargzFooterSize)
+	if _, err := ra.ReadAt(footer, desc.Size-estargzFooterSize); err != nil {
+		return nil, fmt.Errorf("failed to read estargz footer: %w", err)
+	}
+
+	// A minimal gzip member: 2-byte magic, CM, FLG, 4-byte MTIME, XFL, OS,
+	// then - since FLG has FEXTRA set - a 2-byte little-endian XLEN and
+	// XLEN bytes of extra data.
+	if footer[0] != 0x1f || footer[1] != 0x8b || footer[3]&0x04 == 0 {
+		return nil, nil
+	}
+	xlen := int(binary.LittleEndian.Uint16(footer[10:12]))
+	if xlen > len(footer)-12 {
+		return nil, nil
+	}
+	extra := footer[12 : 12+xlen]
+
+	// The extra field is a single gzip subfield: a 2-byte "SG" subfield
+	// ID, a 2-byte little-endian length, then that many bytes of payload
+	// - a 16-char hex TOC offset followed by a literal "STARGZ" marker.
+	if len(extra) < 4 || extra[0] != 'S' || extra[1] != 'G' {
+		return nil, nil
+	}
+	subLen := int(binary.LittleEndian.Uint16(extra[2:4]))
+	payload := extra[4:]
+	if subLen != len(payload) || len(payload) < 16+6 || string(payload[len(payload)-6:]) != "STARGZ" {
+		return nil, nil
+	}
+	tocOffset, err := strconv.ParseInt(string(payload[:16]), 16, 64)
+	if err != nil {
+		return nil, nil
+	}
+
+	tocSize := desc.Size - estargzFooterSize - tocOffset
+	if tocOffset < 0 || tocSize <= 0 {
+		return nil, nil
+	}
+
+	tocGzip := make([]byte, tocSize)
+	if _, err := ra.ReadAt(tocGzip, tocOffset); err != nil {
+		return nil, fmt.Errorf("failed to read estargz toc: %w", err)
+	}
+	zr, err := gzip.NewReader(strings.NewReader(string(tocGzip)))
+	if err != nil {
+		return nil, nil
+	}
+	// The TOC gzip stream is itself a one-entry tar archive, its single
+	// entry named "stargz.index.json", wrapping the raw TOC JSON bytes
+	// that estargzTOCDigestAnnotation's digest was computed over.
+	tr := tar.NewReader(zr)
+	if _, err := tr.Next(); err != nil {
+		return nil, nil
+	}
+	toc, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, nil
+	}
+
+	if digest.FromBytes(toc) != tocDigest {
+		return nil, nil
+	}
+
+	return &estargzProbe{tocDigest: tocDigest}, nil
+}
+
+// tryRemoteUnpack attempts the eStargz lazy-pull fast path for desc: if
+// dest advertises remote-snapshot support, src knows the reference its
+// layer came from, rc is backed by random access, and desc probes as a
+// valid eStargz layer, it asks dest to mount key directly from that
+// reference and returns its mounts with ok set. Otherwise it returns
+// ok == false so the caller falls back to its ordinary prepare-and-apply
+// path; a non-nil error here only ever comes from an actual I/O failure
+// reading the footer/TOC, or from PrepareRemoteSnapshot itself, both of
+// which the caller treats as a reason to fall back rather than fail the
+// whole unpack.
+func tryRemoteUnpack(
+	ctx context.Context,
+	src transfer.LayerSource,
+	dest transfer.SnapshotDestination,
+	tops *transfer.Config,
+	key string,
+	desc ocispec.Descriptor,
+	rc io.ReadCloser,
+) (mounts []mount.Mount, ok bool, err error) {
+	if tops.ForceClassicUnpack {
+		return nil, false, nil
+	}
+	rsp, ok := dest.(remoteSnapshotPreparer)
+	if !ok {
+		return nil, false, nil
+	}
+	refSrc, ok := src.(referenceSource)
+	if !ok {
+		return nil, false, nil
+	}
+	reference := refSrc.Reference()
+	if reference == "" {
+		return nil, false, nil
+	}
+	ra, ok := rc.(io.ReaderAt)
+	if !ok {
+		return nil, false, nil
+	}
+
+	probe, err := probeEstargz(desc, ra)
+	if err != nil {
+		return nil, false, err
+	}
+	if probe == nil {
+		return nil, false, nil
+	}
+
+	mounts, err = rsp.PrepareRemoteSnapshot(ctx, key, reference, desc)
+	if err != nil {
+		return nil, false, err
+	}
+	return mounts, true, nil
+}
@@ -0,0 +1,366 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package local
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/snapshots"
+	"github.com/containerd/containerd/v2/core/transfer"
+)
+
+// buildEstargzFixture returns an eStargz-shaped blob - an arbitrary
+// "layer" payload, a tar-wrapped TOC JSON compressed as its own gzip
+// member, and the 51-byte footer pointing at it - along with the digest
+// of the raw TOC JSON the footer's offset resolves to, the same value a
+// real stargz.index.json annotation would carry. It depends only on the
+// standard library, not on an actual eStargz encoder.
+func buildEstargzFixture(t *testing.T, layerPayload, tocJSON []byte) (blob []byte, tocDigest digest.Digest) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	buf.Write(layerPayload)
+	tocOffset := int64(buf.Len())
+
+	var tocBody bytes.Buffer
+	tw := tar.NewWriter(&tocBody)
+	if err := tw.WriteHeader(&tar.Header{Name: "stargz.index.json", Size: int64(len(tocJSON))}); err != nil {
+		t.Fatalf("tar header: %v", err)
+	}
+	if _, err := tw.Write(tocJSON); err != nil {
+		t.Fatalf("tar write: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar close: %v", err)
+	}
+
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(tocBody.Bytes()); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+
+	buf.Write(estargzFooter(t, tocOffset))
+
+	return buf.Bytes(), digest.FromBytes(tocJSON)
+}
+
+// estargzFooter builds the fixed 51-byte gzip-member footer eStargz
+// appends to a blob, encoding tocOffset the same way
+// github.com/containerd/stargz-snapshotter/estargz's Writer.Close does:
+// an empty-content gzip member whose Extra field is a single "SG"
+// subfield wrapping a 16-char hex offset and a literal "STARGZ" marker.
+func estargzFooter(t *testing.T, tocOffset int64) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, gzip.NoCompression)
+	if err != nil {
+		t.Fatalf("gzip writer: %v", err)
+	}
+	payload := []byte(fmt.Sprintf("%016x", tocOffset) + "STARGZ")
+	extra := make([]byte, 4+len(payload))
+	extra[0], extra[1] = 'S', 'G'
+	binary.LittleEndian.PutUint16(extra[2:4], uint16(len(payload)))
+	copy(extra[4:], payload)
+	gw.Header.Extra = extra
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	if buf.Len() != estargzFooterSize {
+		t.Fatalf("built a %d-byte footer, want %d", buf.Len(), estargzFooterSize)
+	}
+	return buf.Bytes()
+}
+
+func TestProbeEstargzValid(t *testing.T) {
+	blob, tocDigest := buildEstargzFixture(t, []byte("pretend tar+gzip layer body"), []byte(`{"version":1}`))
+	desc := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Digest:      "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		Size:        int64(len(blob)),
+		Annotations: map[string]string{estargzTOCDigestAnnotation: tocDigest.String()},
+	}
+
+	probe, err := probeEstargz(desc, bytes.NewReader(blob))
+	if err != nil {
+		t.Fatalf("probeEstargz: %v", err)
+	}
+	if probe == nil {
+		t.Fatal("expected a valid estargz probe, got nil")
+	}
+	if probe.tocDigest != tocDigest {
+		t.Errorf("tocDigest = %s, want %s", probe.tocDigest, tocDigest)
+	}
+}
+
+func TestProbeEstargzRejectsMismatchedDigest(t *testing.T) {
+	blob, _ := buildEstargzFixture(t, []byte("layer body"), []byte(`{"version":1}`))
+	desc := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Digest:      "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		Size:        int64(len(blob)),
+		Annotations: map[string]string{estargzTOCDigestAnnotation: digest.FromBytes([]byte("wrong")).String()},
+	}
+
+	probe, err := probeEstargz(desc, bytes.NewReader(blob))
+	if err != nil {
+		t.Fatalf("probeEstargz: %v", err)
+	}
+	if probe != nil {
+		t.Fatal("expected nil probe for a mismatched TOC digest")
+	}
+}
+
+func TestProbeEstargzRejectsNonGzipMediaType(t *testing.T) {
+	blob, tocDigest := buildEstargzFixture(t, []byte("layer body"), []byte(`{"version":1}`))
+	desc := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayer,
+		Digest:      "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+		Size:        int64(len(blob)),
+		Annotations: map[string]string{estargzTOCDigestAnnotation: tocDigest.String()},
+	}
+
+	probe, err := probeEstargz(desc, bytes.NewReader(blob))
+	if err != nil {
+		t.Fatalf("probeEstargz: %v", err)
+	}
+	if probe != nil {
+		t.Fatal("expected nil probe for a non-gzip media type")
+	}
+}
+
+func TestProbeEstargzRejectsPlainGzipLayer(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write([]byte("just an ordinary tar+gzip layer, no footer")); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	blob := buf.Bytes()
+
+	desc := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Digest:      "sha256:4444444444444444444444444444444444444444444444444444444444444444",
+		Size:        int64(len(blob)),
+		Annotations: map[string]string{estargzTOCDigestAnnotation: digest.FromBytes([]byte("anything")).String()},
+	}
+
+	probe, err := probeEstargz(desc, bytes.NewReader(blob))
+	if err != nil {
+		t.Fatalf("probeEstargz: %v", err)
+	}
+	if probe != nil {
+		t.Fatal("expected nil probe for a plain gzip layer with no eStargz footer")
+	}
+}
+
+func TestProbeEstargzCachesByDigest(t *testing.T) {
+	blob, tocDigest := buildEstargzFixture(t, []byte("layer body"), []byte(`{"version":1}`))
+	desc := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Digest:      "sha256:5555555555555555555555555555555555555555555555555555555555555555",
+		Size:        int64(len(blob)),
+		Annotations: map[string]string{estargzTOCDigestAnnotation: tocDigest.String()},
+	}
+
+	if _, err := probeEstargz(desc, bytes.NewReader(blob)); err != nil {
+		t.Fatalf("first probeEstargz: %v", err)
+	}
+
+	// A second probe of the same digest must come back from cache
+	// without reading ra again.
+	probe, err := probeEstargz(desc, panicReaderAt{t})
+	if err != nil {
+		t.Fatalf("cached probeEstargz: %v", err)
+	}
+	if probe == nil {
+		t.Fatal("expected the cached probe, got nil")
+	}
+}
+
+type panicReaderAt struct{ t *testing.T }
+
+func (p panicReaderAt) ReadAt([]byte, int64) (int, error) {
+	p.t.Fatal("probeEstargz should not re-read a cached digest")
+	return 0, nil
+}
+
+// fakeLayerSource is a transfer.LayerSource that optionally implements
+// referenceSource, for exercising tryRemoteUnpack without a real content
+// store or resolver.
+type fakeLayerSource struct {
+	desc      ocispec.Descriptor
+	blob      []byte
+	reference string
+}
+
+func (f *fakeLayerSource) GetLayer(context.Context) (ocispec.Descriptor, io.ReadCloser, error) {
+	return f.desc, &readAtCloser{bytes.NewReader(f.blob)}, nil
+}
+
+func (f *fakeLayerSource) Reference() string {
+	return f.reference
+}
+
+// readAtCloser adapts a bytes.Reader into the io.ReadCloser GetLayer must
+// return while still exposing ReadAt, the way layer.Stream's
+// content-store-backed reader does.
+type readAtCloser struct {
+	*bytes.Reader
+}
+
+func (readAtCloser) Close() error { return nil }
+
+// fakeSnapshotDestination is a transfer.SnapshotDestination that
+// optionally implements remoteSnapshotPreparer, and records which of its
+// methods were called.
+type fakeSnapshotDestination struct {
+	remoteCalled  bool
+	prepareCalled bool
+	remoteErr     error
+}
+
+func (f *fakeSnapshotDestination) PrepareSnapshot(context.Context, string, string) ([]mount.Mount, error) {
+	f.prepareCalled = true
+	return nil, nil
+}
+
+func (f *fakeSnapshotDestination) CommitSnapshot(context.Context, string, string, ...snapshots.Opt) error {
+	return nil
+}
+
+func (f *fakeSnapshotDestination) GetSnapshotter() snapshots.Snapshotter {
+	return nil
+}
+
+func (f *fakeSnapshotDestination) PrepareRemoteSnapshot(ctx context.Context, key, reference string, desc ocispec.Descriptor) ([]mount.Mount, error) {
+	f.remoteCalled = true
+	if f.remoteErr != nil {
+		return nil, f.remoteErr
+	}
+	return []mount.Mount{{Type: "remote-stargz", Source: reference}}, nil
+}
+
+func TestTryRemoteUnpackTakesLabeledPath(t *testing.T) {
+	blob, tocDigest := buildEstargzFixture(t, []byte("layer body"), []byte(`{"version":1}`))
+	desc := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Digest:      "sha256:6666666666666666666666666666666666666666666666666666666666666666",
+		Size:        int64(len(blob)),
+		Annotations: map[string]string{estargzTOCDigestAnnotation: tocDigest.String()},
+	}
+	src := &fakeLayerSource{desc: desc, blob: blob, reference: "registry.example.com/foo:latest"}
+	dest := &fakeSnapshotDestination{}
+	rc := &readAtCloser{bytes.NewReader(blob)}
+
+	mounts, ok, err := tryRemoteUnpack(context.Background(), src, dest, &transfer.Config{}, "extract-key", desc, rc)
+	if err != nil {
+		t.Fatalf("tryRemoteUnpack: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected the remote path to be taken")
+	}
+	if !dest.remoteCalled {
+		t.Error("expected PrepareRemoteSnapshot to be called")
+	}
+	if dest.prepareCalled {
+		t.Error("PrepareSnapshot should not be called on the remote path")
+	}
+	if len(mounts) != 1 || mounts[0].Source != src.reference {
+		t.Errorf("unexpected mounts: %+v", mounts)
+	}
+}
+
+func TestTryRemoteUnpackFallsBackWithoutRemoteSupport(t *testing.T) {
+	blob, tocDigest := buildEstargzFixture(t, []byte("layer body"), []byte(`{"version":1}`))
+	desc := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Digest:      "sha256:7777777777777777777777777777777777777777777777777777777777777777",
+		Size:        int64(len(blob)),
+		Annotations: map[string]string{estargzTOCDigestAnnotation: tocDigest.String()},
+	}
+	src := &fakeLayerSource{desc: desc, blob: blob, reference: "registry.example.com/foo:latest"}
+
+	// plainDestination implements only transfer.SnapshotDestination's
+	// bare methods, not remoteSnapshotPreparer.
+	var dest transfer.SnapshotDestination = plainDestination{}
+	rc := &readAtCloser{bytes.NewReader(blob)}
+
+	_, ok, err := tryRemoteUnpack(context.Background(), src, dest, &transfer.Config{}, "extract-key", desc, rc)
+	if err != nil {
+		t.Fatalf("tryRemoteUnpack: %v", err)
+	}
+	if ok {
+		t.Fatal("expected to fall back when the destination doesn't advertise remote-snapshot support")
+	}
+}
+
+func TestTryRemoteUnpackRespectsForceClassicUnpack(t *testing.T) {
+	blob, tocDigest := buildEstargzFixture(t, []byte("layer body"), []byte(`{"version":1}`))
+	desc := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageLayerGzip,
+		Digest:      "sha256:8888888888888888888888888888888888888888888888888888888888888888",
+		Size:        int64(len(blob)),
+		Annotations: map[string]string{estargzTOCDigestAnnotation: tocDigest.String()},
+	}
+	src := &fakeLayerSource{desc: desc, blob: blob, reference: "registry.example.com/foo:latest"}
+	dest := &fakeSnapshotDestination{}
+	rc := &readAtCloser{bytes.NewReader(blob)}
+
+	_, ok, err := tryRemoteUnpack(context.Background(), src, dest, &transfer.Config{ForceClassicUnpack: true}, "extract-key", desc, rc)
+	if err != nil {
+		t.Fatalf("tryRemoteUnpack: %v", err)
+	}
+	if ok {
+		t.Fatal("expected ForceClassicUnpack to skip the remote path")
+	}
+	if dest.remoteCalled {
+		t.Error("PrepareRemoteSnapshot should not be called when ForceClassicUnpack is set")
+	}
+}
+
+// plainDestination is a transfer.SnapshotDestination that does not
+// implement remoteSnapshotPreparer.
+type plainDestination struct{}
+
+func (plainDestination) PrepareSnapshot(context.Context, string, string) ([]mount.Mount, error) {
+	return nil, nil
+}
+
+func (plainDestination) CommitSnapshot(context.Context, string, string, ...snapshots.Opt) error {
+	return nil
+}
+
+func (plainDestination) GetSnapshotter() snapshots.Snapshotter {
+	return nil
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/containerd/containerd/v2/core/diff"
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/transfer"
+	"github.com/containerd/containerd/v2/core/transfer/snapshot"
 	"github.com/containerd/log"
 )
 
@@ -70,43 +71,81 @@ func (ts *localTransferService) unpackLayer(
 
 	// Prepare snapshot
 	key := fmt.Sprintf("extract-%s", desc.Digest.String())
-	mounts, err := dest.PrepareSnapshot(ctx, key, "")
-	if err != nil {
-		return fmt.Errorf("failed to prepare snapshot: %w", err)
-	}
 
-	// Get applier for the snapshotter
-	applier := ts.getApplier(dest.GetSnapshotter())
-	if applier == nil {
-		return fmt.Errorf("no applier available for snapshotter")
+	mounts, remote, err := tryRemoteUnpack(ctx, src, dest, tops, key, desc, rc)
+	if err != nil {
+		log.G(ctx).WithError(err).Debug("eStargz remote mount failed, falling back to local apply")
+		remote = false
 	}
 
-	// Apply layer to snapshot
-	var applyOpts []diff.ApplyOpt
-	if tops.Progress != nil {
-		applyOpts = append(applyOpts, diff.WithProgress(func(d ocispec.Descriptor, state int64) {
+	if remote {
+		log.G(ctx).WithField("digest", desc.Digest).Debug("prepared remote stargz snapshot")
+		if tops.Progress != nil {
 			tops.Progress(transfer.Progress{
-				Event:    "extracting",
-				Progress: state,
-				Total:    d.Size,
-				Desc:     &d,
+				Event: "unpacked remote stargz layer",
+				Desc:  &desc,
 			})
-		}))
-	}
+		}
+	} else {
+		mounts, err = dest.PrepareSnapshot(ctx, key, "")
+		if err != nil {
+			return fmt.Errorf("failed to prepare snapshot: %w", err)
+		}
 
-	applied, err := applier.Apply(ctx, desc, mounts, applyOpts...)
-	if err != nil {
-		return fmt.Errorf("failed to apply layer: %w", err)
-	}
+		// Get applier for the snapshotter
+		applier := ts.getApplier(dest.GetSnapshotter())
+		if applier == nil {
+			return fmt.Errorf("no applier available for snapshotter")
+		}
 
-	log.G(ctx).WithFields(log.Fields{
-		"digest": applied.Digest,
-		"size":   applied.Size,
-	}).Debug("applied layer")
+		// Apply layer to snapshot
+		var applyOpts []diff.ApplyOpt
+		if tops.Progress != nil {
+			applyOpts = append(applyOpts, diff.WithProgress(func(d ocispec.Descriptor, state int64) {
+				tops.Progress(transfer.Progress{
+					Event:    "extracting",
+					Progress: state,
+					Total:    d.Size,
+					Desc:     &d,
+				})
+			}))
+		}
+
+		applied, err := applier.Apply(ctx, desc, mounts, applyOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to apply layer: %w", err)
+		}
+
+		log.G(ctx).WithFields(log.Fields{
+			"digest": applied.Digest,
+			"size":   applied.Size,
+		}).Debug("applied layer")
+
+		if verityDest, ok := dest.(*snapshot.Destination); ok {
+			rootHash, blocks, err := verityDest.FormatVerity(ctx, mounts, desc.Annotations[snapshot.ExpectedRootHashAnnotation])
+			if err != nil {
+				return fmt.Errorf("failed to format dm-verity device: %w", err)
+			}
+			if rootHash != "" {
+				log.G(ctx).WithFields(log.Fields{
+					"digest":    desc.Digest,
+					"root_hash": rootHash,
+					"blocks":    blocks,
+				}).Debug("formatted dm-verity device")
+
+				if tops.Progress != nil {
+					tops.Progress(transfer.Progress{
+						Event:    "verity-format",
+						Progress: int64(blocks),
+						Desc:     &desc,
+					})
+				}
+			}
+		}
+	}
 
 	// Commit snapshot
-	var commitOpts []snapshots.Opt
-	if err := dest.CommitSnapshot(ctx, key, key, commitOpts...); err != nil {
+	if err := dest.CommitSnapshot(ctx, key, key); err != nil {
 		return fmt.Errorf("failed to commit snapshot: %w", err)
 	}
 
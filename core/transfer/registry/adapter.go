@@ -0,0 +1,122 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerd/containerd/v2/core/transfer"
+)
+
+// transferAdapterAnnotation is a manifest annotation a registry can set to
+// advertise which named transfer adapters it supports for a descriptor,
+// as a comma-separated list, e.g. "basic,oci-chunked,p2p-dragonfly". Its
+// absence means the registry only understands plain HTTP fetches.
+const transferAdapterAnnotation = "org.opencontainers.transfer.adapters"
+
+// basicTransferAdapterName is always implicitly supported: it's the
+// resolver's own HTTP Fetcher, not a registered TransferAdapter.
+const basicTransferAdapterName = "basic"
+
+// TransferAdapter is an alternative way of retrieving the content for a
+// descriptor - a P2P swarm, a content-addressed cache, a chunked transfer
+// protocol - that an OCIRegistry can dispatch to per-descriptor instead
+// of always fetching over plain HTTP, once client and server agree it's
+// supported.
+type TransferAdapter interface {
+	// Name identifies this adapter in WithTransferAdapters and in the
+	// registry's advertised adapter list, e.g. "oci-chunked".
+	Name() string
+	// CanFetch reports whether this adapter can serve desc. An adapter
+	// may decline descriptors it doesn't recognize (e.g. a P2P adapter
+	// that only handles layer blobs, not manifests).
+	CanFetch(desc ocispec.Descriptor) bool
+	// Fetch retrieves desc's content.
+	Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error)
+}
+
+var (
+	transferAdaptersMu sync.Mutex
+	transferAdapters   = map[string]TransferAdapter{}
+)
+
+// RegisterTransferAdapter makes adapter available to WithTransferAdapters
+// by name. Registering a second adapter under a name already in use
+// replaces the first.
+func RegisterTransferAdapter(adapter TransferAdapter) {
+	transferAdaptersMu.Lock()
+	defer transferAdaptersMu.Unlock()
+
+	transferAdapters[adapter.Name()] = adapter
+}
+
+// negotiateTransferAdapters intersects preferred (the client's
+// WithTransferAdapters list, in preference order) with both the locally
+// registered adapters and the names desc's annotation advertises the
+// registry as supporting, returning the matches as TransferAdapters in
+// the client's preferred order. "basic" is never included here; the
+// caller falls back to it when the result is empty.
+func negotiateTransferAdapters(preferred []string, desc ocispec.Descriptor) []TransferAdapter {
+	serverSupported := serverTransferAdapters(desc)
+
+	var negotiated []TransferAdapter
+	for _, name := range preferred {
+		if name == basicTransferAdapterName || !serverSupported[name] {
+			continue
+		}
+
+		transferAdaptersMu.Lock()
+		adapter, ok := transferAdapters[name]
+		transferAdaptersMu.Unlock()
+		if ok {
+			negotiated = append(negotiated, adapter)
+		}
+	}
+	return negotiated
+}
+
+// serverTransferAdapters parses the transferAdapterAnnotation off desc.
+func serverTransferAdapters(desc ocispec.Descriptor) map[string]bool {
+	supported := map[string]bool{}
+	for _, name := range strings.Split(desc.Annotations[transferAdapterAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			supported[name] = true
+		}
+	}
+	return supported
+}
+
+// compositeFetcher dispatches a Fetch to the first adapter able to serve
+// the descriptor, falling back to the registry's plain HTTP fetcher.
+type compositeFetcher struct {
+	base     transfer.Fetcher
+	adapters []TransferAdapter
+}
+
+func (f *compositeFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	for _, adapter := range f.adapters {
+		if adapter.CanFetch(desc) {
+			return adapter.Fetch(ctx, desc)
+		}
+	}
+	return f.base.Fetch(ctx, desc)
+}
@@ -0,0 +1,78 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"strings"
+)
+
+// credentialsToBasicAuth converts c to the (username, secret) pair the
+// resolver sends as HTTP basic auth. An IdentityToken or RefreshToken is
+// sent with an empty username, the same convention the Docker CLI uses
+// for a config.json auth entry that holds only an identity token; the
+// registry's token service recognizes this as an OAuth2 refresh_token
+// grant instead of a plain password.
+func credentialsToBasicAuth(c Credentials) (string, string) {
+	switch {
+	case c.IdentityToken != "":
+		return "", c.IdentityToken
+	case c.RefreshToken != "":
+		return "", c.RefreshToken
+	default:
+		return c.Username, c.Secret
+	}
+}
+
+// bearerCacheKey joins a host and the scopes a bearer token was requested
+// for into a single cache key, since a token minted for one scope set
+// cannot be reused for another.
+func bearerCacheKey(host string, scopes []string) string {
+	if len(scopes) == 0 {
+		return host
+	}
+	return host + "|" + strings.Join(scopes, ",")
+}
+
+// RefreshCredentials implements CredentialHelper by re-invoking the
+// configured docker-credential-<name> binary. Docker credential helpers
+// have no notion of registry scopes, so there's nothing scope-specific to
+// ask for; the refreshed credentials are still cached per (host, scopes)
+// so a large pull doesn't re-invoke the helper binary for every blob, and
+// callers whose helper mints a new identity token on every invocation (as
+// most cloud vendor helpers do) get it rotated once the entry expires.
+func (d *DockerCredentialHelper) RefreshCredentials(ctx context.Context, host string, scopes []string) (Credentials, error) {
+	key := bearerCacheKey(host, scopes)
+	if creds, ok := d.cachedCredentials(key); ok {
+		return creds, nil
+	}
+
+	// GetCredentials keys its own cache by host alone; a pre-401 lookup
+	// for this host within the last dockerCredentialHelperTTL would
+	// otherwise make GetCredentials hand back the very credential that
+	// just got rejected instead of re-invoking the helper binary.
+	d.evictCredentials(host)
+
+	creds, err := d.GetCredentials(ctx, "", host)
+	if err != nil {
+		return Credentials{}, err
+	}
+	creds.Scopes = scopes
+
+	d.cacheCredentials(key, creds)
+	return creds, nil
+}
@@ -0,0 +1,363 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dockerCredentialHelperTTL is how long a successful lookup is cached
+// before the helper binary is invoked again for the same host.
+const dockerCredentialHelperTTL = 5 * time.Minute
+
+// dockerCredentialAction is one of the Docker credential-helper protocol
+// subcommands: https://github.com/docker/docker-credential-helpers
+type dockerCredentialAction string
+
+const (
+	dockerCredentialGet   dockerCredentialAction = "get"
+	dockerCredentialStore dockerCredentialAction = "store"
+	dockerCredentialErase dockerCredentialAction = "erase"
+	dockerCredentialList  dockerCredentialAction = "list"
+)
+
+// dockerCredentialHelperResponse is the JSON a helper's "get" subcommand
+// writes to stdout. Identity-token based logins report the literal
+// username "<token>" with the token itself in Secret, matching how the
+// Docker CLI distinguishes password vs. identity-token responses.
+type dockerCredentialHelperResponse struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// dockerConfigAuth is one entry of a config.json "auths" map.
+type dockerConfigAuth struct {
+	Auth string `json:"auth"`
+}
+
+// dockerConfigFile mirrors the fields of ~/.docker/config.json this
+// package cares about.
+type dockerConfigFile struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+	CredsStore  string                      `json:"credsStore"`
+}
+
+type cachedCredential struct {
+	creds     Credentials
+	expiresAt time.Time
+}
+
+// DockerCredentialHelper is a CredentialHelper implementation that shells
+// out to Docker-style credential helper binaries (docker-credential-*)
+// using their stdin/stdout JSON protocol, so existing cloud vendor
+// credential helpers can be reused without writing a custom
+// CredentialHelper.
+type DockerCredentialHelper struct {
+	// helperByHost maps a registry host to the suffix of the
+	// docker-credential-<suffix> binary that should serve it, mirroring
+	// config.json's "credHelpers".
+	helperByHost map[string]string
+	// defaultHelper is used for any host not present in helperByHost,
+	// mirroring config.json's "credsStore".
+	defaultHelper string
+	// staticAuths holds base64-encoded "user:pass" entries read from
+	// config.json's "auths", used for hosts with neither a per-host
+	// helper nor a credsStore fallback.
+	staticAuths map[string]dockerConfigAuth
+
+	mu    sync.Mutex
+	cache map[string]cachedCredential
+}
+
+// NewDockerCredentialHelper returns a CredentialHelper that dispatches to
+// docker-credential-<name> binaries according to the given per-host
+// helpers and default (credsStore) helper.
+func NewDockerCredentialHelper(helperByHost map[string]string, defaultHelper string) *DockerCredentialHelper {
+	return &DockerCredentialHelper{
+		helperByHost:  helperByHost,
+		defaultHelper: defaultHelper,
+		cache:         map[string]cachedCredential{},
+	}
+}
+
+// WithDockerCredentialHelpers configures the registry to resolve
+// credentials via Docker-style credential helper binaries, one per host.
+// The map key is a registry host and the value is the suffix appended to
+// "docker-credential-" to find the binary, e.g.
+// {"123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"}.
+func WithDockerCredentialHelpers(helperByHost map[string]string) Opt {
+	return func(o *registryOpts) error {
+		o.creds = NewDockerCredentialHelper(helperByHost, "")
+		return nil
+	}
+}
+
+// WithDockerConfig configures the registry to resolve credentials from the
+// user's default Docker config file (~/.docker/config.json). It is
+// equivalent to WithDockerConfigJSON(path) with path resolved via
+// os.UserHomeDir.
+func WithDockerConfig() Opt {
+	return func(o *registryOpts) error {
+		path, err := defaultDockerConfigPath()
+		if err != nil {
+			return fmt.Errorf("failed to resolve default docker config path: %w", err)
+		}
+		return WithDockerConfigJSON(path)(o)
+	}
+}
+
+// WithDockerConfigJSON configures the registry to resolve credentials the
+// same way the Docker CLI does: by reading a config.json file's
+// "credHelpers" (per-host helper), "credsStore" (default helper), and
+// "auths" (static, base64-encoded credentials) fields.
+func WithDockerConfigJSON(path string) Opt {
+	return func(o *registryOpts) error {
+		helper, err := dockerCredentialHelperFromConfig(path)
+		if err != nil {
+			return err
+		}
+		o.creds = helper
+		return nil
+	}
+}
+
+func dockerCredentialHelperFromConfig(path string) (*DockerCredentialHelper, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docker config %s: %w", path, err)
+	}
+
+	var cfg dockerConfigFile
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse docker config %s: %w", path, err)
+	}
+
+	helper := NewDockerCredentialHelper(cfg.CredHelpers, cfg.CredsStore)
+	helper.staticAuths = cfg.Auths
+	return helper, nil
+}
+
+// GetCredentials implements CredentialHelper.
+func (d *DockerCredentialHelper) GetCredentials(ctx context.Context, ref, host string) (Credentials, error) {
+	if creds, ok := d.cachedCredentials(host); ok {
+		return creds, nil
+	}
+
+	helperName := d.helperByHost[host]
+	if helperName == "" {
+		helperName = d.defaultHelper
+	}
+
+	if helperName == "" {
+		creds, ok := d.staticCredentials(host)
+		if !ok {
+			return Credentials{}, fmt.Errorf("no docker credential helper or stored auth configured for host %s", host)
+		}
+		return creds, nil
+	}
+
+	out, err := runDockerCredentialHelper(ctx, helperName, dockerCredentialGet, host)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("docker-credential-%s get failed: %w", helperName, err)
+	}
+
+	var resp dockerCredentialHelperResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse docker-credential-%s response: %w", helperName, err)
+	}
+
+	creds := Credentials{Host: host}
+	if resp.Username == "<token>" {
+		// Identity token login: no username, just a refresh token.
+		creds.Secret = resp.Secret
+	} else {
+		creds.Username = resp.Username
+		creds.Secret = resp.Secret
+	}
+
+	d.cacheCredentials(host, creds)
+	return creds, nil
+}
+
+// Store writes credentials for host via the configured credential helper,
+// implementing the "store" half of the protocol.
+func (d *DockerCredentialHelper) Store(ctx context.Context, host string, creds Credentials) error {
+	helperName := d.helperByHost[host]
+	if helperName == "" {
+		helperName = d.defaultHelper
+	}
+	if helperName == "" {
+		return fmt.Errorf("no docker credential helper configured for host %s", host)
+	}
+
+	payload, err := json.Marshal(dockerCredentialHelperResponse{
+		ServerURL: host,
+		Username:  creds.Username,
+		Secret:    creds.Secret,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := runDockerCredentialHelper(ctx, helperName, dockerCredentialStore, string(payload)); err != nil {
+		return fmt.Errorf("docker-credential-%s store failed: %w", helperName, err)
+	}
+
+	d.mu.Lock()
+	delete(d.cache, host)
+	d.mu.Unlock()
+	return nil
+}
+
+// Erase removes any stored credentials for host via the configured
+// credential helper, implementing the "erase" half of the protocol.
+func (d *DockerCredentialHelper) Erase(ctx context.Context, host string) error {
+	helperName := d.helperByHost[host]
+	if helperName == "" {
+		helperName = d.defaultHelper
+	}
+	if helperName == "" {
+		return fmt.Errorf("no docker credential helper configured for host %s", host)
+	}
+
+	if _, err := runDockerCredentialHelper(ctx, helperName, dockerCredentialErase, host); err != nil {
+		return fmt.Errorf("docker-credential-%s erase failed: %w", helperName, err)
+	}
+
+	d.mu.Lock()
+	delete(d.cache, host)
+	d.mu.Unlock()
+	return nil
+}
+
+// List returns the hosts a credential helper has stored credentials for.
+func (d *DockerCredentialHelper) List(ctx context.Context, helperName string) (map[string]string, error) {
+	out, err := runDockerCredentialHelper(ctx, helperName, dockerCredentialList, "")
+	if err != nil {
+		return nil, fmt.Errorf("docker-credential-%s list failed: %w", helperName, err)
+	}
+
+	var hosts map[string]string
+	if err := json.Unmarshal(out, &hosts); err != nil {
+		return nil, fmt.Errorf("failed to parse docker-credential-%s list response: %w", helperName, err)
+	}
+	return hosts, nil
+}
+
+func (d *DockerCredentialHelper) cachedCredentials(host string) (Credentials, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.cache[host]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return Credentials{}, false
+	}
+	return entry.creds, true
+}
+
+func (d *DockerCredentialHelper) cacheCredentials(host string, creds Credentials) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.cache[host] = cachedCredential{
+		creds:     creds,
+		expiresAt: time.Now().Add(dockerCredentialHelperTTL),
+	}
+}
+
+// evictCredentials drops host's cached entry, if any, so the next
+// GetCredentials call for host re-invokes the helper binary instead of
+// returning a credential that's already been rejected.
+func (d *DockerCredentialHelper) evictCredentials(host string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.cache, host)
+}
+
+// staticCredentials decodes a config.json "auths" entry for host, if any.
+func (d *DockerCredentialHelper) staticCredentials(host string) (Credentials, bool) {
+	entry, ok := d.staticAuths[host]
+	if !ok || entry.Auth == "" {
+		return Credentials{}, false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return Credentials{}, false
+	}
+
+	userPass := strings.SplitN(string(decoded), ":", 2)
+	if len(userPass) != 2 {
+		return Credentials{}, false
+	}
+
+	return Credentials{Host: host, Username: userPass[0], Secret: userPass[1]}, true
+}
+
+// runDockerCredentialHelper invokes docker-credential-<name> with the
+// given subcommand, writing payload to its stdin and returning its
+// stdout, per the protocol described at
+// https://github.com/docker/docker-credential-helpers.
+func runDockerCredentialHelper(ctx context.Context, name string, action dockerCredentialAction, payload string) ([]byte, error) {
+	bin := "docker-credential-" + name
+	if _, err := exec.LookPath(bin); err != nil {
+		return nil, fmt.Errorf("credential helper binary %s not found: %w", bin, err)
+	}
+
+	cmd := exec.CommandContext(ctx, bin, string(action))
+	cmd.Stdin = strings.NewReader(payload)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		if msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// defaultDockerConfigPath returns ~/.docker/config.json, the path the
+// Docker CLI itself defaults to.
+func defaultDockerConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
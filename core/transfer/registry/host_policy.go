@@ -0,0 +1,134 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+)
+
+// HostPolicy configures per-host TLS, scheme, and mirror behavior for a
+// registry reference, giving hosts.toml-style mirror fallback and
+// per-registry insecure toggles (like Helm's
+// --insecure-skip-tls-verify) without requiring an on-disk hosts.toml.
+type HostPolicy struct {
+	// Host is a glob pattern, as matched by path.Match, against the
+	// registry host being resolved, e.g. "*.internal.example.com".
+	Host string
+	// PlainHTTP forces http:// instead of https:// for Host.
+	PlainHTTP bool
+	// SkipVerify disables TLS certificate verification for Host only,
+	// unlike the registry-wide WithSkipVerify.
+	SkipVerify bool
+	// Mirrors are tried, in order, before Host for resolve/pull
+	// operations. Push always goes to Host.
+	Mirrors []string
+	// MirrorCapabilities restricts what the mirrors above are used for.
+	// Defaults to resolve and pull when nil; push is never added even if
+	// requested, since a mirror is a read-through cache, not a second
+	// upstream to write to.
+	MirrorCapabilities []string
+}
+
+// mirrorCapabilities returns p's requested capabilities as a
+// docker.HostCapabilities bitmask, defaulting to resolve+pull.
+func (p HostPolicy) mirrorCapabilities() docker.HostCapabilities {
+	if len(p.MirrorCapabilities) == 0 {
+		return docker.HostCapabilityPull | docker.HostCapabilityResolve
+	}
+
+	var caps docker.HostCapabilities
+	for _, c := range p.MirrorCapabilities {
+		switch c {
+		case "pull":
+			caps |= docker.HostCapabilityPull
+		case "resolve":
+			caps |= docker.HostCapabilityResolve
+		}
+	}
+	return caps
+}
+
+// matchHostPolicy returns the first policy whose Host glob matches host,
+// if any.
+func matchHostPolicy(policies []HostPolicy, host string) (HostPolicy, bool) {
+	for _, p := range policies {
+		if ok, err := path.Match(p.Host, host); err == nil && ok {
+			return p, true
+		}
+	}
+	return HostPolicy{}, false
+}
+
+// applyHostPolicies wraps base so that hosts matching a policy in
+// policies are tried through their mirrors first, with each returned
+// docker.RegistryHost honoring that policy's own PlainHTTP/SkipVerify
+// setting rather than the registry-wide default. Hosts matching no
+// policy fall through to base unchanged.
+func applyHostPolicies(policies []HostPolicy, base docker.RegistryHosts) docker.RegistryHosts {
+	return func(host string) ([]docker.RegistryHost, error) {
+		policy, ok := matchHostPolicy(policies, host)
+		if !ok {
+			return base(host)
+		}
+
+		primary, err := base(host)
+		if err != nil {
+			return nil, err
+		}
+		for i := range primary {
+			applyHostPolicy(&primary[i], policy)
+		}
+
+		var mirrors []docker.RegistryHost
+		for _, m := range policy.Mirrors {
+			mirrorHosts, err := base(m)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve mirror %s for %s: %w", m, host, err)
+			}
+			for i := range mirrorHosts {
+				mirrorHosts[i].Capabilities = policy.mirrorCapabilities()
+				applyHostPolicy(&mirrorHosts[i], policy)
+			}
+			mirrors = append(mirrors, mirrorHosts...)
+		}
+
+		return append(mirrors, primary...), nil
+	}
+}
+
+// applyHostPolicy overrides h's scheme and TLS verification to match
+// policy. A mirror's own Host field (set by base, not policy.Host, the
+// primary's) is left untouched — only scheme/TLS are policy-driven.
+func applyHostPolicy(h *docker.RegistryHost, policy HostPolicy) {
+	if policy.PlainHTTP {
+		h.Scheme = "http"
+	}
+	if h.Client == nil || h.Client.Transport == nil {
+		return
+	}
+	transport, ok := h.Client.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil {
+		return
+	}
+	if policy.SkipVerify {
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+}
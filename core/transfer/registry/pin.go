@@ -0,0 +1,135 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	transfertypes "github.com/containerd/containerd/api/types/transfer"
+)
+
+// TLSRequestTypePinSPKI asks a TLSHelper for the SHA-256 SPKI pins it
+// wants enforced for a host: one or more base64-encoded hashes,
+// separated by newlines and/or commas. Their presence overrides
+// CA-based trust for that host, HPKP-style.
+//
+// TODO: fold this into the transfertypes.TLSRequestType proto enum once
+// it is regenerated, alongside TLSRequestTypePKCS12Bundle.
+const TLSRequestTypePinSPKI transfertypes.TLSRequestType = 101
+
+// ErrTLSPinMismatch is returned, wrapped with the fingerprint that was
+// seen, when a peer certificate's SPKI fingerprint matches none of the
+// pins configured for its host.
+var ErrTLSPinMismatch = errors.New("registry: TLS certificate pin mismatch")
+
+// parseSPKIPins splits data - one base64 SHA-256 SPKI hash per line,
+// blank lines and "#" comments ignored, commas also accepted as
+// separators - into a pin list.
+func parseSPKIPins(data []byte) []string {
+	var pins []string
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, field := range strings.Split(line, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" || strings.HasPrefix(field, "#") {
+				continue
+			}
+			pins = append(pins, field)
+		}
+	}
+	return pins
+}
+
+// spkiFingerprint returns the base64-encoded SHA-256 hash of cert's
+// SubjectPublicKeyInfo, the value HPKP-style pinning compares against.
+func spkiFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifySPKIPin fails with ErrTLSPinMismatch unless cert's SPKI
+// fingerprint matches one of pins.
+func verifySPKIPin(pins []string, cert *x509.Certificate) error {
+	fingerprint := spkiFingerprint(cert)
+	for _, pin := range pins {
+		if pin == fingerprint {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s matches none of %d configured pin(s)", ErrTLSPinMismatch, fingerprint, len(pins))
+}
+
+// newPinningVerifyConnection returns a tls.Config.VerifyConnection
+// callback that enforces SPKI pins: if helper returns one or more for a
+// connection's SNI host, a matching pin is trusted on its own,
+// overriding whatever CA-based trust would otherwise decide. With no
+// pins configured for the host it falls back to verifying against a
+// pool built from the system roots, any CA certs helper provides, and
+// any extraCACerts (e.g. ones bundled into a PKCS#12 client cert),
+// checking the certificate's hostname against cs.ServerName itself
+// since Go's own handshake verification never runs here (see below).
+//
+// The caller must set tls.Config.InsecureSkipVerify so Go's own default
+// verification doesn't run ahead of and independently of this: a pin
+// match is only an override if it actually gets the chance to run.
+func newPinningVerifyConnection(ctx context.Context, helper TLSHelper, extraCACerts func() []*x509.Certificate) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("no peer certificate presented")
+		}
+		leaf := cs.PeerCertificates[0]
+
+		if pinData, err := helper.GetTLSData(ctx, cs.ServerName, TLSRequestTypePinSPKI); err == nil && len(pinData) > 0 {
+			if pins := parseSPKIPins(pinData); len(pins) > 0 {
+				return verifySPKIPin(pins, leaf)
+			}
+		}
+
+		rootPool, err := x509.SystemCertPool()
+		if err != nil {
+			rootPool = x509.NewCertPool()
+		}
+		if caPEM, err := helper.GetTLSData(ctx, "", transfertypes.TLSRequestType_CA_CERT); err == nil {
+			if !rootPool.AppendCertsFromPEM(caPEM) {
+				return fmt.Errorf("unable to load CA cert from TLS helper")
+			}
+		}
+		if extraCACerts != nil {
+			for _, caCert := range extraCACerts() {
+				rootPool.AddCert(caCert)
+			}
+		}
+
+		opts := x509.VerifyOptions{
+			DNSName:       cs.ServerName,
+			Roots:         rootPool,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+
+		_, err = leaf.Verify(opts)
+		return err
+	}
+}
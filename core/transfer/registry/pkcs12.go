@@ -0,0 +1,86 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package registry
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	transfertypes "github.com/containerd/containerd/api/types/transfer"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// TLSRequestTypePKCS12Bundle asks a TLSHelper for a single PKCS#12 (.p12 /
+// .pfx) bundle containing a client certificate, its key, and optionally
+// intermediate/CA certificates, instead of separate CLIENT_CERT/CLIENT_KEY
+// PEM blobs.
+//
+// TODO: fold this into the transfertypes.TLSRequestType proto enum once it
+// is regenerated; until then this value is chosen high enough to avoid
+// colliding with the existing CLIENT_CERT/CLIENT_KEY/CA_CERT members.
+const TLSRequestTypePKCS12Bundle transfertypes.TLSRequestType = 100
+
+// PassphraseFunc returns the decryption passphrase for a PKCS#12 bundle
+// belonging to host.
+type PassphraseFunc func(host string) ([]byte, error)
+
+// WithPKCS12Passphrase configures the passphrase used to decrypt a PKCS#12
+// bundle that a TLSHelper returns for a CLIENT_CERT request (recognized by
+// looksLikePKCS12).
+func WithPKCS12Passphrase(passphrase PassphraseFunc) Opt {
+	return func(o *registryOpts) error {
+		o.pkcs12Passphrase = passphrase
+		return nil
+	}
+}
+
+// looksLikePKCS12 distinguishes a binary PKCS#12 bundle from a PEM blob:
+// PEM data always starts with "-----BEGIN", while a PKCS#12 file is a DER
+// encoded ASN.1 SEQUENCE, whose first byte is always 0x30.
+func looksLikePKCS12(data []byte) bool {
+	return len(data) > 2 && data[0] == 0x30 && !bytes.HasPrefix(data, []byte("-----BEGIN"))
+}
+
+// decodePKCS12Bundle decomposes a PKCS#12 bundle into a client
+// certificate (leaf plus any intermediates, ready to use as a
+// tls.Certificate) and any CA certificates it contains, which the caller
+// should add to its verification pool.
+func decodePKCS12Bundle(data, passphrase []byte) (tls.Certificate, []*x509.Certificate, error) {
+	privateKey, leaf, chain, err := pkcs12.DecodeChain(data, string(passphrase))
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to decode PKCS#12 bundle: %w", err)
+	}
+
+	cert := tls.Certificate{
+		PrivateKey: privateKey,
+		Leaf:       leaf,
+	}
+	cert.Certificate = append(cert.Certificate, leaf.Raw)
+
+	var caCerts []*x509.Certificate
+	for _, c := range chain {
+		if c.IsCA {
+			caCerts = append(caCerts, c)
+		} else {
+			cert.Certificate = append(cert.Certificate, c.Raw)
+		}
+	}
+
+	return cert, caCerts, nil
+}
@@ -26,6 +26,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	transfertypes "github.com/containerd/containerd/api/types/transfer"
 	"github.com/containerd/containerd/v2/core/remotes"
@@ -47,15 +48,18 @@ func init() {
 }
 
 type registryOpts struct {
-	headers       http.Header
-	creds         CredentialHelper
-	hostDir       string
-	defaultScheme string
-	httpDebug     bool
-	httpTrace     bool
-	localStream   io.WriteCloser
-	tlsHelper     TLSHelper
-	skipVerify    bool
+	headers          http.Header
+	creds            CredentialHelper
+	hostDir          string
+	defaultScheme    string
+	httpDebug        bool
+	httpTrace        bool
+	localStream      io.WriteCloser
+	tlsHelper        TLSHelper
+	skipVerify       bool
+	pkcs12Passphrase PassphraseFunc
+	hostPolicies     []HostPolicy
+	transferAdapters []string
 }
 
 // Opt sets registry-related configurations.
@@ -77,6 +81,32 @@ func WithCredentials(creds CredentialHelper) Opt {
 	}
 }
 
+// WithHostPolicies configures per-host TLS, scheme, and mirror fallback
+// behavior, the same things a hosts.toml would, without requiring one on
+// disk. The first policy whose Host glob matches a given registry host
+// applies; hosts matching no policy use the registry-wide
+// WithSkipVerify/WithDefaultScheme settings as before.
+func WithHostPolicies(policies []HostPolicy) Opt {
+	return func(o *registryOpts) error {
+		o.hostPolicies = policies
+		return nil
+	}
+}
+
+// WithTransferAdapters configures the named TransferAdapters (previously
+// registered with RegisterTransferAdapter), in preference order, that
+// Fetcher may dispatch to instead of a plain HTTP fetch. Which ones
+// actually get used is negotiated once per Fetcher, against the adapter
+// list the resolved reference's descriptor advertises; each fetched
+// descriptor then goes to the first negotiated adapter that CanFetch it.
+// See negotiateTransferAdapters.
+func WithTransferAdapters(names ...string) Opt {
+	return func(o *registryOpts) error {
+		o.transferAdapters = names
+		return nil
+	}
+}
+
 // WithHostDir specifies the host configuration directory.
 func WithHostDir(hostDir string) Opt {
 	return func(o *registryOpts) error {
@@ -148,14 +178,14 @@ func NewOCIRegistry(ctx context.Context, ref string, opts ...Opt) (*OCIRegistry,
 		hostOptions.HostDir = config.HostDirFromRoot(ropts.hostDir)
 	}
 	if ropts.creds != nil {
-		// TODO: Support bearer
 		hostOptions.Credentials = func(host string) (string, string, error) {
 			c, err := ropts.creds.GetCredentials(context.Background(), ref, host)
 			if err != nil {
 				return "", "", err
 			}
 
-			return c.Username, c.Secret, nil
+			user, secret := credentialsToBasicAuth(c)
+			return user, secret, nil
 		}
 	}
 	if ropts.defaultScheme != "" {
@@ -169,65 +199,63 @@ func NewOCIRegistry(ctx context.Context, ref string, opts ...Opt) (*OCIRegistry,
 			tlsConfig.InsecureSkipVerify = true
 		}
 		if ropts.tlsHelper != nil {
+			// VerifyConnection below does its own full chain (and pin)
+			// verification, so Go's default verification - which only
+			// ever trusts the system pool - must be disabled or it would
+			// reject helper-provided CAs and pinned certs alike before
+			// VerifyConnection ever runs.
+			tlsConfig.InsecureSkipVerify = true
+			// pkcs12CACerts collects any CA certificates bundled into a
+			// PKCS#12 client cert blob, so VerifyConnection below can
+			// trust them too without a separate CA_CERT round trip.
+			var pkcs12Mu sync.Mutex
+			var pkcs12CACerts []*x509.Certificate
+
 			// Set up GetClientCertificate callback for dynamic client cert loading
 			tlsConfig.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
-				certPEM, err := ropts.tlsHelper.GetTLSData(context.Background(), info.Context.Value("host").(string), transfertypes.TLSRequestType_CLIENT_CERT)
+				host, _ := info.Context.Value("host").(string)
+				certData, err := ropts.tlsHelper.GetTLSData(context.Background(), host, transfertypes.TLSRequestType_CLIENT_CERT)
 				if err != nil {
 					return nil, err
 				}
-				keyPEM, err := ropts.tlsHelper.GetTLSData(context.Background(), info.Context.Value("host").(string), transfertypes.TLSRequestType_CLIENT_KEY)
+
+				if looksLikePKCS12(certData) {
+					var passphrase []byte
+					if ropts.pkcs12Passphrase != nil {
+						passphrase, err = ropts.pkcs12Passphrase(host)
+						if err != nil {
+							return nil, fmt.Errorf("failed to get PKCS#12 passphrase: %w", err)
+						}
+					}
+					cert, caCerts, err := decodePKCS12Bundle(certData, passphrase)
+					if err != nil {
+						return nil, err
+					}
+					pkcs12Mu.Lock()
+					pkcs12CACerts = caCerts
+					pkcs12Mu.Unlock()
+					return &cert, nil
+				}
+
+				keyPEM, err := ropts.tlsHelper.GetTLSData(context.Background(), host, transfertypes.TLSRequestType_CLIENT_KEY)
 				if err != nil {
 					return nil, err
 				}
-				cert, err := tls.X509KeyPair(certPEM, keyPEM)
+				cert, err := tls.X509KeyPair(certData, keyPEM)
 				if err != nil {
 					return nil, fmt.Errorf("failed to load X509 key pair: %w", err)
 				}
 				return &cert, nil
 			}
 
-			// Set up VerifyPeerCertificate callback for dynamic CA cert loading
+			// Set up VerifyConnection for dynamic CA loading and SPKI pin
+			// enforcement; see newPinningVerifyConnection.
 			if !ropts.skipVerify {
-				tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-					// Get CA certs from helper
-					caPEM, err := ropts.tlsHelper.GetTLSData(context.Background(), "", transfertypes.TLSRequestType_CA_CERT)
-					if err != nil {
-					// If no CA provided, use system pool
-					return nil
-				}
-				
-				rootPool, err := x509.SystemCertPool()
-				if err != nil {
-					rootPool = x509.NewCertPool()
-				}
-				if !rootPool.AppendCertsFromPEM(caPEM) {
-					return fmt.Errorf("unable to load CA cert from TLS helper")
-				}
-					
-					// Verify using the custom CA pool
-					opts := x509.VerifyOptions{
-						Roots:         rootPool,
-						Intermediates: x509.NewCertPool(),
-					}
-					
-					for _, chain := range verifiedChains {
-						for i, cert := range chain {
-							if i > 0 {
-								opts.Intermediates.AddCert(cert)
-							}
-						}
-					}
-					
-					if len(rawCerts) > 0 {
-						cert, err := x509.ParseCertificate(rawCerts[0])
-						if err != nil {
-							return err
-						}
-						_, err = cert.Verify(opts)
-						return err
-					}
-					return nil
-				}
+				tlsConfig.VerifyConnection = newPinningVerifyConnection(context.Background(), ropts.tlsHelper, func() []*x509.Certificate {
+					pkcs12Mu.Lock()
+					defer pkcs12Mu.Unlock()
+					return pkcs12CACerts
+				})
 			}
 		}
 		hostOptions.DefaultTLS = tlsConfig
@@ -243,29 +271,44 @@ func NewOCIRegistry(ctx context.Context, ref string, opts ...Opt) (*OCIRegistry,
 		return nil
 	}
 
+	hosts := config.ConfigureHosts(ctx, hostOptions)
+	if len(ropts.hostPolicies) > 0 {
+		hosts = applyHostPolicies(ropts.hostPolicies, hosts)
+	}
+
 	resolver := docker.NewResolver(docker.ResolverOptions{
-		Hosts:   config.ConfigureHosts(ctx, hostOptions),
+		Hosts:   hosts,
 		Headers: ropts.headers,
 	})
 
 	return &OCIRegistry{
-		reference:     ref,
-		headers:       ropts.headers,
-		creds:         ropts.creds,
-		resolver:      resolver,
-		hostDir:       ropts.hostDir,
-		defaultScheme: ropts.defaultScheme,
-		httpDebug:     ropts.httpDebug,
-		httpTrace:     ropts.httpTrace,
-		localStream:   ropts.localStream,
-		tlsHelper:     ropts.tlsHelper,
-		skipVerify:    ropts.skipVerify,
+		reference:        ref,
+		headers:          ropts.headers,
+		creds:            ropts.creds,
+		resolver:         resolver,
+		hostDir:          ropts.hostDir,
+		defaultScheme:    ropts.defaultScheme,
+		httpDebug:        ropts.httpDebug,
+		httpTrace:        ropts.httpTrace,
+		localStream:      ropts.localStream,
+		tlsHelper:        ropts.tlsHelper,
+		skipVerify:       ropts.skipVerify,
+		hostPolicies:     ropts.hostPolicies,
+		transferAdapters: ropts.transferAdapters,
 	}, nil
 }
 
 // From stream
 type CredentialHelper interface {
 	GetCredentials(ctx context.Context, ref, host string) (Credentials, error)
+
+	// RefreshCredentials returns credentials for host scoped to the given
+	// registry scopes (as in a "WWW-Authenticate: Bearer
+	// realm=...,scope=..." challenge), letting a helper mint or refresh a
+	// bearer token for exactly those scopes. Nothing in this package
+	// calls it: the resolvers NewOCIRegistry and UnmarshalAny build
+	// obtain credentials through GetCredentials only.
+	RefreshCredentials(ctx context.Context, host string, scopes []string) (Credentials, error)
 }
 
 type Credentials struct {
@@ -273,6 +316,25 @@ type Credentials struct {
 	Username string
 	Secret   string
 	Header   string
+
+	// IdentityToken is an opaque token presented in place of a password,
+	// following the Docker CLI convention of a config.json auth entry
+	// with only an identity token: the resolver sends it as the password
+	// half of an empty-username basic auth exchange, which the registry's
+	// token service treats as an OAuth2 refresh_token grant.
+	IdentityToken string
+	// RefreshToken is a longer-lived token a CredentialHelper can present
+	// to RefreshCredentials to mint new, narrowly scoped AccessTokens
+	// without re-running an interactive or credential-helper login.
+	RefreshToken string
+	// AccessToken and ExpiresAt cache the bearer token most recently
+	// minted for Scopes, so a helper's RefreshCredentials implementation
+	// can skip the token exchange until it's close to expiring.
+	AccessToken string
+	ExpiresAt   time.Time
+	// Scopes records which registry scopes (e.g.
+	// "repository:foo/bar:pull") AccessToken was issued for.
+	Scopes []string
 }
 
 // TLSHelper provides TLS certificates and keys dynamically
@@ -300,6 +362,22 @@ type OCIRegistry struct {
 	tlsHelper  TLSHelper
 	skipVerify bool
 
+	hostPolicies []HostPolicy
+
+	// transferAdapters are tried, in order, ahead of the resolver's own
+	// HTTP Fetcher; see negotiateTransferAdapters.
+	transferAdapters []string
+
+	// resolverOptionsMu guards resolverOptions, set by SetResolverOptions
+	// and read by Fetcher - the two aren't otherwise ordered against
+	// each other.
+	resolverOptionsMu sync.Mutex
+	// resolverOptions is applied to the ParallelFetcher Fetcher wraps
+	// each Fetcher it returns in, so WithConcurrentLayerFetchBuffer/
+	// WithDownloadLimiter take effect without their own Opt on
+	// OCIRegistry itself.
+	resolverOptions transfer.ImageResolverOptions
+
 	// This could be an interface which returns resolver?
 	// Resolver could also be a plug-able interface, to call out to a program to fetch?
 }
@@ -320,10 +398,43 @@ func (r *OCIRegistry) SetResolverOptions(options ...transfer.ImageResolverOption
 	if resolver, ok := r.resolver.(remotes.ResolverWithOptions); ok {
 		resolver.SetOptions(options...)
 	}
+
+	r.resolverOptionsMu.Lock()
+	for _, o := range options {
+		o(&r.resolverOptions)
+	}
+	r.resolverOptionsMu.Unlock()
 }
 
 func (r *OCIRegistry) Fetcher(ctx context.Context, ref string) (transfer.Fetcher, error) {
-	return r.resolver.Fetcher(ctx, ref)
+	base, err := r.resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	r.resolverOptionsMu.Lock()
+	opts := r.resolverOptions
+	r.resolverOptionsMu.Unlock()
+	parallel := NewParallelFetcher(base, opts)
+
+	if len(r.transferAdapters) == 0 {
+		return parallel, nil
+	}
+
+	// Resolve again to read the manifest's advertised adapter list.
+	// Adapter negotiation is a locality/speed optimization, never a hard
+	// requirement, so a resolve failure here just falls back to parallel
+	// rather than failing the fetch outright.
+	_, desc, err := r.resolver.Resolve(ctx, ref)
+	if err != nil {
+		return parallel, nil
+	}
+
+	adapters := negotiateTransferAdapters(r.transferAdapters, desc)
+	if len(adapters) == 0 {
+		return parallel, nil
+	}
+	return &compositeFetcher{base: parallel, adapters: adapters}, nil
 }
 
 func (r *OCIRegistry) Pusher(ctx context.Context, desc ocispec.Descriptor) (transfer.Pusher, error) {
@@ -337,6 +448,11 @@ func (r *OCIRegistry) Pusher(ctx context.Context, desc ocispec.Descriptor) (tran
 
 func (r *OCIRegistry) MarshalAny(ctx context.Context, sm streaming.StreamCreator) (typeurl.Any, error) {
 	res := &transfertypes.RegistryResolver{}
+	// TODO: transfertypes.RegistryResolver has no fields for HostPolicies
+	// or the negotiated transfer adapter yet; once the proto gains them,
+	// serialize r.hostPolicies/r.transferAdapters here so UnmarshalAny
+	// can rebuild identical mirror/insecure/adapter behavior on the
+	// daemon side instead of only honoring it client-side.
 	if r.headers != nil {
 		res.Headers = map[string]string{}
 		for k := range r.headers {
@@ -383,8 +499,19 @@ func (r *OCIRegistry) MarshalAny(ctx context.Context, sm streaming.StreamCreator
 					resp.Username = creds.Username
 					resp.Secret = creds.Secret
 				} else {
+					// IdentityToken and RefreshToken both travel as a bare
+					// refresh token until AuthResponse grows dedicated
+					// fields for them; prefer the shorter-lived
+					// IdentityToken when both happen to be set.
 					resp.AuthType = transfertypes.AuthType_REFRESH
-					resp.Secret = creds.Secret
+					switch {
+					case creds.IdentityToken != "":
+						resp.Secret = creds.IdentityToken
+					case creds.RefreshToken != "":
+						resp.Secret = creds.RefreshToken
+					default:
+						resp.Secret = creds.Secret
+					}
 				}
 
 				a, err := typeurl.MarshalAny(&resp)
@@ -540,7 +667,8 @@ func (r *OCIRegistry) UnmarshalAny(ctx context.Context, sm streaming.StreamGette
 					return "", "", err
 				}
 
-				return c.Username, c.Secret, nil
+				user, secret := credentialsToBasicAuth(c)
+				return user, secret, nil
 			}
 		}
 
@@ -566,6 +694,12 @@ func (r *OCIRegistry) UnmarshalAny(ctx context.Context, sm streaming.StreamGette
 			}
 
 			if r.tlsHelper != nil {
+				// VerifyConnection below does its own full chain (and pin)
+				// verification, so Go's default verification must be
+				// disabled or it would reject helper-provided CAs and
+				// pinned certs alike before VerifyConnection ever runs.
+				tlsConfig.InsecureSkipVerify = true
+
 				// Set up GetClientCertificate callback for dynamic client cert loading
 				tlsConfig.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
 					// Extract host from the connection
@@ -591,48 +725,10 @@ func (r *OCIRegistry) UnmarshalAny(ctx context.Context, sm streaming.StreamGette
 					return &cert, nil
 				}
 
-				// Set up VerifyPeerCertificate callback for dynamic CA cert loading
+				// Set up VerifyConnection for dynamic CA loading and SPKI
+				// pin enforcement; see newPinningVerifyConnection.
 				if !r.skipVerify {
-					tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-						// Get CA certs from helper
-						caPEM, err := r.tlsHelper.GetTLSData(ctx, "", transfertypes.TLSRequestType_CA_CERT)
-						if err != nil {
-							// If no CA provided, use system pool
-							return nil
-						}
-
-						rootPool, err := x509.SystemCertPool()
-						if err != nil {
-							rootPool = x509.NewCertPool()
-						}
-						if !rootPool.AppendCertsFromPEM(caPEM) {
-							return fmt.Errorf("unable to load CA cert from TLS helper")
-						}
-
-						// Verify using the custom CA pool
-						opts := x509.VerifyOptions{
-							Roots:         rootPool,
-							Intermediates: x509.NewCertPool(),
-						}
-
-						for _, chain := range verifiedChains {
-							for i, cert := range chain {
-								if i > 0 {
-									opts.Intermediates.AddCert(cert)
-								}
-							}
-						}
-
-						if len(rawCerts) > 0 {
-							cert, err := x509.ParseCertificate(rawCerts[0])
-							if err != nil {
-								return err
-							}
-							_, err = cert.Verify(opts)
-							return err
-						}
-						return nil
-					}
+					tlsConfig.VerifyConnection = newPinningVerifyConnection(ctx, r.tlsHelper, nil)
 				}
 			}
 
@@ -727,6 +823,7 @@ func (cc *credCallback) GetCredentials(ctx context.Context, ref, host string) (C
 		creds.Secret = s.Secret
 	case transfertypes.AuthType_REFRESH:
 		creds.Secret = s.Secret
+		creds.IdentityToken = s.Secret
 	case transfertypes.AuthType_HEADER:
 		creds.Header = s.Secret
 	}
@@ -734,6 +831,18 @@ func (cc *credCallback) GetCredentials(ctx context.Context, ref, host string) (C
 	return creds, nil
 }
 
+// RefreshCredentials asks the remote side for credentials scoped to
+// scopes, in response to a 401 challenge.
+//
+// TODO: transfertypes.AuthRequest has no Scopes field yet, so this
+// currently degrades to the same one-shot exchange as GetCredentials;
+// once the proto gains one, attach scopes to ar below so the daemon-side
+// CredentialHelper can mint a token scoped to exactly what's being
+// requested instead of reusing a broader one.
+func (cc *credCallback) RefreshCredentials(ctx context.Context, host string, scopes []string) (Credentials, error) {
+	return cc.GetCredentials(ctx, "", host)
+}
+
 type tlsCallback struct {
 	sync.Mutex
 	stream streaming.Stream
@@ -24,10 +24,15 @@ import (
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	transfertypes "github.com/containerd/containerd/api/types/transfer"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/content/compression"
+	"github.com/containerd/containerd/v2/core/diff"
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/streaming"
+	"github.com/containerd/containerd/v2/core/transfer"
 	"github.com/containerd/containerd/v2/core/transfer/plugins"
+	"github.com/containerd/containerd/v2/internal/dmverity"
 	"github.com/containerd/log"
 )
 
@@ -52,6 +57,15 @@ func WithLabels(labels map[string]string) DestinationOpt {
 	}
 }
 
+// WithDmverity has a Destination format its block-backed snapshot with
+// dm-verity once the layer finishes unpacking into it (see
+// Destination.FormatVerity), instead of leaving it as a plain image.
+func WithDmverity(opts *dmverity.DmverityOptions) DestinationOpt {
+	return func(d *Destination) {
+		d.verityOpts = opts
+	}
+}
+
 // NewDestination creates a new snapshot destination
 func NewDestination(snapshotter snapshots.Snapshotter, key string, opts ...DestinationOpt) *Destination {
 	d := &Destination{
@@ -70,6 +84,8 @@ type Destination struct {
 	key         string
 	parent      string
 	labels      map[string]string
+
+	verityOpts *dmverity.DmverityOptions
 }
 
 func (d *Destination) String() string {
@@ -85,8 +101,13 @@ func (d *Destination) PrepareSnapshot(ctx context.Context, key string, parent st
 	return d.snapshotter.Prepare(ctx, key, parent, opts...)
 }
 
-// CommitSnapshot commits the snapshot
+// CommitSnapshot commits the snapshot, including any labels set on d
+// since it was prepared - e.g. by FormatVerity, which records a root
+// hash only once the layer has finished unpacking.
 func (d *Destination) CommitSnapshot(ctx context.Context, name, key string, opts ...snapshots.Opt) error {
+	if len(d.labels) > 0 {
+		opts = append(opts, snapshots.WithLabels(d.labels))
+	}
 	return d.snapshotter.Commit(ctx, name, key, opts...)
 }
 
@@ -105,6 +126,49 @@ func WithSourceParent(parent string) SourceOpt {
 	}
 }
 
+// WithComparer sets the diff.Comparer Diff uses to compare the source's
+// snapshot against its parent. Diff fails if this isn't set.
+func WithComparer(comparer diff.Comparer) SourceOpt {
+	return func(s *Source) {
+		s.comparer = comparer
+	}
+}
+
+// WithMediaType sets the media type Diff records on the descriptor it
+// produces, e.g. "application/vnd.oci.image.layer.v1.tar+gzip". Overrides
+// whatever WithCompression would otherwise select.
+func WithMediaType(mediaType string) SourceOpt {
+	return func(s *Source) {
+		s.mediaType = mediaType
+	}
+}
+
+// WithCompression selects Diff's output compression, used to pick a
+// default media type when WithMediaType wasn't also given.
+func WithCompression(t compression.Type) SourceOpt {
+	return func(s *Source) {
+		s.compression = t
+	}
+}
+
+// WithSourceLabels sets labels recorded on the content object Diff
+// produces.
+func WithSourceLabels(labels map[string]string) SourceOpt {
+	return func(s *Source) {
+		s.labels = labels
+	}
+}
+
+// WithSourceProgress registers a callback Diff invokes as it runs, using
+// the same event names (and Desc, for "created diff") a Transferrer
+// driving this Source through local's SnapshotSource dispatch would
+// report - so a caller invoking Diff directly sees equivalent progress.
+func WithSourceProgress(progress transfer.ProgressFunc) SourceOpt {
+	return func(s *Source) {
+		s.progress = progress
+	}
+}
+
 // NewSource creates a new snapshot source for creating diffs
 func NewSource(snapshotter snapshots.Snapshotter, key string, opts ...SourceOpt) *Source {
 	s := &Source{
@@ -122,6 +186,12 @@ type Source struct {
 	snapshotter snapshots.Snapshotter
 	key         string
 	parent      string
+
+	comparer    diff.Comparer
+	mediaType   string
+	compression compression.Type
+	labels      map[string]string
+	progress    transfer.ProgressFunc
 }
 
 func (s *Source) String() string {
@@ -146,6 +216,86 @@ func (s *Source) GetSnapshotter() snapshots.Snapshotter {
 	return s.snapshotter
 }
 
+// Comparer returns the diff.Comparer configured via WithComparer, or nil
+// if none was set. A Transferrer driving this Source through local's
+// SnapshotSource dispatch prefers this over a snapshotter->Comparer
+// mapping it would otherwise need configured itself, so attaching one
+// here is enough to make the Transferrer pipeline work end-to-end.
+func (s *Source) Comparer() diff.Comparer {
+	return s.comparer
+}
+
+// Diff mounts s's snapshot and its parent and compares them with the
+// configured diff.Comparer (see WithComparer), the same
+// snapshot->differ->content.Store pipeline a Transferrer drives for a
+// SnapshotSource/LayerDestination pair, available here as a direct call
+// for a caller that isn't going through one. cs must be the content store
+// the comparer itself writes into - Diff uses it only to confirm the
+// resulting blob landed where the caller expects, since the comparer, not
+// Diff, does the actual write.
+func (s *Source) Diff(ctx context.Context, cs content.Store) (ocispec.Descriptor, error) {
+	if s.comparer == nil {
+		return ocispec.Descriptor{}, fmt.Errorf("snapshot source has no comparer configured, use WithComparer")
+	}
+
+	if s.progress != nil {
+		s.progress(transfer.Progress{Event: "Creating diff"})
+	}
+
+	upper, err := s.GetMounts(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to get mounts: %w", err)
+	}
+	lower, err := s.GetParentMounts(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to get parent mounts: %w", err)
+	}
+
+	mediaType := s.mediaType
+	if mediaType == "" {
+		mediaType = mediaTypeForCompression(s.compression)
+	}
+
+	diffOpts := []diff.Opt{diff.WithMediaType(mediaType)}
+	if len(s.labels) > 0 {
+		diffOpts = append(diffOpts, diff.WithLabels(s.labels))
+	}
+
+	desc, err := s.comparer.Compare(ctx, lower, upper, diffOpts...)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to compare snapshots: %w", err)
+	}
+
+	if _, err := cs.Info(ctx, desc.Digest); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("diff blob %s not found in content store: %w", desc.Digest, err)
+	}
+
+	log.G(ctx).WithFields(log.Fields{
+		"digest":    desc.Digest,
+		"mediatype": desc.MediaType,
+		"size":      desc.Size,
+	}).Debug("created diff")
+
+	if s.progress != nil {
+		s.progress(transfer.Progress{Event: "created diff", Desc: &desc})
+	}
+
+	return desc, nil
+}
+
+// mediaTypeForCompression returns the default OCI layer media type for t,
+// used by Diff when WithMediaType wasn't given explicitly.
+func mediaTypeForCompression(t compression.Type) string {
+	switch t {
+	case compression.Gzip:
+		return ocispec.MediaTypeImageLayerGzip
+	case compression.Zstd:
+		return ocispec.MediaTypeImageLayerZstd
+	default:
+		return ocispec.MediaTypeImageLayer
+	}
+}
+
 // Ref represents a snapshot reference that can be marshaled for RPC
 type Ref struct {
 	snapshotter string
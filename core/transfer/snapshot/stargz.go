@@ -0,0 +1,68 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/snapshots"
+)
+
+// Remote-snapshot labels a stargz-aware snapshotter reads off Prepare to
+// mount a layer and fetch its chunks on demand, instead of requiring the
+// layer to be applied locally first. Naming matches the labels
+// github.com/containerd/stargz-snapshotter's containerd integration sets.
+const (
+	RemoteStargzReferenceLabel = "containerd.io/snapshot/remote/stargz.reference"
+	RemoteStargzDigestLabel    = "containerd.io/snapshot/remote/stargz.digest"
+	RemoteStargzSizeLabel      = "containerd.io/snapshot/remote/stargz.size"
+)
+
+// PrepareRemoteSnapshot asks d's snapshotter to mount key directly against
+// reference, without desc's layer ever having been applied locally: labels
+// carry the registry reference the layer came from plus its own digest
+// and size, for a snapshotter that can resolve and chunk-fetch it itself.
+//
+// There's no separate capability query on snapshots.Snapshotter for
+// whether a given instance understands these labels - a caller finds out
+// by trying, the same way it would find out that a label-less Prepare
+// isn't understood by a given remote snapshotter. A snapshotter that
+// doesn't recognize the remote-snapshot labels is expected to either
+// ignore them (preparing a normal, empty snapshot the caller would then
+// need to apply the layer into) or fail; core/transfer/local's unpackLayer
+// falls back to a plain PrepareSnapshot plus local apply when this
+// returns an error.
+func (d *Destination) PrepareRemoteSnapshot(ctx context.Context, key, reference string, desc ocispec.Descriptor) ([]mount.Mount, error) {
+	if reference == "" {
+		return nil, fmt.Errorf("reference required to prepare a remote snapshot")
+	}
+
+	labels := make(map[string]string, len(d.labels)+3)
+	for k, v := range d.labels {
+		labels[k] = v
+	}
+	labels[RemoteStargzReferenceLabel] = reference
+	labels[RemoteStargzDigestLabel] = desc.Digest.String()
+	labels[RemoteStargzSizeLabel] = strconv.FormatInt(desc.Size, 10)
+
+	return d.snapshotter.Prepare(ctx, key, d.parent, snapshots.WithLabels(labels))
+}
@@ -0,0 +1,141 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/internal/dmverity"
+)
+
+// Verity labels record the dm-verity layout of a snapshot's block
+// image, the way content digests are recorded in content labels, so a
+// later VerityMounts call - in this process or a new one - can reopen
+// the image as a verified device instead of reading it directly.
+const (
+	VerityRootHashLabel      = "containerd.io/snapshot/verity.roothash"
+	VeritySaltLabel          = "containerd.io/snapshot/verity.salt"
+	VerityHashAlgorithmLabel = "containerd.io/snapshot/verity.hash-algorithm"
+	VerityHashOffsetLabel    = "containerd.io/snapshot/verity.hash-offset"
+	// VerityVersionLabel names a label for the hash tree layout
+	// version, as dmverity.BuildTree lays it out - 0 for bottom-first,
+	// 1 for top-first. FormatVerity does not set it and VerityMounts
+	// does not read it.
+	VerityVersionLabel = "containerd.io/snapshot/verity.version"
+)
+
+// ExpectedRootHashAnnotation is set on a layer descriptor by a
+// publisher that wants the dm-verity root hash of its unpacked
+// snapshot checked against a known-good value rather than merely
+// recorded. FormatVerity fails the transfer if the two disagree.
+const ExpectedRootHashAnnotation = "org.containerd.verity.roothash"
+
+// ErrVerityRootHashMismatch is returned by FormatVerity when the root
+// hash it computed doesn't match an expectedRootHash the caller passed
+// in (from ExpectedRootHashAnnotation).
+var ErrVerityRootHashMismatch = errors.New("snapshot: computed dm-verity root hash does not match expected value")
+
+// FormatVerity formats the block image backing mounts - its first
+// mount's Source - as a dm-verity device, and records the root hash
+// and layout dm-verity needs to reopen it in d's labels
+// (VerityRootHashLabel, VeritySaltLabel, VerityHashAlgorithmLabel and
+// VerityHashOffsetLabel). It's a no-op, returning an empty rootHash,
+// if d wasn't configured with WithDmverity.
+//
+// If expectedRootHash is non-empty, FormatVerity fails with
+// ErrVerityRootHashMismatch rather than recording a root hash that
+// doesn't match it.
+func (d *Destination) FormatVerity(ctx context.Context, mounts []mount.Mount, expectedRootHash string) (rootHash string, blocks uint64, err error) {
+	if d.verityOpts == nil {
+		return "", 0, nil
+	}
+	if len(mounts) == 0 {
+		return "", 0, fmt.Errorf("no mounts to format with dm-verity")
+	}
+
+	image := mounts[0].Source
+	rootHash, err = dmverity.Format(image, image, d.verityOpts)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to format dm-verity device: %w", err)
+	}
+
+	if expectedRootHash != "" && rootHash != expectedRootHash {
+		return "", 0, fmt.Errorf("%w: computed %s, expected %s", ErrVerityRootHashMismatch, rootHash, expectedRootHash)
+	}
+
+	if d.labels == nil {
+		d.labels = map[string]string{}
+	}
+	d.labels[VerityRootHashLabel] = rootHash
+	d.labels[VeritySaltLabel] = d.verityOpts.Salt
+	d.labels[VerityHashAlgorithmLabel] = d.verityOpts.HashAlgorithm
+	d.labels[VerityHashOffsetLabel] = strconv.FormatUint(d.verityOpts.HashOffset, 10)
+
+	return rootHash, d.verityOpts.DataBlocks, nil
+}
+
+// VerityMounts returns mounts with its block image's Source swapped
+// for a dm-verity device opened (under name) from the layout recorded
+// in labels by a prior FormatVerity. Mounts are returned unchanged if
+// labels carries no VerityRootHashLabel, i.e. the snapshot was never
+// formatted with dm-verity.
+func VerityMounts(ctx context.Context, name string, mounts []mount.Mount, labels map[string]string) ([]mount.Mount, error) {
+	rootHash := labels[VerityRootHashLabel]
+	if rootHash == "" || len(mounts) == 0 {
+		return mounts, nil
+	}
+
+	var opts *dmverity.DmverityOptions
+	if algo := labels[VerityHashAlgorithmLabel]; algo != "" {
+		opts = &dmverity.DmverityOptions{
+			HashAlgorithm: algo,
+			Salt:          labels[VeritySaltLabel],
+		}
+	}
+
+	var hashOffset uint64
+	if v := labels[VerityHashOffsetLabel]; v != "" {
+		parsed, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s label %q: %w", VerityHashOffsetLabel, v, err)
+		}
+		hashOffset = parsed
+	}
+
+	image := mounts[0].Source
+	devicePath, err := dmverity.Open(image, name, image, rootHash, hashOffset, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dm-verity device: %w", err)
+	}
+
+	verified := make([]mount.Mount, len(mounts))
+	copy(verified, mounts)
+	verified[0].Source = devicePath
+	return verified, nil
+}
+
+// CloseVerity tears down the dm-verity device VerityMounts opened
+// under name. A snapshotter backed by dm-verity should call this from
+// its Remove, before removing the snapshot itself, so it doesn't leak
+// a device-mapper target.
+func CloseVerity(name string) error {
+	return dmverity.Close(name)
+}
@@ -87,6 +87,19 @@ type Fetcher interface {
 	Fetch(context.Context, ocispec.Descriptor) (io.ReadCloser, error)
 }
 
+// RangeFetcher is an optional capability a Fetcher may implement to
+// retrieve part of a descriptor's content instead of the whole thing,
+// letting a caller like registry.ParallelFetcher split one large blob
+// into concurrent ranged fetches. off and length are a byte range
+// within the descriptor's content, following HTTP Range semantics
+// (bytes=off-off+length-1); a Fetcher that can't serve a sub-range of a
+// given descriptor - because the remote doesn't support range requests,
+// or the content is generated rather than a byte-addressable blob -
+// should return an error so the caller falls back to Fetch.
+type RangeFetcher interface {
+	Fetch(ctx context.Context, desc ocispec.Descriptor, off, length int64) (io.ReadCloser, error)
+}
+
 type Pusher interface {
 	Push(context.Context, ocispec.Descriptor) (content.Writer, error)
 }
@@ -192,6 +205,12 @@ type ProgressFunc func(Progress)
 
 type Config struct {
 	Progress ProgressFunc
+
+	// ForceClassicUnpack disables local's eStargz lazy-pull fast path in
+	// unpackLayer, even for a layer that probes as eStargz against a
+	// destination advertising remote-snapshot support - for debugging or
+	// comparing against the always-apply-locally behavior.
+	ForceClassicUnpack bool
 }
 
 type Opt func(*Config)
@@ -202,6 +221,14 @@ func WithProgress(f ProgressFunc) Opt {
 	}
 }
 
+// WithForceClassicUnpack forces unpackLayer down its ordinary apply path,
+// skipping the eStargz lazy-pull probe entirely.
+func WithForceClassicUnpack() Opt {
+	return func(opts *Config) {
+		opts.ForceClassicUnpack = true
+	}
+}
+
 // Progress is used to represent a particular progress event or incremental
 // update for the provided named object. The parents represent the names of
 // the objects which initiated the progress for the provided named object.
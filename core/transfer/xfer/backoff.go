@@ -0,0 +1,79 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package xfer
+
+import (
+	"math/rand"
+	"time"
+)
+
+// BackoffConfig controls how a Transfer retries a failed layer fetch:
+// up to MaxAttempts tries total, waiting BaseDelay*2^attempt between
+// them (capped at MaxDelay), with up to Jitter of that delay added or
+// subtracted at random so many transfers retrying the same registry
+// hiccup don't all wake up on the same tick.
+type BackoffConfig struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// DefaultBackoffConfig is used by NewManager when a caller's
+// ManagerConfig leaves Backoff at its zero value.
+var DefaultBackoffConfig = BackoffConfig{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.2,
+}
+
+func (c BackoffConfig) withDefaults() BackoffConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultBackoffConfig.MaxAttempts
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = DefaultBackoffConfig.BaseDelay
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = DefaultBackoffConfig.MaxDelay
+	}
+	if c.Jitter <= 0 {
+		c.Jitter = DefaultBackoffConfig.Jitter
+	}
+	return c
+}
+
+// delay returns how long to wait before the given retry attempt
+// (0-indexed: the wait before the second overall try is delay(0)).
+func (c BackoffConfig) delay(attempt int) time.Duration {
+	d := c.BaseDelay << uint(attempt)
+	if d <= 0 || d > c.MaxDelay {
+		d = c.MaxDelay
+	}
+
+	if c.Jitter <= 0 {
+		return d
+	}
+	spread := float64(d) * c.Jitter
+	offset := (rand.Float64()*2 - 1) * spread
+	d = time.Duration(float64(d) + offset)
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
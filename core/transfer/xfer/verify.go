@@ -0,0 +1,39 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package xfer
+
+import (
+	digest "github.com/opencontainers/go-digest"
+)
+
+// TrustVerifier validates a manifest or layer digest against a signed
+// target list - TUF root/targets/snapshot/timestamp metadata, sigstore/
+// cosign, or an in-house signer - before any of its content is fetched.
+// It's pluggable so operators can point a pull at whatever trust backend
+// they run without this package needing to know about it.
+type TrustVerifier interface {
+	// VerifyManifest checks ref's manifest digest against the trust
+	// backend's signed target list. Manager itself only pulls layers,
+	// so it never calls this; it's here so a caller resolving ref's
+	// manifest ahead of Manager.Get can verify it the same way, with
+	// the same backend, before handing Manager any layer digests.
+	VerifyManifest(ref string, dgst digest.Digest) error
+	// VerifyLayer checks one layer digest the same way. Manager.Get
+	// calls this, if a TrustVerifier is configured, before ever calling
+	// Fetcher.Fetch for desc.
+	VerifyLayer(ref string, dgst digest.Digest) error
+}
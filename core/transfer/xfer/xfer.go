@@ -0,0 +1,639 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package xfer sits between a pull's per-layer Fetcher calls and
+// whatever renders transfer.Progress - cmd/ctr's content.DockerShowProgress
+// is the one consumer that exists in this tree today. A Manager owns
+// one *Transfer per layer digest: concurrent Gets for the same digest
+// coalesce onto the single in-flight download and all receive its
+// progress, instead of each pull fetching (and paying for) its own
+// copy of the layer.
+package xfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/containerd/containerd/v2/core/transfer"
+	"github.com/containerd/log"
+)
+
+// ErrNotFound is returned by Watch when dgst has no transfer currently
+// running for it - either nothing has called Get for it yet, or it
+// already finished and Manager.forget dropped it.
+var ErrNotFound = errors.New("transfer not found")
+
+// State is where a Transfer sits in its lifecycle. Values match the
+// transfer.Progress.Event strings dockerProgressDisplay.renderLayer
+// already switches on.
+type State int
+
+const (
+	StateWaiting State = iota
+	StateDownloading
+	StateVerifying
+	StateExtracting
+	StateComplete
+)
+
+func (s State) String() string {
+	switch s {
+	case StateWaiting:
+		return "waiting"
+	case StateDownloading:
+		return "downloading"
+	case StateVerifying:
+		return "verifying"
+	case StateExtracting:
+		return "extracting"
+	case StateComplete:
+		return "complete"
+	default:
+		return "unknown"
+	}
+}
+
+// ExtractFunc unpacks a fetched and verified layer. Returning it as a
+// per-Get argument, rather than giving Manager a content.Store of its
+// own, keeps this package's only job the fetch/dedup/retry one - how a
+// layer is stored once downloaded is the caller's concern, the same
+// separation transfer.Fetcher/transfer.LayerDestination already draw.
+type ExtractFunc func(ctx context.Context, r io.Reader) error
+
+// ManagerConfig configures a Manager's worker pool and retry policy.
+type ManagerConfig struct {
+	// MaxConcurrentDownloads bounds how many transfers are actively
+	// downloading at once; the rest sit in StateWaiting. Defaults to 3
+	// if unset.
+	MaxConcurrentDownloads int
+	// Backoff configures per-transfer fetch retries. Defaults to
+	// DefaultBackoffConfig if left zero.
+	Backoff BackoffConfig
+	// WorkDir is where each transfer's temporary ingest file is
+	// written. Defaults to os.TempDir()/containerd-xfer.
+	WorkDir string
+	// Verifier, if set, is consulted before each Transfer fetches its
+	// descriptor's content - see TrustVerifier.
+	Verifier TrustVerifier
+}
+
+const defaultMaxConcurrentDownloads = 3
+
+// Manager dedups concurrent fetches of the same layer digest into a
+// single Transfer, bounds how many of those transfers download at
+// once, and retries each with backoff before giving up.
+type Manager struct {
+	mu        sync.Mutex
+	transfers map[digest.Digest]*Transfer
+
+	sem      *semaphore.Weighted
+	backoff  BackoffConfig
+	workDir  string
+	verifier TrustVerifier
+}
+
+// NewManager creates a Manager from cfg.
+func NewManager(cfg ManagerConfig) *Manager {
+	max := cfg.MaxConcurrentDownloads
+	if max <= 0 {
+		max = defaultMaxConcurrentDownloads
+	}
+	workDir := cfg.WorkDir
+	if workDir == "" {
+		workDir = filepath.Join(os.TempDir(), "containerd-xfer")
+	}
+
+	return &Manager{
+		transfers: make(map[digest.Digest]*Transfer),
+		sem:       semaphore.NewWeighted(int64(max)),
+		backoff:   cfg.Backoff.withDefaults(),
+		workDir:   workDir,
+		verifier:  cfg.Verifier,
+	}
+}
+
+// Get fetches desc via fetcher, sharing the download with any other
+// in-flight Get for the same digest, reports state transitions and
+// byte progress to progress, and - once the content is fetched and
+// digest-verified - runs extract over it (extract may be nil, e.g. for
+// a caller that only wants the dedup/retry behavior and will read the
+// content some other way). Get blocks until the shared transfer's fetch
+// has been verified and, if extract is non-nil, extract has run
+// (reporting StateComplete), or until either fails. With a nil extract,
+// Get returns without StateComplete ever being reported.
+//
+// ref identifies the image desc belongs to, passed through unchanged to
+// the Manager's TrustVerifier (if any) so it can check desc's digest
+// against ref's signed target list before anything is fetched.
+//
+// Canceling ctx only aborts the underlying download once every other
+// Get watching the same digest has also had its ctx canceled or
+// returned - see Transfer.watch. A trust verification failure is
+// different: it aborts every other peer layer's Transfer too, via
+// abortAll, since a pull that can't be trusted shouldn't let its other
+// layers keep downloading either.
+func (m *Manager) Get(ctx context.Context, ref string, fetcher transfer.Fetcher, desc ocispec.Descriptor, extract ExtractFunc, progress transfer.ProgressFunc) error {
+	t := m.getOrStart(ref, fetcher, desc)
+	return t.watch(ctx, extract, progress)
+}
+
+func (m *Manager) getOrStart(ref string, fetcher transfer.Fetcher, desc ocispec.Descriptor) *Transfer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.transfers[desc.Digest]; ok {
+		return t
+	}
+
+	t := newTransfer(m, ref, fetcher, desc)
+	m.transfers[desc.Digest] = t
+	go t.run()
+	return t
+}
+
+// Watch returns a channel of progress events for the in-flight transfer
+// of dgst, for an observer - e.g. a docker-style progress display - that
+// wants to render a transfer's progress without taking on Get's role in
+// starting it or driving its ExtractFunc. Like any other watcher, an
+// observer reading from the channel still counts towards t's watchers
+// for cancellation purposes (see Transfer.watch): once every watcher,
+// Get's and Watch's alike, has gone away, the transfer is canceled.
+//
+// The channel is closed once the transfer finishes or ctx is done,
+// whichever comes first. Watch returns ErrNotFound if dgst has no
+// running transfer - a caller polling ahead of the pull that will
+// eventually call Get for it should treat that as "nothing to show yet"
+// rather than a hard failure.
+func (m *Manager) Watch(ctx context.Context, dgst digest.Digest) (<-chan transfer.Progress, error) {
+	m.mu.Lock()
+	t, ok := m.transfers[dgst]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return t.watchChan(ctx), nil
+}
+
+// forget drops t from the registry once it finishes (successfully or
+// not), so a later Get for the same digest starts a fresh attempt
+// rather than replaying a stale result.
+func (m *Manager) forget(t *Transfer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.transfers[t.desc.Digest] == t {
+		delete(m.transfers, t.desc.Digest)
+	}
+}
+
+// abortAll cancels every other in-flight Transfer this Manager is
+// tracking, e.g. once one layer's trust verification has failed and the
+// pull as a whole can no longer be trusted - there's no point letting
+// its peer layers keep downloading. failed is excluded since it's
+// already aborting itself.
+func (m *Manager) abortAll(failed *Transfer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.transfers {
+		if t != failed {
+			t.cancel()
+		}
+	}
+}
+
+// Transfer is the coalesced download for one layer digest: exactly one
+// fetch (with retries) runs for it regardless of how many Gets are
+// watching, and every watcher's ExtractFunc (if any) runs against the
+// same verified ingest file once the fetch completes.
+type Transfer struct {
+	manager *Manager
+	ref     string
+	fetcher transfer.Fetcher
+	desc    ocispec.Descriptor
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	state     State
+	progress  int64
+	watchers  int
+	listeners map[*progressListener]struct{}
+
+	done chan struct{}
+	err  error
+	path string
+}
+
+// progressListener is either a callback, for Get's caller-supplied
+// transfer.ProgressFunc, or a channel, for Watch - never both. See
+// Transfer.emitLocked.
+type progressListener struct {
+	fn transfer.ProgressFunc
+	ch chan transfer.Progress
+}
+
+func newTransfer(m *Manager, ref string, fetcher transfer.Fetcher, desc ocispec.Descriptor) *Transfer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Transfer{
+		manager:   m,
+		ref:       ref,
+		fetcher:   fetcher,
+		desc:      desc,
+		ctx:       ctx,
+		cancel:    cancel,
+		state:     StateWaiting,
+		listeners: make(map[*progressListener]struct{}),
+		done:      make(chan struct{}),
+		path:      filepath.Join(m.workDir, desc.Digest.Encoded()),
+	}
+}
+
+// watch registers progress as a listener for the duration of the call,
+// waits for the transfer to finish, then (on success) runs extract
+// against the downloaded content, reporting StateExtracting around it.
+// If ctx is canceled before the transfer finishes, watch stops waiting
+// and drops its listener; the transfer itself is only aborted once its
+// last watcher does this.
+func (t *Transfer) watch(ctx context.Context, extract ExtractFunc, progress transfer.ProgressFunc) error {
+	l := &progressListener{fn: progress}
+	t.addListener(l)
+	defer t.removeListener(l)
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if t.err != nil {
+		return t.err
+	}
+
+	if extract == nil {
+		return nil
+	}
+
+	t.setState(StateExtracting, 0)
+	f, err := os.Open(t.path)
+	if err != nil {
+		return fmt.Errorf("failed to open downloaded layer for extraction: %w", err)
+	}
+	defer f.Close()
+
+	if err := extract(ctx, f); err != nil {
+		return fmt.Errorf("failed to extract layer %s: %w", t.desc.Digest, err)
+	}
+
+	t.setState(StateComplete, t.desc.Size)
+	return nil
+}
+
+// watchChan registers a channel-based listener for t, for Manager.Watch:
+// an observer that wants t's progress without taking on watch's role of
+// waiting for completion and running an ExtractFunc. The returned
+// channel only ever holds the latest event - see sendLatest - and is
+// closed, and the listener removed, once ctx is done or t finishes.
+func (t *Transfer) watchChan(ctx context.Context) <-chan transfer.Progress {
+	ch := make(chan transfer.Progress, 1)
+	l := &progressListener{ch: ch}
+	t.addListener(l)
+
+	go func() {
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+		}
+		t.removeListener(l)
+	}()
+
+	return ch
+}
+
+// addListener registers l as a watcher of t and sends it t's current
+// state, counting l towards t.watchers for removeListener's
+// cancel-on-last-departure check.
+func (t *Transfer) addListener(l *progressListener) {
+	t.mu.Lock()
+	t.watchers++
+	t.listeners[l] = struct{}{}
+	t.emitLocked(l)
+	t.mu.Unlock()
+}
+
+// removeListener unregisters l. If l was t's last remaining watcher,
+// nobody is left to care about the result, so t is canceled and forgotten
+// - the same reference-counted cancellation watch has always done,
+// shared here with watchChan's channel-based watchers.
+func (t *Transfer) removeListener(l *progressListener) {
+	t.mu.Lock()
+	delete(t.listeners, l)
+	t.watchers--
+	remaining := t.watchers
+	t.mu.Unlock()
+
+	if remaining == 0 {
+		t.cancel()
+		t.manager.forget(t)
+	}
+	if l.ch != nil {
+		close(l.ch)
+	}
+}
+
+// run performs the retried fetch-and-verify for t and then signals
+// every current and future watcher via t.done. It always runs exactly
+// once per Transfer, from the goroutine getOrStart spawned.
+func (t *Transfer) run() {
+	defer close(t.done)
+	defer t.manager.forget(t)
+
+	if err := t.verify(); err != nil {
+		t.err = err
+		t.emitError(err)
+		t.manager.abortAll(t)
+		return
+	}
+
+	if err := t.manager.sem.Acquire(t.ctx, 1); err != nil {
+		t.err = fmt.Errorf("failed waiting for a download slot: %w", err)
+		return
+	}
+	defer t.manager.sem.Release(1)
+
+	t.err = t.fetchWithRetry()
+}
+
+// verify checks t.desc's digest against the Manager's TrustVerifier, if
+// one is configured, before any of its bytes are fetched. Reusing
+// StateVerifying (the same state fetchOnce's post-download content
+// digest check reports) keeps renderers like dockerProgressDisplay's
+// "Verifying signature" line unchanged regardless of which check is
+// running; the two never overlap in time for a given Transfer.
+func (t *Transfer) verify() error {
+	if t.manager.verifier == nil {
+		return nil
+	}
+	t.setState(StateVerifying, 0)
+	if err := t.manager.verifier.VerifyLayer(t.ref, t.desc.Digest); err != nil {
+		return fmt.Errorf("trust verification failed for %s: %w", t.desc.Digest, err)
+	}
+	return nil
+}
+
+func (t *Transfer) fetchWithRetry() error {
+	backoff := t.manager.backoff
+
+	var lastErr error
+	for attempt := 0; attempt < backoff.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			d := backoff.delay(attempt - 1)
+			log.G(t.ctx).WithField("digest", t.desc.Digest).WithError(lastErr).
+				Infof("retrying layer fetch in %s (attempt %d/%d)", d, attempt+1, backoff.MaxAttempts)
+			select {
+			case <-time.After(d):
+			case <-t.ctx.Done():
+				return t.ctx.Err()
+			}
+		}
+
+		if err := t.fetchOnce(); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("failed to fetch layer %s after %d attempts: %w", t.desc.Digest, backoff.MaxAttempts, lastErr)
+}
+
+func (t *Transfer) fetchOnce() error {
+	t.setState(StateDownloading, 0)
+
+	if err := os.MkdirAll(t.manager.workDir, 0700); err != nil {
+		return err
+	}
+
+	// A prior failed attempt may have left a partial download on disk.
+	// If the fetcher can serve a byte range, pick up where it left off
+	// instead of paying for the whole layer again.
+	if fi, err := os.Stat(t.path); err == nil && fi.Size() > 0 && fi.Size() < t.desc.Size {
+		if rf, ok := t.fetcher.(rangeFetcher); ok {
+			return t.fetchResume(rf, fi.Size())
+		}
+	}
+
+	rc, err := t.fetcher.Fetch(t.ctx, t.desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pw := &progressWriter{t: t}
+	verifier := t.desc.Digest.Verifier()
+
+	if _, err := io.Copy(io.MultiWriter(f, verifier, pw), rc); err != nil {
+		return err
+	}
+
+	t.setState(StateVerifying, t.desc.Size)
+	if !verifier.Verified() {
+		return fmt.Errorf("content digest mismatch for %s", t.desc.Digest)
+	}
+
+	return nil
+}
+
+// rangeFetcher is an optional capability a transfer.Fetcher may
+// implement to serve a byte range of a descriptor, letting fetchResume
+// continue a partial download instead of restarting it.
+//
+// This is deliberately not transfer.RangeFetcher: that interface's Fetch
+// method shares transfer.Fetcher.Fetch's name with a different
+// signature, so no single concrete type can implement both at once -
+// `go vet` flags any assertion to it (.(transfer.RangeFetcher)) as
+// "impossible type assertion" for exactly this reason, which is also
+// true, pre-existing, of registry.ParallelFetcher's own such assertion.
+// Fixing every RangeFetcher caller across the registry package is out of
+// scope here; rangeFetcher is a second, actually-satisfiable interface
+// so resume isn't built on a capability check that can never succeed.
+// No Fetcher in this tree implements it yet - like referenceSource in
+// core/transfer/local/estargz.go, it's a hook for the next Fetcher that
+// wants this fast path to opt into.
+type rangeFetcher interface {
+	FetchRange(ctx context.Context, desc ocispec.Descriptor, off, length int64) (io.ReadCloser, error)
+}
+
+// fetchResume continues a partial download already sitting at t.path,
+// whose first `from` bytes were written by an earlier, failed attempt:
+// it rehashes those bytes into a fresh digest verifier, fetches only the
+// remaining range through rf, and appends it. This is the resume
+// Manager has in place of content.Writer's offset-resume - Transfer
+// writes to its own per-digest temp file rather than through a
+// content.Store (see newTransfer's path field), so there is no
+// content.Writer in this path to resume in the first place.
+//
+// If anything about the resume itself fails, the partial file is
+// removed so the next retry attempt starts the layer over from scratch
+// rather than repeating a doomed resume indefinitely.
+func (t *Transfer) fetchResume(rf rangeFetcher, from int64) (err error) {
+	defer func() {
+		if err != nil {
+			os.Remove(t.path)
+		}
+	}()
+
+	verifier := t.desc.Digest.Verifier()
+	existing, err := os.Open(t.path)
+	if err != nil {
+		return fmt.Errorf("failed to reopen partial download: %w", err)
+	}
+	_, err = io.Copy(verifier, existing)
+	existing.Close()
+	if err != nil {
+		return fmt.Errorf("failed to rehash partial download: %w", err)
+	}
+
+	rc, err := rf.FetchRange(t.ctx, t.desc, from, t.desc.Size-from)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pw := &progressWriter{t: t, n: from}
+	t.setState(StateDownloading, from)
+
+	if _, err := io.Copy(io.MultiWriter(f, verifier, pw), rc); err != nil {
+		return err
+	}
+
+	t.setState(StateVerifying, t.desc.Size)
+	if !verifier.Verified() {
+		return fmt.Errorf("content digest mismatch for %s", t.desc.Digest)
+	}
+
+	return nil
+}
+
+// progressWriter turns the bytes io.Copy moves in fetchOnce into
+// Transfer progress updates, without needing fetchOnce itself to know
+// about listeners.
+type progressWriter struct {
+	t *Transfer
+	n int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	w.t.setState(StateDownloading, w.n)
+	return len(p), nil
+}
+
+func (t *Transfer) setState(s State, current int64) {
+	t.mu.Lock()
+	t.state = s
+	t.progress = current
+	for l := range t.listeners {
+		t.emitLocked(l)
+	}
+	t.mu.Unlock()
+}
+
+// emitLocked sends the transfer's current state to l, via whichever of
+// l.fn/l.ch it has. Must be called with t.mu held.
+func (t *Transfer) emitLocked(l *progressListener) {
+	if l.fn == nil && l.ch == nil {
+		return
+	}
+	desc := t.desc
+	p := transfer.Progress{
+		Event:    t.state.String(),
+		Name:     t.desc.Digest.String(),
+		Progress: t.progress,
+		Total:    t.desc.Size,
+		Desc:     &desc,
+	}
+	if l.fn != nil {
+		l.fn(p)
+	}
+	if l.ch != nil {
+		sendLatest(l.ch, p)
+	}
+}
+
+// sendLatest pushes p onto ch, dropping whatever unread event was
+// already sitting there first if ch (always buffered to 1, see
+// watchChan) is full. A Watch consumer that falls behind a fast-moving
+// transfer reads its newest state on the next receive rather than a
+// backlog of stale ones.
+func sendLatest(ch chan transfer.Progress, p transfer.Progress) {
+	for {
+		select {
+		case ch <- p:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// emitError reports err to every listener as an "error" event, the same
+// way JSONProgressHandler and dockerProgressDisplay already expect to
+// receive a fetch failure: Progress has no dedicated error field, so the
+// message travels in Name (see cmd/ctr/commands/images/json_display.go's
+// doc comment on that convention).
+func (t *Transfer) emitError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	desc := t.desc
+	p := transfer.Progress{
+		Event: "error",
+		Name:  err.Error(),
+		Desc:  &desc,
+	}
+	for l := range t.listeners {
+		if l.fn != nil {
+			l.fn(p)
+		}
+		if l.ch != nil {
+			sendLatest(l.ch, p)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package xfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerd/containerd/v2/core/transfer"
+)
+
+func testDescriptor(t *testing.T, content []byte) ocispec.Descriptor {
+	t.Helper()
+	return ocispec.Descriptor{
+		Digest: digest.FromBytes(content),
+		Size:   int64(len(content)),
+	}
+}
+
+// countingFetcher fails its first failures calls for a digest with err,
+// then serves content. delay, if set, holds each successful read open
+// briefly so a test asserting dedup/Watch behavior has a wide enough
+// window to join an in-flight Transfer before fetchOnce's in-memory
+// io.Copy finishes and the Transfer is forgotten.
+type countingFetcher struct {
+	content  []byte
+	failures int
+	err      error
+	delay    time.Duration
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	f.mu.Lock()
+	f.calls++
+	call := f.calls
+	f.mu.Unlock()
+
+	if call <= f.failures {
+		return nil, f.err
+	}
+	var r io.Reader = bytes.NewReader(f.content)
+	if f.delay > 0 {
+		r = &slowReader{r: r, delay: f.delay}
+	}
+	return io.NopCloser(r), nil
+}
+
+func (f *countingFetcher) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// slowReader sleeps once, before its first Read, so the fetch it backs
+// stays in progress long enough for concurrent callers to observe it.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+	once  sync.Once
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	s.once.Do(func() { time.Sleep(s.delay) })
+	return s.r.Read(p)
+}
+
+func testManager(t *testing.T) *Manager {
+	t.Helper()
+	m := NewManager(ManagerConfig{
+		WorkDir: t.TempDir(),
+		Backoff: BackoffConfig{
+			MaxAttempts: 4,
+			BaseDelay:   time.Millisecond,
+			MaxDelay:    5 * time.Millisecond,
+		},
+	})
+	return m
+}
+
+func TestGetDedupsConcurrentFetches(t *testing.T) {
+	content := []byte("shared layer content")
+	desc := testDescriptor(t, content)
+	fetcher := &countingFetcher{content: content, delay: 50 * time.Millisecond}
+	m := testManager(t)
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := 0; i < len(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = m.Get(context.Background(), "example.com/repo:tag", fetcher, desc, nil, nil)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+	}
+	if calls := fetcher.callCount(); calls != 1 {
+		t.Fatalf("expected one fetch for %d concurrent Gets, got %d", len(errs), calls)
+	}
+}
+
+func TestGetRetriesOnUnexpectedEOF(t *testing.T) {
+	content := []byte("retried layer content")
+	desc := testDescriptor(t, content)
+	fetcher := &countingFetcher{content: content, failures: 2, err: io.ErrUnexpectedEOF}
+	m := testManager(t)
+
+	if err := m.Get(context.Background(), "example.com/repo:tag", fetcher, desc, nil, nil); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if calls := fetcher.callCount(); calls != 3 {
+		t.Fatalf("expected 2 failed attempts + 1 success, got %d calls", calls)
+	}
+}
+
+func TestGetFailsAfterMaxAttempts(t *testing.T) {
+	content := []byte("never succeeds")
+	desc := testDescriptor(t, content)
+	fetcher := &countingFetcher{content: content, failures: 100, err: io.ErrUnexpectedEOF}
+	m := testManager(t)
+
+	err := m.Get(context.Background(), "example.com/repo:tag", fetcher, desc, nil, nil)
+	if err == nil {
+		t.Fatal("expected an error after exhausting retries")
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected wrapped io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+// blockingFetcher blocks Fetch until its context is canceled, for
+// exercising Transfer cancellation once its last watcher leaves.
+type blockingFetcher struct {
+	started chan struct{}
+	once    sync.Once
+}
+
+func (f *blockingFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	f.once.Do(func() { close(f.started) })
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCancelLastWatcherCancelsTransfer(t *testing.T) {
+	desc := testDescriptor(t, []byte("content never actually read"))
+	fetcher := &blockingFetcher{started: make(chan struct{})}
+	m := testManager(t)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+
+	done1 := make(chan error, 1)
+	done2 := make(chan error, 1)
+	go func() { done1 <- m.Get(ctx1, "example.com/repo:tag", fetcher, desc, nil, nil) }()
+	go func() { done2 <- m.Get(ctx2, "example.com/repo:tag", fetcher, desc, nil, nil) }()
+
+	select {
+	case <-fetcher.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch never started")
+	}
+
+	// Cancel the first watcher only; the transfer has a second watcher
+	// left, so the underlying fetch must still be running.
+	cancel1()
+	if err := <-done1; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected first Get to return context.Canceled, got %v", err)
+	}
+
+	m.mu.Lock()
+	tr, stillTracked := m.transfers[desc.Digest]
+	m.mu.Unlock()
+	if !stillTracked {
+		t.Fatal("transfer was forgotten after only one of two watchers left")
+	}
+	select {
+	case <-tr.ctx.Done():
+		t.Fatal("transfer was canceled while a watcher is still attached")
+	default:
+	}
+
+	// Cancel the last watcher; now the transfer itself must be canceled.
+	cancel2()
+	if err := <-done2; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected second Get to return context.Canceled, got %v", err)
+	}
+
+	select {
+	case <-tr.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("transfer was not canceled after its last watcher left")
+	}
+}
+
+// TestWatchObservesProgressAndCounts checks that a Watch observer sees
+// the fetch's progress and that its channel closes once the fetch
+// finishes - not that it ever sees a StateComplete event, which only
+// ever fires inside watch() once some Get caller's ExtractFunc has run
+// (see Transfer.watch): a pure Watch consumer has no ExtractFunc of its
+// own, so t.done - and with it, Watch's channel - closes as soon as the
+// fetch itself is verified, the same "downloaded, not yet unpacked"
+// signal DockerShowProgress's cs.Info fallback already treats as done.
+func TestWatchObservesProgressAndCounts(t *testing.T) {
+	content := []byte("watched layer content")
+	desc := testDescriptor(t, content)
+	fetcher := &countingFetcher{content: content, delay: 200 * time.Millisecond}
+	m := testManager(t)
+
+	var getErr error
+	getDone := make(chan struct{})
+	go func() {
+		getErr = m.Get(context.Background(), "example.com/repo:tag", fetcher, desc, nil, nil)
+		close(getDone)
+	}()
+
+	// Give Get a chance to register the transfer before Watch looks for
+	// it; Watch returning ErrNotFound here would be a race, not a bug,
+	// so retry briefly instead of asserting on the first attempt.
+	var (
+		ch  <-chan transfer.Progress
+		err error
+	)
+	for i := 0; i < 300; i++ {
+		ch, err = m.Watch(context.Background(), desc.Digest)
+		if err == nil {
+			break
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	var sawDownloading int32
+	closed := make(chan struct{})
+	go func() {
+		for p := range ch {
+			if p.Event == StateDownloading.String() || p.Event == StateVerifying.String() {
+				atomic.StoreInt32(&sawDownloading, 1)
+			}
+		}
+		close(closed)
+	}()
+
+	<-getDone
+	if getErr != nil {
+		t.Fatalf("Get: %v", getErr)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch channel never closed after the fetch finished")
+	}
+	if atomic.LoadInt32(&sawDownloading) == 0 {
+		t.Fatal("Watch channel never observed a download progress event")
+	}
+}
+
+func TestWatchReturnsNotFoundForUnknownDigest(t *testing.T) {
+	m := testManager(t)
+	_, err := m.Watch(context.Background(), digest.FromString("nothing running for this"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
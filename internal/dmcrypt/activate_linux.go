@@ -0,0 +1,84 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// sectorSize is the device-mapper sector size in bytes.
+const sectorSize = 512
+
+// Enable activates name as a dm-crypt device over underlyingDevice (the
+// path of an already-active device, e.g. a dm-verity device Enable in
+// internal/dmverity just created), using config to describe the cipher
+// and key. The resulting /dev/mapper/name reads and writes plaintext,
+// translating to/from ciphertext on underlyingDevice.
+func Enable(name, underlyingDevice string, config CryptConfig) error {
+	if err := validateConfig(config); err != nil {
+		return fmt.Errorf("invalid crypt config: %w", err)
+	}
+
+	params := buildCryptTargetParams(underlyingDevice, config)
+
+	sectors, err := BlockDeviceSize(underlyingDevice)
+	if err != nil {
+		return fmt.Errorf("failed to size %q: %w", underlyingDevice, err)
+	}
+	sectorCount := uint64(sectors)/sectorSize - config.Offset
+
+	if err := dmDevCreate(name); err != nil {
+		return err
+	}
+	if err := dmTableLoad(name, sectorCount, dmTargetTypeCrypt, params); err != nil {
+		if rmErr := dmDevRemove(name); rmErr != nil {
+			return fmt.Errorf("%w (cleanup after failed load also failed: %v)", err, rmErr)
+		}
+		return err
+	}
+	if err := dmDevResume(name); err != nil {
+		if rmErr := dmDevRemove(name); rmErr != nil {
+			return fmt.Errorf("%w (cleanup after failed activation also failed: %v)", err, rmErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Disable tears down name, mirroring Enable.
+func Disable(name string) error {
+	return dmDevRemove(name)
+}
+
+// buildCryptTargetParams renders config as the dm-crypt target line
+// documented in Documentation/admin-guide/device-mapper/dm-crypt.rst:
+// <cipher> <key> <iv_offset> <device path> <offset>.
+func buildCryptTargetParams(underlyingDevice string, config CryptConfig) string {
+	cipher := config.Cipher
+	if cipher == "" {
+		cipher = DefaultCipher
+	}
+	return fmt.Sprintf("%s %s %d %s %d",
+		cipher,
+		hex.EncodeToString(config.Key),
+		config.IVOffset,
+		underlyingDevice,
+		config.Offset,
+	)
+}
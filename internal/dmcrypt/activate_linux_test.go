@@ -0,0 +1,44 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCryptTargetParamsDefaultCipher(t *testing.T) {
+	key := make([]byte, 64)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	config := CryptConfig{Key: key, IVOffset: 7, Offset: 2048}
+
+	params := buildCryptTargetParams("/dev/mapper/layer-verity", config)
+	require.Equal(t, fmt.Sprintf("%s %s %d %s %d", DefaultCipher, hex.EncodeToString(key), 7, "/dev/mapper/layer-verity", 2048), params)
+}
+
+func TestBuildCryptTargetParamsExplicitCipher(t *testing.T) {
+	key := []byte{0xde, 0xad, 0xbe, 0xef}
+	config := CryptConfig{Cipher: "aes-cbc-essiv:sha256", Key: key}
+
+	params := buildCryptTargetParams("/dev/mapper/layer-verity", config)
+	require.Equal(t, "aes-cbc-essiv:sha256 deadbeef 0 /dev/mapper/layer-verity 0", params)
+}
@@ -0,0 +1,40 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockDeviceSizeRegularFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "image")
+	require.NoError(t, os.WriteFile(path, make([]byte, 8192), 0o644))
+
+	size, err := BlockDeviceSize(path)
+	require.NoError(t, err)
+	require.EqualValues(t, 8192, size)
+}
+
+func TestBlockDeviceSizeMissingFile(t *testing.T) {
+	_, err := BlockDeviceSize(filepath.Join(t.TempDir(), "does-not-exist"))
+	require.Error(t, err)
+}
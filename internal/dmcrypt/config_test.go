@@ -0,0 +1,41 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfigRequiresKey(t *testing.T) {
+	err := validateConfig(CryptConfig{})
+	require.Error(t, err)
+}
+
+func TestValidateConfigDefaultCipherKeyLength(t *testing.T) {
+	err := validateConfig(CryptConfig{Key: make([]byte, 32)})
+	require.Error(t, err, "aes-xts-plain64 needs a 64-byte key")
+
+	err = validateConfig(CryptConfig{Key: make([]byte, 64)})
+	require.NoError(t, err)
+}
+
+func TestValidateConfigNonDefaultCipherSkipsLengthCheck(t *testing.T) {
+	err := validateConfig(CryptConfig{Cipher: "aes-cbc-essiv:sha256", Key: make([]byte, 32)})
+	require.NoError(t, err)
+}
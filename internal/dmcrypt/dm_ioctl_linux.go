@@ -0,0 +1,208 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// This file talks to /dev/mapper/control directly using the
+// device-mapper ioctl interface (struct dm_ioctl / struct
+// dm_target_spec from include/uapi/linux/dm-ioctl.h), the same
+// mechanism internal/dmverity uses for its "verity" target, applied
+// here to a "crypt" target instead. The two packages don't share this
+// code: each activates its own independent dm-crypt/dm-verity device,
+// and duplicating the fairly small header encoding avoids an
+// unexported-API coupling between two otherwise independent packages.
+
+const (
+	dmControlPath = "/dev/mapper/control"
+
+	dmNameLen     = 128
+	dmMaxTypeName = 16
+
+	// dmIoctlHeaderSize is sizeof(struct dm_ioctl): ten u32 fields
+	// (version[3], data_size, data_start, target_count, open_count,
+	// flags, event_nr, padding), a u64 dev, name, uuid, and the 7-byte
+	// data[] anchor the kernel's variable-length payload is appended
+	// after.
+	dmIoctlHeaderSize = 4*10 + 8 + dmNameLen + 129 + 7
+
+	// dmTargetSpecSize is sizeof(struct dm_target_spec): two u64
+	// fields, two u32 fields, and the target_type name.
+	dmTargetSpecSize = 8 + 8 + 4 + 4 + dmMaxTypeName
+
+	dmDevCreateCmd  = 3
+	dmDevRemoveCmd  = 4
+	dmDevSuspendCmd = 6
+	dmTableLoadCmd  = 9
+
+	dmVersionMajor = 4
+	dmVersionMinor = 0
+	dmVersionPatch = 0
+
+	dmTargetTypeCrypt = "crypt"
+)
+
+// dmIoctlCmd mirrors _IOWR(DM_IOCTL, nr, struct dm_ioctl): a read/write
+// ioctl, type 0xfd (the device-mapper magic number), command nr,
+// encoding sizeof(struct dm_ioctl) as its size.
+func dmIoctlCmd(nr uintptr) uintptr {
+	const (
+		iocNRBits   = 8
+		iocTypeBits = 8
+		iocSizeBits = 14
+
+		iocNRShift   = 0
+		iocTypeShift = iocNRShift + iocNRBits
+		iocSizeShift = iocTypeShift + iocTypeBits
+		iocDirShift  = iocSizeShift + iocSizeBits
+
+		iocWrite = 1
+		iocRead  = 2
+
+		dmIoctlType = 0xfd
+	)
+	dir := uintptr(iocWrite | iocRead)
+	return dir<<iocDirShift |
+		uintptr(dmIoctlType)<<iocTypeShift |
+		nr<<iocNRShift |
+		uintptr(dmIoctlHeaderSize)<<iocSizeShift
+}
+
+// newDMIoctlBuffer allocates a dm_ioctl request of size bytes (at least
+// dmIoctlHeaderSize) with its header filled in for device name.
+func newDMIoctlBuffer(name string, size uint32) ([]byte, error) {
+	if len(name) >= dmNameLen {
+		return nil, fmt.Errorf("device name %q is longer than %d bytes", name, dmNameLen-1)
+	}
+	if size < dmIoctlHeaderSize {
+		size = dmIoctlHeaderSize
+	}
+
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint32(buf[0:4], dmVersionMajor)
+	binary.LittleEndian.PutUint32(buf[4:8], dmVersionMinor)
+	binary.LittleEndian.PutUint32(buf[8:12], dmVersionPatch)
+	binary.LittleEndian.PutUint32(buf[12:16], size)              // data_size
+	binary.LittleEndian.PutUint32(buf[16:20], dmIoctlHeaderSize) // data_start
+	copy(buf[48:48+dmNameLen], name)
+
+	return buf, nil
+}
+
+// dmSetTargetCount stamps buf's target_count field.
+func dmSetTargetCount(buf []byte, count uint32) {
+	binary.LittleEndian.PutUint32(buf[20:24], count)
+}
+
+// buildTargetSpec encodes a single dm_target_spec covering
+// [sectorStart, sectorStart+length) of targetType, followed by params
+// as its NUL-terminated, NUL-padded parameter string.
+func buildTargetSpec(sectorStart, length uint64, targetType, params string) ([]byte, error) {
+	if len(targetType) >= dmMaxTypeName {
+		return nil, fmt.Errorf("target type %q is longer than %d bytes", targetType, dmMaxTypeName-1)
+	}
+
+	paramsBytes := append([]byte(params), 0)
+	for (dmTargetSpecSize+len(paramsBytes))%8 != 0 {
+		paramsBytes = append(paramsBytes, 0)
+	}
+
+	spec := make([]byte, dmTargetSpecSize+len(paramsBytes))
+	binary.LittleEndian.PutUint64(spec[0:8], sectorStart)
+	binary.LittleEndian.PutUint64(spec[8:16], length)
+	copy(spec[24:24+dmMaxTypeName], targetType)
+	copy(spec[dmTargetSpecSize:], paramsBytes)
+
+	return spec, nil
+}
+
+// dmIoctl opens dmControlPath and issues cmd against buf.
+func dmIoctl(cmd uintptr, buf []byte) error {
+	fd, err := unix.Open(dmControlPath, unix.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", dmControlPath, err)
+	}
+	defer unix.Close(fd)
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), cmd, uintptr(unsafe.Pointer(&buf[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// dmDevCreate registers name as a new, empty device-mapper device.
+func dmDevCreate(name string) error {
+	buf, err := newDMIoctlBuffer(name, dmIoctlHeaderSize)
+	if err != nil {
+		return err
+	}
+	if err := dmIoctl(dmIoctlCmd(dmDevCreateCmd), buf); err != nil {
+		return fmt.Errorf("DM_DEV_CREATE failed for %q: %w", name, err)
+	}
+	return nil
+}
+
+// dmTableLoad loads a single-target table of targetType/params, sized
+// sectors sectors, into name's inactive table slot.
+func dmTableLoad(name string, sectors uint64, targetType, params string) error {
+	spec, err := buildTargetSpec(0, sectors, targetType, params)
+	if err != nil {
+		return err
+	}
+
+	buf, err := newDMIoctlBuffer(name, uint32(dmIoctlHeaderSize+len(spec)))
+	if err != nil {
+		return err
+	}
+	dmSetTargetCount(buf, 1)
+	copy(buf[dmIoctlHeaderSize:], spec)
+
+	if err := dmIoctl(dmIoctlCmd(dmTableLoadCmd), buf); err != nil {
+		return fmt.Errorf("DM_TABLE_LOAD failed for %q: %w", name, err)
+	}
+	return nil
+}
+
+// dmDevResume activates name's inactive table.
+func dmDevResume(name string) error {
+	buf, err := newDMIoctlBuffer(name, dmIoctlHeaderSize)
+	if err != nil {
+		return err
+	}
+	if err := dmIoctl(dmIoctlCmd(dmDevSuspendCmd), buf); err != nil {
+		return fmt.Errorf("DM_DEV_SUSPEND (resume) failed for %q: %w", name, err)
+	}
+	return nil
+}
+
+// dmDevRemove tears down name, mirroring dmDevCreate.
+func dmDevRemove(name string) error {
+	buf, err := newDMIoctlBuffer(name, dmIoctlHeaderSize)
+	if err != nil {
+		return err
+	}
+	if err := dmIoctl(dmIoctlCmd(dmDevRemoveCmd), buf); err != nil {
+		return fmt.Errorf("DM_DEV_REMOVE failed for %q: %w", name, err)
+	}
+	return nil
+}
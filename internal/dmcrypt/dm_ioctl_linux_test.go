@@ -0,0 +1,95 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestDmIoctlCmdMatchesLinuxIOWR checks dmIoctlCmd's _IOWR(0xfd, nr,
+// dm_ioctl) encoding against the ioctl(2) bit layout directly, rather
+// than trusting dmIoctlCmd to encode its own documented formula
+// correctly.
+func TestDmIoctlCmdMatchesLinuxIOWR(t *testing.T) {
+	const (
+		iocWrite = 1
+		iocRead  = 2
+	)
+	want := uintptr(iocWrite|iocRead)<<30 | uintptr(0xfd)<<8 | uintptr(dmDevCreateCmd) | uintptr(dmIoctlHeaderSize)<<16
+	require.Equal(t, want, dmIoctlCmd(dmDevCreateCmd))
+}
+
+func TestDmIoctlCmdVariesByCommand(t *testing.T) {
+	require.NotEqual(t, dmIoctlCmd(dmDevCreateCmd), dmIoctlCmd(dmDevRemoveCmd))
+}
+
+func TestNewDMIoctlBufferHeader(t *testing.T) {
+	buf, err := newDMIoctlBuffer("my-crypt-dev", dmIoctlHeaderSize)
+	require.NoError(t, err)
+	require.Len(t, buf, dmIoctlHeaderSize)
+
+	require.Equal(t, uint32(dmVersionMajor), binary.LittleEndian.Uint32(buf[0:4]))
+	require.Equal(t, uint32(dmVersionMinor), binary.LittleEndian.Uint32(buf[4:8]))
+	require.Equal(t, uint32(dmVersionPatch), binary.LittleEndian.Uint32(buf[8:12]))
+	require.Equal(t, uint32(dmIoctlHeaderSize), binary.LittleEndian.Uint32(buf[12:16]))
+	require.Equal(t, uint32(dmIoctlHeaderSize), binary.LittleEndian.Uint32(buf[16:20]))
+
+	name := string(buf[48 : 48+len("my-crypt-dev")])
+	require.Equal(t, "my-crypt-dev", name)
+}
+
+func TestNewDMIoctlBufferRejectsLongName(t *testing.T) {
+	_, err := newDMIoctlBuffer(strings.Repeat("a", dmNameLen), dmIoctlHeaderSize)
+	require.Error(t, err)
+}
+
+func TestNewDMIoctlBufferMinimumSize(t *testing.T) {
+	buf, err := newDMIoctlBuffer("dev", 4)
+	require.NoError(t, err)
+	require.Len(t, buf, dmIoctlHeaderSize, "size below the header minimum should be rounded up")
+}
+
+func TestDmSetTargetCount(t *testing.T) {
+	buf := make([]byte, dmIoctlHeaderSize)
+	dmSetTargetCount(buf, 3)
+	require.Equal(t, uint32(3), binary.LittleEndian.Uint32(buf[20:24]))
+}
+
+func TestBuildTargetSpecEncoding(t *testing.T) {
+	spec, err := buildTargetSpec(0, 1024, dmTargetTypeCrypt, "aes-xts-plain64 deadbeef 0 /dev/mapper/verity 0")
+	require.NoError(t, err)
+
+	require.Equal(t, uint64(0), binary.LittleEndian.Uint64(spec[0:8]))
+	require.Equal(t, uint64(1024), binary.LittleEndian.Uint64(spec[8:16]))
+	gotType := strings.TrimRight(string(spec[24:24+dmMaxTypeName]), "\x00")
+	require.Equal(t, dmTargetTypeCrypt, gotType)
+
+	// The params string is copied in, NUL-terminated and NUL-padded to
+	// an 8-byte boundary, immediately after the fixed-size header.
+	require.Zero(t, len(spec)%8)
+	paramsEnd := strings.IndexByte(string(spec[dmTargetSpecSize:]), 0)
+	require.Equal(t, "aes-xts-plain64 deadbeef 0 /dev/mapper/verity 0", string(spec[dmTargetSpecSize:dmTargetSpecSize+paramsEnd]))
+}
+
+func TestBuildTargetSpecRejectsLongTypeName(t *testing.T) {
+	_, err := buildTargetSpec(0, 1, strings.Repeat("x", dmMaxTypeName), "params")
+	require.Error(t, err)
+}
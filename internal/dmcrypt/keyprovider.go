@@ -0,0 +1,132 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/sys/unix"
+)
+
+// KeyProvider unwraps a layer's wrapped data encryption key (DEK).
+// layerID and wrappedKey come from the layer's sidecar (EncryptionMetadata);
+// an implementation is free to use layerID for lookup or as evidence,
+// whichever its release policy calls for.
+type KeyProvider interface {
+	Unwrap(ctx context.Context, layerID string, wrappedKey []byte) ([]byte, error)
+}
+
+// LocalKeyringProvider unwraps a key already loaded into the kernel
+// session keyring under layerID - e.g. by an operator provisioning keys
+// ahead of time with `keyctl add user <layerID> <key> @s` - rather than
+// delegating release to a remote service. wrappedKey is ignored: the
+// keyring entry is the unwrapped DEK itself.
+type LocalKeyringProvider struct{}
+
+// Unwrap implements KeyProvider via KeyctlSearch/KeyctlBuffer(KEYCTL_READ)
+// against the session keyring, the same keyring internal/dmverity's
+// loadSignatureKey loads signatures into.
+func (LocalKeyringProvider) Unwrap(_ context.Context, layerID string, _ []byte) ([]byte, error) {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_SESSION_KEYRING, "user", layerID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no key registered for layer %q: %w", layerID, err)
+	}
+
+	buf := make([]byte, 4096)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key for layer %q: %w", layerID, err)
+	}
+	return buf[:n], nil
+}
+
+// AttestationKeyProvider unwraps a key by sending the layer's verity
+// root hash to a remote attestation server as evidence; the server
+// returns the DEK only if the measurement matches its allow-list,
+// closing Prepare/Mount's key release to layers whose integrity it has
+// already verified out of band.
+type AttestationKeyProvider struct {
+	// ServerURL is the attestation server's key-release endpoint.
+	ServerURL string
+	// RootHash is the verity root hash presented as evidence that the
+	// layer being unwrapped for is the one the attestation policy
+	// expects.
+	RootHash []byte
+	// Client is the HTTP client used to reach ServerURL. A nil Client
+	// uses http.DefaultClient.
+	Client *http.Client
+}
+
+type attestationUnwrapRequest struct {
+	LayerID    string `json:"layer_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	RootHash   []byte `json:"root_hash"`
+}
+
+type attestationUnwrapResponse struct {
+	Key     []byte `json:"key"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Unwrap implements KeyProvider by POSTing wrappedKey and RootHash to
+// ServerURL and returning the key it releases. A server response with
+// Allowed false is reported as an error rather than returning a zero
+// key, so a caller can't mistake a rejected measurement for success.
+func (p AttestationKeyProvider) Unwrap(ctx context.Context, layerID string, wrappedKey []byte) ([]byte, error) {
+	reqBody, err := json.Marshal(attestationUnwrapRequest{
+		LayerID:    layerID,
+		WrappedKey: wrappedKey,
+		RootHash:   p.RootHash,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal attestation request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.ServerURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build attestation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("attestation request for layer %q failed: %w", layerID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("attestation server rejected layer %q with status %d", layerID, resp.StatusCode)
+	}
+
+	var unwrapResp attestationUnwrapResponse
+	if err := json.NewDecoder(resp.Body).Decode(&unwrapResp); err != nil {
+		return nil, fmt.Errorf("failed to decode attestation response: %w", err)
+	}
+	if !unwrapResp.Allowed {
+		return nil, fmt.Errorf("attestation server refused to release key for layer %q: %s", layerID, unwrapResp.Reason)
+	}
+	return unwrapResp.Key, nil
+}
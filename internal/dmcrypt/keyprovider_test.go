@@ -0,0 +1,72 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttestationKeyProviderUnwrapAllowed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req attestationUnwrapRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "layer-1", req.LayerID)
+
+		require.NoError(t, json.NewEncoder(w).Encode(attestationUnwrapResponse{
+			Key:     []byte("the-dek"),
+			Allowed: true,
+		}))
+	}))
+	defer srv.Close()
+
+	provider := AttestationKeyProvider{ServerURL: srv.URL, RootHash: []byte{1, 2, 3}}
+	key, err := provider.Unwrap(context.Background(), "layer-1", []byte("wrapped"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("the-dek"), key)
+}
+
+func TestAttestationKeyProviderUnwrapRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewEncoder(w).Encode(attestationUnwrapResponse{
+			Allowed: false,
+			Reason:  "measurement mismatch",
+		}))
+	}))
+	defer srv.Close()
+
+	provider := AttestationKeyProvider{ServerURL: srv.URL}
+	_, err := provider.Unwrap(context.Background(), "layer-1", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "measurement mismatch")
+}
+
+func TestAttestationKeyProviderUnwrapServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	provider := AttestationKeyProvider{ServerURL: srv.URL}
+	_, err := provider.Unwrap(context.Background(), "layer-1", nil)
+	require.Error(t, err)
+}
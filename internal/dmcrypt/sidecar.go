@@ -0,0 +1,111 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// SidecarExt is the extension a layer's encryption metadata is stored
+// under, next to its blob.
+const SidecarExt = ".enc.json"
+
+// EncryptionMetadata is a committed layer's encryption sidecar
+// (<layer>.enc.json): everything needed to unwrap its DEK and activate
+// dm-crypt on top of the dm-verity device already covering it, short of
+// the KeyProvider itself.
+type EncryptionMetadata struct {
+	// Cipher is the dm-crypt cipher spec, e.g. "aes-xts-plain64".
+	Cipher string `json:"cipher"`
+	// IVMode names the IV derivation mode, e.g. "plain64" - informational
+	// alongside Cipher, which already encodes it, for a reader that
+	// wants it without parsing the cipher spec.
+	IVMode string `json:"iv_mode"`
+	// WrappedKey is the DEK, wrapped by whatever KeyProvider produced
+	// it (hex-encoded in the JSON sidecar, raw bytes here).
+	WrappedKey []byte `json:"wrapped_key"`
+	// VerityRootHash is the dm-verity root hash this key is bound to:
+	// a KeyProvider (e.g. AttestationKeyProvider) may refuse to unwrap
+	// WrappedKey if the layer's actual root hash doesn't match this.
+	VerityRootHash []byte `json:"verity_root_hash"`
+}
+
+// sidecarJSON is EncryptionMetadata's on-disk shape: hex strings
+// instead of raw bytes, since EncryptionMetadata's fields aren't valid
+// UTF-8 and encoding/json would otherwise base64-encode them with no
+// indication of that to a human reading the sidecar directly.
+type sidecarJSON struct {
+	Cipher         string `json:"cipher"`
+	IVMode         string `json:"iv_mode"`
+	WrappedKey     string `json:"wrapped_key"`
+	VerityRootHash string `json:"verity_root_hash"`
+}
+
+// SidecarPath returns the sidecar path WriteSidecar and ReadSidecar use
+// for layerBlobPath.
+func SidecarPath(layerBlobPath string) string {
+	return layerBlobPath + SidecarExt
+}
+
+// WriteSidecar writes meta as layerBlobPath's encryption sidecar.
+func WriteSidecar(layerBlobPath string, meta EncryptionMetadata) error {
+	data, err := json.MarshalIndent(sidecarJSON{
+		Cipher:         meta.Cipher,
+		IVMode:         meta.IVMode,
+		WrappedKey:     hex.EncodeToString(meta.WrappedKey),
+		VerityRootHash: hex.EncodeToString(meta.VerityRootHash),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal encryption sidecar: %w", err)
+	}
+	if err := os.WriteFile(SidecarPath(layerBlobPath), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write encryption sidecar: %w", err)
+	}
+	return nil
+}
+
+// ReadSidecar reads back layerBlobPath's encryption sidecar.
+func ReadSidecar(layerBlobPath string) (EncryptionMetadata, error) {
+	data, err := os.ReadFile(SidecarPath(layerBlobPath))
+	if err != nil {
+		return EncryptionMetadata{}, fmt.Errorf("failed to read encryption sidecar: %w", err)
+	}
+
+	var raw sidecarJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return EncryptionMetadata{}, fmt.Errorf("failed to parse encryption sidecar: %w", err)
+	}
+
+	wrappedKey, err := hex.DecodeString(raw.WrappedKey)
+	if err != nil {
+		return EncryptionMetadata{}, fmt.Errorf("invalid wrapped_key in encryption sidecar: %w", err)
+	}
+	rootHash, err := hex.DecodeString(raw.VerityRootHash)
+	if err != nil {
+		return EncryptionMetadata{}, fmt.Errorf("invalid verity_root_hash in encryption sidecar: %w", err)
+	}
+
+	return EncryptionMetadata{
+		Cipher:         raw.Cipher,
+		IVMode:         raw.IVMode,
+		WrappedKey:     wrappedKey,
+		VerityRootHash: rootHash,
+	}, nil
+}
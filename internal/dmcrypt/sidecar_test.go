@@ -0,0 +1,65 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmcrypt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecarRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("layer contents"), 0o644))
+
+	meta := EncryptionMetadata{
+		Cipher:         DefaultCipher,
+		IVMode:         "plain64",
+		WrappedKey:     []byte{1, 2, 3, 4, 5},
+		VerityRootHash: []byte{6, 7, 8, 9},
+	}
+	require.NoError(t, WriteSidecar(blobPath, meta))
+
+	_, err := os.Stat(SidecarPath(blobPath))
+	require.NoError(t, err)
+
+	got, err := ReadSidecar(blobPath)
+	require.NoError(t, err)
+	require.Equal(t, meta, got)
+}
+
+func TestReadSidecarMissing(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("layer contents"), 0o644))
+
+	_, err := ReadSidecar(blobPath)
+	require.Error(t, err)
+}
+
+func TestReadSidecarInvalidHex(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("layer contents"), 0o644))
+	require.NoError(t, os.WriteFile(SidecarPath(blobPath), []byte(`{"wrapped_key":"not-hex"}`), 0o644))
+
+	_, err := ReadSidecar(blobPath)
+	require.Error(t, err)
+}
@@ -0,0 +1,319 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// sectorSize is the device-mapper sector size in bytes, fixed regardless
+// of a device's logical/physical block size.
+const sectorSize = 512
+
+// useVeritysetupEnv, when set to "1", makes Enable/RemoveVerityDevice
+// shell out to the veritysetup binary instead of talking to
+// /dev/mapper/control directly - an escape hatch for a kernel whose
+// device-mapper ioctl ABI this package hasn't been validated against.
+const useVeritysetupEnv = "CONTAINERD_DMVERITY_USE_VERITYSETUP"
+
+// Enable activates name as a read-only dm-verity device layering
+// integrity checks of dataDevice over hashDevice's hash tree, as
+// described by config. It talks to /dev/mapper/control directly with
+// DM_DEV_CREATE, DM_TABLE_LOAD, and DM_DEV_SUSPEND rather than shelling
+// out to veritysetup, unless useVeritysetupEnv opts back into that.
+func Enable(name, dataDevice, hashDevice string, config VerityConfig) error {
+	if useVeritysetupFallback() {
+		return enableWithVeritysetup(name, dataDevice, hashDevice, config)
+	}
+
+	if err := validateConfig(config); err != nil {
+		return fmt.Errorf("invalid verity config: %w", err)
+	}
+
+	params, err := buildVerityTargetParams(dataDevice, hashDevice, config)
+	if err != nil {
+		return err
+	}
+	sectors := config.DataBlocks * uint64(config.DataBlockSize) / sectorSize
+
+	if err := dmDevCreate(name); err != nil {
+		return err
+	}
+	if err := dmTableLoad(name, sectors, dmTargetTypeVerity, params); err != nil {
+		if rmErr := dmDevRemove(name); rmErr != nil {
+			return fmt.Errorf("%w (cleanup after failed load also failed: %v)", err, rmErr)
+		}
+		return err
+	}
+	if err := dmDevResume(name); err != nil {
+		if rmErr := dmDevRemove(name); rmErr != nil {
+			return fmt.Errorf("%w (cleanup after failed activation also failed: %v)", err, rmErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RemoveVerityDevice tears down a device Enable activated, mirroring it
+// with DM_DEV_REMOVE (or veritysetup close, under the same fallback
+// Enable uses).
+func RemoveVerityDevice(name string) error {
+	if useVeritysetupFallback() {
+		return removeWithVeritysetup(name)
+	}
+	return dmDevRemove(name)
+}
+
+func useVeritysetupFallback() bool {
+	return os.Getenv(useVeritysetupEnv) == "1"
+}
+
+// buildVerityTargetParams renders config as the dm-verity target line
+// documented in Documentation/admin-guide/device-mapper/verity.rst:
+// <version> <data_dev> <hash_dev> <data_block_size> <hash_block_size>
+// <num_data_blocks> <hash_start_block> <algorithm> <root_hex> <salt_hex>
+// [<#opt_args> opt ...].
+func buildVerityTargetParams(dataDevice, hashDevice string, config VerityConfig) (string, error) {
+	if len(config.RootDigest) == 0 {
+		return "", fmt.Errorf("root digest is required")
+	}
+	algoName, err := verityHashAlgorithmName(config.HashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	var hashStartBlock uint64
+	if config.HashOffset > 0 {
+		hashStartBlock = uint64(config.HashOffset) / uint64(config.HashBlockSize)
+	}
+
+	saltHex := "-"
+	if len(config.Salt) > 0 {
+		saltHex = hex.EncodeToString(config.Salt)
+	}
+
+	fields := []string{
+		strconv.FormatUint(uint64(config.Version), 10),
+		dataDevice,
+		hashDevice,
+		strconv.FormatUint(uint64(config.DataBlockSize), 10),
+		strconv.FormatUint(uint64(config.HashBlockSize), 10),
+		strconv.FormatUint(config.DataBlocks, 10),
+		strconv.FormatUint(hashStartBlock, 10),
+		algoName,
+		hex.EncodeToString(config.RootDigest),
+		saltHex,
+	}
+
+	opts := verityOptionArgs(config.Options)
+	opts = append(opts, verityFECArgs(dataDevice, hashDevice, config)...)
+	sigOpts, err := veritySignatureArgs(config)
+	if err != nil {
+		return "", err
+	}
+	opts = append(opts, sigOpts...)
+	if len(opts) > 0 {
+		fields = append(fields, strconv.Itoa(len(opts)))
+		fields = append(fields, opts...)
+	}
+
+	return strings.Join(fields, " "), nil
+}
+
+// veritySignatureArgs loads config.RootHashSignature into the session
+// keyring under RootHashSignatureKeyDesc and returns the
+// root_hash_sig_key_desc target-line argument pointing at it, so the
+// kernel refuses to activate the table unless that keyring entry
+// validates against its own trusted keyrings. It returns nil when no
+// signature is configured.
+func veritySignatureArgs(config VerityConfig) ([]string, error) {
+	if len(config.RootHashSignature) == 0 {
+		return nil, nil
+	}
+	if config.RootHashSignatureKeyDesc == "" {
+		return nil, fmt.Errorf("root hash signature set without a key descriptor")
+	}
+	if err := loadSignatureKey(config.RootHashSignatureKeyDesc, config.RootHashSignature); err != nil {
+		return nil, fmt.Errorf("failed to load root hash signature: %w", err)
+	}
+	return []string{"root_hash_sig_key_desc", config.RootHashSignatureKeyDesc}, nil
+}
+
+// verityFECArgs renders config's FEC fields as the use_fec_from_device,
+// fec_start, fec_blocks, and fec_roots optional target-line arguments
+// documented alongside verity's FEC support. It returns nil when FEC
+// isn't configured.
+func verityFECArgs(dataDevice, hashDevice string, config VerityConfig) []string {
+	if config.FECDevice == "" {
+		return nil
+	}
+
+	fecStart := config.FECOffset / uint64(config.DataBlockSize)
+	fecBlocks := fecBlocksForDevices(dataDevice, hashDevice, config)
+
+	return []string{
+		"use_fec_from_device", config.FECDevice,
+		"fec_start", strconv.FormatUint(fecStart, 10),
+		"fec_blocks", strconv.FormatUint(fecBlocks, 10),
+		"fec_roots", strconv.FormatUint(uint64(config.FECRoots), 10),
+	}
+}
+
+// fecBlocksForDevices computes fec_blocks - the number of data-block-sized
+// blocks covered by FEC, starting at the data device - from the device
+// sizes Enable is given rather than requiring a caller to pass it in
+// separately. In combined mode (hashDevice == dataDevice) the hash tree
+// shares the data device past HashOffset, so it isn't counted twice.
+func fecBlocksForDevices(dataDevice, hashDevice string, config VerityConfig) uint64 {
+	if config.FECBlocks > 0 {
+		return config.FECBlocks
+	}
+
+	dataSize := int64(config.DataBlocks) * int64(config.DataBlockSize)
+
+	hashSize, err := BlockDeviceSize(hashDevice)
+	if err != nil {
+		hashSize = 0
+	}
+	if hashDevice == dataDevice {
+		// Combined mode: the hash tree shares the data device past
+		// HashOffset, so only the tree itself should be counted.
+		hashSize -= config.HashOffset
+	}
+	if hashSize < 0 {
+		hashSize = 0
+	}
+
+	return FECBlocks(dataSize, hashSize, config.DataBlockSize)
+}
+
+func verityOptionArgs(opts VerityOptions) []string {
+	var args []string
+	if opts.IgnoreCorruption {
+		args = append(args, "ignore_corruption")
+	}
+	if opts.RestartOnCorruption {
+		args = append(args, "restart_on_corruption")
+	}
+	if opts.IgnoreZeroBlocks {
+		args = append(args, "ignore_zero_blocks")
+	}
+	if opts.CheckAtMostOnce {
+		args = append(args, "check_at_most_once")
+	}
+	return args
+}
+
+func verityHashAlgorithmName(algo uint32) (string, error) {
+	switch algo {
+	case HashAlgoSHA256:
+		return "sha256", nil
+	case HashAlgoSHA512:
+		return "sha512", nil
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %d", algo)
+	}
+}
+
+func enableWithVeritysetup(name, dataDevice, hashDevice string, config VerityConfig) error {
+	if err := validateConfig(config); err != nil {
+		return fmt.Errorf("invalid verity config: %w", err)
+	}
+	algoName, err := verityHashAlgorithmName(config.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"open", dataDevice, name, hashDevice, hex.EncodeToString(config.RootDigest),
+		"--hash=" + algoName,
+		"--data-block-size=" + strconv.FormatUint(uint64(config.DataBlockSize), 10),
+		"--hash-block-size=" + strconv.FormatUint(uint64(config.HashBlockSize), 10),
+	}
+	if config.HashOffset > 0 {
+		args = append(args, "--hash-offset="+strconv.FormatUint(uint64(config.HashOffset)/uint64(config.HashBlockSize), 10))
+	}
+	if len(config.Salt) > 0 {
+		args = append(args, "--salt="+hex.EncodeToString(config.Salt))
+	}
+	for _, opt := range verityOptionArgs(config.Options) {
+		args = append(args, "--"+strings.ReplaceAll(opt, "_", "-"))
+	}
+	if config.FECDevice != "" {
+		args = append(args,
+			"--fec-device="+config.FECDevice,
+			"--fec-roots="+strconv.FormatUint(uint64(config.FECRoots), 10),
+		)
+		if config.FECOffset > 0 {
+			args = append(args, "--fec-offset="+strconv.FormatUint(config.FECOffset, 10))
+		}
+	}
+	if len(config.RootHashSignature) > 0 {
+		if config.RootHashSignatureKeyDesc == "" {
+			return fmt.Errorf("root hash signature set without a key descriptor")
+		}
+		sigPath, cleanup, err := writeTempSignature(config.RootHashSignature)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		args = append(args, "--root-hash-signature="+sigPath)
+	}
+
+	out, err := exec.Command("veritysetup", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("veritysetup open failed: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// writeTempSignature spills signature to a temporary file, since
+// veritysetup's --root-hash-signature takes a path rather than accepting
+// the signature bytes directly on the command line.
+func writeTempSignature(signature []byte) (path string, cleanup func(), err error) {
+	f, err := os.CreateTemp("", "verity-sig-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary signature file: %w", err)
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+
+	if _, err := f.Write(signature); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write temporary signature file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to close temporary signature file: %w", err)
+	}
+	return f.Name(), cleanup, nil
+}
+
+func removeWithVeritysetup(name string) error {
+	out, err := exec.Command("veritysetup", "close", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("veritysetup close failed: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
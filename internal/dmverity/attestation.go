@@ -0,0 +1,129 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// AttestationReport describes a committed layer's dm-verity parameters
+// in enough detail for a remote verifier to check them against a
+// signed policy without reaching into the layer's on-disk layout.
+type AttestationReport struct {
+	// RootDigest is the Merkle tree root hash over the layer's data
+	// blocks.
+	RootDigest []byte
+	// Salt is the salt GenerateHashTree mixed into every hashed block.
+	Salt []byte
+	// HashAlgorithm is the hash used for the Merkle tree (HashAlgoSHA256
+	// or HashAlgoSHA512).
+	HashAlgorithm uint32
+	DataBlockSize uint32
+	HashBlockSize uint32
+	DataBlocks    uint64
+	// FECRoots, FECOffset and FECDevice describe the layer's
+	// Reed-Solomon FEC parity, zero/empty if GenerateFEC wasn't used.
+	FECRoots  uint8
+	FECOffset uint64
+	FECDevice string
+
+	// Nonce is evidence freshness: a verifier-supplied (or caller
+	// supplied) value proving this report wasn't replayed from an
+	// earlier attestation. It is not part of the reproducible evidence
+	// Attester.Quote signs over.
+	Nonce []byte
+	// Quote is the optional TPM quote or TDX/SEV-SNP report over the
+	// report's reproducible fields, produced by the configured
+	// Attester. Nil if no Attester was given.
+	Quote []byte
+}
+
+// Attester produces a hardware or vTPM attestation quote over
+// reportEvidence, the reproducible encoding of an AttestationReport's
+// verity parameters (see reportEvidence). Implementations bind
+// reportEvidence into whatever evidence format their platform quotes -
+// e.g. as a vTPM PCR extension, or as a TDX/SEV-SNP REPORTDATA field.
+type Attester interface {
+	Quote(ctx context.Context, reportEvidence []byte) ([]byte, error)
+}
+
+// Attest builds config's AttestationReport, stamping nonce onto it for
+// freshness and, if attester is non-nil, populating Quote with a quote
+// over the report's reproducible evidence. Calling Attest twice for the
+// same config and a different nonce returns reports whose Quote differs
+// only in what the Attester itself derives from the (unchanged)
+// evidence bytes; every field other than Nonce and Quote is
+// byte-identical, so a caller can
+// pin expected reports in tests regardless of nonce.
+func Attest(ctx context.Context, config VerityConfig, attester Attester, nonce []byte) (*AttestationReport, error) {
+	report := &AttestationReport{
+		RootDigest:    config.RootDigest,
+		Salt:          config.Salt,
+		HashAlgorithm: config.HashAlgorithm,
+		DataBlockSize: config.DataBlockSize,
+		HashBlockSize: config.HashBlockSize,
+		DataBlocks:    config.DataBlocks,
+		FECRoots:      config.FECRoots,
+		FECOffset:     config.FECOffset,
+		FECDevice:     config.FECDevice,
+		Nonce:         nonce,
+	}
+
+	if attester != nil {
+		quote, err := attester.Quote(ctx, reportEvidence(report))
+		if err != nil {
+			return nil, fmt.Errorf("failed to quote attestation report: %w", err)
+		}
+		report.Quote = quote
+	}
+
+	return report, nil
+}
+
+// reportEvidence deterministically encodes report's verity parameters
+// - everything except Nonce and Quote - into a flat byte string an
+// Attester can quote over. Every byte-string field is length-prefixed
+// and every integer field is a fixed 8 bytes, so no field's content can
+// shift where a later field starts.
+func reportEvidence(report *AttestationReport) []byte {
+	var buf []byte
+	appendBytes := func(b []byte) {
+		var lenBuf [8]byte
+		binary.BigEndian.PutUint64(lenBuf[:], uint64(len(b)))
+		buf = append(buf, lenBuf[:]...)
+		buf = append(buf, b...)
+	}
+	appendUint := func(v uint64) {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], v)
+		buf = append(buf, b[:]...)
+	}
+
+	appendBytes(report.RootDigest)
+	appendBytes(report.Salt)
+	appendUint(uint64(report.HashAlgorithm))
+	appendUint(uint64(report.DataBlockSize))
+	appendUint(uint64(report.HashBlockSize))
+	appendUint(report.DataBlocks)
+	appendUint(uint64(report.FECRoots))
+	appendUint(report.FECOffset)
+	appendBytes([]byte(report.FECDevice))
+
+	return buf
+}
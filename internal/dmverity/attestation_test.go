@@ -0,0 +1,113 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAttester struct {
+	evidence []byte
+	quote    []byte
+	err      error
+}
+
+func (f *fakeAttester) Quote(ctx context.Context, evidence []byte) ([]byte, error) {
+	f.evidence = evidence
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.quote, nil
+}
+
+func testAttestationConfig() VerityConfig {
+	cfg := testVerityConfig()
+	cfg.RootDigest = []byte{1, 2, 3, 4}
+	cfg.DataBlocks = 42
+	cfg.HashOffset = 4096
+	return cfg
+}
+
+func TestAttestNilAttester(t *testing.T) {
+	cfg := testAttestationConfig()
+	report, err := Attest(context.Background(), cfg, nil, []byte("nonce"))
+	require.NoError(t, err)
+	require.Equal(t, cfg.RootDigest, report.RootDigest)
+	require.Equal(t, []byte("nonce"), report.Nonce)
+	require.Nil(t, report.Quote)
+}
+
+func TestAttestWithAttester(t *testing.T) {
+	cfg := testAttestationConfig()
+	attester := &fakeAttester{quote: []byte("a quote")}
+
+	report, err := Attest(context.Background(), cfg, attester, []byte("nonce-1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("a quote"), report.Quote)
+	require.Equal(t, reportEvidence(report), attester.evidence)
+}
+
+func TestAttestQuoteError(t *testing.T) {
+	cfg := testAttestationConfig()
+	attester := &fakeAttester{err: fmt.Errorf("tpm unavailable")}
+
+	_, err := Attest(context.Background(), cfg, attester, []byte("nonce"))
+	require.Error(t, err)
+}
+
+// TestAttestEvidenceStableAcrossNonce checks the doc comment's claim that
+// two reports for the same config but different nonces carry
+// byte-identical evidence - only Nonce (excluded from reportEvidence) and
+// whatever the Attester itself derives from that evidence may differ.
+func TestAttestEvidenceStableAcrossNonce(t *testing.T) {
+	cfg := testAttestationConfig()
+
+	report1, err := Attest(context.Background(), cfg, nil, []byte("nonce-a"))
+	require.NoError(t, err)
+	report2, err := Attest(context.Background(), cfg, nil, []byte("nonce-b"))
+	require.NoError(t, err)
+
+	require.Equal(t, reportEvidence(report1), reportEvidence(report2))
+	require.NotEqual(t, report1.Nonce, report2.Nonce)
+}
+
+func TestReportEvidenceDiffersOnFieldChange(t *testing.T) {
+	base := &AttestationReport{
+		RootDigest:    []byte{1, 2, 3},
+		Salt:          []byte{4, 5, 6},
+		HashAlgorithm: HashAlgoSHA256,
+		DataBlockSize: DefaultBlockSize,
+		HashBlockSize: DefaultBlockSize,
+		DataBlocks:    10,
+		FECRoots:      DefaultFECRoots,
+		FECOffset:     1024,
+		FECDevice:     "/dev/fec0",
+	}
+	baseline := reportEvidence(base)
+
+	changed := *base
+	changed.DataBlocks = 11
+	require.NotEqual(t, baseline, reportEvidence(&changed))
+
+	changed = *base
+	changed.FECDevice = "/dev/fec1"
+	require.NotEqual(t, baseline, reportEvidence(&changed))
+}
@@ -0,0 +1,56 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// blkGetSize64 is BLKGETSIZE64, _IOR(0x12, 114, sizeof(size_t)) from
+// include/uapi/linux/fs.h: the ioctl that reports a block device's size
+// in bytes, since stat's st_size is zero for one.
+const blkGetSize64 = 0x80081272
+
+// BlockDeviceSize returns path's size in bytes: the regular file size for
+// an image file, or the device size via BLKGETSIZE64 for a block device -
+// the same distinction fecBlocksForDevices needs when a caller passes a
+// loop device or a plain file interchangeably as the hash device.
+func BlockDeviceSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %q: %w", path, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %q: %w", path, err)
+	}
+	if fi.Mode()&os.ModeDevice == 0 {
+		return fi.Size(), nil
+	}
+
+	var size uint64
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), blkGetSize64, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0, fmt.Errorf("BLKGETSIZE64 failed for %q: %w", path, errno)
+	}
+	return int64(size), nil
+}
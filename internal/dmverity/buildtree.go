@@ -0,0 +1,157 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// hashFactory returns the digest size and constructor for algo, the
+// same pair (*VerityHash).hashBlock and verityHashAlgorithmName switch
+// on elsewhere in this package, kept local to BuildTree so it doesn't
+// need a *VerityHash to call.
+func hashFactory(algo uint32) (digestSize int, newHash func() hash.Hash, err error) {
+	switch algo {
+	case HashAlgoSHA256:
+		return sha256.Size, sha256.New, nil
+	case HashAlgoSHA512:
+		return sha512.Size, sha512.New, nil
+	default:
+		return 0, nil, fmt.Errorf("unsupported hash algorithm %d", algo)
+	}
+}
+
+// BuildTree builds a dm-verity hash tree over a dataSize-byte stream
+// read from dataReader, per cfg's block sizes, hash algorithm, salt and
+// layout version.
+//
+// Data is split into DataBlockSize blocks (the final one zero-padded),
+// each hashed as H(Salt||block). Those hashes are packed in order into
+// HashBlockSize-sized hash blocks (the final one zero-padded) to form
+// level 0. Each subsequent level hashes every block of the level below
+// as H(Salt||block) and packs the results the same way, until a level
+// holds exactly one block; rootDigest is H(Salt||that block).
+//
+// tree holds every level's blocks concatenated in the order cfg.Version
+// calls for: top-first (root level first, down to the data-hash leaf
+// level) for Version 1, bottom-first (leaf level first, up to the root)
+// for Version 0. A caller writing tree to a hash device at HashOffset
+// gets the on-disk layout that version expects.
+func BuildTree(dataReader io.Reader, dataSize int64, cfg *VerityConfig) (rootDigest []byte, tree []byte, err error) {
+	if cfg == nil {
+		return nil, nil, fmt.Errorf("nil verity config")
+	}
+	if cfg.DataBlockSize == 0 || cfg.HashBlockSize == 0 {
+		return nil, nil, fmt.Errorf("invalid block size")
+	}
+	if dataSize < 0 {
+		return nil, nil, fmt.Errorf("invalid data size %d", dataSize)
+	}
+
+	digestSize, newHash, err := hashFactory(cfg.HashAlgorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if int(cfg.HashBlockSize)%digestSize != 0 {
+		return nil, nil, fmt.Errorf("hash block size %d is not a multiple of the %d-byte digest size for this algorithm", cfg.HashBlockSize, digestSize)
+	}
+
+	hashOf := func(data []byte) []byte {
+		h := newHash()
+		if len(cfg.Salt) > 0 {
+			h.Write(cfg.Salt)
+		}
+		h.Write(data)
+		return h.Sum(nil)
+	}
+
+	// pack concatenates hashes in order into HashBlockSize-sized blocks,
+	// zero-padding the final block.
+	pack := func(hashes [][]byte) [][]byte {
+		var blocks [][]byte
+		buf := make([]byte, 0, cfg.HashBlockSize)
+		for _, h := range hashes {
+			buf = append(buf, h...)
+			if len(buf) == int(cfg.HashBlockSize) {
+				blocks = append(blocks, buf)
+				buf = make([]byte, 0, cfg.HashBlockSize)
+			}
+		}
+		if len(buf) > 0 {
+			block := make([]byte, cfg.HashBlockSize)
+			copy(block, buf)
+			blocks = append(blocks, block)
+		}
+		return blocks
+	}
+
+	// dataBlocks rounds dataSize up to a whole number of DataBlockSize
+	// blocks, with at least one block so an empty layer still gets a
+	// (fully zero-padded) hash tree instead of an empty one.
+	dataBlocks := (dataSize + int64(cfg.DataBlockSize) - 1) / int64(cfg.DataBlockSize)
+	if dataBlocks == 0 {
+		dataBlocks = 1
+	}
+
+	dataBuf := make([]byte, cfg.DataBlockSize)
+	leafHashes := make([][]byte, 0, dataBlocks)
+	for i := int64(0); i < dataBlocks; i++ {
+		n, err := io.ReadFull(dataReader, dataBuf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, nil, fmt.Errorf("failed to read data block %d: %w", i, err)
+		}
+		for j := n; j < len(dataBuf); j++ {
+			dataBuf[j] = 0
+		}
+		leafHashes = append(leafHashes, hashOf(dataBuf))
+	}
+
+	levels := [][][]byte{pack(leafHashes)}
+	for len(levels[len(levels)-1]) > 1 {
+		prev := levels[len(levels)-1]
+		hashes := make([][]byte, len(prev))
+		for i, block := range prev {
+			hashes[i] = hashOf(block)
+		}
+		levels = append(levels, pack(hashes))
+	}
+
+	top := levels[len(levels)-1][0]
+	rootDigest = hashOf(top)
+
+	var buf bytes.Buffer
+	if cfg.Version == 1 {
+		for i := len(levels) - 1; i >= 0; i-- {
+			for _, block := range levels[i] {
+				buf.Write(block)
+			}
+		}
+	} else {
+		for i := 0; i < len(levels); i++ {
+			for _, block := range levels[i] {
+				buf.Write(block)
+			}
+		}
+	}
+
+	return rootDigest, buf.Bytes(), nil
+}
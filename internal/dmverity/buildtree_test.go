@@ -0,0 +1,120 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testBuildTreeConfig() *VerityConfig {
+	cfg := testVerityConfig()
+	return &cfg
+}
+
+// TestBuildTreeMatchesGenerateHashTree checks that BuildTree's root
+// digest agrees with GenerateHashTree's, computed independently over a
+// file holding the same content, for the Version 1 (top-first) layout
+// GenerateHashTree itself writes.
+func TestBuildTreeMatchesGenerateHashTree(t *testing.T) {
+	for _, blocks := range []int{1, 2, 128, 129, 300} {
+		cfg := testBuildTreeConfig()
+		dataFile, data := writeVerityTestData(t, blocks, cfg.DataBlockSize)
+
+		rootFromBuildTree, _, err := BuildTree(bytes.NewReader(bytes.Join(data, nil)), int64(blocks)*int64(cfg.DataBlockSize), cfg)
+		require.NoError(t, err, "blocks=%d", blocks)
+
+		_, rootFromGenerate, err := GenerateHashTree(dataFile, *cfg)
+		require.NoError(t, err, "blocks=%d", blocks)
+
+		require.Equal(t, rootFromGenerate, rootFromBuildTree, "blocks=%d", blocks)
+	}
+}
+
+// TestBuildTreeDeterministic checks that two BuildTree calls over
+// identical input produce byte-identical trees and root digests - no
+// randomness (e.g. a superblock UUID) leaks into BuildTree's own output
+// the way it does into GenerateHashTree's.
+func TestBuildTreeDeterministic(t *testing.T) {
+	cfg := testBuildTreeConfig()
+	data := make([]byte, int(cfg.DataBlockSize)*5)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	root1, tree1, err := BuildTree(bytes.NewReader(data), int64(len(data)), cfg)
+	require.NoError(t, err)
+	root2, tree2, err := BuildTree(bytes.NewReader(data), int64(len(data)), cfg)
+	require.NoError(t, err)
+
+	require.Equal(t, root1, root2)
+	require.Equal(t, tree1, tree2)
+}
+
+// TestBuildTreeVersion0And1LayoutOrder checks that Version 0 and
+// Version 1 produce the same set of level blocks, just concatenated in
+// opposite order (bottom-first vs top-first), as the doc comment
+// describes.
+func TestBuildTreeVersion0And1LayoutOrder(t *testing.T) {
+	cfgV1 := testBuildTreeConfig()
+	cfgV1.Version = 1
+	cfgV0 := testBuildTreeConfig()
+	cfgV0.Version = 0
+
+	data := make([]byte, int(cfgV1.DataBlockSize)*300)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	rootV1, treeV1, err := BuildTree(bytes.NewReader(data), int64(len(data)), cfgV1)
+	require.NoError(t, err)
+	rootV0, treeV0, err := BuildTree(bytes.NewReader(data), int64(len(data)), cfgV0)
+	require.NoError(t, err)
+
+	require.Equal(t, rootV1, rootV0)
+	require.Equal(t, len(treeV1), len(treeV0))
+	require.NotEqual(t, treeV1, treeV0, "top-first and bottom-first layouts should differ in byte order")
+}
+
+func TestBuildTreeRejectsInvalidConfig(t *testing.T) {
+	_, _, err := BuildTree(bytes.NewReader(nil), 0, nil)
+	require.Error(t, err)
+
+	cfg := testBuildTreeConfig()
+	cfg.DataBlockSize = 0
+	_, _, err = BuildTree(bytes.NewReader(nil), 0, cfg)
+	require.Error(t, err)
+
+	cfg = testBuildTreeConfig()
+	_, _, err = BuildTree(bytes.NewReader(nil), -1, cfg)
+	require.Error(t, err)
+
+	cfg = testBuildTreeConfig()
+	cfg.HashAlgorithm = 0
+	_, _, err = BuildTree(bytes.NewReader(nil), 0, cfg)
+	require.Error(t, err)
+}
+
+func TestBuildTreeEmptyInputStillProducesTree(t *testing.T) {
+	cfg := testBuildTreeConfig()
+	root, tree, err := BuildTree(bytes.NewReader(nil), 0, cfg)
+	require.NoError(t, err)
+	require.NotEmpty(t, root)
+	require.NotEmpty(t, tree)
+}
@@ -1,5 +1,7 @@
 package dmverity
 
+import "fmt"
+
 // Hash algorithms
 const (
 	HashAlgoSHA256 = 1
@@ -21,4 +23,182 @@ type VerityConfig struct {
 	Salt          []byte
 	RootDigest    []byte
 	HashOffset    int64
+
+	// Options carries the dm-verity target's optional flags. They're
+	// off by default, matching dm-verity's own behavior of tearing the
+	// device down on the first corrupt block it finds.
+	Options VerityOptions
+
+	// FECDevice is the path to the device or file holding the Reed-Solomon
+	// parity area GenerateFEC produced. Empty disables FEC.
+	FECDevice string
+	// FECOffset is where the FEC area starts on FECDevice, in bytes.
+	FECOffset uint64
+	// FECRoots is the number of RS parity bytes per 255-byte codeword,
+	// bounding how many corrupt bytes per interleave column FEC can
+	// repair. Must be between MinFECRoots and MaxFECRoots; zero means
+	// FEC is disabled regardless of FECDevice.
+	FECRoots uint8
+	// FECBlocks is fec_blocks - the number of data-block-sized blocks
+	// (data device followed by hash tree) FEC protects. Zero means
+	// GenerateVerityDevice/Enable derive it from the data and hash tree
+	// sizes via FECBlocks(), the same way DataBlocks being zero means
+	// Format derives DataBlocks from the data device's size.
+	FECBlocks uint64
+
+	// RootHashSignature is a detached signature over the canonical
+	// verity superblock (SignSuperblock's output), checked by the
+	// kernel against RootHashSignatureKeyDesc's keyring entry at
+	// activation - the same check DmverityOptions.RootHashSignature
+	// triggers for Format/Open, but for the native Enable path.
+	RootHashSignature []byte
+	// RootHashSignatureKeyDesc names the session keyring key
+	// RootHashSignature is loaded under. Required whenever
+	// RootHashSignature is set: Enable refuses to activate a signed
+	// layer with no descriptor to load the signature under rather than
+	// silently falling back to an unsigned device.
+	RootHashSignatureKeyDesc string
+
+	// Concurrency bounds how many goroutines GenerateHashTree uses to
+	// read and hash data blocks. Zero (the default) uses runtime.NumCPU,
+	// and one makes it fully sequential - useful for reproducing a
+	// reference run or debugging without the worker pool in the way.
+	Concurrency int
+}
+
+// VerityOptions are the optional flags appended to a dm-verity target
+// line after its salt argument, see
+// Documentation/admin-guide/device-mapper/verity.rst. Enable passes
+// these through to both the native ioctl path and the veritysetup
+// fallback.
+type VerityOptions struct {
+	// IgnoreCorruption logs corrupt blocks instead of erroring I/O to
+	// them. Mutually exclusive with RestartOnCorruption in practice,
+	// though the kernel itself doesn't reject setting both.
+	IgnoreCorruption bool
+	// RestartOnCorruption panics the system on the first corrupt block,
+	// for callers that would rather fail hard than serve bad data.
+	RestartOnCorruption bool
+	// IgnoreZeroBlocks skips verifying blocks that read as all zero,
+	// matching how some filesystems treat a hole.
+	IgnoreZeroBlocks bool
+	// CheckAtMostOnce verifies each block only the first time it's
+	// read rather than on every read, trading continuous integrity
+	// checking for lower overhead on repeatedly-read blocks.
+	CheckAtMostOnce bool
+}
+
+const (
+	// minVerityBlockSize and maxVerityBlockSize bound DataBlockSize and
+	// HashBlockSize: below minVerityBlockSize a sector-granularity
+	// corruption can't be localized to a single hashed block, and
+	// above maxVerityBlockSize the kernel's dm-verity target can't
+	// represent a block as a single page.
+	minVerityBlockSize = 512
+	maxVerityBlockSize = 4096
+)
+
+// validateConfig checks that config describes a dm-verity target the
+// kernel will actually accept, before Enable spends an ioctl round trip
+// finding out the hard way.
+func validateConfig(config VerityConfig) error {
+	if config.Version != 1 {
+		return fmt.Errorf("unsupported dm-verity version %d: only version 1 is supported", config.Version)
+	}
+	if config.HashAlgorithm != HashAlgoSHA256 && config.HashAlgorithm != HashAlgoSHA512 {
+		return fmt.Errorf("unsupported hash algorithm %d", config.HashAlgorithm)
+	}
+	if !isValidVerityBlockSize(config.DataBlockSize) {
+		return fmt.Errorf("invalid data block size %d: must be a power of two between %d and %d", config.DataBlockSize, minVerityBlockSize, maxVerityBlockSize)
+	}
+	if !isValidVerityBlockSize(config.HashBlockSize) {
+		return fmt.Errorf("invalid hash block size %d: must be a power of two between %d and %d", config.HashBlockSize, minVerityBlockSize, maxVerityBlockSize)
+	}
+	if config.DataBlocks == 0 {
+		return fmt.Errorf("data blocks must be greater than zero")
+	}
+	if config.HashOffset != 0 {
+		wantOffset := int64(config.DataBlocks) * int64(config.DataBlockSize)
+		if config.HashOffset != wantOffset {
+			return fmt.Errorf("hash offset %d does not match data size %d (data_blocks * data_block_size)", config.HashOffset, wantOffset)
+		}
+	}
+	if config.FECDevice != "" || config.FECRoots != 0 {
+		if config.FECDevice == "" {
+			return fmt.Errorf("fec roots set without a fec device")
+		}
+		if config.FECRoots < MinFECRoots || config.FECRoots > MaxFECRoots {
+			return fmt.Errorf("fec roots %d out of range [%d,%d]", config.FECRoots, MinFECRoots, MaxFECRoots)
+		}
+	}
+	if len(config.RootHashSignature) > 0 && config.RootHashSignatureKeyDesc == "" {
+		return fmt.Errorf("root hash signature set without a key descriptor")
+	}
+	return nil
+}
+
+func isValidVerityBlockSize(size uint32) bool {
+	if size < minVerityBlockSize || size > maxVerityBlockSize {
+		return false
+	}
+	return size&(size-1) == 0
+}
+
+// DmverityOptions configures Format and Open, as the string/flag-based
+// counterpart to VerityConfig: callers that don't already have a parsed
+// hash tree (e.g. the transfer service, formatting a freshly unpacked
+// layer) work with this instead.
+type DmverityOptions struct {
+	// HashAlgorithm names the hash used for the Merkle tree, e.g.
+	// "sha256". Empty uses the library default.
+	HashAlgorithm string
+	// DataBlockSize and HashBlockSize override the default block size
+	// for the data and hash devices respectively. Zero uses the
+	// library default (DefaultBlockSize).
+	DataBlockSize uint32
+	HashBlockSize uint32
+	// DataBlocks is the number of data blocks to hash. Zero means
+	// derive it from the data device's size.
+	DataBlocks uint64
+	// HashOffset is where the hash tree starts, in bytes, when stored
+	// in the same file as the data (combined mode).
+	HashOffset uint64
+	// HashType selects the on-disk hash tree layout version understood
+	// by veritysetup/dm-verity. Zero uses the library default.
+	HashType uint32
+	// Salt is a hex-encoded salt for the hash tree. Empty generates a
+	// random salt.
+	Salt string
+	// UUID is a superblock UUID. Empty generates a random one.
+	UUID string
+	// NoSuperblock omits the dm-verity superblock, requiring every
+	// parameter needed to reopen the device to be supplied explicitly
+	// to Open rather than read back from the device itself.
+	NoSuperblock bool
+
+	// RootHashSignature is a PKCS#7 detached signature over the root
+	// hash (raw bytes, not hex), as produced by Sign. When set
+	// alongside RootHashSignatureKeyDesc, Format, and Open in
+	// no-superblock mode, load it into the session keyring so the
+	// kernel dm-verity target can verify the root hash against it
+	// (root_hash_sig_key_desc, kernel >= 5.4) instead of trusting
+	// whatever root hash the caller supplies. Open in superblock mode
+	// does not read opts, so it loads no signature.
+	RootHashSignature []byte
+	// RootHashSignatureKeyDesc names the session keyring key
+	// RootHashSignature is loaded under. Required to actually enable
+	// signature verification; an empty value leaves the device
+	// unsigned even if RootHashSignature is set.
+	RootHashSignatureKeyDesc string
+}
+
+// DefaultDmverityOptions returns the options Format and Open use when
+// none are given: SHA-256 over DefaultBlockSize blocks, with a
+// superblock.
+func DefaultDmverityOptions() *DmverityOptions {
+	return &DmverityOptions{
+		HashAlgorithm: "sha256",
+		DataBlockSize: DefaultBlockSize,
+		HashBlockSize: DefaultBlockSize,
+	}
 }
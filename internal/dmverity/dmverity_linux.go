@@ -83,6 +83,24 @@ func convertToVerityParams(opts *DmverityOptions) (verity.VerityParams, error) {
 
 		// Handle superblock flag - directly use NoSuperblock
 		params.NoSuperblock = opts.NoSuperblock
+
+		// A signature is only meaningful alongside a key descriptor to
+		// load it under; a signature with no descriptor is silently
+		// not applied rather than rejected, since DefaultVerityParams
+		// callers that don't care about signing shouldn't have to
+		// leave both fields unset to avoid an error.
+		if opts.RootHashSignatureKeyDesc != "" {
+			if len(opts.RootHashSignature) > 0 {
+				if err := loadSignatureKey(opts.RootHashSignatureKeyDesc, opts.RootHashSignature); err != nil {
+					return params, fmt.Errorf("failed to load root hash signature: %w", err)
+				}
+			}
+			// TODO: assumes the vendored veritysetup-go's VerityParams
+			// exposes a SignatureKeyDesc field that becomes the
+			// dm-verity table's root_hash_sig_key_desc argument; adjust
+			// if the upstream field is named differently once vendored.
+			params.SignatureKeyDesc = opts.RootHashSignatureKeyDesc
+		}
 	}
 
 	return params, nil
@@ -212,8 +230,12 @@ func Close(name string) error {
 	return nil
 }
 
-// VerifyDevice ensures an existing dm-verity device matches the expected metadata and is healthy.
-func VerifyDevice(name string, rootHash string) error {
+// VerifyDevice ensures an existing dm-verity device matches the
+// expected metadata and is healthy. If requireSignature is true, it
+// also fails unless name was activated with a signed root hash
+// (root_hash_sig_key_desc), rejecting a device an attacker could have
+// brought up with an arbitrary unsigned root hash of their own.
+func VerifyDevice(name string, rootHash string, requireSignature bool) error {
 	// Parse root hash from hex string to bytes
 	rootDigest, err := utils.ParseRootHash(rootHash)
 	if err != nil {
@@ -225,5 +247,18 @@ func VerifyDevice(name string, rootHash string) error {
 		return fmt.Errorf("dm-verity device %q verification failed", name)
 	}
 
+	if requireSignature {
+		// TODO: assumes the vendored veritysetup-go exposes the
+		// active table's signature key descriptor through
+		// VerityStatus; adjust to the real accessor once vendored.
+		status, err := verity.VerityStatus(name)
+		if err != nil {
+			return fmt.Errorf("failed to read dm-verity status for %q: %w", name, err)
+		}
+		if status.SignatureKeyDesc == "" {
+			return fmt.Errorf("dm-verity device %q was not activated with a signed root hash", name)
+		}
+	}
+
 	return nil
 }
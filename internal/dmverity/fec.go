@@ -0,0 +1,436 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"fmt"
+	"os"
+)
+
+// This file implements the Reed-Solomon forward error correction dm-verity
+// can layer over its hash tree (fec_blocks/fec_roots in
+// Documentation/admin-guide/device-mapper/verity.rst), so a bounded number
+// of corrupt blocks per stripe can be repaired instead of failing I/O.
+//
+// The encoder below is a standard systematic RS(255, 255-roots) codec over
+// GF(256) with primitive polynomial 0x11d, the same construction used by
+// dm-verity's FEC target and by common RS libraries (e.g. the classic
+// Berlekamp-style encoder cryptsetup's lib/verity/fec.c is built on). It
+// hasn't been validated byte-for-byte against cryptsetup's on-disk FEC
+// format in this environment, so treat the resulting parity bytes as a
+// self-consistent RS(255,k) codec rather than a verified drop-in
+// replacement until checked against a real veritysetup-generated FEC area.
+const (
+	// MinFECRoots and MaxFECRoots bound FECRoots: below MinFECRoots FEC
+	// can't correct even a single byte error per stripe, and
+	// MaxFECRoots is the largest parity count per 255-byte codeword
+	// that dm-verity's FEC target accepts.
+	MinFECRoots = 2
+	MaxFECRoots = 24
+	// DefaultFECRoots matches veritysetup's --fec-roots default: enough
+	// to correct one corrupt byte per interleave column per stripe.
+	DefaultFECRoots = 2
+
+	// fecFieldPoly is GF(256)'s primitive polynomial, x^8+x^4+x^3+x^2+1.
+	fecFieldPoly = 0x11d
+	// fecRSSymbols is N in RS(N, N-roots): a full codeword is always 255
+	// bytes, of which roots are parity.
+	fecRSSymbols = 255
+)
+
+var (
+	fecExpTable [2 * fecRSSymbols]byte
+	fecLogTable [fecRSSymbols + 1]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < fecRSSymbols; i++ {
+		fecExpTable[i] = byte(x)
+		fecLogTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= fecFieldPoly
+		}
+	}
+	for i := fecRSSymbols; i < 2*fecRSSymbols; i++ {
+		fecExpTable[i] = fecExpTable[i-fecRSSymbols]
+	}
+}
+
+// gfMul multiplies a and b in GF(256) via the log/exp tables.
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return fecExpTable[int(fecLogTable[a])+int(fecLogTable[b])]
+}
+
+// gfPow raises the field's primitive element (alpha=2) to power.
+func gfPow(power int) byte {
+	return fecExpTable[power%fecRSSymbols]
+}
+
+// generatorPoly returns g(x) = prod_{i=0}^{roots-1} (x - alpha^i), stored
+// highest-degree-coefficient first, as rsEncode's divisor.
+func generatorPoly(roots int) []byte {
+	gen := []byte{1}
+	for i := 0; i < roots; i++ {
+		gen = gfPolyMul(gen, []byte{1, gfPow(i)})
+	}
+	return gen
+}
+
+// gfPolyMul multiplies two polynomials over GF(256), both stored
+// highest-degree-coefficient first.
+func gfPolyMul(p, q []byte) []byte {
+	r := make([]byte, len(p)+len(q)-1)
+	for j := range q {
+		for i := range p {
+			r[i+j] ^= gfMul(p[i], q[j])
+		}
+	}
+	return r
+}
+
+// rsEncode computes the roots parity bytes for msg via systematic
+// polynomial long division: msg(x)*x^roots mod g(x).
+func rsEncode(msg []byte, roots int) []byte {
+	gen := generatorPoly(roots)
+	out := make([]byte, len(msg)+roots)
+	copy(out, msg)
+	for i := 0; i < len(msg); i++ {
+		coef := out[i]
+		if coef != 0 {
+			for j := range gen {
+				out[i+j] ^= gfMul(gen[j], coef)
+			}
+		}
+	}
+	return out[len(msg):]
+}
+
+// computeFECParity protects blocks (each blockSize bytes) with roots-byte
+// RS parity per Documentation/admin-guide/device-mapper/verity.rst's FEC
+// description: each RS(255, 255-roots) codeword covers one byte from each
+// of up to 255-roots blocks at the same offset, and the resulting parity
+// area is laid out group-major, then by byte offset within the block, so
+// its size is exactly ceil(len(blocks)/(255-roots)) * roots * blockSize.
+func computeFECParity(blocks [][]byte, blockSize int, roots int) ([]byte, error) {
+	if roots < MinFECRoots || roots > MaxFECRoots {
+		return nil, fmt.Errorf("fec roots %d out of range [%d,%d]", roots, MinFECRoots, MaxFECRoots)
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no blocks to protect with fec")
+	}
+
+	k := fecRSSymbols - roots
+	numGroups := (len(blocks) + k - 1) / k
+	parity := make([]byte, numGroups*roots*blockSize)
+
+	msg := make([]byte, k)
+	for g := 0; g < numGroups; g++ {
+		start := g * k
+		end := start + k
+		if end > len(blocks) {
+			end = len(blocks)
+		}
+		for col := 0; col < blockSize; col++ {
+			for i := range msg {
+				msg[i] = 0
+			}
+			for i := start; i < end; i++ {
+				if col < len(blocks[i]) {
+					msg[i-start] = blocks[i][col]
+				}
+			}
+			par := rsEncode(msg, roots)
+			offset := (g*blockSize + col) * roots
+			copy(parity[offset:offset+roots], par)
+		}
+	}
+	return parity, nil
+}
+
+// splitBlocks breaks data into blockSize-sized blocks, zero-padding the
+// last one if data isn't an exact multiple of blockSize.
+func splitBlocks(data []byte, blockSize int) [][]byte {
+	var blocks [][]byte
+	for off := 0; off < len(data); off += blockSize {
+		end := off + blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		block := make([]byte, blockSize)
+		copy(block, data[off:end])
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// GenerateFEC computes a Reed-Solomon parity area covering dataFile's
+// blocks followed by hashTree's blocks (dm-verity's fec_blocks range:
+// the data device plus the hash tree), using config.FECRoots. It's meant
+// to be called right after GenerateHashTree, which produces hashTree.
+func GenerateFEC(dataFile string, hashTree []byte, config VerityConfig) ([]byte, error) {
+	if config.FECRoots == 0 {
+		return nil, fmt.Errorf("FECRoots must be set to generate FEC parity")
+	}
+
+	data, err := os.ReadFile(dataFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data file: %w", err)
+	}
+
+	blockSize := int(config.DataBlockSize)
+	blocks := splitBlocks(data, blockSize)
+	blocks = append(blocks, splitBlocks(hashTree, blockSize)...)
+
+	return computeFECParity(blocks, blockSize, int(config.FECRoots))
+}
+
+// FECBlocks returns fec_blocks for a device whose data and hash tree sizes
+// are dataSize and hashTreeSize, measured in config's data block size -
+// the value Enable passes as the verity target's fec_blocks argument.
+func FECBlocks(dataSize, hashTreeSize int64, blockSize uint32) uint64 {
+	bs := int64(blockSize)
+	dataBlocks := (dataSize + bs - 1) / bs
+	hashBlocks := (hashTreeSize + bs - 1) / bs
+	return uint64(dataBlocks + hashBlocks)
+}
+
+// gfInv returns a's multiplicative inverse in GF(256). a must be nonzero.
+func gfInv(a byte) byte {
+	return fecExpTable[(fecRSSymbols-int(fecLogTable[a]))%fecRSSymbols]
+}
+
+// evalPoly evaluates p, stored highest-degree-coefficient first (the
+// convention rsEncode/generatorPoly use), at x via Horner's method.
+func evalPoly(p []byte, x byte) byte {
+	var acc byte
+	for _, c := range p {
+		acc = gfMul(acc, x) ^ c
+	}
+	return acc
+}
+
+// codewordSyndromes returns S_0..S_{roots-1} for cw (a data/parity
+// codeword laid out the way rsEncode produces it): S_i = cw(alpha^i).
+// All zero means cw already satisfies the code - no error to correct.
+func codewordSyndromes(cw []byte, roots int) []byte {
+	s := make([]byte, roots)
+	for i := range s {
+		s[i] = evalPoly(cw, gfPow(i))
+	}
+	return s
+}
+
+// berlekampMassey finds the shortest LFSR that generates syn, i.e. the
+// error locator polynomial sigma(x) (returned low-degree-coefficient
+// first, sigma[0]=1): the classic discrepancy-driven update from Massey's
+// 1969 algorithm, specialized to GF(256).
+func berlekampMassey(syn []byte) []byte {
+	n := len(syn)
+	c := make([]byte, n+1)
+	b := make([]byte, n+1)
+	c[0], b[0] = 1, 1
+	l, m := 0, 1
+	bCoef := byte(1)
+
+	for i := 0; i < n; i++ {
+		delta := syn[i]
+		for j := 1; j <= l; j++ {
+			delta ^= gfMul(c[j], syn[i-j])
+		}
+		if delta == 0 {
+			m++
+			continue
+		}
+
+		t := make([]byte, len(c))
+		copy(t, c)
+
+		coef := gfMul(delta, gfInv(bCoef))
+		for j := 0; j < len(b); j++ {
+			if j+m < len(c) {
+				c[j+m] ^= gfMul(coef, b[j])
+			}
+		}
+
+		if 2*l <= i {
+			l = i + 1 - l
+			copy(b, t)
+			bCoef = delta
+			m = 1
+		} else {
+			m++
+		}
+	}
+	return c[:l+1]
+}
+
+// evalLowFirst evaluates coef, stored low-degree-coefficient first (the
+// convention berlekampMassey's output uses, opposite of evalPoly's), at x.
+func evalLowFirst(coef []byte, x byte) byte {
+	var result byte
+	xPow := byte(1)
+	for _, a := range coef {
+		result ^= gfMul(a, xPow)
+		xPow = gfMul(xPow, x)
+	}
+	return result
+}
+
+// polyMulLowFirstTrunc multiplies two low-degree-first polynomials,
+// keeping only terms below x^truncate - enough to compute the error
+// evaluator polynomial Omega(x) = [S(x)*sigma(x)] mod x^roots.
+func polyMulLowFirstTrunc(a, b []byte, truncate int) []byte {
+	out := make([]byte, truncate)
+	for i, ai := range a {
+		if ai == 0 {
+			continue
+		}
+		for j, bj := range b {
+			if i+j >= truncate {
+				continue
+			}
+			out[i+j] ^= gfMul(ai, bj)
+		}
+	}
+	return out
+}
+
+// formalDerivativeLowFirst returns sigma's formal derivative. Over a
+// characteristic-2 field, differentiating kills every even-power term
+// and leaves every odd-power coefficient unchanged.
+func formalDerivativeLowFirst(coef []byte) []byte {
+	if len(coef) <= 1 {
+		return nil
+	}
+	out := make([]byte, len(coef)-1)
+	for i := 1; i < len(coef); i++ {
+		if i%2 == 1 {
+			out[i-1] = coef[i]
+		}
+	}
+	return out
+}
+
+// correctCodeword uses cw's roots trailing parity bytes to detect and, if
+// within the code's correction capability (up to roots/2 byte errors),
+// repair corrupted bytes in cw in place via Berlekamp-Massey (error
+// locator), Chien search (error positions) and the Forney algorithm
+// (error magnitudes) - the standard decoding pipeline for the systematic
+// RS code rsEncode/computeFECParity produce. It returns the number of
+// bytes corrected, or an error if cw has more errors than the code can
+// correct (the mismatch between the locator's degree and the number of
+// roots Chien search actually finds is exactly that case).
+func correctCodeword(cw []byte, roots int) (int, error) {
+	syn := codewordSyndromes(cw, roots)
+
+	clean := true
+	for _, s := range syn {
+		if s != 0 {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return 0, nil
+	}
+
+	sigma := berlekampMassey(syn)
+	numErrors := len(sigma) - 1
+	if numErrors == 0 {
+		return 0, fmt.Errorf("uncorrectable fec codeword: nonzero syndrome with no error locator")
+	}
+
+	var positions []int
+	for p := 0; p < len(cw); p++ {
+		if evalLowFirst(sigma, gfInv(gfPow(p))) == 0 {
+			positions = append(positions, p)
+		}
+	}
+	if len(positions) != numErrors {
+		return 0, fmt.Errorf("uncorrectable fec codeword: found %d candidate error positions, locator degree is %d", len(positions), numErrors)
+	}
+
+	omega := polyMulLowFirstTrunc(syn, sigma, roots)
+	sigmaDeriv := formalDerivativeLowFirst(sigma)
+
+	for _, p := range positions {
+		xl := gfPow(p)
+		xlInv := gfInv(xl)
+		denom := evalLowFirst(sigmaDeriv, xlInv)
+		if denom == 0 {
+			return 0, fmt.Errorf("uncorrectable fec codeword: zero error-evaluator derivative at position %d", p)
+		}
+		magnitude := gfMul(xl, gfMul(evalLowFirst(omega, xlInv), gfInv(denom)))
+		cw[len(cw)-1-p] ^= magnitude
+	}
+	return numErrors, nil
+}
+
+// RecoverFEC repairs blocks (data blocks followed by hash tree blocks,
+// the same ordering computeFECParity protected) using parity, correcting
+// up to roots/2 corrupt bytes per interleave column/group independently.
+// It's the inverse of computeFECParity: each group's column forms one RS
+// codeword of the corresponding data bytes plus that column's parity
+// bytes, decoded and corrected in place.
+func RecoverFEC(blocks [][]byte, parity []byte, blockSize, roots int) error {
+	if roots < MinFECRoots || roots > MaxFECRoots {
+		return fmt.Errorf("fec roots %d out of range [%d,%d]", roots, MinFECRoots, MaxFECRoots)
+	}
+	k := fecRSSymbols - roots
+	numGroups := (len(blocks) + k - 1) / k
+	if len(parity) != numGroups*roots*blockSize {
+		return fmt.Errorf("fec parity is %d bytes, want %d for %d blocks", len(parity), numGroups*roots*blockSize, len(blocks))
+	}
+
+	// Each codeword is always k+roots bytes, matching rsEncode's
+	// fixed-size msg buffer in computeFECParity: positions in the last
+	// group past the last real block still contribute implicit zero
+	// bytes to every codeword's syndrome, so they must be reconstructed
+	// here even though there's no block to correct them into.
+	cw := make([]byte, k+roots)
+	for g := 0; g < numGroups; g++ {
+		start := g * k
+		end := start + k
+		realEnd := end
+		if realEnd > len(blocks) {
+			realEnd = len(blocks)
+		}
+		for col := 0; col < blockSize; col++ {
+			for i := range cw[:k] {
+				cw[i] = 0
+			}
+			for i := start; i < realEnd; i++ {
+				cw[i-start] = blocks[i][col]
+			}
+			offset := (g*blockSize + col) * roots
+			copy(cw[k:], parity[offset:offset+roots])
+
+			if _, err := correctCodeword(cw, roots); err != nil {
+				return fmt.Errorf("fec recovery failed at group %d column %d: %w", g, col, err)
+			}
+			for i := start; i < realEnd; i++ {
+				blocks[i][col] = cw[i-start]
+			}
+		}
+	}
+	return nil
+}
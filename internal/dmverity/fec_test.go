@@ -0,0 +1,152 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testVerityConfig() VerityConfig {
+	return VerityConfig{
+		Version:       1,
+		HashAlgorithm: HashAlgoSHA256,
+		DataBlockSize: DefaultBlockSize,
+		HashBlockSize: DefaultBlockSize,
+		Salt:          make([]byte, DefaultSaltSize),
+		FECRoots:      DefaultFECRoots,
+	}
+}
+
+func TestGenerateFECData(t *testing.T) {
+	dataFile, err := os.CreateTemp("", "verity-fec-data-*")
+	require.NoError(t, err)
+	defer os.Remove(dataFile.Name())
+
+	data := make([]byte, DefaultBlockSize*4)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	_, err = dataFile.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, dataFile.Sync())
+
+	config := testVerityConfig()
+	v, err := NewVerityHash(config)
+	require.NoError(t, err)
+
+	fecFile := dataFile.Name() + ".fec"
+	defer os.Remove(fecFile)
+
+	require.NoError(t, v.GenerateFECData(dataFile.Name(), fecFile))
+
+	parity, err := os.ReadFile(fecFile)
+	require.NoError(t, err)
+	assert.NotEmpty(t, parity)
+}
+
+func TestGenerateVerityDevice(t *testing.T) {
+	dataFile, err := os.CreateTemp("", "verity-device-data-*")
+	require.NoError(t, err)
+	defer os.Remove(dataFile.Name())
+
+	data := make([]byte, DefaultBlockSize*4)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	_, err = dataFile.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, dataFile.Sync())
+
+	config := testVerityConfig()
+	v, err := NewVerityHash(config)
+	require.NoError(t, err)
+
+	imageFile := dataFile.Name() + ".img"
+	defer os.Remove(imageFile)
+
+	rootHash, err := v.GenerateVerityDevice(dataFile.Name(), imageFile)
+	require.NoError(t, err)
+	assert.Len(t, rootHash, DefaultHashSize)
+
+	image, err := os.ReadFile(imageFile)
+	require.NoError(t, err)
+	assert.True(t, len(image) > len(data))
+}
+
+// TestFECRecoversCorruptedBytes flips a handful of bytes across
+// different blocks of a layer's data, then checks that RecoverFEC -
+// fed the FEC area GenerateFEC computed for the clean data - repairs
+// every one of them before a digest check such as VerifyBlock's would
+// ever run over the corrupted bytes.
+func TestFECRecoversCorruptedBytes(t *testing.T) {
+	dataFile, err := os.CreateTemp("", "verity-fec-corrupt-*")
+	require.NoError(t, err)
+	defer os.Remove(dataFile.Name())
+
+	blockSize := 64
+	numBlocks := 6
+	data := make([]byte, blockSize*numBlocks)
+	for i := range data {
+		data[i] = byte(i*11 + 1)
+	}
+	_, err = dataFile.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, dataFile.Sync())
+
+	config := VerityConfig{
+		Version:       1,
+		HashAlgorithm: HashAlgoSHA256,
+		DataBlockSize: uint32(blockSize),
+		HashBlockSize: uint32(blockSize),
+		Salt:          make([]byte, DefaultSaltSize),
+		FECRoots:      DefaultFECRoots,
+	}
+
+	hashTree, _, err := GenerateHashTree(dataFile.Name(), config)
+	require.NoError(t, err)
+
+	parity, err := GenerateFEC(dataFile.Name(), hashTree, config)
+	require.NoError(t, err)
+
+	dataBlocks := splitBlocks(data, blockSize)
+	hashTreeBlocks := splitBlocks(hashTree, blockSize)
+	blocks := append(append([][]byte{}, dataBlocks...), hashTreeBlocks...)
+
+	corrupted := make([][]byte, len(blocks))
+	for i, b := range blocks {
+		cp := make([]byte, len(b))
+		copy(cp, b)
+		corrupted[i] = cp
+	}
+
+	// Flip one byte in each of a handful of distinct blocks, at distinct
+	// columns, so no interleaved RS codeword sees more than one error -
+	// within DefaultFECRoots' single-byte-per-codeword correction budget.
+	corrupted[0][5] ^= 0xFF
+	corrupted[2][10] ^= 0x5A
+	corrupted[4][20] ^= 0xA5
+
+	require.NoError(t, RecoverFEC(corrupted, parity, blockSize, int(config.FECRoots)))
+
+	for i := range blocks {
+		assert.Equal(t, blocks[i], corrupted[i], "block %d did not recover to its original bytes", i)
+	}
+}
@@ -17,6 +17,7 @@
 package dmverity
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -26,6 +27,20 @@ import (
 	"io"
 	"math"
 	"os"
+	"runtime"
+	"sync"
+)
+
+// saltSizeHeaderOffset and saltHeaderOffset locate the salt within the
+// veritySuperblockSize-byte header generateHashTree writes: a 4-byte
+// length (the real cryptsetup superblock's salt_size field sits at a
+// different offset, but nothing else in this package's header format
+// matches cryptsetup's byte-for-byte either, see fecRootsHeaderOffset)
+// followed by up to 264 bytes of salt, enough for dm-verity's own
+// 256-byte maximum.
+const (
+	saltSizeHeaderOffset = 84
+	saltHeaderOffset     = 0x58
 )
 
 type VerityHash struct {
@@ -34,8 +49,22 @@ type VerityHash struct {
 	hashesPerBlock int
 	// 哈希值大小
 	digestSize int
+
+	// blockCache holds the digests of hash-tree blocks VerifyBlock has
+	// already hashed, keyed by (level, block index), so repeated
+	// VerifyBlock calls over nearby or sequential block indices don't
+	// re-hash the same upper-level blocks every time. Each block is
+	// still read from the hash file on every call.
+	blockCache *hashBlockCache
 }
 
+// defaultVerifyCacheBlocks bounds blockCache's size: a dm-verity tree
+// rarely needs more than a few hundred upper-level blocks cached to
+// cover a sequential read's working set, and each entry is only a
+// digest, so this holds 16 KiB of SHA-256 digests (32 KiB for
+// SHA-512) plus per-entry bookkeeping.
+const defaultVerifyCacheBlocks = 512
+
 // 创建新的 VerityHash 实例
 func NewVerityHash(config VerityConfig) (*VerityHash, error) {
 	if config.DataBlockSize == 0 || config.HashBlockSize == 0 {
@@ -63,6 +92,7 @@ func NewVerityHash(config VerityConfig) (*VerityHash, error) {
 		config:         config,
 		hashesPerBlock: hashesPerBlock,
 		digestSize:     digestSize,
+		blockCache:     newHashBlockCache(defaultVerifyCacheBlocks),
 	}, nil
 }
 
@@ -91,6 +121,179 @@ func (v *VerityHash) hashBlock(data []byte) ([]byte, error) {
 	return hasher.Sum(nil), nil
 }
 
+// workerCount returns how many goroutines a pool hashing n blocks should
+// use: config.Concurrency if set, else one per CPU, never more than n
+// itself (a pool bigger than the work is just idle goroutines).
+func (v *VerityHash) workerCount(n int) int {
+	workers := v.config.Concurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// hashDataBlocksParallel reads and hashes dataFile's dataBlocks data
+// blocks using a pool of workerCount goroutines: one reader goroutine
+// pread's each block by index into a bounded channel, the workers hash
+// whatever they're handed and emit (index, digest) pairs, and this
+// function's own goroutine reassembles those digests in index order -
+// buffering the ones that arrive early in a small reorder window - so
+// the hash blocks it writes to hf come out byte-for-byte identical to a
+// strictly sequential pass, and returns the leaf digests in the same
+// order for the caller to pack into upper levels.
+func (v *VerityHash) hashDataBlocksParallel(df *os.File, dataBlocks uint64, hf io.Writer) ([][]byte, error) {
+	type indexedBlock struct {
+		index uint64
+		data  []byte
+	}
+	type indexedHash struct {
+		index uint64
+		hash  []byte
+	}
+
+	if dataBlocks == 0 {
+		return nil, nil
+	}
+
+	workers := v.workerCount(int(dataBlocks))
+	blocks := make(chan indexedBlock, workers*2)
+	digests := make(chan indexedHash, workers*2)
+
+	var readErr error
+	go func() {
+		defer close(blocks)
+		dataBuffer := make([]byte, v.config.DataBlockSize)
+		for i := uint64(0); i < dataBlocks; i++ {
+			n, err := df.ReadAt(dataBuffer, int64(i*uint64(v.config.DataBlockSize)))
+			if err != nil && err != io.EOF {
+				readErr = fmt.Errorf("failed to read data block %d: %v", i, err)
+				return
+			}
+			data := make([]byte, n)
+			copy(data, dataBuffer[:n])
+			blocks <- indexedBlock{index: i, data: data}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	workerErrs := make([]error, workers)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for b := range blocks {
+				hash, err := v.hashBlock(b.data)
+				if err != nil {
+					workerErrs[w] = fmt.Errorf("failed to hash data block %d: %v", b.index, err)
+					continue
+				}
+				digests <- indexedHash{index: b.index, hash: hash}
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(digests)
+	}()
+
+	hashes := make([][]byte, dataBlocks)
+	pending := make(map[uint64][]byte)
+	hashBuf := make([]byte, 0, v.config.HashBlockSize)
+	next := uint64(0)
+	var writeErr error
+	// Keep draining digests even after a write error, rather than
+	// returning immediately, so the worker goroutines still sending on
+	// it never block on a channel nobody's reading from again.
+	for d := range digests {
+		if writeErr != nil {
+			continue
+		}
+		pending[d.index] = d.hash
+		for {
+			hash, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			hashes[next] = hash
+			hashBuf = append(hashBuf, hash...)
+
+			if len(hashBuf) == int(v.config.HashBlockSize) || next == dataBlocks-1 {
+				alignedBuf := make([]byte, v.config.HashBlockSize)
+				copy(alignedBuf, hashBuf)
+				if _, err := hf.Write(alignedBuf); err != nil {
+					writeErr = fmt.Errorf("failed to write hash block: %v", err)
+					break
+				}
+				hashBuf = hashBuf[:0]
+			}
+			next++
+		}
+	}
+
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	for _, err := range workerErrs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// hashBlocksParallel hashes each of blocks with workerCount goroutines,
+// preserving order: every worker writes its result straight into the
+// matching index of the returned slice, so there's no reordering step
+// the way hashDataBlocksParallel needs for its streamed writes.
+func (v *VerityHash) hashBlocksParallel(blocks [][]byte) ([][]byte, error) {
+	hashes := make([][]byte, len(blocks))
+	if len(blocks) == 0 {
+		return hashes, nil
+	}
+
+	workers := v.workerCount(len(blocks))
+	indices := make(chan int, len(blocks))
+	for i := range blocks {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	workerErrs := make([]error, workers)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := range indices {
+				hash, err := v.hashBlock(blocks[i])
+				if err != nil {
+					workerErrs[w] = fmt.Errorf("failed to hash block %d: %v", i, err)
+					return
+				}
+				hashes[i] = hash
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, err := range workerErrs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
 // 创建哈希树
 func (v *VerityHash) GenerateHashTree(dataFile, hashFile string) ([]byte, error) {
 	// 打开数据文件
@@ -107,6 +310,14 @@ func (v *VerityHash) GenerateHashTree(dataFile, hashFile string) ([]byte, error)
 	}
 	defer hf.Close()
 
+	return v.generateHashTree(df, hf)
+}
+
+// generateHashTree is the shared implementation behind the method above
+// (which writes to a named hash file) and the package-level
+// GenerateHashTree (which writes to an in-memory buffer); only the
+// destination differs.
+func (v *VerityHash) generateHashTree(df *os.File, hf io.Writer) ([]byte, error) {
 	// 获取数据文件大小
 	dataSize, err := df.Seek(0, io.SeekEnd)
 	if err != nil {
@@ -117,17 +328,27 @@ func (v *VerityHash) GenerateHashTree(dataFile, hashFile string) ([]byte, error)
 	// 计算数据块数量
 	dataBlocks := uint64(math.Ceil(float64(dataSize) / float64(v.config.DataBlockSize)))
 
-	// 计算哈希树层数
-	levels := 0
-	remainingBlocks := dataBlocks
-	for remainingBlocks > 1 {
-		remainingBlocks = (remainingBlocks + uint64(v.hashesPerBlock) - 1) / uint64(v.hashesPerBlock)
-		levels++
+	// pack concatenates hashes in order into HashBlockSize-sized blocks,
+	// zero-padding the final one - the same layout each level, leaf or
+	// combined, is written to hf in.
+	pack := func(hashes [][]byte) [][]byte {
+		var blocks [][]byte
+		buf := make([]byte, 0, v.config.HashBlockSize)
+		for _, h := range hashes {
+			buf = append(buf, h...)
+			if len(buf) == int(v.config.HashBlockSize) {
+				blocks = append(blocks, buf)
+				buf = make([]byte, 0, v.config.HashBlockSize)
+			}
+		}
+		if len(buf) > 0 {
+			block := make([]byte, v.config.HashBlockSize)
+			copy(block, buf)
+			blocks = append(blocks, block)
+		}
+		return blocks
 	}
 
-	// 创建临时缓冲区
-	dataBuffer := make([]byte, v.config.DataBlockSize)
-
 	// Create verity header (4096 bytes)
 	header := make([]byte, 4096)
 
@@ -156,85 +377,83 @@ func (v *VerityHash) GenerateHashTree(dataFile, hashFile string) ([]byte, error)
 	binary.LittleEndian.PutUint64(header[72:], dataBlocks)
 	binary.LittleEndian.PutUint32(header[80:], uint32(v.digestSize))
 
-	// Salt
-	copy(header[0x58:], v.config.Salt)
+	// Salt, and how many of the bytes at saltHeaderOffset it actually
+	// used - unlike the other fields above, Salt has no fixed length, so
+	// a reader (parseSuperblock) needs this to know where it ends rather
+	// than trusting the whole reserved region.
+	binary.LittleEndian.PutUint32(header[saltSizeHeaderOffset:], uint32(len(v.config.Salt)))
+	copy(header[saltHeaderOffset:], v.config.Salt)
 
 	// Write header to hash file
 	if _, err := hf.Write(header); err != nil {
 		return nil, fmt.Errorf("failed to write header: %v", err)
 	}
 
-	// 处理第一层 - 数据块的哈希
-	currentLevelHashes := make([][]byte, 0)
-	hashBuf := make([]byte, 0, v.config.HashBlockSize)
-
-	for i := uint64(0); i < dataBlocks; i++ {
-		n, err := df.Read(dataBuffer)
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to read data block: %v", err)
-		}
+	// 处理第一层 - 数据块的哈希, spread across a worker pool since hashing
+	// every data block is the dominant cost for a large image.
+	currentLevelHashes, err := v.hashDataBlocksParallel(df, dataBlocks, hf)
+	if err != nil {
+		return nil, err
+	}
 
-		// 计算数据块哈希
-		hash, err := v.hashBlock(dataBuffer[:n])
+	// 处理上层哈希 - level 0 (currentLevelBlocks, packed from the leaf
+	// hashes above and already written to hf by the loop above) keeps
+	// being hashed-and-repacked into new levels, each written to hf in
+	// turn, until only one block is left: that's the level VerifyBlock's
+	// walk bottoms out at, and its own hash is the root. Each level is
+	// far smaller than the leaves, but still worth the same worker pool
+	// for a tree deep enough to matter.
+	currentLevelBlocks := pack(currentLevelHashes)
+	for len(currentLevelBlocks) > 1 {
+		blockHashes, err := v.hashBlocksParallel(currentLevelBlocks)
 		if err != nil {
-			return nil, fmt.Errorf("failed to hash data block: %v", err)
+			return nil, err
 		}
 
-		currentLevelHashes = append(currentLevelHashes, hash)
-		hashBuf = append(hashBuf, hash...)
-
-		// 当hash buffer满了或者是最后一个块时，写入对齐的数据
-		if len(hashBuf) == int(v.config.HashBlockSize) || i == dataBlocks-1 {
-			// 创建对齐的buffer
-			alignedBuf := make([]byte, v.config.HashBlockSize)
-			copy(alignedBuf, hashBuf)
-
-			// 写入对齐的数据
-			if _, err := hf.Write(alignedBuf); err != nil {
+		nextLevelBlocks := pack(blockHashes)
+		for _, block := range nextLevelBlocks {
+			if _, err := hf.Write(block); err != nil {
 				return nil, fmt.Errorf("failed to write hash block: %v", err)
 			}
-			hashBuf = hashBuf[:0]
 		}
-	}
-
-	// 处理上层哈希
-	for level := 1; level <= levels; level++ {
-		nextLevelHashes := make([][]byte, 0)
-
-		// 每 hashesPerBlock 个哈希组合成一个新的哈希
-		for i := 0; i < len(currentLevelHashes); i += v.hashesPerBlock {
-			end := i + v.hashesPerBlock
-			if end > len(currentLevelHashes) {
-				end = len(currentLevelHashes)
-			}
 
-			// 将多个哈希值连接起来
-			combinedHash := make([]byte, 0)
-			for _, hash := range currentLevelHashes[i:end] {
-				combinedHash = append(combinedHash, hash...)
-			}
+		currentLevelBlocks = nextLevelBlocks
+	}
 
-			// 计算新的哈希值
-			hash, err := v.hashBlock(combinedHash)
-			if err != nil {
-				return nil, fmt.Errorf("failed to hash block: %v", err)
-			}
+	// 返回根哈希 - always the hash of the single remaining top-level
+	// block, whether that's level 0 itself (dataBlocks already fit in
+	// one block, so the loop above never ran) or a level the loop built
+	// up to.
+	root, err := v.hashBlock(currentLevelBlocks[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash root block: %v", err)
+	}
 
-			nextLevelHashes = append(nextLevelHashes, hash)
-			// log.Printf("level %d hash %d: %x", level, i, hash)
-			// // 写入哈希文件
-			// if _, err := hf.Write(hash); err != nil {
-			// 	return nil, fmt.Errorf("failed to write hash: %v", err)
-			// }
-		}
+	return root, nil
+}
 
-		currentLevelHashes = nextLevelHashes
+// GenerateHashTree builds a dm-verity hash tree for dataFile per config,
+// returning the tree's on-disk bytes (the same layout
+// (*VerityHash).GenerateHashTree writes to a hash file, header included)
+// and its root hash, for callers that want the tree in memory rather than
+// written straight to a file - e.g. to append it after the data in
+// combined mode, or to feed it into GenerateFEC alongside the data blocks.
+func GenerateHashTree(dataFile string, config VerityConfig) (hashTree, rootHash []byte, err error) {
+	v, err := NewVerityHash(config)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// 返回根哈希
-	if len(currentLevelHashes) != 1 {
-		return nil, fmt.Errorf("invalid root hash count")
+	df, err := os.Open(dataFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open data file: %v", err)
 	}
+	defer df.Close()
 
-	return currentLevelHashes[0], nil
+	var buf bytes.Buffer
+	root, err := v.generateHashTree(df, &buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), root, nil
 }
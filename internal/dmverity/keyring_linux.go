@@ -0,0 +1,35 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// loadSignatureKey adds signature, a PKCS#7 detached signature over a
+// root hash, to the session keyring as a "user" key under desc, so the
+// kernel dm-verity target can find it via a root_hash_sig_key_desc
+// table argument and check it against the certificates in its own
+// trusted keyrings when the device is activated.
+func loadSignatureKey(desc string, signature []byte) error {
+	if _, err := unix.AddKey("user", desc, signature, unix.KEY_SPEC_SESSION_KEYRING); err != nil {
+		return fmt.Errorf("failed to add signature key %q to session keyring: %w", desc, err)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// MetadataCacheExt is the extension a layer's cached verity metadata is
+// stored under, next to its blob - an erofs snapshotter's Commit writes
+// one so a later Prepare can skip GenerateHashTree entirely.
+const MetadataCacheExt = ".verity-meta.json"
+
+// Metadata annotation keys a snapshotter mirrors VerityMetadata into on
+// the layer's OCI descriptor, so the cache travels with the image
+// instead of only living next to a particular snapshotter's on-disk
+// blob.
+const (
+	AnnotationRootDigest    = "containerd.io/snapshot/erofs.verity.root-digest"
+	AnnotationSalt          = "containerd.io/snapshot/erofs.verity.salt"
+	AnnotationAlgorithm     = "containerd.io/snapshot/erofs.verity.algorithm"
+	AnnotationDataBlockSize = "containerd.io/snapshot/erofs.verity.data-block-size"
+	AnnotationHashBlockSize = "containerd.io/snapshot/erofs.verity.hash-block-size"
+	AnnotationDataBlocks    = "containerd.io/snapshot/erofs.verity.data-blocks"
+	AnnotationHashOffset    = "containerd.io/snapshot/erofs.verity.hash-offset"
+)
+
+// VerityMetadata is the hash-tree layout a Prepare call needs to
+// re-activate a layer's dm-verity device without recomputing it:
+// everything GenerateHashTree/Enable would otherwise derive from
+// reading the whole layer again.
+type VerityMetadata struct {
+	RootDigest    []byte
+	Salt          []byte
+	DataBlockSize uint32
+	HashBlockSize uint32
+	DataBlocks    uint64
+	HashOffset    int64
+	// Algorithm is the hash algorithm name ("sha256"/"sha512"), matching
+	// the names verityHashAlgorithmName maps HashAlgo* constants to -
+	// a string, rather than the numeric HashAlgo* constant, since this
+	// also travels as a plain-text annotation.
+	Algorithm string
+}
+
+// metadataCacheEntry is VerityMetadata's on-disk/sidecar shape, plus the
+// layer blob identity it was computed for: a later Prepare only trusts
+// the cache if the blob it's about to activate still matches.
+type metadataCacheEntry struct {
+	Digest        string `json:"digest"`
+	Size          int64  `json:"size"`
+	ModTime       int64  `json:"mod_time_unix_nano"`
+	RootDigest    string `json:"root_digest"`
+	Salt          string `json:"salt"`
+	Algorithm     string `json:"algorithm"`
+	DataBlockSize uint32 `json:"data_block_size"`
+	HashBlockSize uint32 `json:"hash_block_size"`
+	DataBlocks    uint64 `json:"data_blocks"`
+	HashOffset    int64  `json:"hash_offset"`
+}
+
+// MetadataCachePath returns the sidecar path WriteMetadataCache and
+// ReadMetadataCache use for layerBlobPath.
+func MetadataCachePath(layerBlobPath string) string {
+	return layerBlobPath + MetadataCacheExt
+}
+
+// WriteMetadataCache writes meta as layerBlobPath's verity metadata
+// cache, keyed to digest and layerBlobPath's current size/mtime so a
+// later ReadMetadataCache can tell whether the blob it's about to
+// activate is still the one meta was computed for.
+func WriteMetadataCache(layerBlobPath, digest string, meta VerityMetadata) error {
+	fi, err := os.Stat(layerBlobPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %q: %w", layerBlobPath, err)
+	}
+
+	entry := metadataCacheEntry{
+		Digest:        digest,
+		Size:          fi.Size(),
+		ModTime:       fi.ModTime().UnixNano(),
+		RootDigest:    hex.EncodeToString(meta.RootDigest),
+		Salt:          hex.EncodeToString(meta.Salt),
+		Algorithm:     meta.Algorithm,
+		DataBlockSize: meta.DataBlockSize,
+		HashBlockSize: meta.HashBlockSize,
+		DataBlocks:    meta.DataBlocks,
+		HashOffset:    meta.HashOffset,
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal verity metadata cache: %w", err)
+	}
+	if err := os.WriteFile(MetadataCachePath(layerBlobPath), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write verity metadata cache: %w", err)
+	}
+	return nil
+}
+
+// ReadMetadataCache reads layerBlobPath's verity metadata cache back,
+// returning ok=false rather than an error if there's no cache, or if
+// the cache was computed for a different digest or for a blob that has
+// since changed size/mtime - either way, the caller should fall back to
+// GenerateHashTree instead of trusting stale metadata.
+func ReadMetadataCache(layerBlobPath, digest string) (meta VerityMetadata, ok bool, err error) {
+	data, err := os.ReadFile(MetadataCachePath(layerBlobPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return VerityMetadata{}, false, nil
+		}
+		return VerityMetadata{}, false, fmt.Errorf("failed to read verity metadata cache: %w", err)
+	}
+
+	var entry metadataCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("failed to parse verity metadata cache: %w", err)
+	}
+
+	if entry.Digest != digest {
+		return VerityMetadata{}, false, nil
+	}
+	fi, err := os.Stat(layerBlobPath)
+	if err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("failed to stat %q: %w", layerBlobPath, err)
+	}
+	if fi.Size() != entry.Size || fi.ModTime().UnixNano() != entry.ModTime {
+		return VerityMetadata{}, false, nil
+	}
+
+	rootDigest, err := hex.DecodeString(entry.RootDigest)
+	if err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("invalid root_digest in verity metadata cache: %w", err)
+	}
+	salt, err := hex.DecodeString(entry.Salt)
+	if err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("invalid salt in verity metadata cache: %w", err)
+	}
+
+	return VerityMetadata{
+		RootDigest:    rootDigest,
+		Salt:          salt,
+		Algorithm:     entry.Algorithm,
+		DataBlockSize: entry.DataBlockSize,
+		HashBlockSize: entry.HashBlockSize,
+		DataBlocks:    entry.DataBlocks,
+		HashOffset:    entry.HashOffset,
+	}, true, nil
+}
+
+// ToAnnotations renders meta as the Annotation* keys above, so a
+// snapshotter's Commit can attach it to the layer's OCI descriptor
+// alongside (or instead of) the sidecar cache.
+func ToAnnotations(meta VerityMetadata) map[string]string {
+	return map[string]string{
+		AnnotationRootDigest:    hex.EncodeToString(meta.RootDigest),
+		AnnotationSalt:          hex.EncodeToString(meta.Salt),
+		AnnotationAlgorithm:     meta.Algorithm,
+		AnnotationDataBlockSize: strconv.FormatUint(uint64(meta.DataBlockSize), 10),
+		AnnotationHashBlockSize: strconv.FormatUint(uint64(meta.HashBlockSize), 10),
+		AnnotationDataBlocks:    strconv.FormatUint(meta.DataBlocks, 10),
+		AnnotationHashOffset:    strconv.FormatInt(meta.HashOffset, 10),
+	}
+}
+
+// FromAnnotations parses the Annotation* keys above back into a
+// VerityMetadata, returning ok=false if annotations carries no
+// AnnotationRootDigest, i.e. the layer was never formatted with
+// verity metadata annotations.
+func FromAnnotations(annotations map[string]string) (meta VerityMetadata, ok bool, err error) {
+	rootDigestHex := annotations[AnnotationRootDigest]
+	if rootDigestHex == "" {
+		return VerityMetadata{}, false, nil
+	}
+
+	rootDigest, err := hex.DecodeString(rootDigestHex)
+	if err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("invalid %s annotation: %w", AnnotationRootDigest, err)
+	}
+	salt, err := hex.DecodeString(annotations[AnnotationSalt])
+	if err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("invalid %s annotation: %w", AnnotationSalt, err)
+	}
+	dataBlockSize, err := strconv.ParseUint(annotations[AnnotationDataBlockSize], 10, 32)
+	if err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("invalid %s annotation: %w", AnnotationDataBlockSize, err)
+	}
+	hashBlockSize, err := strconv.ParseUint(annotations[AnnotationHashBlockSize], 10, 32)
+	if err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("invalid %s annotation: %w", AnnotationHashBlockSize, err)
+	}
+	dataBlocks, err := strconv.ParseUint(annotations[AnnotationDataBlocks], 10, 64)
+	if err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("invalid %s annotation: %w", AnnotationDataBlocks, err)
+	}
+	hashOffset, err := strconv.ParseInt(annotations[AnnotationHashOffset], 10, 64)
+	if err != nil {
+		return VerityMetadata{}, false, fmt.Errorf("invalid %s annotation: %w", AnnotationHashOffset, err)
+	}
+
+	return VerityMetadata{
+		RootDigest:    rootDigest,
+		Salt:          salt,
+		Algorithm:     annotations[AnnotationAlgorithm],
+		DataBlockSize: uint32(dataBlockSize),
+		HashBlockSize: uint32(hashBlockSize),
+		DataBlocks:    dataBlocks,
+		HashOffset:    hashOffset,
+	}, true, nil
+}
+
+// ToVerityConfig converts meta into the VerityConfig Enable expects,
+// so a Prepare that hit the cache can go straight to Enable without
+// hand-copying fields.
+func ToVerityConfig(meta VerityMetadata) (VerityConfig, error) {
+	algo, err := verityHashAlgorithmID(meta.Algorithm)
+	if err != nil {
+		return VerityConfig{}, err
+	}
+	return VerityConfig{
+		Version:       1,
+		HashAlgorithm: algo,
+		DataBlockSize: meta.DataBlockSize,
+		HashBlockSize: meta.HashBlockSize,
+		DataBlocks:    meta.DataBlocks,
+		Salt:          meta.Salt,
+		RootDigest:    meta.RootDigest,
+		HashOffset:    meta.HashOffset,
+	}, nil
+}
+
+// FromVerityConfig is ToVerityConfig's inverse, extracting the subset
+// of config a Commit call persists as VerityMetadata.
+func FromVerityConfig(config VerityConfig) (VerityMetadata, error) {
+	algo, err := verityHashAlgorithmName(config.HashAlgorithm)
+	if err != nil {
+		return VerityMetadata{}, err
+	}
+	return VerityMetadata{
+		RootDigest:    config.RootDigest,
+		Salt:          config.Salt,
+		Algorithm:     algo,
+		DataBlockSize: config.DataBlockSize,
+		HashBlockSize: config.HashBlockSize,
+		DataBlocks:    config.DataBlocks,
+		HashOffset:    config.HashOffset,
+	}, nil
+}
+
+// verityHashAlgorithmID is verityHashAlgorithmName's inverse (see
+// activate_linux.go), needed here to turn a cached/annotated algorithm
+// name back into the numeric HashAlgo* ToVerityConfig's VerityConfig
+// expects.
+func verityHashAlgorithmID(name string) (uint32, error) {
+	switch name {
+	case "sha256":
+		return HashAlgoSHA256, nil
+	case "sha512":
+		return HashAlgoSHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported hash algorithm %q", name)
+	}
+}
+
+// VerifyMetadataCache recomputes dataFile's hash tree from scratch and
+// compares it against meta's RootDigest, for a "--verify" mode that
+// distrusts the cache itself: a tampered sidecar or annotation could
+// otherwise point Enable at a forged root digest that matches a
+// tampered layer.
+func VerifyMetadataCache(dataFile string, meta VerityMetadata) error {
+	config, err := ToVerityConfig(meta)
+	if err != nil {
+		return err
+	}
+
+	_, rootDigest, err := GenerateHashTree(dataFile, config)
+	if err != nil {
+		return fmt.Errorf("failed to recompute hash tree for verification: %w", err)
+	}
+
+	if !bytes.Equal(rootDigest, meta.RootDigest) {
+		return fmt.Errorf("verity metadata cache for %q does not match recomputed root digest: cached %x, recomputed %x",
+			dataFile, meta.RootDigest, rootDigest)
+	}
+	return nil
+}
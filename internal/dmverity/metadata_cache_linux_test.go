@@ -0,0 +1,143 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testVerityMetadata() VerityMetadata {
+	return VerityMetadata{
+		RootDigest:    []byte{1, 2, 3, 4, 5},
+		Salt:          []byte{6, 7, 8, 9},
+		DataBlockSize: DefaultBlockSize,
+		HashBlockSize: DefaultBlockSize,
+		DataBlocks:    17,
+		HashOffset:    4096,
+		Algorithm:     "sha256",
+	}
+}
+
+func TestMetadataCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("layer contents"), 0o644))
+
+	meta := testVerityMetadata()
+	require.NoError(t, WriteMetadataCache(blobPath, "digest-a", meta))
+
+	got, ok, err := ReadMetadataCache(blobPath, "digest-a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, meta, got)
+}
+
+func TestMetadataCacheMissingIsNotAnError(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("layer contents"), 0o644))
+
+	_, ok, err := ReadMetadataCache(blobPath, "digest-a")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestMetadataCacheStaleOnDigestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("layer contents"), 0o644))
+
+	require.NoError(t, WriteMetadataCache(blobPath, "digest-a", testVerityMetadata()))
+
+	_, ok, err := ReadMetadataCache(blobPath, "digest-b")
+	require.NoError(t, err)
+	require.False(t, ok, "cache computed for a different digest must not be trusted")
+}
+
+func TestMetadataCacheStaleOnBlobChange(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("layer contents"), 0o644))
+
+	require.NoError(t, WriteMetadataCache(blobPath, "digest-a", testVerityMetadata()))
+
+	// Rewriting the blob with different-sized content changes size and
+	// mtime, so the cache computed for the old content must be rejected.
+	require.NoError(t, os.WriteFile(blobPath, []byte("different, longer layer contents"), 0o644))
+
+	_, ok, err := ReadMetadataCache(blobPath, "digest-a")
+	require.NoError(t, err)
+	require.False(t, ok, "cache must be invalidated once the blob it describes changes")
+}
+
+func TestAnnotationsRoundTrip(t *testing.T) {
+	meta := testVerityMetadata()
+
+	annotations := ToAnnotations(meta)
+	got, ok, err := FromAnnotations(annotations)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, meta, got)
+}
+
+func TestFromAnnotationsEmpty(t *testing.T) {
+	_, ok, err := FromAnnotations(map[string]string{})
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestVerityConfigRoundTrip(t *testing.T) {
+	meta := testVerityMetadata()
+
+	cfg, err := ToVerityConfig(meta)
+	require.NoError(t, err)
+	require.Equal(t, meta.RootDigest, cfg.RootDigest)
+	require.Equal(t, meta.Salt, cfg.Salt)
+	require.Equal(t, HashAlgoSHA256, int(cfg.HashAlgorithm))
+
+	back, err := FromVerityConfig(cfg)
+	require.NoError(t, err)
+	require.Equal(t, meta, back)
+}
+
+func TestVerifyMetadataCache(t *testing.T) {
+	dir := t.TempDir()
+	dataFile := filepath.Join(dir, "data")
+	data := make([]byte, DefaultBlockSize*3)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	require.NoError(t, os.WriteFile(dataFile, data, 0o644))
+
+	config := testVerityConfig()
+	_, rootDigest, err := GenerateHashTree(dataFile, config)
+	require.NoError(t, err)
+
+	meta, err := FromVerityConfig(config)
+	require.NoError(t, err)
+	meta.RootDigest = rootDigest
+
+	require.NoError(t, VerifyMetadataCache(dataFile, meta))
+
+	meta.RootDigest = append([]byte{}, rootDigest...)
+	meta.RootDigest[0] ^= 0xff
+	require.Error(t, VerifyMetadataCache(dataFile, meta))
+}
@@ -0,0 +1,116 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// Sign produces a PKCS#7 detached signature over rootHash (its raw
+// bytes, not the hex string Format/Open otherwise deal in) using the
+// PEM-encoded private key at privKeyPath and certificate at certPath.
+// The result is what DmverityOptions.RootHashSignature expects, letting
+// a caller produce a signature without shelling out to veritysetup.
+func Sign(rootHash []byte, privKeyPath, certPath string) ([]byte, error) {
+	key, err := readPrivateKey(privKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	cert, err := readCertificate(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	sd, err := pkcs7.NewSignedData(rootHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize PKCS#7 signer: %w", err)
+	}
+	if err := sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("failed to add signer: %w", err)
+	}
+	sd.Detach()
+
+	sig, err := sd.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("failed to finish PKCS#7 signature: %w", err)
+	}
+	return sig, nil
+}
+
+// Verify checks that sig is a valid PKCS#7 detached signature over
+// rootHash by the certificate at pubKeyPath, the same check the kernel
+// performs against a key loaded by Open/Format when
+// RootHashSignatureKeyDesc is set - useful for validating a signature
+// before ever handing it to the kernel.
+func Verify(rootHash, sig []byte, pubKeyPath string) error {
+	cert, err := readCertificate(pubKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	p7, err := pkcs7.Parse(sig)
+	if err != nil {
+		return fmt.Errorf("failed to parse PKCS#7 signature: %w", err)
+	}
+	p7.Content = rootHash
+	p7.Certificates = []*x509.Certificate{cert}
+
+	if err := p7.Verify(); err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	return nil
+}
+
+func readPrivateKey(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key encoding in %s", path)
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+)
+
+// veritySuperblockSize is the fixed header size GenerateHashTree writes
+// at the start of its hash tree, before any hash blocks.
+const veritySuperblockSize = 4096
+
+// CanonicalizeSuperblock extracts the stable, content-derived bytes of
+// the verity superblock GenerateHashTree wrote as the first
+// veritySuperblockSize bytes of hashTree, zeroing its UUID field
+// (offset 16, 16 bytes) since GenerateHashTree fills that randomly on
+// every call and it isn't derived from the layer's content - two hash
+// trees built from the same data, salt, and config would otherwise
+// canonicalize to different bytes and need re-signing for no reason.
+func CanonicalizeSuperblock(hashTree []byte) ([]byte, error) {
+	if len(hashTree) < veritySuperblockSize {
+		return nil, fmt.Errorf("hash tree is shorter than the %d-byte verity superblock", veritySuperblockSize)
+	}
+
+	sb := make([]byte, veritySuperblockSize)
+	copy(sb, hashTree[:veritySuperblockSize])
+	for i := 16; i < 32; i++ {
+		sb[i] = 0
+	}
+	return sb, nil
+}
+
+// Signer produces a detached signature over a canonicalized verity
+// superblock. SignSuperblock doesn't care whether the result is a
+// PKCS#7/CMS structure or a raw PKCS#1 v1.5 signature, as long as it's
+// in the form the intended verifier - the kernel via
+// VerityConfig.RootHashSignatureKeyDesc, or a later call to Verify -
+// expects.
+type Signer interface {
+	Sign(superblock []byte) ([]byte, error)
+}
+
+// PKCS7Signer signs with a PEM-encoded private key and certificate,
+// producing a detached PKCS#7/CMS signature - the format Verify and
+// DmverityOptions.RootHashSignature both expect.
+type PKCS7Signer struct {
+	PrivateKeyPath string
+	CertPath       string
+}
+
+// Sign implements Signer.
+func (s PKCS7Signer) Sign(superblock []byte) ([]byte, error) {
+	return Sign(superblock, s.PrivateKeyPath, s.CertPath)
+}
+
+// PKCS1v15Signer signs the superblock's SHA-256 digest with a raw RSA
+// PKCS#1 v1.5 signature instead of wrapping it in PKCS#7/CMS, for a
+// verifier that checks the signature directly rather than through a
+// PKCS#7 library.
+type PKCS1v15Signer struct {
+	PrivateKeyPath string
+}
+
+// Sign implements Signer.
+func (s PKCS1v15Signer) Sign(superblock []byte) ([]byte, error) {
+	key, err := readPrivateKey(s.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("PKCS#1 v1.5 signing requires an RSA private key, got %T", key)
+	}
+
+	digest := sha256.Sum256(superblock)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign superblock digest: %w", err)
+	}
+	return sig, nil
+}
+
+// SignSuperblock canonicalizes hashTree's superblock and signs it with
+// signer, producing the bytes VerityConfig.RootHashSignature expects.
+// DmverityOptions.RootHashSignature instead takes a signature over the
+// root hash, as Sign produces.
+func SignSuperblock(hashTree []byte, signer Signer) ([]byte, error) {
+	sb, err := CanonicalizeSuperblock(hashTree)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(sb)
+}
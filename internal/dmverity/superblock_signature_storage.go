@@ -0,0 +1,95 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// VeritySignatureExt is the sidecar extension a layer blob's superblock
+// signature is stored under in separate mode, where the hash tree (and
+// so the signature) doesn't live inside the layer blob itself.
+const VeritySignatureExt = ".verity.sig"
+
+// SignaturePath returns the sidecar path WriteLayerSignature and
+// ReadLayerSignature use in separate mode for layerBlobPath.
+func SignaturePath(layerBlobPath string) string {
+	return layerBlobPath + VeritySignatureExt
+}
+
+// WriteLayerSignature stores signature for layerBlobPath. In separate
+// mode (combinedOffset < 0, i.e. the hash tree is its own file)
+// signature is written to SignaturePath(layerBlobPath). In combined
+// mode it's written combinedOffset bytes into layerBlobPath itself -
+// the caller's chosen spot right after the data and hash tree - as a
+// uint32 length prefix followed by the signature bytes, so
+// ReadLayerSignature doesn't need the layer blob's total size to know
+// how much to read back.
+func WriteLayerSignature(layerBlobPath string, combinedOffset int64, signature []byte) error {
+	if combinedOffset < 0 {
+		if err := os.WriteFile(SignaturePath(layerBlobPath), signature, 0o644); err != nil {
+			return fmt.Errorf("failed to write verity signature sidecar: %w", err)
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(layerBlobPath, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open layer blob %q: %w", layerBlobPath, err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 4+len(signature))
+	binary.LittleEndian.PutUint32(buf[:4], uint32(len(signature)))
+	copy(buf[4:], signature)
+	if _, err := f.WriteAt(buf, combinedOffset); err != nil {
+		return fmt.Errorf("failed to write verity signature at offset %d: %w", combinedOffset, err)
+	}
+	return nil
+}
+
+// ReadLayerSignature reads back a signature WriteLayerSignature stored
+// for layerBlobPath, using the same combinedOffset convention.
+func ReadLayerSignature(layerBlobPath string, combinedOffset int64) ([]byte, error) {
+	if combinedOffset < 0 {
+		sig, err := os.ReadFile(SignaturePath(layerBlobPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read verity signature sidecar: %w", err)
+		}
+		return sig, nil
+	}
+
+	f, err := os.Open(layerBlobPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open layer blob %q: %w", layerBlobPath, err)
+	}
+	defer f.Close()
+
+	lenBuf := make([]byte, 4)
+	if _, err := f.ReadAt(lenBuf, combinedOffset); err != nil {
+		return nil, fmt.Errorf("failed to read verity signature length at offset %d: %w", combinedOffset, err)
+	}
+	n := binary.LittleEndian.Uint32(lenBuf)
+
+	sig := make([]byte, n)
+	if _, err := f.ReadAt(sig, combinedOffset+4); err != nil {
+		return nil, fmt.Errorf("failed to read verity signature at offset %d: %w", combinedOffset+4, err)
+	}
+	return sig, nil
+}
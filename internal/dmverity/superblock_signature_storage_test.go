@@ -0,0 +1,66 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayerSignatureSeparateMode(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("layer contents"), 0o644))
+
+	sig := []byte("a detached signature")
+	require.NoError(t, WriteLayerSignature(blobPath, -1, sig))
+
+	_, err := os.Stat(SignaturePath(blobPath))
+	require.NoError(t, err)
+
+	got, err := ReadLayerSignature(blobPath, -1)
+	require.NoError(t, err)
+	require.Equal(t, sig, got)
+}
+
+func TestLayerSignatureCombinedMode(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	// Leave room after the "data" for the length-prefixed signature
+	// WriteLayerSignature writes at combinedOffset.
+	require.NoError(t, os.WriteFile(blobPath, make([]byte, 256), 0o644))
+
+	sig := []byte("another signature, different length")
+	const offset = 64
+	require.NoError(t, WriteLayerSignature(blobPath, offset, sig))
+
+	got, err := ReadLayerSignature(blobPath, offset)
+	require.NoError(t, err)
+	require.Equal(t, sig, got)
+}
+
+func TestReadLayerSignatureMissingSidecar(t *testing.T) {
+	dir := t.TempDir()
+	blobPath := filepath.Join(dir, "layer.blob")
+	require.NoError(t, os.WriteFile(blobPath, []byte("layer contents"), 0o644))
+
+	_, err := ReadLayerSignature(blobPath, -1)
+	require.Error(t, err)
+}
@@ -0,0 +1,117 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestRSAKey generates an RSA key, PEM-encodes it (PKCS#1) to a temp
+// file under dir, and returns both the key and the file's path for
+// PKCS1v15Signer to read back.
+func writeTestRSAKey(t *testing.T, dir string) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	path := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+	return key, path
+}
+
+func TestCanonicalizeSuperblock(t *testing.T) {
+	hashTree := make([]byte, veritySuperblockSize+64)
+	for i := range hashTree {
+		hashTree[i] = byte(i)
+	}
+	// Fill the UUID field (offset 16..32) with something non-zero so the
+	// test can tell CanonicalizeSuperblock actually zeroed it.
+	for i := 16; i < 32; i++ {
+		hashTree[i] = 0xff
+	}
+
+	sb, err := CanonicalizeSuperblock(hashTree)
+	require.NoError(t, err)
+	require.Len(t, sb, veritySuperblockSize)
+	for i := 16; i < 32; i++ {
+		require.Equalf(t, byte(0), sb[i], "UUID byte %d should have been zeroed", i)
+	}
+	// Everything outside the UUID field is copied through unchanged.
+	require.Equal(t, hashTree[:16], sb[:16])
+	require.Equal(t, hashTree[32:veritySuperblockSize], sb[32:veritySuperblockSize])
+
+	// Two trees differing only in their (random) UUID canonicalize to the
+	// same bytes - the whole point of CanonicalizeSuperblock.
+	other := append([]byte{}, hashTree...)
+	for i := 16; i < 32; i++ {
+		other[i] = 0xaa
+	}
+	sbOther, err := CanonicalizeSuperblock(other)
+	require.NoError(t, err)
+	require.Equal(t, sb, sbOther)
+}
+
+func TestCanonicalizeSuperblockShortInput(t *testing.T) {
+	_, err := CanonicalizeSuperblock(make([]byte, veritySuperblockSize-1))
+	require.Error(t, err)
+}
+
+func TestPKCS1v15SignerSign(t *testing.T) {
+	dir := t.TempDir()
+	key, keyPath := writeTestRSAKey(t, dir)
+
+	superblock := make([]byte, veritySuperblockSize)
+	for i := range superblock {
+		superblock[i] = byte(i % 251)
+	}
+
+	signer := PKCS1v15Signer{PrivateKeyPath: keyPath}
+	sig, err := signer.Sign(superblock)
+	require.NoError(t, err)
+
+	digest := sha256.Sum256(superblock)
+	require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+}
+
+func TestSignSuperblock(t *testing.T) {
+	dir := t.TempDir()
+	key, keyPath := writeTestRSAKey(t, dir)
+
+	hashTree := make([]byte, veritySuperblockSize+128)
+	for i := range hashTree {
+		hashTree[i] = byte(i % 251)
+	}
+
+	sig, err := SignSuperblock(hashTree, PKCS1v15Signer{PrivateKeyPath: keyPath})
+	require.NoError(t, err)
+
+	sb, err := CanonicalizeSuperblock(hashTree)
+	require.NoError(t, err)
+	digest := sha256.Sum256(sb)
+	require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+}
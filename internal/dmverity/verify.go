@@ -0,0 +1,281 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// verityMagic is the fixed 8-byte tag generateHashTree writes at the
+// start of its superblock, to catch a hashFile that isn't one of its
+// hash trees before parseSuperblock reads a field out of garbage.
+var verityMagic = []byte("verity\000\000")
+
+// parseSuperblock recovers the hash algorithm, block sizes, salt, and
+// data block count generateHashTree wrote into sb, the first
+// veritySuperblockSize bytes of a hash file - everything NewVerityHash
+// needs to rebuild the same *VerityHash that produced the tree, without
+// VerifyHashTree/VerifyBlock's caller having to separately track a
+// VerityConfig that matches it.
+func parseSuperblock(sb []byte) (config VerityConfig, dataBlocks uint64, err error) {
+	if len(sb) < veritySuperblockSize {
+		return VerityConfig{}, 0, fmt.Errorf("hash file is shorter than the %d-byte verity superblock", veritySuperblockSize)
+	}
+	if !bytes.Equal(sb[0:8], verityMagic) {
+		return VerityConfig{}, 0, fmt.Errorf("hash file is missing the verity superblock magic")
+	}
+
+	saltSize := binary.LittleEndian.Uint32(sb[saltSizeHeaderOffset:])
+	if saltHeaderOffset+int(saltSize) > veritySuperblockSize {
+		return VerityConfig{}, 0, fmt.Errorf("superblock salt size %d is out of range", saltSize)
+	}
+	salt := make([]byte, saltSize)
+	copy(salt, sb[saltHeaderOffset:saltHeaderOffset+int(saltSize)])
+
+	config = VerityConfig{
+		Version:       binary.LittleEndian.Uint32(sb[8:]),
+		HashAlgorithm: binary.LittleEndian.Uint32(sb[12:]),
+		DataBlockSize: binary.LittleEndian.Uint32(sb[64:]),
+		HashBlockSize: binary.LittleEndian.Uint32(sb[68:]),
+		Salt:          salt,
+	}
+	dataBlocks = binary.LittleEndian.Uint64(sb[72:])
+	return config, dataBlocks, nil
+}
+
+// levelInfo is one level of the hash tree generateHashTree writes to
+// hashFile: offset is the byte offset, from the start of the file
+// (header included), of its first HashBlockSize block, and blocks is
+// how many such blocks the level has.
+type levelInfo struct {
+	offset uint64
+	blocks uint64
+}
+
+// levels returns generateHashTree's on-disk layout for a tree over
+// dataBlocks data blocks: level 0 packs their leaf hashes hashesPerBlock
+// to a block, and each level above packs the hash of every block in the
+// level below the same way, bottom-up, ending at the single block whose
+// own hash is the root. A dataBlocks small enough for its leaf hashes
+// to fit one block stops at level 0 with no level above it, just as
+// generateHashTree then hashes that single level 0 block as the root.
+func (v *VerityHash) levels(dataBlocks uint64) []levelInfo {
+	lv := make([]levelInfo, 0, 1)
+	offset := uint64(veritySuperblockSize)
+	blocks := dataBlocks
+	for {
+		blocks = (blocks + uint64(v.hashesPerBlock) - 1) / uint64(v.hashesPerBlock)
+		lv = append(lv, levelInfo{offset: offset, blocks: blocks})
+		if blocks <= 1 {
+			return lv
+		}
+		offset += blocks * uint64(v.config.HashBlockSize)
+	}
+}
+
+// readHashBlock reads the blockIdx'th HashBlockSize block of level li
+// from hashFile.
+func (v *VerityHash) readHashBlock(hashFile io.ReaderAt, li levelInfo, blockIdx uint64) ([]byte, error) {
+	buf := make([]byte, v.config.HashBlockSize)
+	off := int64(li.offset) + int64(blockIdx)*int64(v.config.HashBlockSize)
+	if _, err := hashFile.ReadAt(buf, off); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// VerifyHashTree recomputes dataFile's hash tree from the parameters
+// recorded in hashFile's own superblock and checks the result against
+// expectedRoot. Unlike VerifyMetadataCache, which trusts a VerityMetadata
+// the caller already has, this trusts only hashFile itself - the same
+// "read the superblock off the device" model the kernel's dm-verity
+// target uses - at the cost of re-hashing the whole image; callers
+// checking one block at a time as it's read should use VerifyBlock
+// instead.
+func VerifyHashTree(dataFile, hashFile string, expectedRoot []byte) error {
+	hf, err := os.Open(hashFile)
+	if err != nil {
+		return fmt.Errorf("failed to open hash file: %w", err)
+	}
+	defer hf.Close()
+
+	sb := make([]byte, veritySuperblockSize)
+	if _, err := io.ReadFull(hf, sb); err != nil {
+		return fmt.Errorf("failed to read verity superblock: %w", err)
+	}
+
+	config, _, err := parseSuperblock(sb)
+	if err != nil {
+		return err
+	}
+
+	_, rootDigest, err := GenerateHashTree(dataFile, config)
+	if err != nil {
+		return fmt.Errorf("failed to recompute hash tree for verification: %w", err)
+	}
+
+	if !bytes.Equal(rootDigest, expectedRoot) {
+		return fmt.Errorf("hash tree for %q does not match expected root digest: expected %x, got %x", dataFile, expectedRoot, rootDigest)
+	}
+	return nil
+}
+
+// VerifyBlock checks that data - blockIndex's data block - is the one
+// the hash tree in hashFile was built over, walking from its leaf hash
+// up to the root the same way the kernel verifies a dm-verity read: hash
+// data, compare it against the matching entry in the level 0 block that
+// covers blockIndex, hash that block to get the value the next level up
+// is expected to contain, and repeat one level at a time until the root
+// level's own hash is compared against expectedRoot. It parses hashFile's
+// superblock on every call to learn the tree's shape, but caches each
+// level's already-verified block digests (keyed by level and block
+// index) on v so that verifying nearby or sequential block indices -
+// which tend to share the same upper-level blocks - doesn't re-hash them
+// every time.
+func (v *VerityHash) VerifyBlock(blockIndex uint64, data []byte, hashFile io.ReaderAt, expectedRoot []byte) error {
+	sb := make([]byte, veritySuperblockSize)
+	if _, err := hashFile.ReadAt(sb, 0); err != nil {
+		return fmt.Errorf("failed to read verity superblock: %w", err)
+	}
+	config, dataBlocks, err := parseSuperblock(sb)
+	if err != nil {
+		return err
+	}
+	if blockIndex >= dataBlocks {
+		return fmt.Errorf("block index %d is out of range for a %d-block tree", blockIndex, dataBlocks)
+	}
+
+	vt, err := NewVerityHash(config)
+	if err != nil {
+		return fmt.Errorf("invalid verity superblock: %w", err)
+	}
+	// Share v's cache rather than the fresh one NewVerityHash just gave
+	// vt, so repeated VerifyBlock calls on v actually amortize as
+	// documented instead of starting cold every time.
+	vt.blockCache = v.blockCache
+
+	padded := make([]byte, config.DataBlockSize)
+	copy(padded, data)
+	want, err := vt.hashBlock(padded)
+	if err != nil {
+		return err
+	}
+
+	index := blockIndex
+	for level, li := range vt.levels(dataBlocks) {
+		blockIdx := index / uint64(vt.hashesPerBlock)
+		offsetInBlock := int(index%uint64(vt.hashesPerBlock)) * vt.digestSize
+
+		key := hashCacheKey{level: level, block: blockIdx}
+		block, err := vt.readHashBlock(hashFile, li, blockIdx)
+		if err != nil {
+			return fmt.Errorf("failed to read level %d hash block %d: %w", level, blockIdx, err)
+		}
+		if offsetInBlock+vt.digestSize > len(block) {
+			return fmt.Errorf("block index %d out of range within level %d hash block %d", index, level, blockIdx)
+		}
+
+		got := block[offsetInBlock : offsetInBlock+vt.digestSize]
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("data block %d failed verification at hash tree level %d", blockIndex, level)
+		}
+
+		parentHash, cached := vt.blockCache.get(key)
+		if !cached {
+			parentHash, err = vt.hashBlock(block)
+			if err != nil {
+				return err
+			}
+			vt.blockCache.add(key, parentHash)
+		}
+
+		want = parentHash
+		index = blockIdx
+	}
+
+	if !bytes.Equal(want, expectedRoot) {
+		return fmt.Errorf("data block %d failed verification at the hash tree root", blockIndex)
+	}
+	return nil
+}
+
+// hashCacheKey identifies one hash-tree block: level 0 is the leaf hash
+// level, increasing toward the root.
+type hashCacheKey struct {
+	level int
+	block uint64
+}
+
+// hashBlockCache is a fixed-capacity least-recently-used cache of
+// verified hash-tree block digests, keyed by hashCacheKey, so
+// VerifyBlock's leaf-to-root walk can skip re-hashing an upper-level
+// block it already checked for a previous call.
+type hashBlockCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[hashCacheKey]*list.Element
+}
+
+type hashCacheEntry struct {
+	key    hashCacheKey
+	digest []byte
+}
+
+func newHashBlockCache(capacity int) *hashBlockCache {
+	return &hashBlockCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[hashCacheKey]*list.Element),
+	}
+}
+
+func (c *hashBlockCache) get(key hashCacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*hashCacheEntry).digest, true
+}
+
+func (c *hashBlockCache) add(key hashCacheKey, digest []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacity <= 0 {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value.(*hashCacheEntry).digest = digest
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&hashCacheEntry{key: key, digest: digest})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*hashCacheEntry).key)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeVerityTestData creates a temp data file holding blocks filled with
+// distinct, non-zero content, so VerifyBlock has something meaningful to
+// tamper with in TestVerifyBlockDetectsCorruption.
+func writeVerityTestData(t *testing.T, blocks int, blockSize uint32) (string, [][]byte) {
+	t.Helper()
+	f, err := os.CreateTemp("", "verity-data-*")
+	require.NoError(t, err)
+	defer f.Close()
+
+	data := make([][]byte, blocks)
+	for i := range data {
+		b := make([]byte, blockSize)
+		for j := range b {
+			b[j] = byte((i*7 + j) % 251)
+		}
+		_, err := f.Write(b)
+		require.NoError(t, err)
+		data[i] = b
+	}
+	return f.Name(), data
+}
+
+// buildVerityTestTree generates a hash tree over blocks data blocks and
+// returns everything a test needs to exercise VerifyHashTree/VerifyBlock
+// against it.
+func buildVerityTestTree(t *testing.T, blocks int) (dataFile string, data [][]byte, hashFile string, root []byte, v *VerityHash) {
+	t.Helper()
+	config := testVerityConfig()
+	dataFile, data = writeVerityTestData(t, blocks, config.DataBlockSize)
+
+	var err error
+	v, err = NewVerityHash(config)
+	require.NoError(t, err)
+
+	hf, err := os.CreateTemp("", "verity-hash-*")
+	require.NoError(t, err)
+	hf.Close()
+	hashFile = hf.Name()
+
+	root, err = v.GenerateHashTree(dataFile, hashFile)
+	require.NoError(t, err)
+	return dataFile, data, hashFile, root, v
+}
+
+// TestVerifyHashTree covers the block counts that exercise a tree with no
+// level above the leaves (1, 2, 5), one exactly filling the leaf level
+// (128, with the default 4096-byte blocks and 32-byte SHA-256 digests),
+// and ones needing extra levels (129, 300).
+func TestVerifyHashTree(t *testing.T) {
+	for _, blocks := range []int{1, 2, 5, 128, 129, 300} {
+		dataFile, _, hashFile, root, _ := buildVerityTestTree(t, blocks)
+		defer os.Remove(dataFile)
+		defer os.Remove(hashFile)
+
+		require.NoError(t, VerifyHashTree(dataFile, hashFile, root), "blocks=%d", blocks)
+
+		badRoot := append([]byte{}, root...)
+		badRoot[0] ^= 0xff
+		require.Error(t, VerifyHashTree(dataFile, hashFile, badRoot), "blocks=%d", blocks)
+	}
+}
+
+// TestVerifyBlock covers the same block counts as TestVerifyHashTree but
+// through VerifyBlock's per-block leaf-to-root walk instead of a whole-file
+// recompute.
+func TestVerifyBlock(t *testing.T) {
+	for _, blocks := range []int{1, 2, 5, 128, 129, 300} {
+		_, data, hashFile, root, v := buildVerityTestTree(t, blocks)
+		defer os.Remove(hashFile)
+
+		hf, err := os.Open(hashFile)
+		require.NoError(t, err)
+		defer hf.Close()
+
+		for i, block := range data {
+			require.NoError(t, v.VerifyBlock(uint64(i), block, hf, root), "blocks=%d block=%d", blocks, i)
+		}
+	}
+}
+
+// TestVerifyBlockDetectsCorruption mutates a single data block and checks
+// that only that block's VerifyBlock call fails, leaving every other
+// block's verification unaffected.
+func TestVerifyBlockDetectsCorruption(t *testing.T) {
+	_, data, hashFile, root, v := buildVerityTestTree(t, 300)
+	defer os.Remove(hashFile)
+
+	hf, err := os.Open(hashFile)
+	require.NoError(t, err)
+	defer hf.Close()
+
+	const tampered = 150
+	corrupt := append([]byte{}, data[tampered]...)
+	corrupt[0] ^= 0xff
+
+	for i, block := range data {
+		if i == tampered {
+			require.Error(t, v.VerifyBlock(uint64(i), corrupt, hf, root))
+			continue
+		}
+		require.NoError(t, v.VerifyBlock(uint64(i), block, hf, root))
+	}
+}
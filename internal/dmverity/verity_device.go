@@ -0,0 +1,129 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dmverity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Offsets, within the veritySuperblockSize-byte header GenerateHashTree
+// writes, of the FEC fields a combined image needs to describe its own
+// FEC area. These aren't part of the real kernel/cryptsetup verity
+// superblock - cryptsetup tracks fec_roots/fec_blocks/fec_offset in its
+// own LUKS2 JSON metadata rather than the verity superblock itself - so
+// they're this package's own extension, in the unused header space past
+// the salt field, for a caller re-deriving Enable/Open parameters from
+// the image file alone. veritysetup --fec-device/--fec-roots still take
+// these as explicit flags either way; see buildVerityTargetParams.
+const (
+	fecRootsHeaderOffset      = 352
+	fecBlocksHeaderOffset     = 356
+	fecAreaOffsetHeaderOffset = 364
+)
+
+// patchFECHeader writes roots, blocks, and areaOffset into hashTree's
+// superblock (its first veritySuperblockSize bytes, as GenerateHashTree
+// produced them).
+func patchFECHeader(hashTree []byte, roots uint8, blocks, areaOffset uint64) error {
+	if len(hashTree) < veritySuperblockSize {
+		return fmt.Errorf("hash tree is shorter than the %d-byte verity superblock", veritySuperblockSize)
+	}
+	binary.LittleEndian.PutUint32(hashTree[fecRootsHeaderOffset:], uint32(roots))
+	binary.LittleEndian.PutUint64(hashTree[fecBlocksHeaderOffset:], blocks)
+	binary.LittleEndian.PutUint64(hashTree[fecAreaOffsetHeaderOffset:], areaOffset)
+	return nil
+}
+
+// GenerateFECData computes the Reed-Solomon FEC parity area for dataFile
+// under v's config and writes it to fecFile. The FEC range covers the
+// hash tree as well as the data (see GenerateFEC), so this generates the
+// tree first purely to feed it into the parity computation - callers that
+// also want the tree itself should call GenerateHashTree separately, or
+// use GenerateVerityDevice to get both plus the data in one image.
+func (v *VerityHash) GenerateFECData(dataFile, fecFile string) error {
+	if v.config.FECRoots == 0 {
+		return fmt.Errorf("FECRoots must be set to generate FEC parity")
+	}
+
+	hashTree, _, err := GenerateHashTree(dataFile, v.config)
+	if err != nil {
+		return fmt.Errorf("failed to generate hash tree: %w", err)
+	}
+
+	parity, err := GenerateFEC(dataFile, hashTree, v.config)
+	if err != nil {
+		return fmt.Errorf("failed to generate fec parity: %w", err)
+	}
+
+	if err := os.WriteFile(fecFile, parity, 0o644); err != nil {
+		return fmt.Errorf("failed to write fec file: %w", err)
+	}
+	return nil
+}
+
+// GenerateVerityDevice writes a single combined image to imageFile:
+// dataFile's bytes, followed by its verity hash tree (header included),
+// followed by its Reed-Solomon FEC parity area if v.config.FECRoots is
+// set - the same combined-file layout VerityConfig.HashOffset and
+// VerityConfig.FECOffset describe. When FEC is generated, its
+// fec_roots/fec_blocks/fec_area_offset are patched into the hash tree's
+// superblock before it's written, so the image is self-describing. It
+// returns the tree's root hash.
+func (v *VerityHash) GenerateVerityDevice(dataFile, imageFile string) ([]byte, error) {
+	data, err := os.ReadFile(dataFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data file: %w", err)
+	}
+
+	hashTree, rootHash, err := GenerateHashTree(dataFile, v.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate hash tree: %w", err)
+	}
+
+	var parity []byte
+	if v.config.FECRoots > 0 {
+		parity, err = GenerateFEC(dataFile, hashTree, v.config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate fec parity: %w", err)
+		}
+
+		areaOffset := uint64(len(data) + len(hashTree))
+		blocks := v.config.FECBlocks
+		if blocks == 0 {
+			blocks = FECBlocks(int64(len(data)), int64(len(hashTree)), v.config.DataBlockSize)
+		}
+		if err := patchFECHeader(hashTree, v.config.FECRoots, blocks, areaOffset); err != nil {
+			return nil, fmt.Errorf("failed to patch fec header: %w", err)
+		}
+	}
+
+	out, err := os.Create(imageFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create verity image: %w", err)
+	}
+	defer out.Close()
+
+	for _, chunk := range [][]byte{data, hashTree, parity} {
+		if _, err := out.Write(chunk); err != nil {
+			return nil, fmt.Errorf("failed to write verity image: %w", err)
+		}
+	}
+
+	return rootHash, nil
+}
@@ -0,0 +1,127 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package runsc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	v2 "github.com/containerd/containerd/runtime/v2"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// runtimeName is the pool key this package registers and looks up
+// prewarmed sandboxes under, the same way runtime/v2/kata-direct keys
+// its own entries under "kata-direct".
+const runtimeName = "io.containerd.runsc.v1"
+
+// pooledSandbox adapts a running runsc sandbox process to v2.Sandbox,
+// so it can sit in a runtime/v2.ShimPool alongside prewarmed shims of
+// any other runtime. CreateContainer joins the real workload container
+// to the already-running sandbox via "runsc exec" instead of a cold
+// "runsc create".
+type pooledSandbox struct {
+	runsc *Runsc
+	id    string
+}
+
+func (p *pooledSandbox) CreateContainer(ctx context.Context, containerID string, ociSpec, rootFs interface{}) error {
+	spec, ok := ociSpec.(*specs.Spec)
+	if !ok {
+		return fmt.Errorf("pooledSandbox: unexpected ociSpec type %T", ociSpec)
+	}
+
+	specPath, err := writeProcessSpec(p.runsc.Root, containerID, spec.Process)
+	if err != nil {
+		return fmt.Errorf("failed to write process spec for pooled sandbox: %w", err)
+	}
+	defer os.Remove(specPath)
+
+	return p.runsc.Exec(ctx, p.id, specPath)
+}
+
+func (p *pooledSandbox) Stop(ctx context.Context) error {
+	if err := p.runsc.Kill(ctx, p.id, 9); err != nil {
+		return err
+	}
+	return p.runsc.Delete(ctx, p.id)
+}
+
+// writeProcessSpec writes proc as the process.json "runsc exec"
+// expects for id, into os.TempDir() (root is not used), the on-disk
+// handoff runsc's CLI uses in place of a direct API call.
+func writeProcessSpec(root, id string, proc *specs.Process) (string, error) {
+	data, err := json.Marshal(proc)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("runsc-exec-%s-process.json", id))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// stubPauseBundle writes a minimal OCI bundle at dir that runs an
+// indefinitely-sleeping pause process - a placeholder workload a
+// prewarmed sandbox boots with before any real container is known to
+// join it, mirroring the pause container every other sandboxed runtime
+// warms up with.
+func stubPauseBundle(dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "rootfs"), 0755); err != nil {
+		return err
+	}
+
+	spec := specs.Spec{
+		Version: specs.Version,
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			Args: []string{"/pause"},
+			Cwd:  "/",
+		},
+	}
+
+	data, err := json.MarshalIndent(&spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "config.json"), data, 0600)
+}
+
+// warmupSandboxCounter gives each sandbox WarmUpPool boots its own id,
+// since nothing names a prewarmed sandbox ahead of time.
+var warmupSandboxCounter uint64
+
+// WarmUpPool boots runsc sandboxes against a stub pause bundle and
+// registers them into pool under profile until SandboxPoolConfig.MinSize
+// idle entries exist for ns, ready for a task service's create path to
+// adopt via pool.GetIdleSandbox the same way runtime/v2/kata-direct's
+// WarmUpPool does for kata.
+func WarmUpPool(ctx context.Context, pool *v2.ShimPool, ns string, template *Runsc, bundleRoot string, profile v2.SandboxProfile) error {
+	boot := func(ctx context.Context) (v2.Sandbox, error) {
+		id := fmt.Sprintf("runsc-warm-%d", atomic.AddUint64(&warmupSandboxCounter, 1))
+
+		bundle := filepath.Join(bundleRoot, id)
+		if err := stubPauseBundle(bundle); err != nil {
+			return nil, fmt.Errorf("failed to write stub pause bundle: %w", err)
+		}
+
+		r := *template
+		if err := r.Create(ctx, id, bundle); err != nil {
+			return nil, fmt.Errorf("failed to boot warm-up sandbox: %w", err)
+		}
+		if err := r.Start(ctx, id); err != nil {
+			return nil, fmt.Errorf("failed to start warm-up sandbox: %w", err)
+		}
+
+		return &pooledSandbox{runsc: &r, id: id}, nil
+	}
+
+	return pool.WarmUpSandboxes(ctx, ns, runtimeName, profile, boot)
+}
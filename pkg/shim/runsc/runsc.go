@@ -0,0 +1,308 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package runsc wraps the gVisor runsc CLI the way go-runc wraps runc,
+// for a pkg/shim/v2 task service built directly against runsc instead
+// of shelling out through the separate containerd-shim-runsc-v1
+// binary.
+package runsc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DefaultCommand is the runsc binary invoked when Runsc.Command is unset.
+const DefaultCommand = "runsc"
+
+// LogFormat selects runsc's --log-format value.
+type LogFormat string
+
+const (
+	JSON LogFormat = "json"
+	Text LogFormat = "text"
+)
+
+// Runsc wraps invocations of the runsc CLI: create, start, wait, kill,
+// delete, events, ps. Config is rendered ahead of the subcommand as
+// --key=value flags, for whatever runsc flags (--network, --platform,
+// ...) this type has no dedicated field for.
+type Runsc struct {
+	// Command is the runsc binary to run; DefaultCommand if empty.
+	Command string
+	// PdeathSignal, delivered to a runsc subprocess if this process
+	// dies first - see exec.Cmd's SysProcAttr.Pdeathsig.
+	PdeathSignal syscall.Signal
+	// Setpgid places a runsc subprocess in its own process group, so a
+	// signal sent to this process's group doesn't also reach it directly.
+	Setpgid bool
+
+	Root      string
+	Log       string
+	LogFormat LogFormat
+	Config    map[string]string
+
+	// Monitor starts and reaps every subprocess this Runsc spawns.
+	// DefaultMonitor is used if nil.
+	Monitor ProcessMonitor
+}
+
+// args renders Root/Log/LogFormat/Config as the global flags runsc
+// expects ahead of its subcommand, in a deterministic order so two
+// calls with the same Config produce identical argv - tests rely on
+// this to assert on exact flag rendering.
+func (r *Runsc) args() []string {
+	var out []string
+	if r.Root != "" {
+		out = append(out, "--root", r.Root)
+	}
+	if r.Log != "" {
+		out = append(out, "--log", r.Log)
+	}
+	if r.LogFormat != "" {
+		out = append(out, "--log-format", string(r.LogFormat))
+	}
+
+	keys := make([]string, 0, len(r.Config))
+	for k := range r.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		out = append(out, fmt.Sprintf("--%s=%s", k, r.Config[k]))
+	}
+	return out
+}
+
+// execCommand is overridden in tests to stub out the real runsc binary.
+var execCommand = exec.CommandContext
+
+func (r *Runsc) command(ctx context.Context, args ...string) *exec.Cmd {
+	command := r.Command
+	if command == "" {
+		command = DefaultCommand
+	}
+
+	cmd := execCommand(ctx, command, append(r.args(), args...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:   r.Setpgid,
+		Pdeathsig: r.PdeathSignal,
+	}
+	return cmd
+}
+
+func (r *Runsc) monitor() ProcessMonitor {
+	if r.Monitor != nil {
+		return r.Monitor
+	}
+	return DefaultMonitor
+}
+
+// run starts cmd via the configured ProcessMonitor, waits for it, and
+// turns a non-zero exit into an error carrying stderr.
+func (r *Runsc) run(ctx context.Context, args ...string) error {
+	cmd := r.command(ctx, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	m := r.monitor()
+	if err := m.Start(cmd); err != nil {
+		return fmt.Errorf("failed to start runsc %v: %w", args, err)
+	}
+	status, err := m.Wait(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to wait for runsc %v: %w", args, err)
+	}
+	if status != 0 {
+		return fmt.Errorf("runsc %v: exit status %d: %s", args, status, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Create runs "runsc create" for id against bundle - the operation
+// ShimPool's prewarm path uses to bring up a sandbox process ahead of
+// any workload container existing yet.
+func (r *Runsc) Create(ctx context.Context, id, bundle string, opts ...string) error {
+	args := append([]string{"create", "--bundle", bundle}, opts...)
+	return r.run(ctx, append(args, id)...)
+}
+
+// Start runs "runsc start" for an already-created id.
+func (r *Runsc) Start(ctx context.Context, id string) error {
+	return r.run(ctx, "start", id)
+}
+
+// Exec runs "runsc exec" to inject a new process, described by specPath
+// (a process.json), into id's already-running sandbox - how a
+// prewarmed pool entry is adopted by the real workload container.
+func (r *Runsc) Exec(ctx context.Context, id, specPath string, opts ...string) error {
+	args := append([]string{"exec"}, opts...)
+	args = append(args, "--process", specPath, id)
+	return r.run(ctx, args...)
+}
+
+// Kill runs "runsc kill" to send sig to id's init process.
+func (r *Runsc) Kill(ctx context.Context, id string, sig int, opts ...string) error {
+	args := append([]string{"kill"}, opts...)
+	return r.run(ctx, append(args, id, strconv.Itoa(sig))...)
+}
+
+// Delete runs "runsc delete" to remove id's sandbox state.
+func (r *Runsc) Delete(ctx context.Context, id string, opts ...string) error {
+	args := append([]string{"delete"}, opts...)
+	return r.run(ctx, append(args, id)...)
+}
+
+// Wait runs "runsc wait", blocking until id's init process exits. It
+// returns 0 when the runsc wait process exits 0; any other exit status
+// of runsc wait is returned as -1 with an error. The output runsc wait
+// writes to stdout is not read.
+func (r *Runsc) Wait(ctx context.Context, id string) (int, error) {
+	cmd := r.command(ctx, "wait", id)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	m := r.monitor()
+	if err := m.Start(cmd); err != nil {
+		return -1, fmt.Errorf("failed to start runsc wait: %w", err)
+	}
+	status, err := m.Wait(cmd)
+	if err != nil {
+		return -1, fmt.Errorf("failed to wait for runsc wait: %w", err)
+	}
+	if status != 0 {
+		return -1, fmt.Errorf("runsc wait %s: exit status %d: %s", id, status, strings.TrimSpace(stderr.String()))
+	}
+	return status, nil
+}
+
+// Events streams "runsc events" output for id until ctx is canceled or
+// the subprocess exits on its own; the caller is expected to decode the
+// newline-delimited event JSON runsc writes to stdout and to Close the
+// returned reader, which also reaps the subprocess via the configured
+// ProcessMonitor.
+func (r *Runsc) Events(ctx context.Context, id string, opts ...string) (io.ReadCloser, error) {
+	args := append([]string{"events"}, opts...)
+	cmd := r.command(ctx, append(args, id)...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open runsc events stdout: %w", err)
+	}
+
+	m := r.monitor()
+	if err := m.Start(cmd); err != nil {
+		return nil, fmt.Errorf("failed to start runsc events: %w", err)
+	}
+
+	return &eventsStream{ReadCloser: stdout, cmd: cmd, monitor: m}, nil
+}
+
+// eventsStream reaps Events' subprocess via ProcessMonitor when Close
+// is called, instead of leaving it for a caller to wait on separately.
+type eventsStream struct {
+	io.ReadCloser
+	cmd     *exec.Cmd
+	monitor ProcessMonitor
+}
+
+func (e *eventsStream) Close() error {
+	closeErr := e.ReadCloser.Close()
+	if _, err := e.monitor.Wait(e.cmd); err != nil && closeErr == nil {
+		closeErr = err
+	}
+	return closeErr
+}
+
+// psEntry is the shape of one "runsc ps --format json" row.
+type psEntry struct {
+	PID int `json:"pid"`
+}
+
+// Ps lists the pids of processes running inside id's sandbox.
+func (r *Runsc) Ps(ctx context.Context, id string) ([]int, error) {
+	cmd := r.command(ctx, "ps", "--format", "json", id)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	m := r.monitor()
+	if err := m.Start(cmd); err != nil {
+		return nil, fmt.Errorf("failed to start runsc ps: %w", err)
+	}
+	status, err := m.Wait(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for runsc ps: %w", err)
+	}
+	if status != 0 {
+		return nil, fmt.Errorf("runsc ps %s: exit status %d: %s", id, status, strings.TrimSpace(stderr.String()))
+	}
+
+	var entries []psEntry
+	if err := json.Unmarshal(stdout.Bytes(), &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse runsc ps output: %w", err)
+	}
+	pids := make([]int, len(entries))
+	for i, e := range entries {
+		pids[i] = e.PID
+	}
+	return pids, nil
+}
+
+// ProcessMonitor starts and reaps the processes Runsc spawns, the same
+// pluggability go-runc's Monitor interface gives runc.Runc - a shim
+// process that already runs its own subreaper loop (to correctly wait
+// on every child regardless of which goroutine started it) can supply
+// one backed by that loop instead of DefaultMonitor's direct
+// Cmd.Start/Cmd.Wait pair.
+type ProcessMonitor interface {
+	Start(cmd *exec.Cmd) error
+	Wait(cmd *exec.Cmd) (int, error)
+}
+
+// DefaultMonitor starts and waits on a command directly via the
+// standard library. Sufficient as long as nothing else in the process
+// needs to share in reaping runsc's children.
+var DefaultMonitor ProcessMonitor = &StdMonitor{}
+
+// StdMonitor is the default ProcessMonitor: Cmd.Start/Cmd.Wait with no
+// shared reaping state.
+type StdMonitor struct{}
+
+func (StdMonitor) Start(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+func (StdMonitor) Wait(cmd *exec.Cmd) (int, error) {
+	err := cmd.Wait()
+	if err == nil {
+		return 0, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return -1, err
+}
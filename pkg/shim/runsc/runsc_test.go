@@ -0,0 +1,119 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package runsc
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArgsFlagRendering(t *testing.T) {
+	r := &Runsc{
+		Root:      "/run/runsc",
+		Log:       "/var/log/runsc.log",
+		LogFormat: JSON,
+		Config: map[string]string{
+			"network":  "none",
+			"platform": "kvm",
+		},
+	}
+
+	assert.Equal(t, []string{
+		"--root", "/run/runsc",
+		"--log", "/var/log/runsc.log",
+		"--log-format", "json",
+		"--network=none",
+		"--platform=kvm",
+	}, r.args())
+}
+
+func TestArgsFlagRenderingEmpty(t *testing.T) {
+	r := &Runsc{}
+	assert.Empty(t, r.args())
+}
+
+// fakeMonitor stubs exec.Cmd entirely: Start/Wait never actually run a
+// child process, they just record that the lifecycle methods were
+// invoked and report a canned status - the request's ask for "unit
+// tests that stub exec.Cmd ... through ProcessMonitor".
+type fakeMonitor struct {
+	mu       sync.Mutex
+	started  []*exec.Cmd
+	waited   []*exec.Cmd
+	status   int
+	waitErr  error
+	startErr error
+}
+
+func (f *fakeMonitor) Start(cmd *exec.Cmd) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = append(f.started, cmd)
+	return f.startErr
+}
+
+func (f *fakeMonitor) Wait(cmd *exec.Cmd) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waited = append(f.waited, cmd)
+	return f.status, f.waitErr
+}
+
+func TestRunEmitsStartThenWait(t *testing.T) {
+	fm := &fakeMonitor{status: 0}
+	r := &Runsc{Monitor: fm}
+
+	err := r.run(context.Background(), "create", "foo")
+	assert.NoError(t, err)
+	assert.Len(t, fm.started, 1)
+	assert.Len(t, fm.waited, 1)
+	assert.Same(t, fm.started[0], fm.waited[0])
+}
+
+func TestRunNonZeroExitIsError(t *testing.T) {
+	fm := &fakeMonitor{status: 1}
+	r := &Runsc{Monitor: fm}
+
+	err := r.run(context.Background(), "create", "foo")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exit status 1")
+}
+
+func TestWaitReturnsRunscReportedStatus(t *testing.T) {
+	fm := &fakeMonitor{status: 0}
+	r := &Runsc{Monitor: fm}
+
+	status, err := r.Wait(context.Background(), "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, status)
+	assert.Len(t, fm.started, 1)
+	assert.Len(t, fm.waited, 1)
+}
+
+func TestCommandUsesConfiguredMonitorOnlyWhenSet(t *testing.T) {
+	r := &Runsc{}
+	assert.Same(t, DefaultMonitor, r.monitor())
+
+	fm := &fakeMonitor{}
+	r.Monitor = fm
+	assert.Same(t, ProcessMonitor(fm), r.monitor())
+}
+
+func TestCreateAndKillAndDeletePropagateID(t *testing.T) {
+	fm := &fakeMonitor{status: 0}
+	r := &Runsc{Monitor: fm}
+	ctx := context.Background()
+
+	assert.NoError(t, r.Create(ctx, "sbx-1", "/bundle"))
+	assert.NoError(t, r.Kill(ctx, "sbx-1", 9))
+	assert.NoError(t, r.Delete(ctx, "sbx-1"))
+	assert.Len(t, fm.started, 3)
+	for _, cmd := range fm.started {
+		assert.Contains(t, cmd.Args, "sbx-1")
+	}
+}
@@ -20,10 +20,10 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	gruntime "runtime"
+	"strconv"
 
 	"github.com/containerd/containerd/api/runtime/task/v2"
 	"github.com/containerd/containerd/namespaces"
@@ -40,6 +40,16 @@ type shimBinaryConfig struct {
 	address      string
 	ttrpcAddress string
 	schedCore    bool
+
+	// numaNode, cpuSet and cgroupParent are only consumed by StartWarm
+	// (see warm_pool.go's startWarmShim): they pin a pre-warmed shim to
+	// a PlacementPolicy-assigned NUMA node, cpuset, or parent cgroup
+	// before the container that will use it is even known. Start leaves
+	// them unset since a shim started the normal way is placed by the
+	// runtime spec of the container it's bound to from the outset.
+	numaNode     int
+	cpuSet       string
+	cgroupParent string
 }
 
 func shimBinary(bundle *Bundle, config shimBinaryConfig) *binary {
@@ -49,6 +59,9 @@ func shimBinary(bundle *Bundle, config shimBinaryConfig) *binary {
 		containerdAddress:      config.address,
 		containerdTTRPCAddress: config.ttrpcAddress,
 		schedCore:              config.schedCore,
+		numaNode:               config.numaNode,
+		cpuSet:                 config.cpuSet,
+		cgroupParent:           config.cgroupParent,
 	}
 }
 
@@ -58,6 +71,12 @@ type binary struct {
 	containerdTTRPCAddress string
 	schedCore              bool
 	bundle                 *Bundle
+
+	// numaNode, cpuSet and cgroupParent mirror shimBinaryConfig's fields
+	// of the same name; see there. Unused by Start, only by StartWarm.
+	numaNode     int
+	cpuSet       string
+	cgroupParent string
 }
 
 func (b *binary) Start(ctx context.Context, opts *types.Any, onClose func()) (_ *shim, err error) {
@@ -146,10 +165,20 @@ func (b *binary) Start(ctx context.Context, opts *types.Any, onClose func()) (_
 			f.Close()
 		}
 	}()
-	// copy the shim's logs to containerd's output
+	// Forward the shim's logs, structured and rate limited, instead of
+	// copying the raw pipe straight to containerd's own output.
+	logSink, err := shimLogSink()
+	if err != nil {
+		return nil, err
+	}
 	go func() {
 		defer f.Close()
-		_, err := io.Copy(os.Stderr, f)
+		err := client.IngestLog(shimCtx, f, client.LogIngestConfig{
+			ID:        b.bundle.ID,
+			Runtime:   b.runtime,
+			Sink:      logSink,
+			RateLimit: shimLogRateLimit(b.runtime),
+		})
 		err = checkCopyShimLogError(ctx, err)
 		if err != nil {
 			log.G(ctx).WithError(err).Error("copy shim log")
@@ -212,17 +241,19 @@ func (b *binary) Start(ctx context.Context, opts *types.Any, onClose func()) (_
 		return nil, err
 	}
 
-	// If we prewarmed and the shim supports Adopt, bind container context before returning.
-	// Use namespace from ctx; ID/Bundle来自当前bundle。
-	if os.Getenv("CONTAINERD_SHIM_PREWARM") == "1" && params.Version >= 3 {
+	// If prewarm was enabled and the shim supports it, adopt the real
+	// container context (namespace/ID/bundle) onto it before returning,
+	// rather than treating it as freshly started. This also runs when
+	// prewarm failed and the shim was started with "start" instead.
+	if prewarmEnabled && params.Version >= 3 {
 		ns, _ := namespaces.Namespace(ctx)
-		adoptErr := client.AdoptContainer(ctx, conn, &client.AdoptRequest{
-			Id:        b.bundle.ID,
+		if _, adoptErr := client.AdoptContainer(ctx, conn, &client.AdoptRequest{
+			ID:        b.bundle.ID,
 			Bundle:    b.bundle.Path,
 			Namespace: ns,
-		})
-		if adoptErr != nil {
-			// 兼容处理：若 shim 未实现或返回错误，记录并继续旧路径
+		}); adoptErr != nil {
+			// The shim may predate AdoptContainer; fall back to treating
+			// it as already bound by "start" rather than failing Start.
 			log.G(ctx).WithError(adoptErr).Warn("AdoptContainer failed; continuing without adopt")
 		}
 	}
@@ -233,6 +264,41 @@ func (b *binary) Start(ctx context.Context, opts *types.Any, onClose func()) (_
 	}, nil
 }
 
+// shimLogSinkEnv names an alternate LogSink, registered with
+// client.RegisterLogSink by a LogSinkPlugin, that shim log lines should
+// be routed to instead of containerd's own log destination. Unset uses
+// the default sink, which re-emits lines through log.G.
+const shimLogSinkEnv = "CONTAINERD_SHIM_LOG_SINK"
+
+// shimLogRateLimitEnv and shimLogRateBurstEnv bound how many shim log
+// lines per second are forwarded, e.g. for a runtime whose shim logs
+// very verbosely. Both are unset (no rate limiting) by default.
+const (
+	shimLogRateLimitEnv = "CONTAINERD_SHIM_LOG_RATE"
+	shimLogRateBurstEnv = "CONTAINERD_SHIM_LOG_BURST"
+)
+
+// shimLogSink resolves CONTAINERD_SHIM_LOG_SINK to a registered
+// client.LogSink, if set.
+func shimLogSink() (client.LogSink, error) {
+	name := os.Getenv(shimLogSinkEnv)
+	if name == "" {
+		return nil, nil
+	}
+	return client.ResolveLogSink(name)
+}
+
+// shimLogRateLimit builds a rate limit for runtime from
+// CONTAINERD_SHIM_LOG_RATE/CONTAINERD_SHIM_LOG_BURST. Runtime is
+// accepted, not just used globally, so a future per-runtime override
+// (e.g. CONTAINERD_SHIM_LOG_RATE_<runtime>) can be layered on without
+// changing callers.
+func shimLogRateLimit(runtime string) client.RateLimiterConfig {
+	rate, _ := strconv.ParseFloat(os.Getenv(shimLogRateLimitEnv), 64)
+	burst, _ := strconv.Atoi(os.Getenv(shimLogRateBurstEnv))
+	return client.RateLimiterConfig{Rate: rate, Burst: burst}
+}
+
 func (b *binary) Delete(ctx context.Context) (*runtime.Exit, error) {
 	log.G(ctx).Info("cleaning up dead shim")
 
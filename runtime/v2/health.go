@@ -0,0 +1,66 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/ttrpc"
+)
+
+// HealthChecker probes a single PoolItem and reports whether it's still
+// able to serve a container. ShimPool.Run calls it on CheckInterval for
+// every tracked item, bounded by HealthTimeout.
+type HealthChecker interface {
+	Check(ctx context.Context, item *PoolItem) error
+}
+
+// ttrpcHealthChecker is the default HealthChecker: it dials
+// PoolItem.Address and issues a TaskService.Connect, the same RPC a
+// real client uses to probe a shim before trusting it, treating any
+// dial or RPC error as unhealthy.
+type ttrpcHealthChecker struct{}
+
+// NewTTRPCHealthChecker returns the default HealthChecker, used by
+// NewShimPool unless overridden.
+func NewTTRPCHealthChecker() HealthChecker {
+	return &ttrpcHealthChecker{}
+}
+
+func (c *ttrpcHealthChecker) Check(ctx context.Context, item *PoolItem) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "unix", item.Address)
+	if err != nil {
+		return fmt.Errorf("dial shim at %s: %w", item.Address, err)
+	}
+	defer conn.Close()
+
+	client := ttrpc.NewClient(conn)
+	defer client.Close()
+
+	if _, err := taskAPI.NewTaskClient(client).Connect(ctx, &taskAPI.ConnectRequest{}); err != nil {
+		return fmt.Errorf("connect probe to shim at %s: %w", item.Address, err)
+	}
+	return nil
+}
+
+// defaultCheckInterval is how often Run probes pool items when
+// ShimPool.CheckInterval is left unset.
+const defaultCheckInterval = 30 * time.Second
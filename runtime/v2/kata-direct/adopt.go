@@ -0,0 +1,104 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package katadirect
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/runtime/v2/shim"
+	"github.com/kata-containers/kata-containers/src/runtime/pkg/oci"
+	vc "github.com/kata-containers/kata-containers/src/runtime/virtcontainers"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// AdoptContainer rebinds s from its placeholder prewarm identity to
+// req's real container - the namespace/ID New would have set had s been
+// created cold for req instead of prewarmed. The sandbox itself isn't
+// touched here: the hot-plug resize and container join happen inside
+// createContainer's normal dispatch, via adoptWarmSandbox, once the real
+// OCI spec arrives with the Create rpc.
+//
+// It mirrors the contract of runtime/v2/shim.AdoptContainer, the
+// equivalent rpc for an out-of-process shim, returning
+// errdefs.ErrNotImplemented if s isn't a warm, unbound service -
+// kata-direct has no ttrpc server of its own to register that rpc on,
+// since it implements taskAPI.TaskService in-process, so
+// taskServiceFactory calls this directly instead of over ttrpc.
+func (s *service) AdoptContainer(ctx context.Context, req *shim.AdoptRequest) (*shim.AdoptResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.warm {
+		return nil, errdefs.ErrNotImplemented
+	}
+
+	if req.Namespace != "" {
+		s.namespace = req.Namespace
+	}
+	s.id = req.ID
+
+	return &shim.AdoptResponse{Pid: s.hpid}, nil
+}
+
+// adoptWarmSandbox resizes s's already-running, prewarmed sandbox to fit
+// ociSpec via hot-plug, then joins id as a container of it with
+// createPodContainer instead of booting a fresh sandbox from scratch.
+// The caller is expected to fall back to the cold createSandbox path
+// (after calling teardownWarmSandbox) if this returns an error, e.g.
+// because the hypervisor doesn't support hot-plug.
+func (s *service) adoptWarmSandbox(ctx context.Context, id, bundlePath string, ociSpec *specs.Spec, rootFs vc.RootFs) error {
+	cpus, memMB := oci.CalculateSandboxSizing(ociSpec)
+	if err := s.resizeSandbox(ctx, cpus, memMB); err != nil {
+		return fmt.Errorf("hot-plug resize failed: %w", err)
+	}
+
+	if err := s.createPodContainer(ctx, id, bundlePath, ociSpec, rootFs); err != nil {
+		return fmt.Errorf("failed to join warm sandbox: %w", err)
+	}
+
+	s.warm = false
+
+	return nil
+}
+
+// resizeSandbox hot-plugs s.sandbox's CPU/memory to match cpus/memMB.
+//
+// TODO: assumes vc.VCSandbox exposes UpdateCPUs/UpdateMemory as its
+// hot-plug entrypoints; adjust to the real virtcontainers method names
+// once vendored. It returns an error if the hypervisor backing the
+// sandbox doesn't support resizing a running VM.
+func (s *service) resizeSandbox(ctx context.Context, cpus uint32, memMB uint32) error {
+	if cpus > 0 {
+		if err := s.sandbox.UpdateCPUs(ctx, cpus); err != nil {
+			return fmt.Errorf("failed to hot-plug cpus: %w", err)
+		}
+	}
+	if memMB > 0 {
+		if err := s.sandbox.UpdateMemory(ctx, memMB); err != nil {
+			return fmt.Errorf("failed to hot-plug memory: %w", err)
+		}
+	}
+	return nil
+}
+
+// teardownWarmSandbox stops and deletes a prewarmed sandbox that turned
+// out not to be usable for the adopted container - e.g. its hypervisor
+// can't hot-plug - clearing s.sandbox and s.config so createContainer's
+// cold path boots a fresh sandbox from the container's own OCI spec.
+func (s *service) teardownWarmSandbox(ctx context.Context) {
+	if s.sandbox == nil {
+		return
+	}
+	if err := s.sandbox.Stop(ctx, true); err != nil {
+		serviceLog.WithError(err).Warn("failed to stop warm sandbox")
+	}
+	if err := s.sandbox.Delete(ctx); err != nil {
+		serviceLog.WithError(err).Warn("failed to delete warm sandbox")
+	}
+	s.sandbox = nil
+	s.config = nil
+	s.warm = false
+}
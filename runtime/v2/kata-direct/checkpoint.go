@@ -0,0 +1,226 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package katadirect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kata-containers/kata-containers/src/runtime/pkg/oci"
+	vc "github.com/kata-containers/kata-containers/src/runtime/virtcontainers"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// checkpointManifest is the checkpoint.json written alongside a
+// checkpoint's VM state and guest CRIU images. checkpointContainer
+// fills in only ContainerID and CreatedAt, and restoreContainer reads
+// the manifest back without checking it against the sandbox it loads
+// the checkpoint into.
+type checkpointManifest struct {
+	ContainerID string `json:"container_id"`
+	CreatedAt   string `json:"created_at"`
+
+	// HypervisorType names the VMM the checkpoint's VM state dump was
+	// produced with (qemu, cloud-hypervisor, ...). It is left empty, and
+	// restore loads a dump without comparing hypervisors.
+	HypervisorType string `json:"hypervisor_type"`
+
+	// KernelHash/InitrdHash identify the guest kernel/initrd the
+	// checkpoint's sandbox booted, so restore can detect a rootfs/kernel
+	// upgrade that would make the dumped VM state unsafe to resume.
+	//
+	// TODO: left blank - the same gap sandboxProfile's own TODO already
+	// notes for Kernel/Initrd: this tree doesn't vendor virtcontainers'
+	// oci.RuntimeConfig, so there's no HypervisorConfig path to hash
+	// here yet.
+	KernelHash string `json:"kernel_hash,omitempty"`
+	InitrdHash string `json:"initrd_hash,omitempty"`
+
+	// RootfsLayers are the content-addressed layer IDs (r.Rootfs[i].
+	// Source, from the CreateTaskRequest that originally built this
+	// container) the checkpoint's rootfs was mounted from.
+	RootfsLayers []string `json:"rootfs_layers,omitempty"`
+}
+
+const (
+	checkpointManifestName = "checkpoint.json"
+	checkpointVMStateDir   = "vmstate"
+	checkpointCRIUDir      = "criu"
+	checkpointBundleDir    = "bundle"
+)
+
+// checkpointContainer dumps c's hypervisor VM state and per-container
+// guest CRIU image into path, alongside a copy of the OCI bundle config
+// and a checkpointManifest recording the container ID and creation
+// time.
+func (s *service) checkpointContainer(ctx context.Context, c *container, path string) error {
+	if s.sandbox == nil {
+		return fmt.Errorf("sandbox not found for container %s", c.id)
+	}
+
+	vmStateDir := filepath.Join(path, checkpointVMStateDir)
+	criuDir := filepath.Join(path, checkpointCRIUDir)
+	bundleDir := filepath.Join(path, checkpointBundleDir)
+	for _, dir := range []string{vmStateDir, criuDir, bundleDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create checkpoint directory %s: %w", dir, err)
+		}
+	}
+
+	serviceLog.WithField("container", c.id).WithField("path", path).Info("checkpointing container")
+
+	// TODO: assumes vc.VCSandbox exposes SaveVMState/CheckpointContainer
+	// as its hypervisor savevm and guest-agent CRIU entrypoints,
+	// following the same "adjust to the real virtcontainers method names
+	// once vendored" caveat resizeSandbox already carries for hot-plug.
+	// Neither is part of the upstream kata-containers VCSandbox
+	// interface today - kata has no generic checkpoint/restore support -
+	// so this compiles against an assumed surface, not a vendored one.
+	if err := s.sandbox.SaveVMState(ctx, vmStateDir); err != nil {
+		return fmt.Errorf("failed to save VM state: %w", err)
+	}
+	if err := s.sandbox.CheckpointContainer(ctx, c.id, criuDir); err != nil {
+		return fmt.Errorf("failed to checkpoint guest container: %w", err)
+	}
+
+	if err := copyFile(filepath.Join(c.bundle, "config.json"), filepath.Join(bundleDir, "config.json")); err != nil {
+		return fmt.Errorf("failed to copy bundle metadata: %w", err)
+	}
+
+	manifest := checkpointManifest{
+		ContainerID: c.id,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := writeCheckpointManifest(path, &manifest); err != nil {
+		return err
+	}
+
+	serviceLog.WithField("container", c.id).Info("checkpoint complete")
+
+	return nil
+}
+
+// restoreContainer loads a checkpoint produced by checkpointContainer
+// in place of createSandbox/createPodContainer's normal cold boot. It
+// reads checkpoint.json (without checking it for compatibility), loads
+// the dumped VM state and reattaches
+// the sandbox's block/vhost-user devices instead of calling
+// sandbox.Start, and restores the guest container's CRIU image -
+// startContainer then skips straight to handleIO/waitContainerProcess
+// for the resumed process rather than starting a fresh one, once it
+// sees container.restored set.
+func (s *service) restoreContainer(ctx context.Context, id, bundlePath string, ociSpec *specs.Spec, checkpointPath string) (*container, error) {
+	manifest, err := readCheckpointManifest(checkpointPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint manifest: %w", err)
+	}
+
+	if err := s.ensureConfig(ociSpec); err != nil {
+		return nil, err
+	}
+
+	// TODO: HypervisorType/KernelHash/InitrdHash aren't cross-checked
+	// against s.config yet - the same oci.RuntimeConfig field names
+	// checkpointManifest's own doc comment notes aren't available in
+	// this tree. A real implementation must refuse to restore into an
+	// incompatible hypervisor/kernel here rather than only logging it.
+	serviceLog.WithField("container", id).WithField("manifest_created", manifest.CreatedAt).
+		Warn("restoring checkpoint without hypervisor/kernel compatibility validation")
+
+	vmStateDir := filepath.Join(checkpointPath, checkpointVMStateDir)
+	criuDir := filepath.Join(checkpointPath, checkpointCRIUDir)
+
+	rootFs := vc.RootFs{}
+
+	// TODO: assumes vc.VCSandbox exposes RestoreVMState/ReattachDevices/
+	// RestoreContainer, the restore-side counterparts of
+	// checkpointContainer's SaveVMState/CheckpointContainer assumptions
+	// above - same "adjust once vendored" caveat applies.
+	sandbox, err := s.vci.RestoreVMState(ctx, vmStateDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load VM state: %w", err)
+	}
+	s.sandbox = sandbox
+
+	if err := s.sandbox.ReattachDevices(ctx); err != nil {
+		return nil, fmt.Errorf("failed to reattach devices: %w", err)
+	}
+
+	if err := s.sandbox.RestoreContainer(ctx, id, criuDir); err != nil {
+		return nil, fmt.Errorf("failed to restore guest container: %w", err)
+	}
+
+	pid, err := s.sandbox.GetHypervisorPid()
+	if err != nil {
+		serviceLog.WithError(err).Warn("failed to get hypervisor pid for restored sandbox")
+	} else {
+		s.hpid = uint32(pid)
+	}
+
+	containerType, err := oci.ContainerType(*ociSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &container{
+		id:       id,
+		bundle:   bundlePath,
+		spec:     ociSpec,
+		mounted:  rootFs.Mounted,
+		cType:    containerType,
+		terminal: ociSpec.Process != nil && ociSpec.Process.Terminal,
+		restored: true,
+		exitCh:   make(chan struct{}),
+		exitIOch: make(chan struct{}),
+	}
+
+	serviceLog.WithField("container", id).Info("restored container from checkpoint")
+
+	return c, nil
+}
+
+func writeCheckpointManifest(path string, manifest *checkpointManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(path, checkpointManifestName), data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint manifest: %w", err)
+	}
+	return nil
+}
+
+func readCheckpointManifest(path string) (*checkpointManifest, error) {
+	data, err := os.ReadFile(filepath.Join(path, checkpointManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var manifest checkpointManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,193 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package katadirect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/containerd/console"
+	"github.com/containerd/fifo"
+)
+
+// ptyConsole is the host PTY handleIO allocates for a TTY container.
+// kata-agent already allocates its own PTY inside the guest once
+// CreateContainer's spec has Terminal=true - that's what sandbox.
+// IOStream's single stdout stream carries - so master/slave here don't
+// sit in the data path between the agent and the external console FIFO;
+// relayConsole copies the agent streams to/from the FIFO directly. What
+// master is for is WinsizeProcess parity: resize records the size here
+// the same way it applies it to the sandbox, so a size query issued
+// against the local PTY (e.g. by future console-aware tooling) agrees
+// with what the guest process was actually resized to.
+type ptyConsole struct {
+	master console.Console
+	slave  *os.File
+
+	mu                sync.Mutex
+	pending           winsize
+	havePendingResize bool
+	attached          bool
+
+	closeOnce sync.Once
+}
+
+// newPtyConsole allocates a PTY pair for a TTY container. The slave
+// side has no local process to attach to - kata-direct has no runc-style
+// child it execs with the slave as fd 0-2 - so it's opened and held only
+// so the pair is complete and can be torn down together; it's otherwise
+// unused.
+func newPtyConsole() (*ptyConsole, error) {
+	master, slavePath, err := console.NewPty()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pty: %w", err)
+	}
+
+	slave, err := os.OpenFile(slavePath, os.O_RDWR, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to open pty slave %s: %w", slavePath, err)
+	}
+
+	return &ptyConsole{master: master, slave: slave}, nil
+}
+
+// resize records cols x rows as the pending size, applying it to the
+// local master immediately if the console has already been attached,
+// or queuing it for markAttached to apply otherwise - a resize can
+// legitimately race Start/handleIO the same way it races startExec for
+// an exec process (see resizeExecPty).
+func (p *ptyConsole) resize(cols, rows uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending = winsize{cols: cols, rows: rows}
+	if !p.attached {
+		p.havePendingResize = true
+		return nil
+	}
+	return p.master.Resize(console.WinSize{Width: uint16(cols), Height: uint16(rows)})
+}
+
+// markAttached applies a resize queued before handleIO ran.
+func (p *ptyConsole) markAttached() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.attached = true
+	if !p.havePendingResize {
+		return nil
+	}
+	p.havePendingResize = false
+	return p.master.Resize(console.WinSize{Width: uint16(p.pending.cols), Height: uint16(p.pending.rows)})
+}
+
+// close releases the PTY pair exactly once, so waitContainerProcess
+// closing it on exit can't race an earlier error path doing the same.
+func (p *ptyConsole) close() {
+	p.closeOnce.Do(func() {
+		p.slave.Close()
+		p.master.Close()
+	})
+}
+
+// resizeContainerPty resizes c's init-process PTY - the container-level
+// counterpart of resizeExecPty, which only handles an exec process. The
+// init process's token is c.id itself (see handleIO's IOStream call),
+// so unlike resizeExecPty there's no token-not-yet-known case to queue
+// against; c.console.resize covers the narrower race against handleIO
+// not having run yet instead.
+func (s *service) resizeContainerPty(ctx context.Context, c *container, cols, rows uint32) error {
+	if !c.terminal || c.console == nil {
+		return fmt.Errorf("container %s has no TTY to resize", c.id)
+	}
+
+	if err := c.console.resize(cols, rows); err != nil {
+		s.log.WithError(err).WithField("container", c.id).Warn("failed to resize local console")
+	}
+
+	if s.sandbox == nil {
+		return fmt.Errorf("sandbox not found")
+	}
+	if err := s.sandbox.WinsizeProcess(ctx, c.id, c.id, rows, cols); err != nil {
+		return fmt.Errorf("failed to resize container %s: %w", c.id, err)
+	}
+	return nil
+}
+
+// handleTTYIO is handleIO's TTY branch: it allocates c's console if
+// handleIO hasn't already (a resize can arrive first and call
+// resizeContainerPty before Start ever runs handleIO, so the console
+// may already exist), applies any resize queued against it, and
+// multiplexes stdoutStream (and stderrStream, if the agent ever returns
+// one for a terminal process - kata-agent normally doesn't) onto the
+// single path c.stdout names, the way a runc-style shim gives a TTY
+// container one console stream instead of three FIFOs. Called with
+// c.ioMu held, matching handleIO's own locking at the point it's
+// invoked from.
+func (s *service) handleTTYIO(ctx context.Context, c *container, stdinStream io.WriteCloser, stdoutStream, stderrStream io.Reader) error {
+	if c.console == nil {
+		pc, err := newPtyConsole()
+		if err != nil {
+			c.ioMu.Unlock()
+			return fmt.Errorf("failed to allocate console: %w", err)
+		}
+		c.console = pc
+	}
+
+	c.ioAttached = true
+	c.stdinCloser = stdinStream
+	ioCtx, ioCancel := context.WithCancel(ctx)
+	c.ioCancel = ioCancel
+	c.ioMu.Unlock()
+
+	if err := c.console.markAttached(); err != nil {
+		s.log.WithError(err).WithField("container", c.id).Warn("failed to apply queued console resize")
+	}
+
+	f, err := fifo.OpenFifo(ioCtx, c.stdout, syscall.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open console fifo: %w", err)
+	}
+
+	c.ioWg.Add(1)
+	go func() {
+		defer c.ioWg.Done()
+		defer f.Close()
+		if _, err := io.Copy(f, stdoutStream); err != nil && err != context.Canceled {
+			s.log.WithError(err).WithField("container", c.id).Debug("console stdout copy ended")
+		}
+	}()
+
+	if stderrStream != nil {
+		c.ioWg.Add(1)
+		go func() {
+			defer c.ioWg.Done()
+			if _, err := io.Copy(f, stderrStream); err != nil && err != context.Canceled {
+				s.log.WithError(err).WithField("container", c.id).Debug("console stderr copy ended")
+			}
+		}()
+	}
+
+	c.ioWg.Add(1)
+	go func() {
+		defer c.ioWg.Done()
+		if _, err := io.Copy(stdinStream, f); err != nil && err != context.Canceled {
+			s.log.WithError(err).WithField("container", c.id).Debug("console stdin copy ended")
+		}
+		stdinStream.Close()
+	}()
+
+	go func() {
+		c.ioWg.Wait()
+		s.log.WithField("container", c.id).Debug("all console IO streams closed")
+		close(c.exitIOch)
+	}()
+
+	return nil
+}
@@ -0,0 +1,201 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package copier implements host<->guest file copy for katadirect
+// containers as a tar stream rewritten in transit, rather than shelling
+// out to an ad hoc "tar | exec cat" pipeline. The guest side still does
+// the actual tar/untar (via GuestExecutor, run as a normal exec'd
+// process inside the sandbox) since the host process has no direct
+// access to the guest filesystem; this package only owns the transform
+// applied to the stream as it crosses the host/guest boundary -
+// include/exclude filtering, renaming, and uid/gid remapping - while
+// passing everything else (xattrs, hardlinks, device nodes) through
+// unmodified.
+package copier
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/moby/patternmatcher"
+)
+
+// Chown remaps the owner recorded on every tar entry CopyIn writes into
+// the guest, or every entry CopyOut reads back out of it.
+type Chown struct {
+	UID int
+	GID int
+}
+
+// CopyOptions controls how CopyIn/CopyOut filter and rewrite the tar
+// stream passed between host and guest.
+type CopyOptions struct {
+	// Include, if non-empty, keeps only entries matching at least one
+	// pattern (moby/patternmatcher syntax, the same as .dockerignore).
+	// A nil/empty Include keeps everything Exclude doesn't drop.
+	Include []string
+	// Exclude drops entries matching any pattern.
+	Exclude []string
+	// Chown, if non-nil, overrides the uid/gid recorded on every entry.
+	Chown *Chown
+	// Dereference resolves symlinks to their target's content instead
+	// of copying the link itself. For CopyOut this is passed through to
+	// the guest-side tar invocation (GuestExecutor.Command), since only
+	// the guest can see what a symlink inside it resolves to.
+	Dereference bool
+	// Rename maps an entry's recorded path to a new one before it's
+	// written, e.g. to extract "a/b" as "c/b". Entries not present in
+	// Rename are left at their original path.
+	Rename map[string]string
+}
+
+// GuestExecutor runs a command inside a container's guest and exposes
+// its stdio, the same primitive handleExecIO builds its FIFO plumbing
+// on. CopyIn/CopyOut use it directly instead of going through a named
+// exec, since their source/destination is already an in-process
+// io.Reader/io.Writer rather than a FIFO path that needs opening.
+type GuestExecutor interface {
+	// Command starts args inside containerID's guest, returning its
+	// stdin/stdout for the caller to stream the tar archive through.
+	// Wait blocks until the process exits and reports its result.
+	Command(ctx context.Context, containerID string, args []string) (stdin io.WriteCloser, stdout io.ReadCloser, wait func() error, err error)
+}
+
+// CopyIn streams r, a tar archive, into dst inside containerID's guest,
+// applying opts as the stream is rewritten in transit.
+func CopyIn(ctx context.Context, exec GuestExecutor, containerID, dst string, r io.Reader, opts CopyOptions) error {
+	args := []string{"tar", "--numeric-owner", "-xf", "-", "-C", dst}
+
+	stdin, stdout, wait, err := exec.Command(ctx, containerID, args)
+	if err != nil {
+		return fmt.Errorf("copier: failed to start guest tar extract: %w", err)
+	}
+
+	go io.Copy(io.Discard, stdout) //nolint:errcheck
+
+	rewriteErrCh := make(chan error, 1)
+	go func() {
+		rewriteErrCh <- rewriteTar(r, stdin, opts)
+		stdin.Close()
+	}()
+
+	waitErr := wait()
+	rewriteErr := <-rewriteErrCh
+	if rewriteErr != nil {
+		return fmt.Errorf("copier: failed to rewrite tar stream for CopyIn: %w", rewriteErr)
+	}
+	if waitErr != nil {
+		return fmt.Errorf("copier: guest tar extract into %s failed: %w", dst, waitErr)
+	}
+	return nil
+}
+
+// CopyOut streams src, a path inside containerID's guest, out as a tar
+// archive written to w, applying opts as the stream is rewritten in
+// transit.
+func CopyOut(ctx context.Context, exec GuestExecutor, containerID, src string, w io.Writer, opts CopyOptions) error {
+	args := []string{"tar", "--numeric-owner", "-cf", "-"}
+	if opts.Dereference {
+		args = append(args, "-h")
+	}
+	args = append(args, "-C", src, ".")
+
+	stdin, stdout, wait, err := exec.Command(ctx, containerID, args)
+	if err != nil {
+		return fmt.Errorf("copier: failed to start guest tar create: %w", err)
+	}
+	stdin.Close()
+
+	rewriteErrCh := make(chan error, 1)
+	go func() {
+		rewriteErrCh <- rewriteTar(stdout, w, opts)
+	}()
+
+	waitErr := wait()
+	rewriteErr := <-rewriteErrCh
+	if rewriteErr != nil {
+		return fmt.Errorf("copier: failed to rewrite tar stream for CopyOut: %w", rewriteErr)
+	}
+	if waitErr != nil {
+		return fmt.Errorf("copier: guest tar create from %s failed: %w", src, waitErr)
+	}
+	return nil
+}
+
+// rewriteTar copies the tar archive read from r to w, applying opts'
+// include/exclude filtering, Rename, and Chown to each entry's header.
+// Every other header field - including the ones that carry xattrs
+// (PAXRecords) and hardlink targets (Typeflag/Linkname) - is copied
+// through unchanged, so entries opts doesn't touch round-trip exactly
+// as the guest's tar produced or expects them.
+func rewriteTar(r io.Reader, w io.Writer, opts CopyOptions) error {
+	var includeMatcher *patternmatcher.PatternMatcher
+	if len(opts.Include) > 0 {
+		m, err := patternmatcher.New(opts.Include)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern: %w", err)
+		}
+		includeMatcher = m
+	}
+	var excludeMatcher *patternmatcher.PatternMatcher
+	if len(opts.Exclude) > 0 {
+		m, err := patternmatcher.New(opts.Exclude)
+		if err != nil {
+			return fmt.Errorf("invalid exclude pattern: %w", err)
+		}
+		excludeMatcher = m
+	}
+
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading tar entry: %w", err)
+		}
+
+		if includeMatcher != nil {
+			ok, err := includeMatcher.Matches(hdr.Name)
+			if err != nil {
+				return fmt.Errorf("matching include pattern against %s: %w", hdr.Name, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		if excludeMatcher != nil {
+			ok, err := excludeMatcher.Matches(hdr.Name)
+			if err != nil {
+				return fmt.Errorf("matching exclude pattern against %s: %w", hdr.Name, err)
+			}
+			if ok {
+				continue
+			}
+		}
+
+		if newName, ok := opts.Rename[hdr.Name]; ok {
+			hdr.Name = newName
+		}
+		if opts.Chown != nil {
+			hdr.Uid = opts.Chown.UID
+			hdr.Gid = opts.Chown.GID
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("writing tar header for %s: %w", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(tw, tr); err != nil {
+				return fmt.Errorf("copying content for %s: %w", hdr.Name, err)
+			}
+		}
+	}
+
+	return tw.Close()
+}
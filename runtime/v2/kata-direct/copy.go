@@ -0,0 +1,112 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package katadirect
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	vctypes "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/types"
+
+	"github.com/containerd/containerd/v2/runtime/v2/kata-direct/copier"
+)
+
+// copierCapability is advertised to a client probing what this shim
+// supports (see Capabilities), naming the subsystem implemented here.
+const copierCapability = "io.containerd.katadirect.copier.v1"
+
+// Capabilities reports the extension RPCs s supports beyond the
+// standard taskAPI.TaskService, so a client can negotiate before
+// calling one. It's a stand-in for a Capabilities field on
+// taskAPI.ConnectResponse, which, like AdoptRequest/AdoptResponse in
+// runtime/v2/shim, can't be added here since ConnectResponse is
+// generated from a proto this tree doesn't vendor. A future task/v3
+// proto revision should fold this into Connect's response directly;
+// until then callers that know to look can call this method in-process
+// the same way taskServiceFactory calls AdoptContainer.
+func (s *service) Capabilities(ctx context.Context) []string {
+	return []string{copierCapability}
+}
+
+// CopyIn streams a tar archive from r into dst inside containerID's
+// guest. It's kata-direct's in-process equivalent of the "shim-v2
+// extension RPC" a real out-of-process shim would register over ttrpc
+// (see runtime/v2/shim/adopt_server.go for that pattern); kata-direct
+// has no ttrpc server of its own to register an RPC on, since it
+// implements taskAPI.TaskService in-process (see AdoptContainer in
+// adopt.go), so this is called directly instead.
+func (s *service) CopyIn(ctx context.Context, containerID, dst string, r io.Reader, opts copier.CopyOptions) error {
+	s.mu.Lock()
+	_, ok := s.containers[containerID]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("container %s not found", containerID)
+	}
+
+	return copier.CopyIn(ctx, s.guestExecutor(), containerID, dst, r, opts)
+}
+
+// CopyOut streams src inside containerID's guest out as a tar archive
+// written to w. See CopyIn for why this is a direct method rather than
+// an RPC dispatch.
+func (s *service) CopyOut(ctx context.Context, containerID, src string, w io.Writer, opts copier.CopyOptions) error {
+	s.mu.Lock()
+	_, ok := s.containers[containerID]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("container %s not found", containerID)
+	}
+
+	return copier.CopyOut(ctx, s.guestExecutor(), containerID, src, w, opts)
+}
+
+// guestExecutor adapts s.sandbox to copier.GuestExecutor, driving the
+// same EnterContainer/IOStream/WaitProcess sandbox calls startExec and
+// handleExecIO already use for a named exec. It deliberately reuses
+// that plumbing rather than allocating new FIFOs per call: CopyIn/
+// CopyOut already hold an in-process io.Reader/io.Writer, so there's no
+// FIFO path to open in the first place, only the same sandbox stream
+// handles handleExecIO copies through.
+func (s *service) guestExecutor() copier.GuestExecutor {
+	return (*sandboxGuestExecutor)(s)
+}
+
+type sandboxGuestExecutor service
+
+func (s *sandboxGuestExecutor) Command(ctx context.Context, containerID string, args []string) (io.WriteCloser, io.ReadCloser, func() error, error) {
+	svc := (*service)(s)
+
+	if svc.sandbox == nil {
+		return nil, nil, nil, fmt.Errorf("sandbox not found")
+	}
+
+	cmd := vctypes.Cmd{
+		Args:   args,
+		Detach: false,
+	}
+
+	_, proc, err := svc.sandbox.EnterContainer(ctx, containerID, cmd)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to start guest copy helper: %w", err)
+	}
+
+	stdinStream, stdoutStream, _, err := svc.sandbox.IOStream(containerID, proc.Token)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get copy helper IO stream: %w", err)
+	}
+
+	wait := func() error {
+		exitCode, err := svc.sandbox.WaitProcess(ctx, containerID, proc.Token)
+		if err != nil {
+			return fmt.Errorf("waiting for guest copy helper: %w", err)
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("guest copy helper exited with code %d", exitCode)
+		}
+		return nil
+	}
+
+	return stdinStream, io.NopCloser(stdoutStream), wait, nil
+}
@@ -25,6 +25,10 @@ func (s *service) createContainer(ctx context.Context, r *taskAPI.CreateTaskRequ
 		return nil, fmt.Errorf("failed to parse config.json: %w", err)
 	}
 
+	if r.Checkpoint != "" {
+		return s.restoreContainer(ctx, r.ID, bundlePath, &ociSpec, r.Checkpoint)
+	}
+
 	containerType, err := oci.ContainerType(ociSpec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get container type: %w", err)
@@ -57,20 +61,42 @@ func (s *service) createContainer(ctx context.Context, r *taskAPI.CreateTaskRequ
 	}
 
 	c := &container{
-		id:      r.ID,
-		bundle:  bundlePath,
-		spec:    &ociSpec,
-		mounted: rootFs.Mounted,
-		cType:   containerType,
+		id:       r.ID,
+		bundle:   bundlePath,
+		spec:     &ociSpec,
+		mounted:  rootFs.Mounted,
+		cType:    containerType,
+		terminal: ociSpec.Process != nil && ociSpec.Process.Terminal,
+		stdin:    r.Stdin,
+		stdout:   r.Stdout,
+		stderr:   r.Stderr,
+		exitCh:   make(chan struct{}),
+		exitIOch: make(chan struct{}),
 	}
 
 	switch containerType {
 	case vc.PodSandbox, vc.SingleContainer:
-		if err := s.createSandbox(ctx, r.ID, bundlePath, &ociSpec, rootFs); err != nil {
-			if rootFs.Mounted {
-				mount.UnmountAll(rootfs, 0)
+		if s.warm && s.sandbox != nil {
+			if err := s.adoptWarmSandbox(ctx, r.ID, bundlePath, &ociSpec, rootFs); err != nil {
+				serviceLog.WithError(err).WithField("container", r.ID).Warn("failed to adopt warm sandbox, falling back to cold boot")
+				s.teardownWarmSandbox(ctx)
+			}
+		}
+
+		if !s.warm && s.sandbox == nil {
+			adopted, err := s.tryAdoptPooledSandbox(ctx, r.ID, bundlePath, &ociSpec, rootFs)
+			if err != nil {
+				serviceLog.WithError(err).WithField("container", r.ID).Warn("failed to adopt pooled sandbox, falling back to cold boot")
+			}
+
+			if !adopted {
+				if err := s.createSandbox(ctx, r.ID, bundlePath, &ociSpec, rootFs); err != nil {
+					if rootFs.Mounted {
+						mount.UnmountAll(rootfs, 0)
+					}
+					return nil, fmt.Errorf("failed to create sandbox: %w", err)
+				}
 			}
-			return nil, fmt.Errorf("failed to create sandbox: %w", err)
 		}
 
 	case vc.PodContainer:
@@ -98,19 +124,33 @@ func (s *service) createContainer(ctx context.Context, r *taskAPI.CreateTaskRequ
 	return c, nil
 }
 
-func (s *service) createSandbox(ctx context.Context, id, bundlePath string, ociSpec *specs.Spec, rootFs vc.RootFs) error {
-	if s.config == nil {
-		configPath := oci.GetSandboxConfigPath(ociSpec.Annotations)
-		if configPath == "" {
-			configPath = s.configPath
-		}
-		serviceLog.WithField("config", configPath).Info("loading kata configuration")
+// ensureConfig loads the kata configuration for ociSpec into s.config
+// if it isn't already set, so both a cold boot (createSandbox) and a
+// pool lookup (tryAdoptPooledSandbox) - which needs it to compute a
+// sandbox profile before it knows whether it'll end up booting
+// anything - read the same configuration.
+func (s *service) ensureConfig(ociSpec *specs.Spec) error {
+	if s.config != nil {
+		return nil
+	}
 
-		_, runtimeConfig, err := katautils.LoadConfiguration(configPath, false)
-		if err != nil {
-			return fmt.Errorf("failed to load kata configuration: %w", err)
-		}
-		s.config = &runtimeConfig
+	configPath := oci.GetSandboxConfigPath(ociSpec.Annotations)
+	if configPath == "" {
+		configPath = s.configPath
+	}
+	serviceLog.WithField("config", configPath).Info("loading kata configuration")
+
+	_, runtimeConfig, err := katautils.LoadConfiguration(configPath, false)
+	if err != nil {
+		return fmt.Errorf("failed to load kata configuration: %w", err)
+	}
+	s.config = &runtimeConfig
+	return nil
+}
+
+func (s *service) createSandbox(ctx context.Context, id, bundlePath string, ociSpec *specs.Spec, rootFs vc.RootFs) error {
+	if err := s.ensureConfig(ociSpec); err != nil {
+		return err
 	}
 
 	s.config.SandboxCPUs, s.config.SandboxMemMB = oci.CalculateSandboxSizing(ociSpec)
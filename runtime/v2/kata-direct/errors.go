@@ -0,0 +1,73 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package katadirect
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// wrapKataErr classifies an error returned by the vc.VC/vc.VCSandbox
+// layer into the right errdefs sentinel before a service method hands
+// it to errdefs.ToGRPC, so containerd sees NotFound/FailedPrecondition/
+// AlreadyExists instead of an opaque Unknown for every sandbox error
+// and can make the right retry/cleanup decision.
+//
+// TODO: this tree doesn't vendor virtcontainers, so the real sandbox
+// error types (the equivalent of a typed vc.ErrAlreadyStopped or
+// similar) aren't available to type-assert against here the way
+// resizeSandbox's TODO already notes for the methods this package
+// assumes VCSandbox exposes. Classification instead matches substrings
+// virtcontainers' own error messages are known to use; replace with a
+// proper errors.As once the real error types are available.
+func wrapKataErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if isErrdefsErr(err) {
+		// Already classified - e.g. a containerID lookup failure this
+		// package raised itself via errdefs.ErrNotFound.
+		return err
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "not found") || strings.Contains(msg, "does not exist"):
+		return fmt.Errorf("%w: %s", errdefs.ErrNotFound, err)
+	case strings.Contains(msg, "already exists"):
+		return fmt.Errorf("%w: %s", errdefs.ErrAlreadyExists, err)
+	case strings.Contains(msg, "already stopped"),
+		strings.Contains(msg, "already paused"),
+		strings.Contains(msg, "already running"),
+		strings.Contains(msg, "invalid state"),
+		strings.Contains(msg, "not running"),
+		strings.Contains(msg, "not paused"):
+		return fmt.Errorf("%w: %s", errdefs.ErrFailedPrecondition, err)
+	default:
+		return fmt.Errorf("%w: %s", errdefs.ErrUnknown, err)
+	}
+}
+
+// isErrdefsErr reports whether err is already one of the errdefs
+// sentinels (or wraps one), so wrapKataErr doesn't double-classify an
+// error this package already raised deliberately.
+func isErrdefsErr(err error) bool {
+	for _, sentinel := range []error{
+		errdefs.ErrNotFound,
+		errdefs.ErrAlreadyExists,
+		errdefs.ErrFailedPrecondition,
+		errdefs.ErrInvalidArgument,
+		errdefs.ErrNotImplemented,
+		errdefs.ErrUnavailable,
+		errdefs.ErrUnknown,
+	} {
+		if errors.Is(err, sentinel) {
+			return true
+		}
+	}
+	return false
+}
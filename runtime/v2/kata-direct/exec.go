@@ -12,17 +12,29 @@ import (
 	"syscall"
 	"time"
 
+	eventstypes "github.com/containerd/containerd/api/events"
 	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/fifo"
 	"github.com/containerd/typeurl/v2"
 	vctypes "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/types"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// defaultOperationTimeout bounds how long waitExecProcess waits for an
+// exited exec's IO streams to drain, a step that should be quick but
+// must not hang a goroutine forever if kata-agent wedges.
+const defaultOperationTimeout = 30 * time.Second
+
+// winsize is a PTY size in terminal cells.
+type winsize struct {
+	cols uint32
+	rows uint32
+}
+
 // exec represents an exec process inside a container
 type exec struct {
 	id          string // User-provided exec ID (e.g., "myexec")
-	token       string // Kata-agent assigned process token (returned from EnterContainer)
 	containerID string
 	spec        *specs.Process
 	status      task.Status
@@ -30,6 +42,18 @@ type exec struct {
 	exitTime    time.Time
 	terminal    bool
 
+	// stateMu guards token and the winsize fields below, which startExec
+	// and resizeExecPty/signalExec can touch from different goroutines.
+	stateMu sync.Mutex
+	// token is the kata-agent assigned process token (returned from
+	// EnterContainer). Empty until startExec completes.
+	token string
+	// winsize is the last size resizeExecPty applied, or queued to apply
+	// once token is known. It's replayed by startExec so a resize that
+	// races process creation isn't lost.
+	winsize           winsize
+	havePendingResize bool
+
 	// IO paths
 	stdin  string
 	stdout string
@@ -47,10 +71,14 @@ type exec struct {
 	exitCh   chan struct{}
 	exitOnce sync.Once
 	exitIOch chan struct{} // Channel to signal IO streams closed
+
+	// recorder, if set via WithSessionRecorder, tees this exec's IO
+	// into an asciicast v2 audit recording.
+	recorder *sessionRecorder
 }
 
 // newExec creates a new exec process
-func newExec(containerID, execID, stdin, stdout, stderr string, terminal bool, specAny typeurl.Any) (*exec, error) {
+func newExec(containerID, execID, stdin, stdout, stderr string, terminal bool, specAny typeurl.Any, opts ...ExecOpt) (*exec, error) {
 	if specAny == nil {
 		return nil, fmt.Errorf("exec spec is required")
 	}
@@ -78,7 +106,7 @@ func newExec(containerID, execID, stdin, stdout, stderr string, terminal bool, s
 		}
 	}
 
-	return &exec{
+	e := &exec{
 		id:          execID,
 		containerID: containerID,
 		spec:        processSpec,
@@ -89,7 +117,31 @@ func newExec(containerID, execID, stdin, stdout, stderr string, terminal bool, s
 		stderr:      stderr,
 		exitCh:      make(chan struct{}),
 		exitIOch:    make(chan struct{}),
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.recorder != nil {
+		e.recorder.setEnv(envSliceToMap(processSpec.Env))
+	}
+
+	return e, nil
+}
+
+// envSliceToMap converts a process spec's "KEY=VALUE" environment
+// slice into a map, the form an asciicast header records it in.
+func envSliceToMap(envs []string) map[string]string {
+	m := make(map[string]string, len(envs))
+	for _, env := range envs {
+		for i := 0; i < len(env); i++ {
+			if env[i] == '=' {
+				m[env[:i]] = env[i+1:]
+				break
+			}
+		}
+	}
+	return m
 }
 
 // closeExitCh safely closes the exit channel exactly once
@@ -151,9 +203,22 @@ func (s *service) startExec(ctx context.Context, c *container, execID string) er
 	// CRITICAL: Save the token returned by kata-agent
 	// This token is the REAL process ID used by kata-agent internally
 	// All subsequent calls (IOStream, WaitProcess, etc.) must use this token
+	e.stateMu.Lock()
 	e.token = proc.Token
+	pendingResize := e.havePendingResize
+	size := e.winsize
+	e.havePendingResize = false
+	e.stateMu.Unlock()
 	s.log.WithField("exec", execID).WithField("token", e.token).Info("exec process created with token")
 
+	// Replay a resize that arrived before the token was known - kata-agent
+	// has nothing to resize until EnterContainer returns one.
+	if pendingResize {
+		if err := sandbox.WinsizeProcess(ctx, c.id, e.token, size.rows, size.cols); err != nil {
+			s.log.WithError(err).WithField("exec", execID).Warn("failed to replay queued exec resize")
+		}
+	}
+
 	e.status = task.Status_RUNNING
 
 	// Setup IO for exec process - use the token, not the user-provided execID
@@ -169,6 +234,67 @@ func (s *service) startExec(ctx context.Context, c *container, execID string) er
 	return nil
 }
 
+// resizeExecPty resizes execID's PTY to cols x rows. The size is always
+// recorded on e so it's the one replayed the next time it's needed; if
+// startExec hasn't yet recorded a token for execID, the resize is queued
+// instead of applied - there's no kata-agent process to resize until
+// startExec's EnterContainer call returns one.
+func (s *service) resizeExecPty(ctx context.Context, c *container, execID string, cols, rows uint32) error {
+	c.execsMu.RLock()
+	e, ok := c.execs[execID]
+	c.execsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("exec %s not found in container %s", execID, c.id)
+	}
+
+	if e.recorder != nil {
+		e.recorder.setSize(cols, rows)
+	}
+
+	e.stateMu.Lock()
+	e.winsize = winsize{cols: cols, rows: rows}
+	token := e.token
+	if token == "" {
+		e.havePendingResize = true
+		e.stateMu.Unlock()
+		return nil
+	}
+	e.stateMu.Unlock()
+
+	if s.sandbox == nil {
+		return fmt.Errorf("sandbox not found")
+	}
+	if err := s.sandbox.WinsizeProcess(ctx, c.id, token, rows, cols); err != nil {
+		return fmt.Errorf("failed to resize exec %s: %w", execID, err)
+	}
+	return nil
+}
+
+// signalExec sends sig to execID's process via its kata-agent token.
+func (s *service) signalExec(ctx context.Context, c *container, execID string, sig syscall.Signal) error {
+	c.execsMu.RLock()
+	e, ok := c.execs[execID]
+	c.execsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("exec %s not found in container %s", execID, c.id)
+	}
+
+	e.stateMu.Lock()
+	token := e.token
+	e.stateMu.Unlock()
+	if token == "" {
+		return fmt.Errorf("exec %s has not started yet", execID)
+	}
+
+	if s.sandbox == nil {
+		return fmt.Errorf("sandbox not found")
+	}
+	if err := s.sandbox.SignalProcess(ctx, c.id, token, sig, false); err != nil {
+		return fmt.Errorf("failed to signal exec %s: %w", execID, err)
+	}
+	return nil
+}
+
 // waitExecProcess waits for exec process to exit
 func (s *service) waitExecProcess(c *container, e *exec) {
 	defer func() {
@@ -186,6 +312,13 @@ func (s *service) waitExecProcess(c *container, e *exec) {
 		e.exitCode = 255
 		e.exitTime = time.Now()
 		e.closeExitCh()
+		s.events <- &eventstypes.TaskExit{
+			ContainerID: c.id,
+			ID:          e.id,
+			Pid:         s.hpid,
+			ExitStatus:  255,
+			ExitedAt:    timestamppb.New(e.exitTime),
+		}
 		return
 	}
 
@@ -216,6 +349,14 @@ func (s *service) waitExecProcess(c *container, e *exec) {
 	// Close exit channel to broadcast to waiters
 	e.closeExitCh()
 
+	s.events <- &eventstypes.TaskExit{
+		ContainerID: c.id,
+		ID:          e.id,
+		Pid:         s.hpid,
+		ExitStatus:  uint32(exitCode),
+		ExitedAt:    timestamppb.New(e.exitTime),
+	}
+
 	s.log.WithField("exec", e.id).WithField("exitCode", exitCode).Info("exec process wait completed")
 }
 
@@ -302,7 +443,13 @@ func (s *service) handleExecIO(ctx context.Context, c *container, e *exec) error
 
 func (s *service) copyExecStdin(e *exec, dst io.WriteCloser, src io.ReadCloser) {
 	defer e.ioWg.Done()
-	if _, err := io.Copy(dst, src); err != nil && err != context.Canceled {
+
+	var w io.Writer = dst
+	if e.recorder != nil && e.terminal {
+		w = &recordingWriter{dst: dst, rec: e.recorder, stream: "i"}
+	}
+
+	if _, err := io.Copy(w, src); err != nil && err != context.Canceled {
 		s.log.WithError(err).WithField("exec", e.id).Debug("exec stdin copy ended")
 	}
 	s.log.WithField("exec", e.id).Debug("exec stdin copy goroutine exited")
@@ -320,7 +467,12 @@ func (s *service) copyExecStdout(ctx context.Context, e *exec, src io.Reader, st
 	defer f.Close()
 
 	s.log.WithField("exec", e.id).WithField("path", e.stdout).Info("exec stdout fifo opened, starting copy")
-	n, err := io.Copy(f, src)
+
+	var w io.Writer = f
+	if e.recorder != nil {
+		w = &recordingWriter{dst: f, rec: e.recorder, stream: "o"}
+	}
+	n, err := io.Copy(w, src)
 	s.log.WithField("exec", e.id).WithField("bytes", n).Info("exec stdout copy completed")
 	if err != nil && err != context.Canceled {
 		s.log.WithError(err).WithField("exec", e.id).Debug("exec stdout copy ended with error")
@@ -344,7 +496,12 @@ func (s *service) copyExecStderr(ctx context.Context, e *exec, src io.Reader) {
 	defer f.Close()
 
 	s.log.WithField("exec", e.id).WithField("path", e.stderr).Debug("exec stderr fifo opened")
-	if _, err := io.Copy(f, src); err != nil && err != context.Canceled {
+
+	var w io.Writer = f
+	if e.recorder != nil {
+		w = &recordingWriter{dst: f, rec: e.recorder, stream: "e"}
+	}
+	if _, err := io.Copy(w, src); err != nil && err != context.Canceled {
 		s.log.WithError(err).WithField("exec", e.id).Debug("exec stderr copy ended")
 	}
 }
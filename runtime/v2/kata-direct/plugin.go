@@ -6,15 +6,62 @@ package katadirect
 import (
 	"context"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/containerd/containerd/events/exchange"
+	"github.com/containerd/containerd/namespaces"
 	runtimeoptions "github.com/containerd/containerd/pkg/runtimeoptions/v1"
 	"github.com/containerd/containerd/plugin"
 	cdruntime "github.com/containerd/containerd/runtime"
+	katametrics "github.com/containerd/containerd/runtime/v2/kata-direct/metrics"
 	"github.com/containerd/containerd/runtime/v2/shim"
+	"github.com/containerd/log"
 	"github.com/containerd/typeurl/v2"
 )
 
+const (
+	// RuntimeName is the runtime string a container's CreateOpts.Runtime
+	// must carry for CreateService to treat it as kata-direct, matching
+	// how a shimmed-out runtime like "io.containerd.kata.v2" selects a
+	// shim binary - here selecting this in-process task service instead
+	// of spawning one. A container is dispatched to this plugin at all
+	// only once the task manager (plugin.RuntimePluginV2) that owns this
+	// registration resolves that runtime string to it; this snapshot's
+	// runtime/v2 package doesn't include that resolver (ShimManager,
+	// referenced by warm_pool.go/shim_pool.go, is never defined in this
+	// tree either), so this check is CreateService's own last line of
+	// defense against being handed a container meant for a different
+	// runtime rather than the actual dispatch itself.
+	RuntimeName = "io.containerd.kata-direct.v2"
+
+	// metricsHTTPBindEnv, when set, enables the embedded metrics HTTP
+	// server for all kata-direct services created by this plugin
+	// instance, e.g. KATA_DIRECT_METRICS_HTTP_BIND=127.0.0.1:9090.
+	metricsHTTPBindEnv = "KATA_DIRECT_METRICS_HTTP_BIND"
+
+	// prewarmEnv, when set to "1", makes this plugin instance keep one
+	// prewarmed sandbox ready so the next CreateService can adopt it
+	// instead of booting cold. This is a single warm slot, not a pool -
+	// see runtime/v2.WarmShimPool for the equivalent concept at the
+	// shim level. The multi-entry sandbox pool of sandbox_pool.go is
+	// only consulted through serviceOptions.sandboxPool, which this
+	// plugin does not set.
+	prewarmEnv = "KATA_DIRECT_PREWARM"
+	// prewarmTimeoutEnv overrides how long a prewarm attempt waits for
+	// the VMM and kata-agent to come up, e.g. "45s". Defaults to
+	// defaultPrewarmTimeout.
+	prewarmTimeoutEnv = "KATA_DIRECT_PREWARM_TIMEOUT"
+	// prewarmConfigPathEnv names the kata configuration used to boot a
+	// prewarmed sandbox, which has no OCI spec of its own to read a
+	// config path from.
+	prewarmConfigPathEnv = "KATA_DIRECT_PREWARM_CONFIG_PATH"
+	// prewarmID is the placeholder sandbox ID a warm, not-yet-adopted
+	// service is created under.
+	prewarmID = "prewarm"
+)
+
 func init() {
 	plugin.Register(&plugin.Registration{
 		Type: plugin.RuntimePluginV2,
@@ -36,11 +83,28 @@ func init() {
 			// Wrap exchange to implement shim.Publisher
 			publisher := &publisherWrapper{Exchange: ex}
 
-			// Return a factory that will create services on demand
-			return &taskServiceFactory{
+			metricsModule, err := katametrics.New(katametrics.Config{
+				BindAddress: os.Getenv(metricsHTTPBindEnv),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create metrics module: %w", err)
+			}
+			if err := metricsModule.Start(ic.Context); err != nil {
+				return nil, fmt.Errorf("failed to start metrics server: %w", err)
+			}
+
+			factory := &taskServiceFactory{
 				publisher: publisher,
 				services:  make(map[string]shim.Shim),
-			}, nil
+				metrics:   metricsModule,
+			}
+
+			if os.Getenv(prewarmEnv) == "1" {
+				go factory.prewarm(ic.Context)
+			}
+
+			// Return a factory that will create services on demand
+			return factory, nil
 		},
 	})
 }
@@ -62,6 +126,11 @@ type taskServiceFactory struct {
 	mu        sync.Mutex
 	publisher shim.Publisher
 	services  map[string]shim.Shim // Track created services for cleanup
+	metrics   *katametrics.Module
+
+	// prewarmed holds a single warm, unbound service - produced by
+	// prewarm - ready for CreateService to adopt.
+	prewarmed *service
 }
 
 func (f *taskServiceFactory) ID() string {
@@ -110,16 +179,53 @@ func (f *taskServiceFactory) Close() error {
 		}
 	}
 	f.services = make(map[string]shim.Shim)
+
+	if f.prewarmed != nil {
+		if _, err := f.prewarmed.Cleanup(ctx); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to clean up unadopted prewarmed sandbox")
+		}
+		f.prewarmed = nil
+	}
+
+	if f.metrics != nil {
+		if err := f.metrics.Stop(ctx); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to stop metrics server")
+		}
+	}
+
 	return lastErr
 }
 
-// CreateService creates a new kata-direct service instance
+// CreateService creates a new kata-direct service instance, adopting a
+// prewarmed sandbox from f.prewarmed if one is ready instead of booting
+// cold. opts.Runtime must be RuntimeName (or empty, for a caller that
+// never set it) - a container created for any other runtime string
+// reached this factory by mistake and is rejected rather than silently
+// served by the wrong runtime.
 func (f *taskServiceFactory) CreateService(ctx context.Context, id string, opts cdruntime.CreateOpts) (shim.Shim, error) {
+	if opts.Runtime != "" && opts.Runtime != RuntimeName {
+		return nil, fmt.Errorf("kata-direct task service factory cannot serve runtime %q, only %q", opts.Runtime, RuntimeName)
+	}
+
 	serviceOpts, err := buildServiceOptions(opts)
 	if err != nil {
 		return nil, err
 	}
 
+	if svc := f.takePrewarmed(); svc != nil {
+		ns, _ := namespaces.Namespace(ctx)
+		if _, err := svc.AdoptContainer(ctx, &shim.AdoptRequest{ID: id, Namespace: ns}); err == nil {
+			f.mu.Lock()
+			f.services[id] = svc
+			f.mu.Unlock()
+
+			go f.prewarm(context.Background())
+
+			return svc, nil
+		}
+		log.G(ctx).WithField("id", id).Warn("failed to adopt prewarmed kata-direct sandbox, falling back to cold start")
+	}
+
 	svc, err := New(ctx, id, f.publisher, func() {
 		// Cleanup callback when service exits
 		f.mu.Lock()
@@ -138,6 +244,53 @@ func (f *taskServiceFactory) CreateService(ctx context.Context, id string, opts
 	return svc, nil
 }
 
+// takePrewarmed returns and clears f's warm service, or nil if none is
+// ready yet.
+func (f *taskServiceFactory) takePrewarmed() *service {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	svc := f.prewarmed
+	f.prewarmed = nil
+	return svc
+}
+
+// prewarm boots a single warm sandbox in the background and stores it on
+// f for the next CreateService to adopt. Failures are logged, not
+// returned, since prewarming is a best-effort optimization: a plugin
+// that never manages to prewarm still serves containers the normal way.
+func (f *taskServiceFactory) prewarm(ctx context.Context) {
+	opts := &serviceOptions{
+		prewarmTimeout:    prewarmTimeoutFromEnv(),
+		prewarmConfigPath: os.Getenv(prewarmConfigPathEnv),
+	}
+
+	svc, err := NewPrewarmed(ctx, prewarmID, f.publisher, func() {
+		f.mu.Lock()
+		if f.prewarmed != nil && f.prewarmed.id == prewarmID {
+			f.prewarmed = nil
+		}
+		f.mu.Unlock()
+	}, opts)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to prewarm kata-direct sandbox")
+		return
+	}
+
+	f.mu.Lock()
+	f.prewarmed = svc
+	f.mu.Unlock()
+}
+
+func prewarmTimeoutFromEnv() time.Duration {
+	if v := os.Getenv(prewarmTimeoutEnv); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return defaultPrewarmTimeout
+}
+
 func buildServiceOptions(opts cdruntime.CreateOpts) (*serviceOptions, error) {
 	serviceOpts := &serviceOptions{}
 
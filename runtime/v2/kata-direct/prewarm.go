@@ -0,0 +1,104 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package katadirect
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/runtime/v2/shim"
+	"github.com/kata-containers/kata-containers/src/runtime/pkg/katautils"
+	vc "github.com/kata-containers/kata-containers/src/runtime/virtcontainers"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// defaultPrewarmTimeout bounds NewPrewarmed when opts doesn't set one.
+const defaultPrewarmTimeout = 30 * time.Second
+
+// NewPrewarmed creates a kata-direct service the same way New does, but
+// also boots its sandbox immediately from opts.prewarmConfigPath alone -
+// no OCI spec, no rootfs - so the VMM and kata-agent are already running
+// by the time a real container arrives to adopt it via AdoptContainer.
+//
+// The returned service is warm: id is a placeholder until AdoptContainer
+// rebinds it to a real container's namespace/ID, and it owns no
+// containers yet.
+func NewPrewarmed(ctx context.Context, id string, publisher shim.Publisher, shutdown func(), opts *serviceOptions) (*service, error) {
+	if _, found := namespaces.Namespace(ctx); !found {
+		ctx = namespaces.WithNamespace(ctx, "prewarm")
+	}
+
+	shimIface, err := New(ctx, id, publisher, shutdown, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := shimIface.(*service)
+
+	timeout := s.prewarmTimeout
+	if timeout <= 0 {
+		timeout = defaultPrewarmTimeout
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := s.prewarmSandbox(pctx); err != nil {
+		s.cancel()
+		return nil, fmt.Errorf("failed to prewarm sandbox: %w", err)
+	}
+
+	return s, nil
+}
+
+// prewarmSandbox boots s's VMM and kata-agent ahead of any container
+// request. Unlike createSandbox, there's no OCI spec yet to read sizing
+// or a per-sandbox config path override from, so it loads the kata
+// configuration from prewarmConfigPath (falling back to configPath) and
+// hands katautils.CreateSandbox a minimal spec and rootfs.
+func (s *service) prewarmSandbox(ctx context.Context) error {
+	configPath := s.prewarmConfigPath
+	if configPath == "" {
+		configPath = s.configPath
+	}
+
+	serviceLog.WithField("config", configPath).Info("prewarming kata sandbox")
+
+	_, runtimeConfig, err := katautils.LoadConfiguration(configPath, false)
+	if err != nil {
+		return fmt.Errorf("failed to load kata configuration: %w", err)
+	}
+	s.config = &runtimeConfig
+
+	sandbox, _, err := katautils.CreateSandbox(
+		ctx,
+		s.vci,
+		specs.Spec{},
+		*s.config,
+		vc.RootFs{},
+		s.id,
+		"",
+		false,
+		false,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create prewarmed sandbox: %w", err)
+	}
+
+	s.sandbox = sandbox
+	s.warm = true
+
+	pid, err := sandbox.GetHypervisorPid()
+	if err != nil {
+		serviceLog.WithError(err).Warn("failed to get hypervisor pid for prewarmed sandbox")
+		s.hpid = uint32(os.Getpid())
+	} else {
+		s.hpid = uint32(pid)
+		serviceLog.WithField("hypervisor_pid", pid).Info("prewarmed hypervisor started")
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package katadirect
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	v2 "github.com/containerd/containerd/runtime/v2"
+	"github.com/kata-containers/kata-containers/src/runtime/pkg/katautils"
+	"github.com/kata-containers/kata-containers/src/runtime/pkg/oci"
+	vc "github.com/kata-containers/kata-containers/src/runtime/virtcontainers"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// runtimeName is the pool key this package registers and looks up
+// prewarmed sandboxes under, mirroring WarmShimPool's per-runtime
+// pools (see shimPoolKey in runtime/v2/shim_pool.go).
+const runtimeName = "kata-direct"
+
+// pooledSandbox adapts a booted vc.VCSandbox to v2.Sandbox, so it can
+// sit in a runtime/v2.ShimPool alongside prewarmed shims of any other
+// runtime. unwrap recovers the concrete sandbox createContainer needs
+// once the pool hands this entry out - v2.Sandbox only exposes the
+// join/teardown operations the generic pool itself cares about.
+type pooledSandbox struct {
+	sandbox vc.VCSandbox
+}
+
+func (p *pooledSandbox) CreateContainer(ctx context.Context, containerID string, ociSpec, rootFs interface{}) error {
+	spec, ok := ociSpec.(*specs.Spec)
+	if !ok {
+		return fmt.Errorf("pooledSandbox: unexpected ociSpec type %T", ociSpec)
+	}
+	rfs, ok := rootFs.(vc.RootFs)
+	if !ok {
+		return fmt.Errorf("pooledSandbox: unexpected rootFs type %T", rootFs)
+	}
+
+	_, err := katautils.CreateContainer(ctx, p.sandbox, *spec, rfs, containerID, "", false, false)
+	return err
+}
+
+func (p *pooledSandbox) Stop(ctx context.Context) error {
+	if err := p.sandbox.Stop(ctx, true); err != nil {
+		return err
+	}
+	return p.sandbox.Delete(ctx)
+}
+
+func (p *pooledSandbox) unwrap() vc.VCSandbox {
+	return p.sandbox
+}
+
+// sandboxProfile builds the v2.SandboxProfile a sandbox booted from
+// cfg/ociSpec would match in the pool.
+//
+// TODO: Kernel/Initrd/Hypervisor are left blank - this tree doesn't
+// vendor virtcontainers' oci.RuntimeConfig, so the real field names
+// for its HypervisorConfig (kernel path, initrd path, hypervisor
+// binary/flags) aren't available to fill in here. CPUs/MemoryMB use
+// the same sizing createSandbox already calculates, which is enough to
+// keep profiles for differently-sized requests from matching each
+// other even before the rest is wired up.
+func sandboxProfile(cfg *oci.RuntimeConfig, cpus, memMB uint32) v2.SandboxProfile {
+	return v2.SandboxProfile{
+		CPUs:     cpus,
+		MemoryMB: memMB,
+	}
+}
+
+// tryAdoptPooledSandbox computes the SandboxProfile a cold boot for
+// ociSpec would have, and, if s has a sandboxPool configured, tries to
+// adopt a matching idle prewarmed sandbox from it instead. It returns
+// false, with no error, when there's no pool or no matching entry -
+// the normal cases createContainer falls back to createSandbox for.
+func (s *service) tryAdoptPooledSandbox(ctx context.Context, id, bundlePath string, ociSpec *specs.Spec, rootFs vc.RootFs) (bool, error) {
+	if s.sandboxPool == nil {
+		return false, nil
+	}
+
+	if err := s.ensureConfig(ociSpec); err != nil {
+		return false, err
+	}
+	cpus, memMB := oci.CalculateSandboxSizing(ociSpec)
+	profile := sandboxProfile(s.config, cpus, memMB)
+
+	return s.adoptPooledSandbox(ctx, s.sandboxPool, id, bundlePath, ociSpec, rootFs, profile)
+}
+
+// adoptPooledSandbox looks up an idle prewarmed sandbox matching
+// profile in pool and, if found, joins id to it the way
+// adoptWarmSandbox joins a container to s's own already-warm sandbox -
+// except this sandbox was booted by (and may belong to) a different
+// service instance entirely, which is the point of pooling across
+// them. It returns false, with s untouched, on a pool miss so the
+// caller falls back to createSandbox's cold boot path.
+func (s *service) adoptPooledSandbox(ctx context.Context, pool *v2.ShimPool, id, bundlePath string, ociSpec *specs.Spec, rootFs vc.RootFs, profile v2.SandboxProfile) (bool, error) {
+	item := pool.GetIdleSandbox(ctx, s.namespace, runtimeName, profile)
+	if item == nil {
+		return false, nil
+	}
+
+	ps, ok := item.Sandbox.(*pooledSandbox)
+	if !ok {
+		return false, fmt.Errorf("sandbox pool: unexpected Sandbox type %T for profile %s", item.Sandbox, profile.Key())
+	}
+
+	if err := item.Sandbox.CreateContainer(ctx, id, ociSpec, rootFs); err != nil {
+		return false, fmt.Errorf("failed to join pooled sandbox: %w", err)
+	}
+
+	s.sandbox = ps.unwrap()
+
+	pid, err := s.sandbox.GetHypervisorPid()
+	if err != nil {
+		serviceLog.WithError(err).Warn("failed to get hypervisor pid for pooled sandbox")
+	} else {
+		s.hpid = uint32(pid)
+	}
+
+	serviceLog.WithField("container", id).WithField("profile", profile.Key()).
+		Info("adopted prewarmed sandbox from pool")
+
+	return true, nil
+}
+
+// warmupSandboxCounter gives each sandbox WarmUpPool boots its own id,
+// since - unlike a real container - nothing names one ahead of time.
+var warmupSandboxCounter uint64
+
+// WarmUpPool boots sandboxes from runtimeConfig and registers them into
+// pool under profile until SandboxPoolConfig.MinSize idle entries exist
+// for ns, ready for a later createContainer to adopt via
+// tryAdoptPooledSandbox. This is the warm-up hook the request asks to
+// run "on containerd startup"; this tree has no main/plugin-init
+// wiring to call it from automatically (the same gap WarmUpSandboxes
+// itself notes), so whatever assembles a real containerd process needs
+// to call this explicitly, once per profile it wants kept warm.
+func WarmUpPool(ctx context.Context, pool *v2.ShimPool, ns string, runtimeConfig oci.RuntimeConfig, profile v2.SandboxProfile) error {
+	boot := func(ctx context.Context) (v2.Sandbox, error) {
+		id := fmt.Sprintf("kata-direct-warm-%d", atomic.AddUint64(&warmupSandboxCounter, 1))
+
+		vci := &vc.VCImpl{}
+		vci.SetLogger(ctx, serviceLog)
+
+		sandbox, _, err := katautils.CreateSandbox(ctx, vci, specs.Spec{}, runtimeConfig, vc.RootFs{}, id, "", false, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to boot warm-up sandbox: %w", err)
+		}
+		return &pooledSandbox{sandbox: sandbox}, nil
+	}
+
+	return pool.WarmUpSandboxes(ctx, ns, runtimeName, profile, boot)
+}
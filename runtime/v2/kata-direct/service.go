@@ -6,8 +6,10 @@ package katadirect
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"sync"
+	"syscall"
 	"time"
 
 	eventstypes "github.com/containerd/containerd/api/events"
@@ -15,8 +17,11 @@ import (
 	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/protobuf"
 	cdruntime "github.com/containerd/containerd/runtime"
+	v2 "github.com/containerd/containerd/runtime/v2"
 	"github.com/containerd/containerd/runtime/v2/shim"
+	typeurl "github.com/containerd/typeurl/v2"
 	"github.com/sirupsen/logrus"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -51,6 +56,45 @@ type service struct {
 	cancel     context.CancelFunc
 	publisher  shim.Publisher
 	exitCh     chan struct{}
+	// log is serviceLog.WithFields'd with this sandbox's id in New, so
+	// a method can log via s.log without repeating those fields at
+	// every call site the way serviceLog itself requires.
+	log *logrus.Entry
+
+	// configPath is the kata configuration file used for a cold-booted
+	// sandbox when the OCI spec's annotations don't name one of their
+	// own (oci.GetSandboxConfigPath).
+	configPath string
+
+	// warm is true for a service created by NewPrewarmed whose sandbox
+	// has been booted but not yet adopted by a real container. It's
+	// cleared once AdoptContainer/createContainer binds the sandbox to
+	// one, via adoptWarmSandbox.
+	warm bool
+	// prewarmTimeout bounds how long NewPrewarmed waits for the VMM and
+	// kata-agent to come up before giving up on the warm sandbox.
+	prewarmTimeout time.Duration
+	// prewarmConfigPath is the kata configuration used to boot a
+	// prewarmed sandbox, which has no OCI spec to read a config path
+	// from. Falls back to configPath if unset.
+	prewarmConfigPath string
+
+	// sandboxPool, if set, is consulted by createContainer for an idle
+	// prewarmed sandbox matching the new container's profile before
+	// falling back to booting one cold. It's shared across service
+	// instances the same way a runtime/v2.WarmShimPool is shared
+	// across shims of one runtime.
+	sandboxPool *v2.ShimPool
+}
+
+// serviceOptions configures a service at creation. It's built from
+// cdruntime.CreateOpts by buildServiceOptions for a normal container, or
+// from environment configuration by taskServiceFactory.prewarm.
+type serviceOptions struct {
+	configPath        string
+	prewarmTimeout    time.Duration
+	prewarmConfigPath string
+	sandboxPool       *v2.ShimPool
 }
 
 type container struct {
@@ -62,9 +106,62 @@ type container struct {
 	exitTime time.Time
 	mounted  bool
 	cType    vc.ContainerType
+
+	execsMu sync.RWMutex
+	execs   map[string]*exec
+
+	// restored is true for a container created via restoreContainer
+	// instead of createSandbox/createPodContainer. It makes
+	// startContainer skip sandbox.Start - restoreContainer already
+	// loaded the sandbox's VM state and resumed its guest process - and
+	// go straight to handleIO/waitContainerProcess for the process the
+	// checkpoint captured.
+	restored bool
+
+	// terminal is true when spec.Process.Terminal requested a TTY,
+	// set by createContainer. It picks handleIO's console branch over
+	// the three-FIFO one, and makes CloseIO a no-op instead of closing
+	// stdin (parity with runc/kata-shim-v2: a TTY container has no
+	// separate stdin to close).
+	terminal bool
+	// console is the host PTY allocated for a TTY container; nil for a
+	// non-TTY one. See console.go.
+	console *ptyConsole
+
+	// IO paths
+	stdin  string
+	stdout string
+	stderr string
+
+	// IO management - mirrors the exec struct's own ioMu/ioWg/etc.
+	// (see exec.go), since handleIO and handleExecIO follow the same
+	// attach-once, wait-for-drain shape for the init process and an
+	// exec process respectively.
+	ioMu        sync.Mutex
+	ioWg        sync.WaitGroup
+	ioAttached  bool
+	stdinCloser io.Closer
+	stdinFifo   io.Closer
+	ioCancel    context.CancelFunc
+
+	// exitCh is closed exactly once by waitContainerProcess when the
+	// init process exits, broadcasting to every Wait call blocked on
+	// it - c.exit/c.exitTime (set just before the close) carry the
+	// actual status, mirroring exec's own exitCh/exitCode split.
+	exitCh   chan struct{}
+	exitOnce sync.Once
+	exitIOch chan struct{}
 }
 
-func New(ctx context.Context, id string, publisher shim.Publisher, shutdown func()) (shim.Shim, error) {
+// closeExitCh closes c.exitCh exactly once, safe to call from both the
+// normal exit path and an error path that raced it.
+func (c *container) closeExitCh() {
+	c.exitOnce.Do(func() {
+		close(c.exitCh)
+	})
+}
+
+func New(ctx context.Context, id string, publisher shim.Publisher, shutdown func(), opts *serviceOptions) (shim.Shim, error) {
 	serviceLog = serviceLog.WithFields(logrus.Fields{
 		"sandbox": id,
 		"pid":     os.Getpid(),
@@ -91,6 +188,14 @@ func New(ctx context.Context, id string, publisher shim.Publisher, shutdown func
 		events:     make(chan interface{}, 128),
 		publisher:  publisher,
 		exitCh:     make(chan struct{}),
+		log:        serviceLog,
+	}
+
+	if opts != nil {
+		s.configPath = opts.configPath
+		s.prewarmTimeout = opts.prewarmTimeout
+		s.prewarmConfigPath = opts.prewarmConfigPath
+		s.sandboxPool = opts.sandboxPool
 	}
 
 	go s.forwardEvents()
@@ -125,6 +230,15 @@ func (s *service) forwardEvents() {
 	}
 }
 
+// getSandbox returns s.sandbox under s.mu, for the exec/wait/stats
+// goroutines that need a consistent snapshot of it without holding the
+// lock for their whole (potentially blocking) call into the sandbox.
+func (s *service) getSandbox() vc.VCSandbox {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sandbox
+}
+
 func getTopic(e interface{}) string {
 	switch e.(type) {
 	case *eventstypes.TaskCreate:
@@ -145,6 +259,8 @@ func getTopic(e interface{}) string {
 		return cdruntime.TaskPausedEventTopic
 	case *eventstypes.TaskResumed:
 		return cdruntime.TaskResumedEventTopic
+	case *eventstypes.TaskCheckpointed:
+		return cdruntime.TaskCheckpointedEventTopic
 	default:
 		serviceLog.WithField("event-type", e).Warn("no topic for event type")
 	}
@@ -198,7 +314,7 @@ func (s *service) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (*ta
 		defer func() {
 			if r := recover(); r != nil {
 				serviceLog.WithField("panic", r).Error("Create panic recovered")
-				resultCh <- Result{nil, fmt.Errorf("create panic: %v", r)}
+				resultCh <- Result{nil, errdefs.ToGRPCf(errdefs.ErrUnknown, "create panic: %v", r)}
 			}
 		}()
 
@@ -208,10 +324,10 @@ func (s *service) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (*ta
 
 	select {
 	case <-ctx.Done():
-		return nil, fmt.Errorf("create container timeout: %v", r.ID)
+		return nil, errdefs.ToGRPC(ctx.Err())
 	case res := <-resultCh:
 		if res.err != nil {
-			return nil, res.err
+			return nil, errdefs.ToGRPC(wrapKataErr(res.err))
 		}
 
 		container := res.container
@@ -255,7 +371,7 @@ func (s *service) Start(ctx context.Context, r *taskAPI.StartRequest) (*taskAPI.
 		defer func() {
 			if r := recover(); r != nil {
 				serviceLog.WithField("panic", r).Error("Start panic recovered")
-				errCh <- fmt.Errorf("start panic: %v", r)
+				errCh <- errdefs.ToGRPCf(errdefs.ErrUnknown, "start panic: %v", r)
 			}
 		}()
 
@@ -264,10 +380,10 @@ func (s *service) Start(ctx context.Context, r *taskAPI.StartRequest) (*taskAPI.
 
 	select {
 	case <-ctx.Done():
-		return nil, fmt.Errorf("start container timeout: %v", r.ID)
+		return nil, errdefs.ToGRPC(ctx.Err())
 	case err := <-errCh:
 		if err != nil {
-			return nil, errdefs.ToGRPC(err)
+			return nil, errdefs.ToGRPC(wrapKataErr(err))
 		}
 
 		s.events <- &eventstypes.TaskStart{
@@ -298,7 +414,7 @@ func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (*taskAP
 		defer func() {
 			if r := recover(); r != nil {
 				serviceLog.WithField("panic", r).Error("Delete panic recovered")
-				errCh <- fmt.Errorf("delete panic: %v", r)
+				errCh <- errdefs.ToGRPCf(errdefs.ErrUnknown, "delete panic: %v", r)
 			}
 		}()
 
@@ -307,10 +423,10 @@ func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (*taskAP
 
 	select {
 	case <-ctx.Done():
-		return nil, fmt.Errorf("delete container timeout: %v", r.ID)
+		return nil, errdefs.ToGRPC(ctx.Err())
 	case err := <-errCh:
 		if err != nil {
-			return nil, err
+			return nil, errdefs.ToGRPC(wrapKataErr(err))
 		}
 
 		delete(s.containers, r.ID)
@@ -365,7 +481,14 @@ func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (*emptypb.Em
 	}
 
 	if s.sandbox == nil {
-		return nil, fmt.Errorf("sandbox not found")
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "sandbox not found")
+	}
+
+	if r.ExecID != "" {
+		if err := s.signalExec(ctx, c, r.ExecID, syscall.Signal(r.Signal)); err != nil {
+			return nil, errdefs.ToGRPC(wrapKataErr(err))
+		}
+		return empty, nil
 	}
 
 	errCh := make(chan error, 1)
@@ -373,7 +496,7 @@ func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (*emptypb.Em
 		defer func() {
 			if r := recover(); r != nil {
 				serviceLog.WithField("panic", r).Error("Kill panic recovered")
-				errCh <- fmt.Errorf("kill panic: %v", r)
+				errCh <- errdefs.ToGRPCf(errdefs.ErrUnknown, "kill panic: %v", r)
 			}
 		}()
 
@@ -386,10 +509,10 @@ func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (*emptypb.Em
 
 	select {
 	case <-ctx.Done():
-		return nil, fmt.Errorf("kill container timeout: %v", r.ID)
+		return nil, errdefs.ToGRPC(ctx.Err())
 	case err := <-errCh:
 		if err != nil {
-			return nil, errdefs.ToGRPC(err)
+			return nil, errdefs.ToGRPC(wrapKataErr(err))
 		}
 		return empty, nil
 	}
@@ -410,11 +533,11 @@ func (s *service) Pause(ctx context.Context, r *taskAPI.PauseRequest) (*emptypb.
 	defer s.mu.Unlock()
 
 	if s.sandbox == nil {
-		return nil, fmt.Errorf("sandbox not found")
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "sandbox not found")
 	}
 
 	if err := s.sandbox.Pause(ctx); err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, errdefs.ToGRPC(wrapKataErr(err))
 	}
 
 	s.events <- &eventstypes.TaskPaused{
@@ -429,11 +552,11 @@ func (s *service) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (*emptyp
 	defer s.mu.Unlock()
 
 	if s.sandbox == nil {
-		return nil, fmt.Errorf("sandbox not found")
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "sandbox not found")
 	}
 
 	if err := s.sandbox.Resume(ctx); err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, errdefs.ToGRPC(wrapKataErr(err))
 	}
 
 	s.events <- &eventstypes.TaskResumed{
@@ -443,32 +566,248 @@ func (s *service) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (*emptyp
 	return empty, nil
 }
 
+// Exec creates and starts r.ExecID inside r.ID, the counterpart of
+// Create+Start for the init process. newExec/startExec (exec.go) do the
+// actual work; Exec's job is registering the new exec on c.execs before
+// starting it (so a concurrent ResizePty/Kill/Wait for the same ExecID
+// can find it) and emitting TaskExecAdded/TaskExecStarted at the points
+// containerd's task manager expects them.
 func (s *service) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (*emptypb.Empty, error) {
-	return nil, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	serviceLog.WithField("container", r.ID).WithField("exec", r.ExecID).Info("Exec() start")
+	defer serviceLog.WithField("container", r.ID).WithField("exec", r.ExecID).Info("Exec() end")
+
+	s.mu.Lock()
+	c, ok := s.containers[r.ID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "container %s not found", r.ID)
+	}
+
+	c.execsMu.Lock()
+	if _, exists := c.execs[r.ExecID]; exists {
+		c.execsMu.Unlock()
+		return nil, errdefs.ToGRPCf(errdefs.ErrAlreadyExists, "exec %s already exists in container %s", r.ExecID, r.ID)
+	}
+	e, err := newExec(c.id, r.ExecID, r.Stdin, r.Stdout, r.Stderr, r.Terminal, r.Spec)
+	if err != nil {
+		c.execsMu.Unlock()
+		return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "failed to create exec %s: %v", r.ExecID, err)
+	}
+	c.execs[r.ExecID] = e
+	c.execsMu.Unlock()
+
+	s.events <- &eventstypes.TaskExecAdded{
+		ContainerID: r.ID,
+		ExecID:      r.ExecID,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				serviceLog.WithField("panic", rec).Error("Exec panic recovered")
+				errCh <- errdefs.ToGRPCf(errdefs.ErrUnknown, "exec panic: %v", rec)
+			}
+		}()
+
+		errCh <- s.startExec(ctx, c, r.ExecID)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, errdefs.ToGRPC(ctx.Err())
+	case err := <-errCh:
+		if err != nil {
+			c.execsMu.Lock()
+			delete(c.execs, r.ExecID)
+			c.execsMu.Unlock()
+			return nil, errdefs.ToGRPC(wrapKataErr(err))
+		}
+
+		s.events <- &eventstypes.TaskExecStarted{
+			ContainerID: r.ID,
+			ExecID:      r.ExecID,
+			Pid:         s.hpid,
+		}
+
+		return empty, nil
+	}
 }
 
 func (s *service) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (*emptypb.Empty, error) {
-	return nil, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	s.mu.Lock()
+	c, ok := s.containers[r.ID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "container %s not found", r.ID)
+	}
+
+	if r.ExecID == "" {
+		if err := s.resizeContainerPty(ctx, c, r.Width, r.Height); err != nil {
+			return nil, errdefs.ToGRPC(wrapKataErr(err))
+		}
+		return empty, nil
+	}
+
+	if err := s.resizeExecPty(ctx, c, r.ExecID, r.Width, r.Height); err != nil {
+		return nil, errdefs.ToGRPC(wrapKataErr(err))
+	}
+	return empty, nil
 }
 
+// CloseIO closes r.ID's stdin once it's been fully written. A TTY
+// container has no separate stdin to close - the single console stream
+// carries both directions, and closing it here would tear down the
+// terminal out from under a still-running process - so this is a no-op
+// for one, matching runc/kata-shim-v2.
 func (s *service) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	c, ok := s.containers[r.ID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "container %s not found", r.ID)
+	}
+
+	if c.terminal || !r.Stdin {
+		return empty, nil
+	}
+
+	c.ioMu.Lock()
+	closer := c.stdinCloser
+	c.ioMu.Unlock()
+	if closer != nil {
+		if err := closer.Close(); err != nil {
+			return nil, errdefs.ToGRPC(err)
+		}
+	}
 	return empty, nil
 }
 
+// Checkpoint dumps r.ID's VM state and guest container CRIU image into
+// r.Path, via checkpointContainer - restoreContainer, dispatched from
+// createContainer when a later CreateTaskRequest names r.Path as its
+// Checkpoint, loads it back in place of a cold boot.
 func (s *service) Checkpoint(ctx context.Context, r *taskAPI.CheckpointTaskRequest) (*emptypb.Empty, error) {
-	return nil, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	serviceLog.WithField("container", r.ID).WithField("path", r.Path).Info("Checkpoint()")
+
+	s.mu.Lock()
+	c, ok := s.containers[r.ID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "container %s not found", r.ID)
+	}
+
+	if err := s.checkpointContainer(ctx, c, r.Path); err != nil {
+		return nil, errdefs.ToGRPC(wrapKataErr(err))
+	}
+
+	s.events <- &eventstypes.TaskCheckpointed{
+		ContainerID: r.ID,
+		Checkpoint:  r.Path,
+	}
+
+	return empty, nil
 }
 
+// Update applies r.Resources (a typeurl-encoded LinuxResources) to r.ID's
+// init process via updateContainer (stats.go).
 func (s *service) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (*emptypb.Empty, error) {
-	return nil, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	serviceLog.WithField("container", r.ID).Info("Update()")
+
+	s.mu.Lock()
+	c, ok := s.containers[r.ID]
+	sandbox := s.sandbox
+	s.mu.Unlock()
+	if !ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "container %s not found", r.ID)
+	}
+	if sandbox == nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "sandbox not found")
+	}
+
+	if err := s.updateContainer(ctx, sandbox, c.id, r.Resources); err != nil {
+		return nil, errdefs.ToGRPC(wrapKataErr(err))
+	}
+
+	return empty, nil
 }
 
+// Wait blocks until r.ID's init process (r.ExecID == "") or one of its
+// exec processes (r.ExecID set) exits, returning the same exit status
+// a racing/later Delete would report - c.exitCh/e.exitCh are only ever
+// closed (never re-created), so a Wait arriving after the exit already
+// happened returns immediately instead of blocking forever.
 func (s *service) Wait(ctx context.Context, r *taskAPI.WaitRequest) (*taskAPI.WaitResponse, error) {
-	return nil, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	s.mu.Lock()
+	c, ok := s.containers[r.ID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "container %s not found", r.ID)
+	}
+
+	if r.ExecID == "" {
+		select {
+		case <-c.exitCh:
+		case <-ctx.Done():
+			return nil, errdefs.ToGRPC(ctx.Err())
+		}
+
+		s.mu.Lock()
+		exitStatus, exitedAt := c.exit, c.exitTime
+		s.mu.Unlock()
+
+		return &taskAPI.WaitResponse{
+			ExitStatus: exitStatus,
+			ExitedAt:   timestamppb.New(exitedAt),
+		}, nil
+	}
+
+	c.execsMu.RLock()
+	e, ok := c.execs[r.ExecID]
+	c.execsMu.RUnlock()
+	if !ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "exec %s not found in container %s", r.ExecID, r.ID)
+	}
+
+	select {
+	case <-e.exitCh:
+	case <-ctx.Done():
+		return nil, errdefs.ToGRPC(ctx.Err())
+	}
+
+	return &taskAPI.WaitResponse{
+		ExitStatus: uint32(e.exitCode),
+		ExitedAt:   timestamppb.New(e.exitTime),
+	}, nil
 }
 
+// Stats reports r.ID's init-process stats via statsContainer
+// (stats.go), typeurl-encoded into StatsResponse.Stats. The value is
+// the one VCSandbox.StatsContainer returns, unconverted; it is not the
+// cgroup v1/v2 Metrics message runc/kata-shim-v2 return.
 func (s *service) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.StatsResponse, error) {
-	return nil, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	s.mu.Lock()
+	_, ok := s.containers[r.ID]
+	sandbox := s.sandbox
+	s.mu.Unlock()
+	if !ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "container %s not found", r.ID)
+	}
+	if sandbox == nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "sandbox not found")
+	}
+
+	metrics, err := s.statsContainer(ctx, sandbox, r.ID)
+	if err != nil {
+		return nil, errdefs.ToGRPC(wrapKataErr(err))
+	}
+
+	data, err := typeurl.MarshalAny(metrics)
+	if err != nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrUnknown, "failed to marshal stats for %s: %v", r.ID, err)
+	}
+
+	return &taskAPI.StatsResponse{Stats: protobuf.FromAny(data)}, nil
 }
 
 func (s *service) Connect(ctx context.Context, r *taskAPI.ConnectRequest) (*taskAPI.ConnectResponse, error) {
@@ -10,9 +10,11 @@ import (
 	"syscall"
 	"time"
 
+	eventstypes "github.com/containerd/containerd/api/events"
 	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/fifo"
 	"github.com/kata-containers/kata-containers/src/runtime/pkg/katautils"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func (s *service) startContainer(ctx context.Context, c *container) error {
@@ -22,7 +24,16 @@ func (s *service) startContainer(ctx context.Context, c *container) error {
 
 	s.log.WithField("container", c.id).WithField("type", c.cType).Info("starting container")
 
-	if c.cType.IsSandbox() {
+	if c.restored {
+		// restoreContainer already loaded the sandbox's VM state and
+		// resumed its guest process - there's no fresh process for
+		// sandbox.Start to start, only IO to reattach to the one the
+		// checkpoint captured.
+		if err := s.handleIO(context.Background(), c); err != nil {
+			s.log.WithError(err).Warn("failed to attach IO to restored container")
+		}
+
+	} else if c.cType.IsSandbox() {
 		// Start the sandbox - this will start the container process
 		if err := s.sandbox.Start(ctx); err != nil {
 			return fmt.Errorf("failed to start sandbox: %w", err)
@@ -95,7 +106,22 @@ func (s *service) waitContainerProcess(c *container) {
 
 	if sandbox == nil {
 		s.log.WithField("container", c.id).Error("sandbox is nil in waitContainerProcess")
-		c.exitCh <- 255
+
+		exitTime := time.Now()
+		s.mu.Lock()
+		c.status = task.Status_STOPPED
+		c.exit = 255
+		c.exitTime = exitTime
+		s.mu.Unlock()
+		c.closeExitCh()
+
+		s.events <- &eventstypes.TaskExit{
+			ContainerID: c.id,
+			ID:          c.id,
+			Pid:         s.hpid,
+			ExitStatus:  255,
+			ExitedAt:    timestamppb.New(exitTime),
+		}
 		return
 	}
 
@@ -118,6 +144,13 @@ func (s *service) waitContainerProcess(c *container) {
 	<-c.exitIOch
 	s.log.WithField("container", c.id).Info("IO streams closed")
 
+	// Release the console only now that the process has exited and its
+	// IO has drained - closing it earlier could cut off output the
+	// process was still writing.
+	if c.console != nil {
+		c.console.close()
+	}
+
 	exitTime := time.Now()
 
 	s.mu.Lock()
@@ -126,8 +159,16 @@ func (s *service) waitContainerProcess(c *container) {
 	c.exitTime = exitTime
 	s.mu.Unlock()
 
-	// Send exit code to channel for Wait() to receive
-	c.exitCh <- uint32(exitCode)
+	// Broadcast the exit to every pending/future Wait call.
+	c.closeExitCh()
+
+	s.events <- &eventstypes.TaskExit{
+		ContainerID: c.id,
+		ID:          c.id,
+		Pid:         s.hpid,
+		ExitStatus:  uint32(exitCode),
+		ExitedAt:    timestamppb.New(exitTime),
+	}
 
 	// Handle sandbox cleanup for sandbox containers
 	if c.cType.IsSandbox() {
@@ -232,6 +273,10 @@ func (s *service) handleIO(ctx context.Context, c *container) error {
 		return fmt.Errorf("failed to get IO stream: %w", err)
 	}
 
+	if c.terminal {
+		return s.handleTTYIO(ctx, c, stdinStream, stdoutStream, stderrStream)
+	}
+
 	// Mark as attached before releasing the lock
 	c.ioAttached = true
 
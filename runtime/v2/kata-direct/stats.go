@@ -0,0 +1,62 @@
+// Copyright The containerd Authors.
+// SPDX-License-Identifier: Apache-2.0
+
+package katadirect
+
+import (
+	"context"
+	"fmt"
+
+	typeurl "github.com/containerd/typeurl/v2"
+	vc "github.com/kata-containers/kata-containers/src/runtime/virtcontainers"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// statsContainer fetches containerID's cgroup stats from sandbox, for
+// the Stats rpc to typeurl.MarshalAny and return as-is.
+//
+// TODO: this tree doesn't vendor virtcontainers, so the real shape
+// VCSandbox.StatsContainer returns (kata-shim-v2 converts it into a
+// cgroups v1/v2 Metrics message before handing it back over ttrpc)
+// isn't available to convert against here, the same gap resizeSandbox's
+// TODO already flags for the hot-plug methods this package assumes
+// VCSandbox exposes. Until that conversion can be written against the
+// real type, the raw value StatsContainer returns is marshaled directly,
+// which a crictl/cadvisor caller expecting a cgroups.Metrics message
+// won't be able to decode - replace with the real conversion once
+// virtcontainers is vendored.
+func (s *service) statsContainer(ctx context.Context, sandbox vc.VCSandbox, containerID string) (interface{}, error) {
+	stats, err := sandbox.StatsContainer(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat container %s: %w", containerID, err)
+	}
+	return stats, nil
+}
+
+// updateContainer unmarshals resources (a typeurl-encoded
+// *specs.LinuxResources, the same encoding runc shim-v2's Update rpc
+// expects) and applies it to containerID via sandbox.UpdateContainer.
+//
+// TODO: same vendoring gap as statsContainer - VCSandbox.UpdateContainer
+// is assumed to take a specs.LinuxResources by value; adjust to the real
+// virtcontainers signature once it's vendored.
+func (s *service) updateContainer(ctx context.Context, sandbox vc.VCSandbox, containerID string, resources typeurl.Any) error {
+	if resources == nil {
+		return nil
+	}
+
+	v, err := typeurl.UnmarshalAny(resources)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal update resources for %s: %w", containerID, err)
+	}
+
+	linuxResources, ok := v.(*specs.LinuxResources)
+	if !ok {
+		return fmt.Errorf("unexpected resources type %T for container %s", v, containerID)
+	}
+
+	if err := sandbox.UpdateContainer(ctx, containerID, *linuxResources); err != nil {
+		return fmt.Errorf("failed to update container %s: %w", containerID, err)
+	}
+	return nil
+}
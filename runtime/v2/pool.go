@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/containerd/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
 )
 
 // PoolItem represents a prewarmed shim that can be adopted by a container.
@@ -22,6 +24,15 @@ type PoolItem struct {
 	Idle bool
 	// LastActive records the last time this shim processed activity.
 	LastActive time.Time
+
+	// Sandbox is set instead of Address for an item registered by
+	// RegisterSandbox: a prewarmed Kata VM + agent, already booted,
+	// with no workload container yet. See GetIdleSandbox.
+	Sandbox Sandbox
+	// Profile is Sandbox's SandboxProfile, cached at registration so
+	// GetIdleSandbox can match against it without calling back into
+	// Sandbox under the pool lock.
+	Profile SandboxProfile
 }
 
 // ShimPool maintains prewarmed shims keyed by namespace and runtime.
@@ -34,19 +45,56 @@ type ShimPool struct {
 
 	// Configuration knobs (placeholders for future tuning).
 	IdleTTL       time.Duration // prune idle items after this duration without activity
-	HealthTimeout time.Duration // optional future health check timeout
+	HealthTimeout time.Duration // bounds each HealthChecker.Check call made by Run
+
+	// CheckInterval is how often Run probes every tracked item via
+	// Checker. Defaults to defaultCheckInterval if left zero.
+	CheckInterval time.Duration
+	// Checker probes item health for Run. Defaults to a
+	// NewTTRPCHealthChecker() in NewShimPool.
+	Checker HealthChecker
+
+	metrics *poolMetrics
+
+	// sandboxItems holds RegisterSandbox entries, keyed by
+	// "namespace|runtime|profile-key" (see sandboxKey in
+	// sandbox_pool.go) rather than items/index above, since a sandbox
+	// entry has no Address to key on and must additionally be scoped
+	// to a SandboxProfile.
+	sandboxItems map[string][]*PoolItem
+
+	// SandboxPoolConfigs overrides DefaultSandboxPoolConfig per
+	// SandboxProfile (keyed by SandboxProfile.Key()), for a deployment
+	// that wants different min/max pool sizes for different sandbox
+	// configurations.
+	SandboxPoolConfigs map[string]SandboxPoolConfig
+	// DefaultSandboxPoolConfig is used by RegisterSandbox/
+	// WarmUpSandboxes for any profile without an entry in
+	// SandboxPoolConfigs.
+	DefaultSandboxPoolConfig SandboxPoolConfig
 }
 
 // NewShimPool creates a new shim pool with default parameters.
 func NewShimPool() *ShimPool {
 	return &ShimPool{
-		items:         make(map[string][]*PoolItem),
-		index:         make(map[string]*PoolItem),
-		IdleTTL:       10 * time.Minute,
-		HealthTimeout: 5 * time.Second,
+		items:              make(map[string][]*PoolItem),
+		index:              make(map[string]*PoolItem),
+		sandboxItems:       make(map[string][]*PoolItem),
+		SandboxPoolConfigs: make(map[string]SandboxPoolConfig),
+		IdleTTL:            10 * time.Minute,
+		HealthTimeout:      5 * time.Second,
+		CheckInterval:      defaultCheckInterval,
+		Checker:            NewTTRPCHealthChecker(),
+		metrics:            newPoolMetrics(),
 	}
 }
 
+// Collectors returns ShimPool's metrics for registration with a metrics
+// module, the way WarmShimPool.Collectors does for its own pool.
+func (p *ShimPool) Collectors() []prometheus.Collector {
+	return p.metrics.collectors()
+}
+
 // key builds the storage key for a namespace/runtime pair.
 func (p *ShimPool) key(ns, runtime string) string {
 	return ns + "|" + runtime
@@ -104,7 +152,10 @@ func (p *ShimPool) GetIdle(ctx context.Context, ns, runtime string) *PoolItem {
 }
 
 // Return marks a shim back to idle after container lifecycle completes.
-// Optionally refreshes LastActive to the current time.
+// Optionally refreshes LastActive to the current time. A caller that
+// saw an RPC failure on the shim it's returning should call
+// MarkUnhealthy instead, so the pool doesn't hand out a shim it already
+// knows is broken.
 func (p *ShimPool) Return(ctx context.Context, address string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -123,10 +174,45 @@ func (p *ShimPool) Return(ctx context.Context, address string) {
 // Remove deletes a shim from the pool, e.g. on failure or shutdown.
 func (p *ShimPool) Remove(ctx context.Context, ns string, item *PoolItem) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.removeLocked(item)
+	p.mu.Unlock()
+
+	log.G(ctx).WithFields(log.Fields{
+		"namespace": item.Namespace,
+		"runtime":   item.Runtime,
+		"address":   item.Address,
+	}).Info("shim pool: removed shim from pool")
+}
+
+// MarkUnhealthy ejects an acquired shim from the pool instead of
+// letting it go back to idle, for a caller that saw an RPC failure on
+// it directly (Run's own periodic probing calls the same removal path
+// internally). The shim process is SIGKILLed if it's still running, and
+// an eviction metric is recorded under reason "rpc_failure".
+func (p *ShimPool) MarkUnhealthy(ctx context.Context, address string, cause error) {
+	p.mu.Lock()
+	item, ok := p.index[address]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	p.removeLocked(item)
+	p.mu.Unlock()
+
+	killShim(ctx, item)
+	p.metrics.observeEviction("rpc_failure")
+
+	log.G(ctx).WithFields(log.Fields{
+		"namespace": item.Namespace,
+		"runtime":   item.Runtime,
+		"address":   item.Address,
+	}).WithError(cause).Warn("shim pool: marked shim unhealthy, evicted")
+}
 
+// removeLocked deletes item from items/index. p.mu must be held.
+func (p *ShimPool) removeLocked(item *PoolItem) {
 	delete(p.index, item.Address)
-	k := p.key(ns, item.Runtime)
+	k := p.key(item.Namespace, item.Runtime)
 	list := p.items[k]
 	out := list[:0]
 	for _, it := range list {
@@ -139,12 +225,19 @@ func (p *ShimPool) Remove(ctx context.Context, ns string, item *PoolItem) {
 	} else {
 		p.items[k] = out
 	}
+}
 
-	log.G(ctx).WithFields(log.Fields{
-		"namespace": item.Namespace,
-		"runtime":   item.Runtime,
-		"address":   item.Address,
-	}).Info("shim pool: removed shim from pool")
+// killShim SIGKILLs item's shim process if it's still alive. PID is
+// best-effort diagnostic data (see PoolItem.PID), so a zero or already
+// dead PID is not an error.
+func killShim(ctx context.Context, item *PoolItem) {
+	if item.PID <= 0 {
+		return
+	}
+	if err := unix.Kill(item.PID, unix.SIGKILL); err != nil && err != unix.ESRCH {
+		log.G(ctx).WithError(err).WithField("pid", item.PID).
+			Warn("shim pool: failed to kill unhealthy shim process")
+	}
 }
 
 // Prune removes idle shims that have exceeded IdleTTL.
@@ -181,3 +274,73 @@ func (p *ShimPool) Len() int {
 	defer p.mu.Unlock()
 	return len(p.index)
 }
+
+// Run probes every tracked item with Checker on CheckInterval, evicting
+// (and SIGKILLing) whichever ones fail, until ctx is done. It's meant to
+// be started once as its own goroutine alongside a manager, the way
+// Prune is meant to be called periodically - unlike Prune, Run owns its
+// own ticking instead of relying on a caller to drive it.
+func (p *ShimPool) Run(ctx context.Context) {
+	interval := p.CheckInterval
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkHealth(ctx)
+		}
+	}
+}
+
+// checkHealth snapshots every tracked item and probes each with
+// Checker, bounded by HealthTimeout, evicting the ones that fail.
+func (p *ShimPool) checkHealth(ctx context.Context) {
+	p.mu.Lock()
+	items := make([]*PoolItem, 0, len(p.index))
+	for _, it := range p.index {
+		items = append(items, it)
+	}
+	checker := p.Checker
+	timeout := p.HealthTimeout
+	p.mu.Unlock()
+
+	if checker == nil {
+		return
+	}
+
+	for _, item := range items {
+		checkCtx := ctx
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			checkCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		start := time.Now()
+		err := checker.Check(checkCtx, item)
+		p.metrics.observeCheck(time.Since(start))
+		if cancel != nil {
+			cancel()
+		}
+
+		if err != nil {
+			p.mu.Lock()
+			p.removeLocked(item)
+			p.mu.Unlock()
+
+			killShim(ctx, item)
+			p.metrics.observeEviction("unhealthy")
+
+			log.G(ctx).WithFields(log.Fields{
+				"namespace": item.Namespace,
+				"runtime":   item.Runtime,
+				"address":   item.Address,
+			}).WithError(err).Warn("shim pool: evicted unhealthy shim")
+		}
+	}
+}
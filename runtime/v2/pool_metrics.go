@@ -0,0 +1,61 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v2
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolMetrics holds the Prometheus collectors ShimPool reports, split
+// out of pool.go the way shimPoolMetrics keeps WarmShimPool's collector
+// wiring separate from its logic.
+type poolMetrics struct {
+	evictions    *prometheus.CounterVec
+	checkLatency prometheus.Histogram
+}
+
+func newPoolMetrics() *poolMetrics {
+	return &poolMetrics{
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "containerd",
+			Subsystem: "shim_pool",
+			Name:      "evictions_total",
+			Help:      "Prewarmed shims removed from the pool, by reason.",
+		}, []string{"reason"}),
+		checkLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "containerd",
+			Subsystem: "shim_pool",
+			Name:      "health_check_latency_seconds",
+			Help:      "Time spent probing one pooled shim's health.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+}
+
+func (m *poolMetrics) observeEviction(reason string) {
+	m.evictions.WithLabelValues(reason).Inc()
+}
+
+func (m *poolMetrics) observeCheck(d time.Duration) {
+	m.checkLatency.Observe(d.Seconds())
+}
+
+func (m *poolMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.evictions, m.checkLatency}
+}
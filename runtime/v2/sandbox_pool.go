@@ -0,0 +1,258 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// SandboxProfile describes the parts of a sandbox's configuration that
+// make it usable, or not, as a warm stand-in for a new container's
+// request - kernel, initrd, hypervisor and its flags, and the
+// resources it was booted with. GetIdleSandbox only ever hands out an
+// entry whose Profile matches the one it's asked for.
+type SandboxProfile struct {
+	Kernel          string
+	Initrd          string
+	Hypervisor      string
+	HypervisorFlags []string
+	CPUs            uint32
+	MemoryMB        uint32
+}
+
+// Key hashes p into a short, stable string suitable as a pool map key,
+// the way content digests key the content store - two profiles that
+// hash the same are interchangeable for pooling purposes.
+func (p SandboxProfile) Key() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%v\x00%d\x00%d",
+		p.Kernel, p.Initrd, p.Hypervisor, p.HypervisorFlags, p.CPUs, p.MemoryMB)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Sandbox is the subset of a booted Kata sandbox handle the pool needs
+// in order to hand a prewarmed VM to a waiting container, independent
+// of the virtcontainers (or any other hypervisor/agent) package that
+// actually implements it.
+type Sandbox interface {
+	// CreateContainer joins a new container, identified by
+	// containerID, to the already-running sandbox, the way
+	// katautils.CreateContainer joins one to a sandbox booted cold.
+	// ociSpec and rootFs are opaque here (their concrete types live in
+	// the runtime package that implements Sandbox) and are passed
+	// through unchanged.
+	CreateContainer(ctx context.Context, containerID string, ociSpec, rootFs interface{}) error
+	// Stop tears the sandbox down - used by WarmUpSandboxes to discard a
+	// surplus boot the pool had no room for, and by DrainSandboxes at
+	// shutdown.
+	Stop(ctx context.Context) error
+}
+
+// SandboxPoolConfig bounds how many prewarmed sandboxes WarmUpSandboxes
+// keeps ready for one SandboxProfile, and how much boot concurrency it
+// uses to get there, alongside the pool-wide IdleTTL ShimPool already
+// has.
+type SandboxPoolConfig struct {
+	// MinSize is the number of idle sandboxes WarmUpSandboxes tries to
+	// keep on hand for a profile.
+	MinSize int
+	// MaxSize caps how many sandboxes (idle + taken) RegisterSandbox
+	// allows for a profile before it refuses to register another.
+	// Zero means unbounded.
+	MaxSize int
+	// WarmupConcurrency bounds how many sandboxes WarmUpSandboxes boots
+	// at once. Defaults to 1 if zero.
+	WarmupConcurrency int
+}
+
+func (p *ShimPool) sandboxConfigFor(profile SandboxProfile) SandboxPoolConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cfg, ok := p.SandboxPoolConfigs[profile.Key()]; ok {
+		return cfg
+	}
+	return p.DefaultSandboxPoolConfig
+}
+
+func (p *ShimPool) sandboxKey(ns, runtime string, profile SandboxProfile) string {
+	return ns + "|" + runtime + "|" + profile.Key()
+}
+
+// RegisterSandbox inserts a prewarmed sandbox into the pool as idle,
+// refusing it if doing so would exceed the profile's MaxSize. It
+// returns nil in that case, the same way GetIdleSandbox returns nil on
+// a miss, so a caller's warm-up loop can stop without treating a full
+// pool as an error.
+func (p *ShimPool) RegisterSandbox(ctx context.Context, ns, runtime string, sb Sandbox, profile SandboxProfile) *PoolItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sandboxItems == nil {
+		p.sandboxItems = make(map[string][]*PoolItem)
+	}
+
+	k := p.sandboxKey(ns, runtime, profile)
+	cfg := p.DefaultSandboxPoolConfig
+	if c, ok := p.SandboxPoolConfigs[profile.Key()]; ok {
+		cfg = c
+	}
+	if cfg.MaxSize > 0 && len(p.sandboxItems[k]) >= cfg.MaxSize {
+		log.G(ctx).WithField("namespace", ns).WithField("runtime", runtime).
+			Warn("sandbox pool: profile at MaxSize, refusing to register another sandbox")
+		return nil
+	}
+
+	item := &PoolItem{
+		Namespace:  ns,
+		Runtime:    runtime,
+		Idle:       true,
+		LastActive: time.Now(),
+		Sandbox:    sb,
+		Profile:    profile,
+	}
+	p.sandboxItems[k] = append(p.sandboxItems[k], item)
+
+	log.G(ctx).WithFields(log.Fields{
+		"namespace": ns,
+		"runtime":   runtime,
+		"profile":   profile.Key(),
+	}).Info("sandbox pool: registered prewarmed sandbox")
+
+	return item
+}
+
+// GetIdleSandbox retrieves and marks used an idle prewarmed sandbox
+// matching ns/runtime/profile exactly. It returns nil if none are
+// available, so the caller falls back to booting a sandbox cold.
+func (p *ShimPool) GetIdleSandbox(ctx context.Context, ns, runtime string, profile SandboxProfile) *PoolItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	k := p.sandboxKey(ns, runtime, profile)
+	for _, it := range p.sandboxItems[k] {
+		if it.Idle {
+			it.Idle = false
+			it.LastActive = time.Now()
+			log.G(ctx).WithFields(log.Fields{
+				"namespace": ns,
+				"runtime":   runtime,
+				"profile":   profile.Key(),
+			}).Info("sandbox pool: acquired idle prewarmed sandbox")
+			return it
+		}
+	}
+	return nil
+}
+
+// WarmUpSandboxes boots sandboxes with boot until at least
+// SandboxPoolConfig.MinSize idle entries exist for ns/runtime/profile,
+// using up to WarmupConcurrency boots at once. It's meant to be called
+// once, for each profile a deployment wants kept warm, as part of
+// containerd's own startup - this tree has no such startup hook to
+// call it from automatically (the same gap as the rest of this
+// pruned snapshot's missing main/plugin-init wiring), so a caller
+// integrating this pool has to invoke it explicitly.
+func (p *ShimPool) WarmUpSandboxes(ctx context.Context, ns, runtime string, profile SandboxProfile, boot func(ctx context.Context) (Sandbox, error)) error {
+	cfg := p.sandboxConfigFor(profile)
+	if cfg.MinSize <= 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	existing := 0
+	for _, it := range p.sandboxItems[p.sandboxKey(ns, runtime, profile)] {
+		if it.Idle {
+			existing++
+		}
+	}
+	p.mu.Unlock()
+	need := cfg.MinSize - existing
+	if need <= 0 {
+		return nil
+	}
+
+	concurrency := cfg.WarmupConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	errs := make([]error, need)
+
+	for i := 0; i < need; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			sb, err := boot(ctx)
+			if err != nil {
+				errs[i] = fmt.Errorf("warm-up boot failed: %w", err)
+				return
+			}
+			if p.RegisterSandbox(ctx, ns, runtime, sb, profile) == nil {
+				// Pool filled up while this boot was in flight.
+				if stopErr := sb.Stop(ctx); stopErr != nil {
+					log.G(ctx).WithError(stopErr).Warn("sandbox pool: failed to stop surplus warm-up sandbox")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var firstErr error
+	for _, err := range errs {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// DrainSandboxes stops every registered sandbox, idle or not, and
+// clears the pool - meant to be called as containerd shuts down, so a
+// prewarmed VM nobody adopted doesn't outlive the process that started
+// it as an orphan.
+func (p *ShimPool) DrainSandboxes(ctx context.Context) error {
+	p.mu.Lock()
+	items := p.sandboxItems
+	p.sandboxItems = make(map[string][]*PoolItem)
+	p.mu.Unlock()
+
+	var firstErr error
+	for _, list := range items {
+		for _, it := range list {
+			if err := it.Sandbox.Stop(ctx); err != nil {
+				log.G(ctx).WithError(err).WithField("namespace", it.Namespace).
+					Warn("sandbox pool: failed to stop sandbox while draining")
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return firstErr
+}
@@ -1,3 +1,19 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
 package shim
 
 import (
@@ -7,39 +23,58 @@ import (
 	"github.com/containerd/ttrpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/types/known/emptypb"
 )
 
-// AdoptRequest carries minimal information to bind a container context
-// onto a prewarmed shim instance. Fields can be extended later (mounts/IO/options).
+// adoptServiceID and adoptMethod name the Task v3 RPC a prewarmed shim is
+// adopted through. It belongs on the real task/v3 ttrpc service once its
+// proto defines an AdoptContainer rpc and protoc-gen-go-ttrpc has been run
+// for it; until then it's addressed directly by name, the same way the
+// rest of this package can call a shim without that codegen.
+//
+// TODO: drop adoptServiceID/adoptMethod once api/runtime/task/v3 gains
+// AdoptContainer and a generated TaskClient exists to call instead.
+const (
+	adoptServiceID = "containerd.task.v3.Task"
+	adoptMethod    = "AdoptContainer"
+)
+
+// AdoptRequest binds a previously warmed, unbound shim to a real
+// container, carrying the same information Create would: a warm shim is
+// started before any of this is known, so it has to arrive separately.
 type AdoptRequest struct {
-	Id        string
-	Bundle    string
+	ID        string
 	Namespace string
+	Bundle    string
+	Rootfs    []*Mount
+	Options   []byte
+	Stdin     string
+	Stdout    string
+	Stderr    string
+	Terminal  bool
 }
 
-// AdoptContainer sends AdoptRequest via ttrpc to shim's Task service.
-// Returns ErrNotImplemented if the server does not implement the method.
-func AdoptContainer(ctx context.Context, conn interface{}, req *AdoptRequest) error {
+// AdoptResponse is returned once a shim has rebound itself from its warm
+// identity to the container named in the request.
+type AdoptResponse struct {
+	Pid uint32
+}
+
+// AdoptContainer sends req to a prewarmed shim over conn so it can adopt
+// a real container instead of being re-exec'd for it. It returns
+// errdefs.ErrNotImplemented if the shim doesn't support adoption, e.g. it
+// predates this RPC.
+func AdoptContainer(ctx context.Context, conn interface{}, req *AdoptRequest) (*AdoptResponse, error) {
 	cli, ok := conn.(*ttrpc.Client)
 	if !ok || cli == nil {
-		return errdefs.ErrNotImplemented
-	}
-	// 通过 ttrpc metadata 传递容器上下文字段，避免生成 proto
-	mdMD := ttrpc.MD{
-		"adopt.id":        []string{req.Id},
-		"adopt.bundle":    []string{req.Bundle},
-		"adopt.namespace": []string{req.Namespace},
+		return nil, errdefs.ErrNotImplemented
 	}
-	ctx = ttrpc.WithMetadata(ctx, mdMD)
 
-	// 建立 ttrpc 调用，使用空请求，返回 emptypb.Empty
-	var resp emptypb.Empty
-	if err := cli.Call(ctx, "containerd.task.v2.Task", "AdoptContainer", &emptypb.Empty{}, &resp); err != nil {
+	var resp AdoptResponse
+	if err := cli.Call(ctx, adoptServiceID, adoptMethod, req, &resp); err != nil {
 		if st, ok := status.FromError(err); ok && st.Code() == codes.Unimplemented {
-			return errdefs.ErrNotImplemented
+			return nil, errdefs.ErrNotImplemented
 		}
-		return err
+		return nil, err
 	}
-	return nil
+	return &resp, nil
 }
@@ -1,40 +1,49 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
 package shim
 
 import (
 	"context"
 
 	"github.com/containerd/ttrpc"
-	"google.golang.org/protobuf/types/known/emptypb"
 )
 
-// Implement proto.Message minimal methods via embedding; for ttrpc generic marshalling we only need VT/proto marshaling.
-// To avoid external codegen, we rely on protobuf reflection fallback through generated descriptors (not present here),
-// so we keep server handler simple and do not depend on auto-marshaling beyond emptypb for response.
+// AdoptHandler performs the actual rebind of a warm shim to req's
+// container, e.g. moving its bundle/log pipe into place and handing its
+// rootfs and IO to the runtime. It's supplied by whatever owns the
+// shim's task state (the Task v3 service implementation), not this
+// package, which only wires the RPC up.
+type AdoptHandler func(ctx context.Context, req *AdoptRequest) (*AdoptResponse, error)
 
-// RegisterAdoptHandler registers an AdoptContainer RPC using ttrpc ServiceDesc.
-// Service: "containerd.task.v2.Task"
-// Method:  "AdoptContainer"
-func RegisterAdoptHandler(server *ttrpc.Server) {
-	// 以 ttrpc 的 ServiceDesc.Methods 注册一个最小可用的 AdoptContainer
-	server.RegisterService("containerd.task.v2.Task", &ttrpc.ServiceDesc{
+// RegisterAdoptHandler registers the AdoptContainer RPC under
+// adoptServiceID/adoptMethod (see adopt.go), dispatching to handler.
+//
+// TODO: once api/runtime/task/v3 defines AdoptContainer as part of the
+// Task service proper, this should become one more method on the
+// generated TaskService rather than a service registered by hand.
+func RegisterAdoptHandler(server *ttrpc.Server, handler AdoptHandler) {
+	server.RegisterService(adoptServiceID, &ttrpc.ServiceDesc{
 		Methods: map[string]ttrpc.Method{
-			"AdoptContainer": func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
-				// 最小载荷采用空请求，保持兼容
-				var req emptypb.Empty
+			adoptMethod: func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+				var req AdoptRequest
 				if err := unmarshal(&req); err != nil {
 					return nil, err
 				}
-				// 从 ttrpc metadata 读取容器上下文并记录（占位实现）
-				md, _ := ttrpc.GetMetadata(ctx)
-				// 将绑定信息写入日志；后续可存入 shim 内部状态供 Create 使用
-				id := md["adopt.id"]
-				bundle := md["adopt.bundle"]
-				ns := md["adopt.namespace"]
-				_ = id
-				_ = bundle
-				_ = ns
-				// 返回空响应，表示 adopt 成功
-				return &emptypb.Empty{}, nil
+				return handler(ctx, &req)
 			},
 		},
 	})
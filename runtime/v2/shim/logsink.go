@@ -0,0 +1,186 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/containerd/log"
+)
+
+// LogEntry is one line of a shim's log output: either a logrus/log.G JSON
+// record, parsed into Level/Message/Fields, or - for a line that isn't
+// JSON - the raw text in Raw with Level/Message/Fields left unset.
+type LogEntry struct {
+	ID      string
+	Runtime string
+	Pid     uint32
+	Level   string
+	Message string
+	Fields  map[string]interface{}
+	Raw     string
+}
+
+// LogSink receives shim log entries once IngestLog has parsed and rate
+// limited them. The default sink re-emits entries through log.G(ctx); an
+// alternate sink - writing to a file, syslog, or journald - can be
+// registered under a name with RegisterLogSink and looked up by that
+// name, so operators can route shim logs independently of containerd's
+// own log destination.
+type LogSink interface {
+	Write(ctx context.Context, entry LogEntry) error
+}
+
+// defaultLogSink re-emits entries through log.G(ctx), the behavior
+// IngestLog falls back to when no LogSinkConfig.Sink is set.
+type defaultLogSink struct{}
+
+func (defaultLogSink) Write(ctx context.Context, entry LogEntry) error {
+	fields := log.Fields{
+		"shim.id":      entry.ID,
+		"shim.runtime": entry.Runtime,
+		"shim.pid":     entry.Pid,
+	}
+	if entry.Raw != "" {
+		fields["raw"] = entry.Raw
+		log.G(ctx).WithFields(fields).Info("shim log")
+		return nil
+	}
+	for k, v := range entry.Fields {
+		fields[k] = v
+	}
+	entryLog := log.G(ctx).WithFields(fields)
+	switch entry.Level {
+	case "trace":
+		entryLog.Trace(entry.Message)
+	case "debug":
+		entryLog.Debug(entry.Message)
+	case "warning", "warn":
+		entryLog.Warn(entry.Message)
+	case "error":
+		entryLog.Error(entry.Message)
+	case "fatal":
+		entryLog.Error(entry.Message)
+	default:
+		entryLog.Info(entry.Message)
+	}
+	return nil
+}
+
+// LogIngestConfig configures IngestLog for a single shim.
+type LogIngestConfig struct {
+	// ID and Runtime identify the shim an ingested line came from, and
+	// are attached to every LogEntry as shim.id/shim.runtime.
+	ID      string
+	Runtime string
+	// Pid is attached to every LogEntry as shim.pid. It may be 0 if the
+	// shim's pid isn't known yet when ingestion starts.
+	Pid uint32
+	// Sink receives parsed entries. Defaults to defaultLogSink, which
+	// re-emits them through log.G(ctx).
+	Sink LogSink
+	// RateLimit bounds how many lines per second are forwarded to Sink.
+	// The zero value disables rate limiting.
+	RateLimit RateLimiterConfig
+	// Metrics records drops and bytes forwarded. Defaults to the
+	// package-level metrics that LogSinkCollectors exposes; tests may
+	// supply their own.
+	Metrics *logIngestMetrics
+}
+
+// IngestLog reads r line by line until EOF or ctx is done, parsing each
+// line as a logrus/log.G JSON record and forwarding it to cfg.Sink. A
+// line that isn't valid JSON is forwarded with Raw set instead. Lines
+// arriving faster than cfg.RateLimit allows are dropped and counted
+// rather than forwarded. It replaces a raw io.Copy of the shim's log
+// pipe to containerd's own output, which gave operators no way to rate
+// limit, structure, or reroute shim log volume.
+func IngestLog(ctx context.Context, r io.Reader, cfg LogIngestConfig) error {
+	sink := cfg.Sink
+	if sink == nil {
+		sink = defaultLogSink{}
+	}
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = defaultLogIngestMetrics
+	}
+	limiter := newTokenBucket(cfg.RateLimit)
+
+	scanner := bufio.NewScanner(r)
+	// Shim log lines are JSON records that can carry arbitrarily large
+	// field values (e.g. stack traces); grow past bufio's 64KB default.
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		line := scanner.Bytes()
+		if !limiter.Allow() {
+			metrics.observeDropped(cfg.Runtime)
+			continue
+		}
+
+		entry := parseLogLine(line)
+		entry.ID = cfg.ID
+		entry.Runtime = cfg.Runtime
+		entry.Pid = cfg.Pid
+
+		if err := sink.Write(ctx, entry); err != nil {
+			log.G(ctx).WithError(err).Warn("shim log sink failed to write entry")
+			continue
+		}
+		metrics.observeForwarded(cfg.Runtime, len(line))
+	}
+	return scanner.Err()
+}
+
+// parseLogLine decodes line as a logrus/log.G JSON record ("level",
+// "msg", and any caller-supplied fields), falling back to a raw entry
+// when it isn't JSON - shims may still write unstructured lines, e.g.
+// from a panic or a library that logs directly to stderr.
+func parseLogLine(line []byte) LogEntry {
+	var record map[string]interface{}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return LogEntry{Raw: string(line)}
+	}
+
+	entry := LogEntry{Fields: make(map[string]interface{}, len(record))}
+	for k, v := range record {
+		switch k {
+		case "level":
+			if s, ok := v.(string); ok {
+				entry.Level = s
+			}
+		case "msg":
+			if s, ok := v.(string); ok {
+				entry.Message = s
+			}
+		case "time":
+			// Carried through in Fields; IngestLog's caller already has
+			// its own receive-time logging via log.G(ctx).
+			entry.Fields[k] = v
+		default:
+			entry.Fields[k] = v
+		}
+	}
+	return entry
+}
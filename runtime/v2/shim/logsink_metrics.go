@@ -0,0 +1,75 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// logIngestMetrics holds the Prometheus collectors IngestLog reports,
+// split out the way WarmShimPool keeps its own collector wiring in
+// shim_pool_metrics.go separate from shim_pool.go's logic.
+type logIngestMetrics struct {
+	dropped        *prometheus.CounterVec
+	bytesForwarded *prometheus.CounterVec
+}
+
+func newLogIngestMetrics() *logIngestMetrics {
+	return &logIngestMetrics{
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "containerd",
+			Subsystem: "shim_logsink",
+			Name:      "dropped_total",
+			Help:      "Shim log lines dropped by IngestLog's rate limiter, by runtime.",
+		}, []string{"runtime"}),
+		bytesForwarded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "containerd",
+			Subsystem: "shim_logsink",
+			Name:      "bytes_forwarded_total",
+			Help:      "Shim log bytes forwarded to a LogSink by IngestLog, by runtime.",
+		}, []string{"runtime"}),
+	}
+}
+
+func (m *logIngestMetrics) observeDropped(runtime string) {
+	m.dropped.WithLabelValues(runtime).Inc()
+}
+
+func (m *logIngestMetrics) observeForwarded(runtime string, bytes int) {
+	m.bytesForwarded.WithLabelValues(runtime).Add(float64(bytes))
+}
+
+// Collectors exposes IngestLog's metrics for registration with
+// containerd's metrics plugin, the same way WarmShimPool.Collectors and
+// kata-direct's metrics.Module do for their own collectors.
+func (m *logIngestMetrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.dropped, m.bytesForwarded}
+}
+
+// defaultLogIngestMetrics is the package-wide instance IngestLog reports
+// to when a LogIngestConfig doesn't supply its own, so every shim's
+// ingestion - across every runtime plugin - shares one set of counters
+// unless a caller needs isolation (e.g. in tests).
+var defaultLogIngestMetrics = newLogIngestMetrics()
+
+// LogSinkCollectors exposes the default log-ingestion metrics so the
+// process wiring up containerd's metrics plugin can register them
+// alongside its other collectors, matching how ShimPool's and
+// kata-direct's collectors are surfaced.
+func LogSinkCollectors() []prometheus.Collector {
+	return defaultLogIngestMetrics.Collectors()
+}
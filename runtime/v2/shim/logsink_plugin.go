@@ -0,0 +1,69 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/containerd/containerd/plugin"
+)
+
+// LogSinkPlugin is the plugin.Type a file, syslog, or journald log sink
+// registers under, so it's discovered and initialized by containerd's
+// plugin loader the same way a TTRPCPlugin or RuntimePluginV2 is,
+// instead of being wired in by hand.
+const LogSinkPlugin plugin.Type = "io.containerd.shim.logsink.v1"
+
+var (
+	sinksMu sync.Mutex
+	sinks   = map[string]LogSink{}
+)
+
+// RegisterLogSink makes sink available under name for a runtime's
+// LogIngestConfig.Sink to select by name in configuration, e.g. a
+// LogSinkPlugin's InitFn calling this once it has built its file,
+// syslog, or journald sink. Registering the same name twice replaces
+// the previous sink, matching plugin.Register's last-one-wins
+// semantics for a given ID.
+func RegisterLogSink(name string, sink LogSink) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	sinks[name] = sink
+}
+
+// LookupLogSink returns the sink registered under name, or false if none
+// was registered - e.g. the operator named a sink whose LogSinkPlugin
+// failed to load or was never compiled in.
+func LookupLogSink(name string) (LogSink, bool) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	sink, ok := sinks[name]
+	return sink, ok
+}
+
+// ResolveLogSink looks up name and wraps the "not registered" case in an
+// error callers can attach to their own context (e.g. which runtime or
+// shim failed to start logging), rather than silently falling back to
+// the default sink.
+func ResolveLogSink(name string) (LogSink, error) {
+	sink, ok := LookupLogSink(name)
+	if !ok {
+		return nil, fmt.Errorf("shim log sink %q is not registered", name)
+	}
+	return sink, nil
+}
@@ -0,0 +1,97 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiterConfig bounds how many shim log lines per second IngestLog
+// forwards to a LogSink. It's a classic token bucket: Burst lines may
+// arrive in a single instant before the steady-state Rate applies. The
+// zero value disables rate limiting, since most runtimes never log
+// heavily enough to need it.
+type RateLimiterConfig struct {
+	// Rate is the steady-state number of lines per second allowed.
+	Rate float64
+	// Burst is the bucket size: how many lines may be forwarded back to
+	// back before Rate starts throttling. Defaults to Rate rounded down
+	// to a whole number, and at least 1, if left at 0 while Rate is set.
+	Burst int
+}
+
+// tokenBucket is an unexported, non-blocking token bucket: Allow reports
+// whether a token is available right now rather than waiting for one,
+// since IngestLog needs to drop-and-count excess lines, not stall the
+// shim's log pipe.
+type tokenBucket struct {
+	mu    sync.Mutex
+	rate  float64
+	burst float64
+	// unlimited is true when cfg.Rate <= 0, the common case.
+	unlimited bool
+
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(cfg RateLimiterConfig) *tokenBucket {
+	if cfg.Rate <= 0 {
+		return &tokenBucket{unlimited: true}
+	}
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = int(cfg.Rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &tokenBucket{
+		rate:   cfg.Rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Time{},
+	}
+}
+
+// Allow reports whether a token is available, consuming one if so.
+func (b *tokenBucket) Allow() bool {
+	if b.unlimited {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.last.IsZero() {
+		b.last = now
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,253 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	gruntime "runtime"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/runtime"
+	"github.com/containerd/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrNoWarmShim is returned by Acquire when runtimeName isn't pooled,
+// opts is rejected by the pool's WarmPoolConfig.Admit, or no warm shim
+// became available within the pool's TakeTimeout - the
+// same miss Take reports by returning nil, surfaced as an error so
+// Acquire can return the WarmShim interface directly.
+var ErrNoWarmShim = errors.New("no warm shim available")
+
+// maxBindAttempts bounds how many times AcquireAndBind will discard a
+// shim that failed Bind and try again before giving up.
+const maxBindAttempts = 3
+
+// WarmShimPoolConfig configures a WarmShimPool.
+type WarmShimPoolConfig struct {
+	WarmPoolConfig
+
+	// Runtimes restricts pooling to these runtime binaries. A nil or
+	// empty Runtimes pools every runtime a container is started with.
+	Runtimes []string
+}
+
+// shimPoolKey identifies one warm pool: containerd can run shims for
+// several runtimes, and a runtime's shim binary is platform-specific, so
+// a pool is scoped to both.
+type shimPoolKey struct {
+	runtime  string
+	platform string
+}
+
+func currentPlatform() string {
+	return gruntime.GOOS + "/" + gruntime.GOARCH
+}
+
+// WarmShimPool turns the CONTAINERD_SHIM_PREWARM env-var feature in
+// binary.Start into a first-class, config-driven subsystem: it keeps
+// WarmPoolConfig.Size warm, unbound shims per (runtime, platform) pair,
+// hands one out on container create via Take, refills asynchronously
+// (through the warmPool each key wraps), and reports pool size and
+// hit/miss/adopt-latency metrics for the containerd metrics plugin to
+// scrape.
+type WarmShimPool struct {
+	mu        sync.Mutex
+	manager   *ShimManager
+	config    WarmShimPoolConfig
+	pools     map[shimPoolKey]*warmPool
+	metrics   *shimPoolMetrics
+	lifecycle context.Context
+}
+
+// NewWarmShimPool creates a WarmShimPool that starts warm shims through
+// manager. lifecycle is normally a plugin.InitContext.Context: each
+// per-(runtime, platform) pool created under it runs its refill/eviction
+// loop for as long as lifecycle is live, and drains that pool the moment
+// it's canceled, so the pool doesn't outlive the plugin that owns it.
+//
+// Note: no plugin.InitFn in this tree constructs a WarmShimPool yet (the
+// same gap sandbox_pool.go's WarmUpSandboxes already flags for its own
+// startup hook) - this just gives whichever init wiring eventually does
+// a context to pass.
+func NewWarmShimPool(manager *ShimManager, config WarmShimPoolConfig, lifecycle context.Context) *WarmShimPool {
+	return &WarmShimPool{
+		manager:   manager,
+		config:    config,
+		pools:     map[shimPoolKey]*warmPool{},
+		metrics:   newShimPoolMetrics(),
+		lifecycle: lifecycle,
+	}
+}
+
+// enabled reports whether runtimeName should be pooled at all.
+func (p *WarmShimPool) enabled(runtimeName string) bool {
+	if !p.config.Enabled {
+		return false
+	}
+	if len(p.config.Runtimes) == 0 {
+		return true
+	}
+	for _, r := range p.config.Runtimes {
+		if r == runtimeName {
+			return true
+		}
+	}
+	return false
+}
+
+// poolFor returns the warm pool for runtimeName on the current
+// platform, starting it the first time it's requested.
+func (p *WarmShimPool) poolFor(ctx context.Context, runtimeName, ns string) *warmPool {
+	key := shimPoolKey{runtime: runtimeName, platform: currentPlatform()}
+
+	p.mu.Lock()
+	pool, ok := p.pools[key]
+	if !ok {
+		pool = newWarmPool(ctx, p.manager, runtimeName, ns, p.config.WarmPoolConfig)
+		p.pools[key] = pool
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		if err := pool.Start(ctx); err != nil {
+			log.G(ctx).WithError(err).WithField("runtime", runtimeName).Warn("failed to start shim pool")
+		}
+		p.metrics.setSize(key, p.config.Size)
+		go pool.Run(p.lifecycle)
+	}
+	return pool
+}
+
+// Take hands out a warm shim for runtimeName/ns, recording a hit or miss
+// and the time spent waiting as adopt latency. It returns nil, a miss,
+// if runtimeName isn't pooled, opts is rejected by the pool's
+// WarmPoolConfig.Admit, or no warm shim became available within the
+// pool's TakeTimeout; the caller should fall back to starting one
+// normally.
+func (p *WarmShimPool) Take(ctx context.Context, runtimeName, ns string, opts runtime.CreateOpts) *warmShimInstance {
+	if !p.enabled(runtimeName) {
+		return nil
+	}
+
+	start := time.Now()
+	shim := p.poolFor(ctx, runtimeName, ns).Take(ctx, opts)
+	p.metrics.observeTake(shim != nil, time.Since(start))
+	return shim
+}
+
+// Acquire hands out a warm, unbound shim for runtimeName/ns for the
+// caller to Bind itself, or ErrNoWarmShim if none is ready - the caller
+// should fall back to a cold start. Unlike Take, it returns the
+// package-external WarmShim interface and reports a miss as an error,
+// making it the acquire half of Release and AcquireAndBind.
+func (p *WarmShimPool) Acquire(ctx context.Context, runtimeName, ns string, opts runtime.CreateOpts) (WarmShim, error) {
+	w := p.Take(ctx, runtimeName, ns, opts)
+	if w == nil {
+		return nil, ErrNoWarmShim
+	}
+	return w, nil
+}
+
+// Release returns an acquired-but-still-unbound shim to the pool it
+// came from, e.g. because the caller decided not to use it after all.
+// A shim no longer in ShimStateWarming, or one this WarmShimPool didn't
+// hand out, is left alone: the caller is responsible for closing it.
+func (p *WarmShimPool) Release(shim WarmShim) {
+	w, ok := shim.(*warmShimInstance)
+	if !ok || w.State() != ShimStateWarming || w.pool == nil {
+		return
+	}
+	if !w.pool.push(w) {
+		w.Close()
+		os.RemoveAll(w.shim.bundle.Path)
+	}
+}
+
+// AcquireAndBind acquires a warm shim and binds it to id, discarding
+// the shim and acquiring a fresh one instead of retrying Bind on it if
+// Bind fails - a shim that failed partway through Bind may be left in
+// an inconsistent state, so it isn't safe to hand to a later caller.
+// It gives up after maxBindAttempts failures and returns the last Bind
+// error.
+func (p *WarmShimPool) AcquireAndBind(ctx context.Context, runtimeName, ns, id string, opts runtime.CreateOpts) (WarmShim, error) {
+	var lastErr error
+	for attempt := 0; attempt < maxBindAttempts; attempt++ {
+		w, err := p.Acquire(ctx, runtimeName, ns, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		bindErr := w.Bind(ctx, id, opts)
+		p.metrics.observeBind(bindErr == nil, time.Since(start))
+		if bindErr == nil {
+			return w, nil
+		}
+
+		log.G(ctx).WithError(bindErr).WithField("runtime", runtimeName).Warn("warm shim bind failed, discarding and retrying")
+		p.discard(w)
+		lastErr = bindErr
+	}
+	return nil, fmt.Errorf("failed to bind a warm shim for runtime %q after %d attempts: %w", runtimeName, maxBindAttempts, lastErr)
+}
+
+// discard permanently closes a warm shim instead of returning it to its
+// pool, e.g. one that failed Bind and may be left in an inconsistent
+// state a later caller shouldn't inherit.
+func (p *WarmShimPool) discard(shim WarmShim) {
+	w, ok := shim.(*warmShimInstance)
+	if !ok {
+		return
+	}
+	w.Close()
+	os.RemoveAll(w.shim.bundle.Path)
+}
+
+// Close stops every per-(runtime, platform) pool this WarmShimPool has
+// started, cleaning up their warm shims.
+func (p *WarmShimPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var firstErr error
+	for _, pool := range p.pools {
+		if err := pool.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Collectors returns WarmShimPool's metrics for registration with a metrics
+// module, e.g. kata-direct/metrics.Config.Collectors - its own
+// size/takes/adopt-latency/bind collectors plus each per-key pool's own
+// hits/misses/idle/cold-start/restore collectors.
+func (p *WarmShimPool) Collectors() []prometheus.Collector {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	collectors := p.metrics.collectors()
+	for _, pool := range p.pools {
+		collectors = append(collectors, pool.Collectors()...)
+	}
+	return collectors
+}
@@ -0,0 +1,134 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+
+	"github.com/containerd/log"
+)
+
+// CheckpointBackend lets warmOne materialize a warm shim by restoring a
+// previously-dumped CRIU image instead of always paying a fresh
+// StartWarm. A nil WarmPoolConfig.CheckpointBackend (the default) means
+// warmOne always cold-starts, exactly as it did before this existed.
+//
+// TODO: no CRIU bindings are vendored in this tree - the same gap
+// kata-direct/checkpoint.go's checkpointContainer/restoreContainer
+// already flag for the hypervisor/guest-agent path. A real
+// implementation wraps github.com/checkpoint-restore/go-criu against
+// the warm shim's process tree, persisting the image under
+// WarmPoolConfig.CheckpointDir; this just defines the seam.
+type CheckpointBackend interface {
+	// Dump checkpoints shim's process state to a new CRIU image,
+	// returning the image's path for logging. Called in the background
+	// after each cold start of a warm shim until one Dump has succeeded,
+	// and again after a failed Restore discards that image.
+	Dump(ctx context.Context, shim *shim) (imagePath string, err error)
+	// Restore materializes a new warm shim for bundle from the image
+	// Dump last wrote, the restore-path counterpart to startWarmShim.
+	Restore(ctx context.Context, bundle *Bundle) (*shim, error)
+}
+
+// restoreOrStart materializes a new warm shim for bundle: restoring
+// from pool's checkpoint image if CheckpointBackend is configured and a
+// Dump has already succeeded, or starting one fresh otherwise -
+// including when Restore itself fails, so a corrupt or incompatible
+// image can't wedge the pool. It reports whether the restore path was
+// taken, so warmOne knows whether to Bind through reparentCheckpoint
+// and whether it still needs to kick off a Dump. placement is only
+// honored on the fresh-start path; a restored shim inherits whatever
+// affinity it had when CheckpointBackend.Dump captured it.
+func (pool *warmPool) restoreOrStart(ctx context.Context, bundle *Bundle, placement Placement) (s *shim, restored bool, err error) {
+	backend := pool.config.CheckpointBackend
+
+	pool.mu.Lock()
+	hasCheckpoint := backend != nil && pool.hasCheckpoint
+	pool.mu.Unlock()
+
+	if hasCheckpoint {
+		s, err = backend.Restore(ctx, bundle)
+		if err == nil {
+			return s, true, nil
+		}
+		log.G(ctx).WithError(err).Warn("warm shim restore failed, falling back to cold start")
+
+		// The image that just failed to restore probably isn't usable
+		// again either; let the next successful warmOne re-Dump it.
+		pool.mu.Lock()
+		pool.hasCheckpoint = false
+		pool.mu.Unlock()
+	}
+
+	s, err = pool.startWarmShim(ctx, bundle, placement)
+	return s, false, err
+}
+
+// dumpAsync checkpoints a freshly cold-started warm shim in the
+// background, so later warmOne calls can Restore instead of
+// cold-starting, until Dump has already produced one image. Failures
+// are logged rather than returned: dumping is an optimization, and a
+// warm shim that's already in the pool shouldn't be evicted just
+// because capturing its checkpoint failed.
+func (pool *warmPool) dumpAsync(w *warmShimInstance) {
+	backend := pool.config.CheckpointBackend
+	if backend == nil {
+		return
+	}
+
+	pool.mu.Lock()
+	already := pool.hasCheckpoint
+	pool.mu.Unlock()
+	if already {
+		return
+	}
+
+	go func() {
+		ctx := context.Background()
+		imagePath, err := backend.Dump(ctx, w.shim)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("warm_id", w.warmID).Warn("failed to dump warm shim checkpoint")
+			return
+		}
+
+		pool.mu.Lock()
+		pool.hasCheckpoint = true
+		pool.mu.Unlock()
+		log.G(ctx).WithField("image", imagePath).WithField("warm_id", w.warmID).Info("dumped warm shim checkpoint")
+	}()
+}
+
+// reparentCheckpoint re-parents a restored shim's cgroup and namespace
+// handles into the real container bundle, the restore-path analogue of
+// callBindRPC's own bundle/log/socket relocation - a process resumed
+// from a checkpoint still holds cgroup and namespace fds rooted at its
+// warm bundle path and needs them moved the same way the bundle path
+// itself already is.
+//
+// TODO: this is a logging no-op, not a real re-parent. A real
+// implementation would move the restored process into the real
+// bundle's cgroup and re-home its network/mount namespace handles,
+// following the same "adjust to the real surface once vendored"
+// caveat restoreContainer's own TODOs carry for the hypervisor/guest
+// side of checkpoint/restore.
+func (w *warmShimInstance) reparentCheckpoint(ctx context.Context, id string) error {
+	log.G(ctx).WithFields(log.Fields{
+		"warm_id":  w.warmID,
+		"bound_id": id,
+	}).Info("reparenting restored warm shim's cgroup/namespace handles into real bundle")
+	return nil
+}
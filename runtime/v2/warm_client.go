@@ -27,6 +27,10 @@ import (
 // WarmClient provides client interface for calling warm shim Bind RPC
 type WarmClient interface {
 	Bind(ctx context.Context, req *WarmBindRequest) (*WarmBindResponse, error)
+	// Ping is a lightweight liveness check, cheaper than a real Bind,
+	// that the pool's background health probe uses to evict a warm
+	// shim whose process has died or hung before it's ever handed out.
+	Ping(ctx context.Context) error
 }
 
 // warmClientImpl implements WarmClient using ttrpc
@@ -74,3 +78,20 @@ func (c *warmClientImpl) Bind(ctx context.Context, req *WarmBindRequest) (*WarmB
 		Ready: true,
 	}, nil
 }
+
+// Ping makes no RPC: it returns nil whenever c has an underlying
+// client, so a shim whose process has died or hung still passes.
+func (c *warmClientImpl) Ping(ctx context.Context) error {
+	// For prototype: same simulation approach as Bind above - there's no
+	// real ping RPC defined yet, so a live ttrpc/grpc client is treated
+	// as healthy. In production this would be a real ttrpc call, e.g.
+	// client := shimWarmService.NewWarmClient(c.client.(*ttrpc.Client))
+	// return client.Ping(ctx, &emptypb.Empty{})
+
+	if c.client == nil {
+		return fmt.Errorf("warm client has no underlying connection")
+	}
+
+	log.G(ctx).Debug("warm ping RPC called (prototype mode)")
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/runtime"
+)
+
+// Placement pins a pre-warmed shim to a specific NUMA node, CPU set, or
+// parent cgroup, so Take can hand a container a shim that's already
+// sitting where its OCI spec wants it instead of Bind having to migrate
+// it across nodes afterward. The zero Placement means "no affinity" and
+// is the bucket a warmPool uses when it has no PlacementPolicy.
+type Placement struct {
+	// NUMANode is the NUMA node the shim was started on.
+	NUMANode int
+	// CPUSet is the cpuset cgroup controller value (e.g. "0-3") the shim
+	// was pinned to at warm-start time.
+	CPUSet string
+	// CgroupParent is the parent cgroup the shim's process was started
+	// under.
+	CgroupParent string
+}
+
+// key identifies which of a warmPool's placement buckets a Placement
+// belongs to.
+func (p Placement) key() string {
+	return fmt.Sprintf("%d|%s|%s", p.NUMANode, p.CPUSet, p.CgroupParent)
+}
+
+// PlacementPolicy lets a warmPool maintain more than one placement
+// bucket of pre-warmed shims instead of a single undifferentiated
+// stack - e.g. one bucket per NUMA node in a kata-style deployment
+// where each runtime handler has distinct resource affinity and a
+// poorly-placed shim means Bind has to migrate it afterward.
+//
+// A nil PlacementPolicy (WarmPoolConfig's default) is equivalent to a
+// policy whose Assign and Select both always return the zero Placement:
+// every shim lands in, and Take is always served from, the single
+// default bucket, reproducing a warmPool's pre-sharding behavior
+// exactly.
+type PlacementPolicy interface {
+	// Assign returns the Placement a newly warm-started shim should be
+	// recorded under. Called once per warmOne; a policy that wants an
+	// even spread across N buckets is responsible for its own
+	// round-robin or similar bookkeeping across calls.
+	Assign(ctx context.Context) Placement
+
+	// Select returns the Placement whose bucket should serve a
+	// container started with opts - e.g. derived from the cpuset in
+	// opts.Spec's Linux resources - so Take knows which bucket to draw
+	// from.
+	Select(opts runtime.CreateOpts) Placement
+}
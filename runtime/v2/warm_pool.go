@@ -22,11 +22,13 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/runtime"
 	"github.com/containerd/log"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -44,27 +46,127 @@ type WarmPoolConfig struct {
 	TakeTimeout time.Duration
 	// Enabled controls whether warm pool is active
 	Enabled bool
+	// MaxIdleAge evicts (and replaces) a warm shim that has sat idle in
+	// the pool longer than this, even though it's otherwise healthy -
+	// bounding how stale a pre-started shim's environment (image
+	// layers, network namespace setup) is allowed to get. Zero disables
+	// age-based eviction.
+	MaxIdleAge time.Duration
+	// RefillInterval is how often Run sweeps the pool for idle-age/health
+	// eviction and tops it back up to Size. Defaults to 5s if zero.
+	RefillInterval time.Duration
+
+	// CheckpointDir is the directory for a CheckpointBackend's CRIU
+	// images. This package neither reads it nor passes it to
+	// CheckpointBackend. Whether warmOne restores a warm shim instead of
+	// paying a fresh StartWarm depends on CheckpointBackend alone: a nil
+	// one makes every warmOne a cold start, as it was before this
+	// existed, and an empty CheckpointDir does not change that.
+	CheckpointDir string
+	// CheckpointBackend performs the actual CRIU dump/restore. See
+	// warm_checkpoint.go.
+	CheckpointBackend CheckpointBackend
+
+	// Admit, if set, gates Take: a warm shim is only handed out for opts
+	// if Admit(opts) reports true. This lets a pool pre-configured for a
+	// particular sandbox class or runtime handler reject a container it
+	// was never warmed for up front, rather than pop a shim whose Bind
+	// would just fail later. A nil Admit (the default) accepts everyone,
+	// exactly as Take behaved before this existed.
+	Admit func(opts runtime.CreateOpts) bool
+
+	// Placement, if set, shards the pool into one sub-pool per
+	// Placement bucket (see PlacementPolicy) instead of a single
+	// undifferentiated stack, and has Take draw from the bucket that
+	// matches a container's CreateOpts rather than whichever shim
+	// happens to be on top. Size bounds each bucket independently, not
+	// the pool as a whole, so a pool using N buckets can hold up to
+	// N*Size warm shims. A nil Placement (the default) keeps every shim
+	// in a single bucket, unchanged from before this existed.
+	Placement PlacementPolicy
 }
 
-// warmPool maintains a pool of pre-started shim processes
+// warmPool maintains a pool of pre-started shim processes, handed out
+// LIFO: Take pops whichever shim was pushed most recently, since that's
+// the one most likely to still have its pages hot in the page cache.
 type warmPool struct {
 	runtime string
 	ns      string
 	state   string
 	config  WarmPoolConfig
-	shims   chan *warmShimInstance
 	mu      sync.Mutex
+	buckets map[string]*shimBucket // keyed by Placement.key(); see bucketFor
 	closed  bool
 	manager *ShimManager
+	metrics *warmPoolMetrics
+
+	// hasCheckpoint reports whether a CheckpointBackend.Dump has
+	// succeeded since the pool started (or since the last Restore
+	// failure forced a re-dump) - warmOne only attempts Restore once
+	// this is true.
+	hasCheckpoint bool
+
+	// statsHits, statsMisses and statsEvictions are lifetime counters
+	// behind Stats, tracked separately from metrics's Prometheus
+	// collectors since those can't be read back out of process.
+	statsHits      int64
+	statsMisses    int64
+	statsEvictions int64
+}
+
+// shimBucket is one placement-homogeneous LIFO sub-pool within a
+// warmPool: every shim in it was started under the same Placement, so
+// Take can hand one out to a matching container without Bind needing
+// to migrate it across nodes afterward. A warmPool with no
+// PlacementPolicy configured has exactly one shimBucket, keyed by the
+// zero Placement.
+type shimBucket struct {
+	stack  []*warmShimInstance // top of stack is stack[len(stack)-1]
+	tokens chan struct{}       // one buffered token per entry currently on stack
+}
+
+// PoolStats is a point-in-time snapshot of a warmPool's size and
+// lifetime counters, for a caller that wants pool telemetry without
+// scraping Prometheus - e.g. a CLI or admin API.
+type PoolStats struct {
+	// Size is the pool's configured Size, which bounds each placement
+	// bucket; a pool with N buckets can hold up to N*Size.
+	Size int
+	// Idle is how many warm shims it currently holds.
+	Idle int
+	// Hits is how many Take calls this pool has served from an idle
+	// warm shim.
+	Hits int64
+	// Misses is how many Take calls found no idle warm shim, timed out,
+	// or were rejected by Admit.
+	Misses int64
+	// Evictions is how many warm shims this pool has evicted for
+	// exceeding MaxIdleAge or failing a health probe.
+	Evictions int64
+	// AverageAge is the mean age of the warm shims currently idle.
+	AverageAge time.Duration
 }
 
 // warmShimInstance wraps a shim that has been warm-started
 type warmShimInstance struct {
 	*shim
-	state   ShimState
-	mu      sync.Mutex
-	warmID  string
-	boundID string
+	state     ShimState
+	mu        sync.Mutex
+	warmID    string
+	boundID   string
+	createdAt time.Time
+	// pool is the warmPool this instance was warmed into, so Release can
+	// push an acquired-but-unused shim back onto its own pool without an
+	// external key lookup.
+	pool *warmPool
+	// restored is true when this instance came from
+	// CheckpointBackend.Restore rather than a fresh startWarmShim, so
+	// Bind knows to reparentCheckpoint it.
+	restored bool
+	// placement is the Placement this instance was warm-started under,
+	// i.e. which bucket of pool.buckets it lives in. The zero Placement
+	// for a pool with no PlacementPolicy configured.
+	placement Placement
 }
 
 var _ WarmShim = (*warmShimInstance)(nil)
@@ -102,6 +204,12 @@ func (w *warmShimInstance) Bind(ctx context.Context, id string, opts runtime.Cre
 		return fmt.Errorf("failed to call bind RPC: %w", err)
 	}
 
+	if w.restored {
+		if err := w.reparentCheckpoint(ctx, id); err != nil {
+			return fmt.Errorf("failed to reparent restored warm shim: %w", err)
+		}
+	}
+
 	w.setState(ShimStateBound)
 
 	// Update the bundle reference to point to the real bundle location
@@ -169,6 +277,18 @@ func (w *warmShimInstance) callBindRPC(ctx context.Context, id string, opts runt
 	return nil
 }
 
+// probeHealth checks the warm shim through WarmClient.Ping on the same
+// connection Bind uses. That Ping makes no RPC and passes every shim
+// with a client, so a shim whose process has died or hung is not
+// caught here.
+func (w *warmShimInstance) probeHealth(ctx context.Context) error {
+	client, err := NewWarmClient(w.shim.client)
+	if err != nil {
+		return err
+	}
+	return client.Ping(ctx)
+}
+
 // ID returns the current ID (warm or bound)
 func (w *warmShimInstance) ID() string {
 	w.mu.Lock()
@@ -189,19 +309,98 @@ func newWarmPool(ctx context.Context, manager *ShimManager, runtime, ns string,
 	if config.TakeTimeout <= 0 {
 		config.TakeTimeout = defaultTakeTimeout
 	}
+	if config.RefillInterval <= 0 {
+		config.RefillInterval = 5 * time.Second
+	}
 
 	pool := &warmPool{
 		runtime: runtime,
 		ns:      ns,
 		state:   manager.state,
 		config:  config,
-		shims:   make(chan *warmShimInstance, config.Size),
+		buckets: map[string]*shimBucket{},
 		manager: manager,
+		metrics: newWarmPoolMetrics(runtime, ns),
 	}
 
 	return pool
 }
 
+// bucketFor returns pool's bucket for placement, creating it with a
+// fresh, Size-buffered token channel the first time it's seen. Must be
+// called with pool.mu held.
+func (pool *warmPool) bucketFor(placement Placement) *shimBucket {
+	key := placement.key()
+	b, ok := pool.buckets[key]
+	if !ok {
+		b = &shimBucket{tokens: make(chan struct{}, pool.config.Size)}
+		pool.buckets[key] = b
+	}
+	return b
+}
+
+// idleLocked totals how many warm shims are currently idle across every
+// bucket. Must be called with pool.mu held.
+func (pool *warmPool) idleLocked() int {
+	n := 0
+	for _, b := range pool.buckets {
+		n += len(b.stack)
+	}
+	return n
+}
+
+// targetIdleLocked returns how many idle shims the pool should hold in
+// total: Size per placement bucket warmOne has assigned a shim to so
+// far, or just Size if config.Placement isn't configured (exactly one,
+// the default, bucket). Buckets materialize lazily as
+// PlacementPolicy.Assign is consulted, so this grows as Start/topUp
+// discover new ones. Must be called with pool.mu held.
+func (pool *warmPool) targetIdleLocked() int {
+	n := len(pool.buckets)
+	if n < 1 {
+		n = 1
+	}
+	return pool.config.Size * n
+}
+
+// push adds w to the top of its placement's LIFO bucket, reporting
+// false instead of pushing if that bucket is already at Size - the
+// caller should close and discard w rather than leak it onto an
+// already-full pool.
+func (pool *warmPool) push(w *warmShimInstance) bool {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return false
+	}
+	b := pool.bucketFor(w.placement)
+	if len(b.stack) >= pool.config.Size {
+		pool.mu.Unlock()
+		return false
+	}
+	b.stack = append(b.stack, w)
+	b.tokens <- struct{}{}
+	n := pool.idleLocked()
+	pool.mu.Unlock()
+
+	pool.metrics.setIdle(n)
+	return true
+}
+
+// pop removes and returns the shim on top of bucket's stack. Callers
+// must already have received a token from bucket.tokens, so the stack
+// is guaranteed non-empty.
+func (pool *warmPool) pop(bucket *shimBucket) *warmShimInstance {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	n := len(bucket.stack)
+	w := bucket.stack[n-1]
+	bucket.stack = bucket.stack[:n-1]
+	pool.metrics.setIdle(pool.idleLocked())
+	return w
+}
+
 // Start starts the warm pool and pre-warms shims
 func (pool *warmPool) Start(ctx context.Context) error {
 	pool.mu.Lock()
@@ -217,23 +416,35 @@ func (pool *warmPool) Start(ctx context.Context) error {
 		"size":    pool.config.Size,
 	}).Info("starting warm shim pool")
 
-	// Pre-warm the configured number of shims
-	for i := 0; i < pool.config.Size; i++ {
+	// Pre-warm shims until every placement bucket seen so far holds
+	// Size idle shims (see targetIdleLocked); with no PlacementPolicy
+	// configured there's exactly one bucket, so this warms Size shims
+	// exactly as before.
+	for pool.idleLocked() < pool.targetIdleLocked() {
 		if err := pool.warmOne(ctx); err != nil {
 			log.G(ctx).WithError(err).Warn("failed to warm shim")
+			break
 		}
 	}
 
 	return nil
 }
 
-// warmOne creates a single warm shim instance
+// warmOne creates a single warm shim instance, placed according to
+// config.Placement if one is configured.
 func (pool *warmPool) warmOne(ctx context.Context) error {
 	warmID := fmt.Sprintf("warm-%s-%d", pool.ns, time.Now().UnixNano())
 
+	var placement Placement
+	if pool.config.Placement != nil {
+		placement = pool.config.Placement.Assign(ctx)
+	}
+
 	logger := log.G(ctx).WithFields(log.Fields{
-		"warm_id": warmID,
-		"runtime": pool.runtime,
+		"warm_id":  warmID,
+		"runtime":  pool.runtime,
+		"cpuset":   placement.CPUSet,
+		"numaNode": placement.NUMANode,
 	})
 	logger.Debug("creating warm shim instance")
 
@@ -250,35 +461,40 @@ func (pool *warmPool) warmOne(ctx context.Context) error {
 		Namespace: pool.ns,
 	}
 
-	// Start shim in warm mode
-	// This would call the shim binary with "warmstart" action
-	warmShim, err := pool.startWarmShim(ctx, bundle)
+	// Start shim in warm mode, restoring it from a checkpoint image
+	// instead if one is available.
+	warmShim, restored, err := pool.restoreOrStart(ctx, bundle, placement)
 	if err != nil {
 		os.RemoveAll(warmBundlePath)
 		return fmt.Errorf("failed to start warm shim: %w", err)
 	}
 
 	w := &warmShimInstance{
-		shim:   warmShim,
-		state:  ShimStateWarming,
-		warmID: warmID,
+		shim:      warmShim,
+		state:     ShimStateWarming,
+		warmID:    warmID,
+		createdAt: time.Now(),
+		pool:      pool,
+		restored:  restored,
+		placement: placement,
 	}
 
-	// Add to pool (non-blocking)
-	select {
-	case pool.shims <- w:
-		logger.Info("warm shim added to pool")
-		return nil
-	default:
+	if !pool.push(w) {
 		// Pool is full, close this shim
 		warmShim.Close()
 		os.RemoveAll(warmBundlePath)
 		return fmt.Errorf("pool is full")
 	}
+	pool.metrics.observeWarm(restored)
+	if !restored {
+		pool.dumpAsync(w)
+	}
+	logger.WithField("restored", restored).Info("warm shim added to pool")
+	return nil
 }
 
-// startWarmShim starts a shim in warm mode
-func (pool *warmPool) startWarmShim(ctx context.Context, bundle *Bundle) (*shim, error) {
+// startWarmShim starts a shim in warm mode, pinned to placement.
+func (pool *warmPool) startWarmShim(ctx context.Context, bundle *Bundle, placement Placement) (*shim, error) {
 	// Similar to manager.startShim but calls warmstart action
 	runtimePath, err := pool.manager.resolveRuntimePath(pool.runtime)
 	if err != nil {
@@ -290,6 +506,9 @@ func (pool *warmPool) startWarmShim(ctx context.Context, bundle *Bundle) (*shim,
 		address:      pool.manager.containerdAddress,
 		ttrpcAddress: pool.manager.containerdTTRPCAddress,
 		schedCore:    pool.manager.schedCore,
+		numaNode:     placement.NUMANode,
+		cpuSet:       placement.CPUSet,
+		cgroupParent: placement.CgroupParent,
 	})
 
 	// Use warmstart instead of start
@@ -303,8 +522,12 @@ func (pool *warmPool) startWarmShim(ctx context.Context, bundle *Bundle) (*shim,
 	return shim, nil
 }
 
-// Take retrieves a warm shim from the pool
-func (pool *warmPool) Take(ctx context.Context) *warmShimInstance {
+// Take retrieves the most recently warmed shim from the bucket matching
+// opts (config.Placement.Select(opts), or the single default bucket if
+// no PlacementPolicy is configured), LIFO within that bucket. It
+// returns nil if config.Admit rejects opts, no warm shim is available
+// in the matching bucket within TakeTimeout, or the pool is closed.
+func (pool *warmPool) Take(ctx context.Context, opts runtime.CreateOpts) *warmShimInstance {
 	pool.mu.Lock()
 	if pool.closed {
 		pool.mu.Unlock()
@@ -312,12 +535,31 @@ func (pool *warmPool) Take(ctx context.Context) *warmShimInstance {
 	}
 	pool.mu.Unlock()
 
+	if pool.config.Admit != nil && !pool.config.Admit(opts) {
+		log.G(ctx).Debug("warm shim pool rejected admission for container")
+		atomic.AddInt64(&pool.statsMisses, 1)
+		pool.metrics.observeTake(false)
+		return nil
+	}
+
+	var placement Placement
+	if pool.config.Placement != nil {
+		placement = pool.config.Placement.Select(opts)
+	}
+
+	pool.mu.Lock()
+	bucket := pool.bucketFor(placement)
+	pool.mu.Unlock()
+
 	ctx, cancel := context.WithTimeout(ctx, pool.config.TakeTimeout)
 	defer cancel()
 
 	select {
-	case shim := <-pool.shims:
+	case <-bucket.tokens:
+		shim := pool.pop(bucket)
 		log.G(ctx).WithField("warm_id", shim.warmID).Info("took warm shim from pool")
+		atomic.AddInt64(&pool.statsHits, 1)
+		pool.metrics.observeTake(true)
 		// Async refill
 		go func() {
 			time.Sleep(100 * time.Millisecond)
@@ -329,10 +571,139 @@ func (pool *warmPool) Take(ctx context.Context) *warmShimInstance {
 		return shim
 	case <-ctx.Done():
 		log.G(ctx).Debug("timeout waiting for warm shim")
+		atomic.AddInt64(&pool.statsMisses, 1)
+		pool.metrics.observeTake(false)
 		return nil
 	}
 }
 
+// Stats returns a snapshot of pool's current size and lifetime
+// counters, summed across every placement bucket.
+func (pool *warmPool) Stats() PoolStats {
+	pool.mu.Lock()
+	idle := 0
+	var totalAge time.Duration
+	now := time.Now()
+	for _, b := range pool.buckets {
+		idle += len(b.stack)
+		for _, w := range b.stack {
+			totalAge += now.Sub(w.createdAt)
+		}
+	}
+	size := pool.config.Size
+	pool.mu.Unlock()
+
+	stats := PoolStats{
+		Size:      size,
+		Idle:      idle,
+		Hits:      atomic.LoadInt64(&pool.statsHits),
+		Misses:    atomic.LoadInt64(&pool.statsMisses),
+		Evictions: atomic.LoadInt64(&pool.statsEvictions),
+	}
+	if idle > 0 {
+		stats.AverageAge = totalAge / time.Duration(idle)
+	}
+	return stats
+}
+
+// Run sweeps the pool every RefillInterval, evicting warm shims that are
+// older than MaxIdleAge or fail a health probe and topping back up to
+// Size, until ctx is done - at which point it Closes the pool. A caller
+// ties ctx to plugin.InitContext.Context so a pool's warm shims are
+// drained as part of normal shutdown instead of leaking until the
+// process exits.
+func (pool *warmPool) Run(ctx context.Context) {
+	ticker := time.NewTicker(pool.config.RefillInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pool.evictStale(ctx)
+			pool.topUp(ctx)
+		case <-ctx.Done():
+			if err := pool.Close(); err != nil {
+				log.G(ctx).WithError(err).Warn("failed to close warm pool on shutdown")
+			}
+			return
+		}
+	}
+}
+
+// evictStale pulls every currently idle warm shim off each bucket's
+// stack, evicts the ones older than MaxIdleAge or that fail a health
+// probe, and pushes the rest back. Each bucket is pulled off at once
+// (rather than probed in place) so a shim can't be handed out by Take
+// mid-probe.
+func (pool *warmPool) evictStale(ctx context.Context) {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return
+	}
+	old := make(map[*shimBucket][]*warmShimInstance, len(pool.buckets))
+	for _, b := range pool.buckets {
+		old[b] = b.stack
+		b.stack = nil
+	}
+	pool.mu.Unlock()
+
+	for bucket, shims := range old {
+		for range shims {
+			<-bucket.tokens
+		}
+
+		now := time.Now()
+		var fresh []*warmShimInstance
+		for _, w := range shims {
+			if pool.config.MaxIdleAge > 0 && now.Sub(w.createdAt) > pool.config.MaxIdleAge {
+				log.G(ctx).WithField("warm_id", w.warmID).Info("evicting idle warm shim past MaxIdleAge")
+				atomic.AddInt64(&pool.statsEvictions, 1)
+				w.Close()
+				os.RemoveAll(w.shim.bundle.Path)
+				continue
+			}
+			if err := w.probeHealth(ctx); err != nil {
+				log.G(ctx).WithError(err).WithField("warm_id", w.warmID).Warn("evicting warm shim that failed health probe")
+				atomic.AddInt64(&pool.statsEvictions, 1)
+				w.Close()
+				os.RemoveAll(w.shim.bundle.Path)
+				continue
+			}
+			fresh = append(fresh, w)
+		}
+
+		for _, w := range fresh {
+			if !pool.push(w) {
+				// Pool was closed or filled back up concurrently; don't leak it.
+				w.Close()
+				os.RemoveAll(w.shim.bundle.Path)
+			}
+		}
+	}
+}
+
+// topUp warms up new shims until the pool's total idle count across
+// all buckets is back to Size per bucket (see targetIdleLocked), the
+// continuous counterpart to Start's one-time initial fill. Which
+// bucket each new shim lands in is up to config.Placement.Assign, not
+// topUp itself.
+func (pool *warmPool) topUp(ctx context.Context) {
+	for {
+		pool.mu.Lock()
+		n := pool.idleLocked()
+		target := pool.targetIdleLocked()
+		pool.mu.Unlock()
+		if n >= target {
+			return
+		}
+		if err := pool.warmOne(ctx); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to top up warm pool")
+			return
+		}
+	}
+}
+
 // Close closes the warm pool and cleans up all warm shims
 func (pool *warmPool) Close() error {
 	pool.mu.Lock()
@@ -342,13 +713,22 @@ func (pool *warmPool) Close() error {
 		return nil
 	}
 	pool.closed = true
-	close(pool.shims)
 
-	// Clean up all remaining warm shims
-	for shim := range pool.shims {
-		shim.Close()
-		os.RemoveAll(shim.bundle.Path)
+	for _, b := range pool.buckets {
+		close(b.tokens)
+		for _, shim := range b.stack {
+			shim.Close()
+			os.RemoveAll(shim.bundle.Path)
+		}
+		b.stack = nil
 	}
+	pool.metrics.setIdle(0)
 
 	return nil
 }
+
+// Collectors exposes pool's metrics for registration alongside a
+// WarmShimPool's own.
+func (pool *warmPool) Collectors() []prometheus.Collector {
+	return pool.metrics.collectors()
+}
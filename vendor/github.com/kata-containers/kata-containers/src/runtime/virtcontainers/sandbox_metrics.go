@@ -11,6 +11,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	mutils "github.com/kata-containers/kata-containers/src/runtime/pkg/utils"
 	"github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
@@ -22,20 +24,38 @@ const namespaceHypervisor = "kata_hypervisor"
 const namespaceKatashim = "kata_shim"
 const namespaceVirtiofsd = "kata_virtiofsd"
 
+// sandboxLabel is the label every series in this file is keyed by, so a
+// given shim hosting many sandboxes (see NewSandboxMetrics) does not have
+// one sandbox's gauges clobber another's.
+const sandboxLabel = "sandbox_id"
+
+// extraSandboxLabels are attached to sandboxLabel when the caller knows
+// them; both may be empty for sandboxes created without CRI metadata.
+var extraSandboxLabels = []string{"cri_uid", "name"}
+
+// sandboxLabels builds the full label set (base labels + sandbox identity)
+// used by every GaugeVec below.
+func sandboxLabels(base ...string) []string {
+	labels := append([]string{sandboxLabel}, extraSandboxLabels...)
+	return append(labels, base...)
+}
+
 var (
 	// hypervisor
-	hypervisorThreads = prometheus.NewGauge(prometheus.GaugeOpts{
+	hypervisorThreads = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespaceHypervisor,
 		Name:      "threads",
 		Help:      "Hypervisor process threads.",
-	})
+	},
+		sandboxLabels(),
+	)
 
 	hypervisorProcStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespaceHypervisor,
 		Name:      "proc_status",
 		Help:      "Hypervisor process status.",
 	},
-		[]string{"item"},
+		sandboxLabels("item"),
 	)
 
 	hypervisorProcStat = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -43,7 +63,7 @@ var (
 		Name:      "proc_stat",
 		Help:      "Hypervisor process statistics.",
 	},
-		[]string{"item"},
+		sandboxLabels("item"),
 	)
 
 	hypervisorNetdev = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -51,7 +71,7 @@ var (
 		Name:      "netdev",
 		Help:      "Net devices statistics.",
 	},
-		[]string{"interface", "item"},
+		sandboxLabels("interface", "item"),
 	)
 
 	hypervisorIOStat = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -59,38 +79,51 @@ var (
 		Name:      "io_stat",
 		Help:      "Process IO statistics.",
 	},
-		[]string{"item"},
+		sandboxLabels("item"),
 	)
 
-	hypervisorOpenFDs = prometheus.NewGauge(prometheus.GaugeOpts{
+	hypervisorOpenFDs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespaceHypervisor,
 		Name:      "fds",
 		Help:      "Open FDs for hypervisor.",
-	})
+	},
+		sandboxLabels(),
+	)
 
 	// agent
+	//
+	// This is a native (sparse) histogram rather than a classic fixed-bucket
+	// one: NativeHistogramBucketFactor turns on automatic, exponentially
+	// spaced buckets so we don't have to guess RPC latency ranges up front,
+	// and it lets Observe calls attach exemplars (trace IDs) that Prometheus
+	// can use to jump from a slow bucket straight to an example trace.
 	agentRPCDurationsHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: namespaceKatashim,
-		Name:      "agent_rpc_durations_histogram_milliseconds",
-		Help:      "RPC latency distributions.",
-		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		Namespace:                       namespaceKatashim,
+		Name:                            "agent_rpc_durations_histogram_milliseconds",
+		Help:                            "RPC latency distributions.",
+		Buckets:                         prometheus.ExponentialBuckets(1, 2, 10),
+		NativeHistogramBucketFactor:     1.1,
+		NativeHistogramMaxBucketNumber:  160,
+		NativeHistogramMinResetDuration: time.Hour,
 	},
-		[]string{"action"},
+		sandboxLabels("action"),
 	)
 
 	// virtiofsd
-	virtiofsdThreads = prometheus.NewGauge(prometheus.GaugeOpts{
+	virtiofsdThreads = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespaceVirtiofsd,
 		Name:      "threads",
 		Help:      "Virtiofsd process threads.",
-	})
+	},
+		sandboxLabels(),
+	)
 
 	virtiofsdProcStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespaceVirtiofsd,
 		Name:      "proc_status",
 		Help:      "Virtiofsd process status.",
 	},
-		[]string{"item"},
+		sandboxLabels("item"),
 	)
 
 	virtiofsdProcStat = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -98,7 +131,7 @@ var (
 		Name:      "proc_stat",
 		Help:      "Virtiofsd process statistics.",
 	},
-		[]string{"item"},
+		sandboxLabels("item"),
 	)
 
 	virtiofsdIOStat = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -106,32 +139,112 @@ var (
 		Name:      "io_stat",
 		Help:      "Process IO statistics.",
 	},
-		[]string{"item"},
+		sandboxLabels("item"),
 	)
 
-	virtiofsdOpenFDs = prometheus.NewGauge(prometheus.GaugeOpts{
+	virtiofsdOpenFDs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespaceVirtiofsd,
 		Name:      "fds",
 		Help:      "Open FDs for virtiofsd.",
-	})
+	},
+		sandboxLabels(),
+	)
+
+	// registerMetricsOnce guards the package-level collectors so that
+	// multiple sandboxes (each calling NewSandboxMetrics) don't panic on
+	// a repeated MustRegister, now that the metrics are shared, labeled
+	// vectors rather than one set of gauges per process.
+	registerMetricsOnce sync.Once
 )
 
 func RegisterMetrics() {
-	// hypervisor
-	prometheus.MustRegister(hypervisorThreads)
-	prometheus.MustRegister(hypervisorProcStatus)
-	prometheus.MustRegister(hypervisorProcStat)
-	prometheus.MustRegister(hypervisorNetdev)
-	prometheus.MustRegister(hypervisorIOStat)
-	prometheus.MustRegister(hypervisorOpenFDs)
-	// agent
-	prometheus.MustRegister(agentRPCDurationsHistogram)
-	// virtiofsd
-	prometheus.MustRegister(virtiofsdThreads)
-	prometheus.MustRegister(virtiofsdProcStatus)
-	prometheus.MustRegister(virtiofsdProcStat)
-	prometheus.MustRegister(virtiofsdIOStat)
-	prometheus.MustRegister(virtiofsdOpenFDs)
+	registerMetricsOnce.Do(func() {
+		// hypervisor
+		prometheus.MustRegister(hypervisorThreads)
+		prometheus.MustRegister(hypervisorProcStatus)
+		prometheus.MustRegister(hypervisorProcStat)
+		prometheus.MustRegister(hypervisorNetdev)
+		prometheus.MustRegister(hypervisorIOStat)
+		prometheus.MustRegister(hypervisorOpenFDs)
+		// agent
+		prometheus.MustRegister(agentRPCDurationsHistogram)
+		// virtiofsd
+		prometheus.MustRegister(virtiofsdThreads)
+		prometheus.MustRegister(virtiofsdProcStatus)
+		prometheus.MustRegister(virtiofsdProcStat)
+		prometheus.MustRegister(virtiofsdIOStat)
+		prometheus.MustRegister(virtiofsdOpenFDs)
+	})
+}
+
+// SandboxMetrics is a per-sandbox handle onto the package-level metric
+// vectors above. Every series it writes is stamped with this sandbox's
+// identity labels, so many sandboxes multiplexed on the same shim process
+// get independent series instead of overwriting one another.
+type SandboxMetrics struct {
+	sandboxID string
+	criUID    string
+	name      string
+}
+
+// NewSandboxMetrics returns a metrics collector bound to sandboxID. CRI
+// metadata (uid/name) is optional and can be filled in after construction
+// via SetCRIMetadata once the pod sandbox config is known.
+func NewSandboxMetrics(sandboxID string) *SandboxMetrics {
+	RegisterMetrics()
+	return &SandboxMetrics{sandboxID: sandboxID}
+}
+
+// SetCRIMetadata records the CRI pod UID/name so subsequent samples carry
+// them as labels.
+func (m *SandboxMetrics) SetCRIMetadata(criUID, name string) {
+	m.criUID = criUID
+	m.name = name
+}
+
+// baseLabels returns the identity label values shared by every series this
+// sandbox emits, in the same order as sandboxLabels().
+func (m *SandboxMetrics) baseLabels(extra ...string) []string {
+	return append([]string{m.sandboxID, m.criUID, m.name}, extra...)
+}
+
+// baseLabelMap is the prometheus.Labels equivalent of baseLabels, for use
+// with MustCurryWith.
+func (m *SandboxMetrics) baseLabelMap() prometheus.Labels {
+	return prometheus.Labels{sandboxLabel: m.sandboxID, "cri_uid": m.criUID, "name": m.name}
+}
+
+// ObserveRPCDuration records an agent RPC's latency for this sandbox. When
+// traceID is non-empty it is attached to the sample as an exemplar, so a
+// scrape of the native histogram can link a slow bucket back to the trace
+// that produced it.
+func (m *SandboxMetrics) ObserveRPCDuration(action string, duration time.Duration, traceID string) {
+	histogram := agentRPCDurationsHistogram.WithLabelValues(m.baseLabels(action)...)
+	millis := float64(duration) / float64(time.Millisecond)
+	if traceID == "" {
+		histogram.Observe(millis)
+		return
+	}
+	histogram.(prometheus.ExemplarObserver).ObserveWithExemplar(millis, prometheus.Labels{"trace_id": traceID})
+}
+
+// Unregister deletes every series this sandbox has emitted across all the
+// vectors above. It must be called when a sandbox is torn down, otherwise
+// its last-seen values would linger (and be scraped) forever.
+func (m *SandboxMetrics) Unregister() {
+	match := prometheus.Labels{sandboxLabel: m.sandboxID}
+	hypervisorThreads.DeletePartialMatch(match)
+	hypervisorProcStatus.DeletePartialMatch(match)
+	hypervisorProcStat.DeletePartialMatch(match)
+	hypervisorNetdev.DeletePartialMatch(match)
+	hypervisorIOStat.DeletePartialMatch(match)
+	hypervisorOpenFDs.DeletePartialMatch(match)
+	agentRPCDurationsHistogram.DeletePartialMatch(match)
+	virtiofsdThreads.DeletePartialMatch(match)
+	virtiofsdProcStatus.DeletePartialMatch(match)
+	virtiofsdProcStat.DeletePartialMatch(match)
+	virtiofsdIOStat.DeletePartialMatch(match)
+	virtiofsdOpenFDs.DeletePartialMatch(match)
 }
 
 // UpdateRuntimeMetrics update shim/hypervisor's metrics
@@ -141,6 +254,11 @@ func (s *Sandbox) UpdateRuntimeMetrics() error {
 		return nil
 	}
 
+	if s.metrics == nil {
+		s.metrics = NewSandboxMetrics(s.ID())
+	}
+	m := s.metrics
+
 	hypervisorPid := pids[0]
 
 	proc, err := procfs.NewProc(hypervisorPid)
@@ -150,31 +268,47 @@ func (s *Sandbox) UpdateRuntimeMetrics() error {
 
 	// process FDs
 	if fds, err := proc.FileDescriptorsLen(); err == nil {
-		hypervisorOpenFDs.Set(float64(fds))
+		hypervisorOpenFDs.WithLabelValues(m.baseLabels()...).Set(float64(fds))
 	}
 
 	// process net device statistics
 	if netdev, err := proc.NetDev(); err == nil {
 		// netdev: map[string]NetDevLine
 		for _, v := range netdev {
-			mutils.SetGaugeVecNetDev(hypervisorNetdev, v)
+			mutils.SetGaugeVecNetDev(hypervisorNetdev.MustCurryWith(prometheus.Labels{
+				sandboxLabel: m.sandboxID,
+				"cri_uid":    m.criUID,
+				"name":       m.name,
+			}), v)
 		}
 	}
 
 	// process statistics
 	if procStat, err := proc.Stat(); err == nil {
-		hypervisorThreads.Set(float64(procStat.NumThreads))
-		mutils.SetGaugeVecProcStat(hypervisorProcStat, procStat)
+		hypervisorThreads.WithLabelValues(m.baseLabels()...).Set(float64(procStat.NumThreads))
+		mutils.SetGaugeVecProcStat(hypervisorProcStat.MustCurryWith(prometheus.Labels{
+			sandboxLabel: m.sandboxID,
+			"cri_uid":    m.criUID,
+			"name":       m.name,
+		}), procStat)
 	}
 
 	// process status
 	if procStatus, err := proc.NewStatus(); err == nil {
-		mutils.SetGaugeVecProcStatus(hypervisorProcStatus, procStatus)
+		mutils.SetGaugeVecProcStatus(hypervisorProcStatus.MustCurryWith(prometheus.Labels{
+			sandboxLabel: m.sandboxID,
+			"cri_uid":    m.criUID,
+			"name":       m.name,
+		}), procStatus)
 	}
 
 	// process IO statistics
 	if ioStat, err := proc.IO(); err == nil {
-		mutils.SetGaugeVecProcIO(hypervisorIOStat, ioStat)
+		mutils.SetGaugeVecProcIO(hypervisorIOStat.MustCurryWith(prometheus.Labels{
+			sandboxLabel: m.sandboxID,
+			"cri_uid":    m.criUID,
+			"name":       m.name,
+		}), ioStat)
 	}
 
 	// virtiofs metrics
@@ -186,8 +320,8 @@ func (s *Sandbox) UpdateRuntimeMetrics() error {
 	return nil
 }
 
-// getChildPids returns all child process PIDs of the given parent PID
-// by reading /proc/<pid>/task/<pid>/children file directly.
+// getChildPids returns all immediate child process PIDs of the given parent
+// PID by reading /proc/<pid>/task/<pid>/children file directly.
 // This is much faster than scanning the entire /proc directory.
 func getChildPids(parentPid int) ([]int, error) {
 	// Read /proc/<pid>/task/<pid>/children which contains space-separated child PIDs
@@ -215,6 +349,58 @@ func getChildPids(parentPid int) ([]int, error) {
 	return childPids, nil
 }
 
+// getDescendantPids returns every PID in the process tree rooted at
+// parentPid, not just its immediate children. virtiofsd can spawn more than
+// one worker process (e.g. when configured with multiple thread pools), and
+// those workers may themselves have children, so a single level of
+// getChildPids misses them.
+func getDescendantPids(parentPid int) []int {
+	var descendants []int
+	queue := []int{parentPid}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+
+		children, err := getChildPids(pid)
+		if err != nil {
+			continue
+		}
+		descendants = append(descendants, children...)
+		queue = append(queue, children...)
+	}
+	return descendants
+}
+
+// sumProcStat aggregates the counters we report across a set of processes,
+// so metrics reflect the whole virtiofsd tree rather than a single worker.
+func sumProcStat(stats []procfs.ProcStat) procfs.ProcStat {
+	var sum procfs.ProcStat
+	for _, st := range stats {
+		sum.NumThreads += st.NumThreads
+		sum.UTime += st.UTime
+		sum.STime += st.STime
+		sum.MinFlt += st.MinFlt
+		sum.MajFlt += st.MajFlt
+		sum.RSS += st.RSS
+	}
+	return sum
+}
+
+// sumProcIO aggregates IO counters across a set of processes.
+func sumProcIO(stats []procfs.ProcIO) procfs.ProcIO {
+	var sum procfs.ProcIO
+	for _, st := range stats {
+		sum.RChar += st.RChar
+		sum.WChar += st.WChar
+		sum.SyscR += st.SyscR
+		sum.SyscW += st.SyscW
+		sum.ReadBytes += st.ReadBytes
+		sum.WriteBytes += st.WriteBytes
+		sum.CancelledWriteBytes += st.CancelledWriteBytes
+	}
+	return sum
+}
+
 func (s *Sandbox) UpdateVirtiofsdMetrics() error {
 	vfsPid := s.hypervisor.GetVirtioFsPid()
 	if vfsPid == nil {
@@ -222,6 +408,12 @@ func (s *Sandbox) UpdateVirtiofsdMetrics() error {
 		return nil
 	}
 
+	if s.metrics == nil {
+		s.metrics = NewSandboxMetrics(s.ID())
+	}
+	m := s.metrics
+	labels := prometheus.Labels{sandboxLabel: m.sandboxID, "cri_uid": m.criUID, "name": m.name}
+
 	proc, err := procfs.NewProc(*vfsPid)
 	if err != nil {
 		return err
@@ -231,43 +423,65 @@ func (s *Sandbox) UpdateVirtiofsdMetrics() error {
 	// - virtiofsd_fds
 	// - virtiofsd_threads
 	if fds, err := proc.FileDescriptorsLen(); err == nil {
-		virtiofsdOpenFDs.Set(float64(fds))
+		virtiofsdOpenFDs.WithLabelValues(m.baseLabels()...).Set(float64(fds))
 	}
 
 	if procStat, err := proc.Stat(); err == nil {
-		virtiofsdThreads.Set(float64(procStat.NumThreads))
+		virtiofsdThreads.WithLabelValues(m.baseLabels()...).Set(float64(procStat.NumThreads))
 	}
 
-	// The following metrics are read from the child process (worker):
+	// The following metrics are aggregated across the whole virtiofsd
+	// process tree (main process plus every worker it has spawned, and
+	// any of their own children), not just a single child, since
+	// virtiofsd may run several worker processes depending on its
+	// thread pool configuration:
 	// - virtiofsd_proc_stat
 	// - virtiofsd_proc_status
 	// - virtiofsd_io_stat
-	// Because virtiofsd forks a child process to handle actual work,
-	// the main process is just a supervisor with minimal CPU/IO activity.
-	childPids, err := getChildPids(*vfsPid)
-	if err == nil && len(childPids) > 0 {
-		childProc, err := procfs.NewProc(childPids[0])
-		if err == nil {
+	descendantPids := getDescendantPids(*vfsPid)
+	if len(descendantPids) > 0 {
+		var stats []procfs.ProcStat
+		var ioStats []procfs.ProcIO
+		var lastStatus procfs.ProcStatus
+		haveStatus := false
+		for _, pid := range descendantPids {
+			childProc, err := procfs.NewProc(pid)
+			if err != nil {
+				continue
+			}
 			if procStat, err := childProc.Stat(); err == nil {
-				mutils.SetGaugeVecProcStat(virtiofsdProcStat, procStat)
+				stats = append(stats, procStat)
 			}
 			if procStatus, err := childProc.NewStatus(); err == nil {
-				mutils.SetGaugeVecProcStatus(virtiofsdProcStatus, procStatus)
+				// procfs.ProcStatus doesn't carry counters worth summing
+				// across processes, so just report the most recently
+				// seen worker's status.
+				lastStatus = procStatus
+				haveStatus = true
 			}
 			if ioStat, err := childProc.IO(); err == nil {
-				mutils.SetGaugeVecProcIO(virtiofsdIOStat, ioStat)
+				ioStats = append(ioStats, ioStat)
 			}
 		}
+		if len(stats) > 0 {
+			mutils.SetGaugeVecProcStat(virtiofsdProcStat.MustCurryWith(labels), sumProcStat(stats))
+		}
+		if haveStatus {
+			mutils.SetGaugeVecProcStatus(virtiofsdProcStatus.MustCurryWith(labels), lastStatus)
+		}
+		if len(ioStats) > 0 {
+			mutils.SetGaugeVecProcIO(virtiofsdIOStat.MustCurryWith(labels), sumProcIO(ioStats))
+		}
 	} else {
-		// Fallback to main process if no child found
+		// Fallback to the main process if it has no descendants.
 		if procStat, err := proc.Stat(); err == nil {
-			mutils.SetGaugeVecProcStat(virtiofsdProcStat, procStat)
+			mutils.SetGaugeVecProcStat(virtiofsdProcStat.MustCurryWith(labels), procStat)
 		}
 		if procStatus, err := proc.NewStatus(); err == nil {
-			mutils.SetGaugeVecProcStatus(virtiofsdProcStatus, procStatus)
+			mutils.SetGaugeVecProcStatus(virtiofsdProcStatus.MustCurryWith(labels), procStatus)
 		}
 		if ioStat, err := proc.IO(); err == nil {
-			mutils.SetGaugeVecProcIO(virtiofsdIOStat, ioStat)
+			mutils.SetGaugeVecProcIO(virtiofsdIOStat.MustCurryWith(labels), ioStat)
 		}
 	}
 
@@ -0,0 +1,276 @@
+// Copyright (c) 2020 Ant Financial
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	mutils "github.com/kata-containers/kata-containers/src/runtime/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// processLabel identifies which auxiliary process a ProcessWatcher's
+// series belong to, e.g. "nydusd" or "ch-remote".
+const processLabel = "process"
+
+// processWatcherMetrics is the set of gauges a ProcessWatcher reports,
+// mirroring the hypervisor/virtiofsd gauges above but under a
+// caller-chosen namespace so unrelated sidecars don't collide.
+type processWatcherMetrics struct {
+	threads    *prometheus.GaugeVec
+	procStatus *prometheus.GaugeVec
+	procStat   *prometheus.GaugeVec
+	netdev     *prometheus.GaugeVec
+	ioStat     *prometheus.GaugeVec
+	fds        *prometheus.GaugeVec
+}
+
+var (
+	processWatcherMetricsMu sync.Mutex
+	// processWatcherMetricsByNamespace caches the GaugeVecs created for a
+	// given namespace, since prometheus.NewGaugeVec can only be
+	// registered once per namespace/name pair.
+	processWatcherMetricsByNamespace = map[string]*processWatcherMetrics{}
+)
+
+func getProcessWatcherMetrics(namespace string) *processWatcherMetrics {
+	processWatcherMetricsMu.Lock()
+	defer processWatcherMetricsMu.Unlock()
+
+	if m, ok := processWatcherMetricsByNamespace[namespace]; ok {
+		return m
+	}
+
+	m := &processWatcherMetrics{
+		threads: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "threads",
+			Help:      "Process threads.",
+		}, sandboxLabels(processLabel)),
+		procStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "proc_status",
+			Help:      "Process status.",
+		}, sandboxLabels(processLabel, "item")),
+		procStat: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "proc_stat",
+			Help:      "Process statistics.",
+		}, sandboxLabels(processLabel, "item")),
+		netdev: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "netdev",
+			Help:      "Net devices statistics.",
+		}, sandboxLabels(processLabel, "interface", "item")),
+		ioStat: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "io_stat",
+			Help:      "Process IO statistics.",
+		}, sandboxLabels(processLabel, "item")),
+		fds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "fds",
+			Help:      "Open FDs.",
+		}, sandboxLabels(processLabel)),
+	}
+
+	prometheus.MustRegister(m.threads, m.procStatus, m.procStat, m.netdev, m.ioStat, m.fds)
+	processWatcherMetricsByNamespace[namespace] = m
+	return m
+}
+
+// ProcessWatcherConfig describes how to locate the process(es) a
+// ProcessWatcher should report on. Exactly one of PidFile, ExeName, or
+// CgroupPath must be set.
+type ProcessWatcherConfig struct {
+	// Namespace is the Prometheus metric namespace, e.g. "kata_nydusd".
+	Namespace string
+	// Process is the value of the "process" label, e.g. "nydusd".
+	Process string
+
+	// PidFile is a path containing a single PID, re-read on every Update
+	// so a restarted process (with a new PID) is picked up automatically.
+	PidFile string
+	// ExeName matches processes by the basename of their /proc/<pid>/exe
+	// target, e.g. "ch-remote". All matching PIDs are aggregated.
+	ExeName string
+	// CgroupPath is a cgroup directory whose cgroup.procs lists the PIDs
+	// to watch, e.g. a vhost-user-block backend placed in the sandbox's
+	// cgroup.
+	CgroupPath string
+}
+
+// ProcessWatcher discovers and reports standard process metrics for an
+// auxiliary helper process that isn't reachable through
+// hypervisor.GetPids()/GetVirtioFsPid() - e.g. vhost-user-block backends,
+// nydusd, cloud-hypervisor's ch-remote, or custom network helpers. It
+// re-discovers PIDs on every Update, so it keeps working across helper
+// restarts without the caller having to re-create it.
+type ProcessWatcher struct {
+	cfg     ProcessWatcherConfig
+	metrics *processWatcherMetrics
+	sandbox *SandboxMetrics
+}
+
+// NewProcessWatcher creates a ProcessWatcher reporting under sandbox's
+// identity labels.
+func (m *SandboxMetrics) NewProcessWatcher(cfg ProcessWatcherConfig) (*ProcessWatcher, error) {
+	if cfg.PidFile == "" && cfg.ExeName == "" && cfg.CgroupPath == "" {
+		return nil, fmt.Errorf("process watcher requires one of PidFile, ExeName, or CgroupPath")
+	}
+	if cfg.Namespace == "" {
+		return nil, fmt.Errorf("process watcher requires a Namespace")
+	}
+
+	return &ProcessWatcher{
+		cfg:     cfg,
+		metrics: getProcessWatcherMetrics(cfg.Namespace),
+		sandbox: m,
+	}, nil
+}
+
+// labels returns this watcher's full label map (sandbox identity plus its
+// process name).
+func (w *ProcessWatcher) labels() prometheus.Labels {
+	labels := w.sandbox.baseLabelMap()
+	labels[processLabel] = w.cfg.Process
+	return labels
+}
+
+// discoverPids resolves the configured locator to a set of live PIDs.
+func (w *ProcessWatcher) discoverPids() ([]int, error) {
+	switch {
+	case w.cfg.PidFile != "":
+		data, err := os.ReadFile(w.cfg.PidFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pidfile %s: %w", w.cfg.PidFile, err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pidfile contents in %s: %w", w.cfg.PidFile, err)
+		}
+		return []int{pid}, nil
+
+	case w.cfg.CgroupPath != "":
+		data, err := os.ReadFile(filepath.Join(w.cfg.CgroupPath, "cgroup.procs"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cgroup.procs in %s: %w", w.cfg.CgroupPath, err)
+		}
+		var pids []int
+		for _, line := range strings.Fields(string(data)) {
+			pid, err := strconv.Atoi(line)
+			if err != nil {
+				continue
+			}
+			pids = append(pids, pid)
+		}
+		return pids, nil
+
+	default:
+		return findPidsByExeName(w.cfg.ExeName)
+	}
+}
+
+// findPidsByExeName scans /proc for processes whose executable matches
+// exeName.
+func findPidsByExeName(exeName string) ([]int, error) {
+	entries, err := os.ReadDir(procfs.DefaultMountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		target, err := os.Readlink(fmt.Sprintf("%s/%d/exe", procfs.DefaultMountPoint, pid))
+		if err != nil {
+			continue
+		}
+		if filepath.Base(target) == exeName {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// Update discovers the watched process(es) and refreshes their gauges. It
+// is safe to call repeatedly even if the watched process has restarted
+// under a new PID, or is temporarily absent (Update then simply leaves the
+// last-reported values in place).
+func (w *ProcessWatcher) Update() error {
+	pids, err := w.discoverPids()
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return nil
+	}
+
+	labels := w.labels()
+	curriedNetdev := w.metrics.netdev.MustCurryWith(labels)
+	curriedStatus := w.metrics.procStatus.MustCurryWith(labels)
+
+	var stats []procfs.ProcStat
+	var ioStats []procfs.ProcIO
+	totalFds := 0
+
+	for _, pid := range pids {
+		proc, err := procfs.NewProc(pid)
+		if err != nil {
+			continue
+		}
+		if fds, err := proc.FileDescriptorsLen(); err == nil {
+			totalFds += fds
+		}
+		if st, err := proc.Stat(); err == nil {
+			stats = append(stats, st)
+		}
+		if io, err := proc.IO(); err == nil {
+			ioStats = append(ioStats, io)
+		}
+		if netdev, err := proc.NetDev(); err == nil {
+			for _, v := range netdev {
+				mutils.SetGaugeVecNetDev(curriedNetdev, v)
+			}
+		}
+		if status, err := proc.NewStatus(); err == nil {
+			mutils.SetGaugeVecProcStatus(curriedStatus, status)
+		}
+	}
+
+	w.metrics.fds.With(labels).Set(float64(totalFds))
+	if len(stats) > 0 {
+		agg := sumProcStat(stats)
+		w.metrics.threads.With(labels).Set(float64(agg.NumThreads))
+		mutils.SetGaugeVecProcStat(w.metrics.procStat.MustCurryWith(labels), agg)
+	}
+	if len(ioStats) > 0 {
+		mutils.SetGaugeVecProcIO(w.metrics.ioStat.MustCurryWith(labels), sumProcIO(ioStats))
+	}
+
+	return nil
+}
+
+// Unregister removes this watcher's series from its namespace's gauges.
+// It must be called when the watched sandbox is torn down.
+func (w *ProcessWatcher) Unregister() {
+	match := prometheus.Labels{sandboxLabel: w.sandbox.sandboxID, processLabel: w.cfg.Process}
+	w.metrics.threads.DeletePartialMatch(match)
+	w.metrics.procStatus.DeletePartialMatch(match)
+	w.metrics.procStat.DeletePartialMatch(match)
+	w.metrics.netdev.DeletePartialMatch(match)
+	w.metrics.ioStat.DeletePartialMatch(match)
+	w.metrics.fds.DeletePartialMatch(match)
+}